@@ -0,0 +1,56 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// serveAdmin serves the admin endpoints over TCP on addr.  The hook socket is left to Pilot alone.
+func serveAdmin(addr string) {
+	container := restful.NewContainer()
+	container.Add(newAdmin())
+	container.Handle("/metrics", promhttp.Handler())
+	log.WithField("listen", addr).Info("Serving admin endpoints.")
+	log.Fatal(http.ListenAndServe(addr, container))
+}
+
+// newAdmin creates a WebService with the admin and debugging routes
+func newAdmin() *restful.WebService {
+	ws := new(restful.WebService)
+	ws.Route(ws.GET("/version").
+		Produces(restful.MIME_JSON).
+		To(versionHandler))
+	ws.Route(ws.GET("/readyz").
+		Produces(restful.MIME_JSON).
+		To(readyz))
+	ws.Route(ws.GET("/selftest").
+		Produces(restful.MIME_JSON).
+		To(selfTest))
+	ws.Route(ws.GET("/debug/requests").
+		Produces(restful.MIME_JSON).
+		To(recentRequests))
+	ws.Route(ws.GET("/debug/nodes").
+		Produces(restful.MIME_JSON).
+		To(servedNodes))
+	ws.Route(ws.GET("/debug/nodes/{serviceNode}/{hook}").
+		Produces(restful.MIME_JSON).
+		To(servedNodeConfig))
+	return ws
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// dikastesVolumeName is the volume added to pods to share the dikastes socket directory with the sidecar.
+	dikastesVolumeName = "dikastes-sock"
+	// sidecarContainerName is the Istio sidecar that the socket is mounted into.
+	sidecarContainerName = "istio-proxy"
+	// InjectedAnnotation records on a pod that the admission webhook added the dikastes volume.
+	InjectedAnnotation = "authz.projectcalico.org/dikastes-injected"
+)
+
+// serveAdmission serves the pod mutating admission webhook over TLS on addr.  It only returns on error.
+func serveAdmission(addr, certFile, keyFile string) error {
+	container := restful.NewContainer()
+	container.Add(newAdmission())
+	log.WithField("listen", addr).Info("Serving admission webhook.")
+	return http.ListenAndServeTLS(addr, certFile, keyFile, container)
+}
+
+// newAdmission creates a WebService with the admission webhook routes
+func newAdmission() *restful.WebService {
+	ws := new(restful.WebService)
+	ws.Route(ws.POST("/mutate-pods").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON).
+		To(mutatePods))
+	return ws
+}
+
+// mutatePods handles AdmissionReviews for pods, patching in the dikastes socket volume and its mount in the sidecar.
+// Pods are always admitted; a pod that cannot be patched is just left alone.
+func mutatePods(req *restful.Request, resp *restful.Response) {
+	var review v1beta1.AdmissionReview
+	if err := req.ReadEntity(&review); err != nil || review.Request == nil {
+		reportError("admission", ErrorClassParse, log.Fields{"err": err}, "failed to decode AdmissionReview")
+		resp.WriteErrorString(http.StatusBadRequest, "could not parse AdmissionReview")
+		return
+	}
+	review.Response = admitPod(review.Request)
+	review.Response.UID = review.Request.UID
+	review.Request = nil
+	resp.WriteEntity(review)
+}
+
+func admitPod(ar *v1beta1.AdmissionRequest) *v1beta1.AdmissionResponse {
+	var pod v1.Pod
+	if err := json.Unmarshal(ar.Object.Raw, &pod); err != nil {
+		reportError("admission", ErrorClassParse, log.Fields{"err": err}, "failed to decode pod")
+		return &v1beta1.AdmissionResponse{Allowed: true, Result: &metav1.Status{Message: err.Error()}}
+	}
+	if pod.Namespace == "" {
+		// Not yet set on pods being created.
+		pod.Namespace = ar.Namespace
+	}
+	patch := podPatch(&pod)
+	if len(patch) == 0 {
+		return &v1beta1.AdmissionResponse{Allowed: true}
+	}
+	b, err := json.Marshal(patch)
+	if err != nil {
+		reportError("admission", ErrorClassEncode, log.Fields{"err": err}, "failed to encode patch")
+		return &v1beta1.AdmissionResponse{Allowed: true, Result: &metav1.Status{Message: err.Error()}}
+	}
+	log.WithFields(log.Fields{"namespace": ar.Namespace, "pod": pod.Name + pod.GenerateName}).Debug("Patching pod")
+	pt := v1beta1.PatchTypeJSONPatch
+	return &v1beta1.AdmissionResponse{Allowed: true, Patch: b, PatchType: &pt}
+}
+
+// podPatch returns the JSON Patch that wires the dikastes socket into the pod's sidecar, or nothing if the pod has
+// opted out of injection or the mesh, runs as an excluded service account, has no sidecar, or already has the volume.
+func podPatch(pod *v1.Pod) []diffOp {
+	if podOptedOut(pod) || podIstioOptedOut(pod) ||
+		excludedServiceAccounts != nil && excludedServiceAccounts.matches(pod) {
+		return nil
+	}
+	for _, v := range pod.Spec.Volumes {
+		if v.Name == dikastesVolumeName {
+			return nil
+		}
+	}
+	sidecar := -1
+	for i, c := range pod.Spec.Containers {
+		if c.Name == sidecarContainerName {
+			sidecar = i
+		}
+	}
+	if sidecar < 0 {
+		return nil
+	}
+
+	var ops []diffOp
+	volume := v1.Volume{
+		Name:         dikastesVolumeName,
+		VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: DikastesSocketDir}},
+	}
+	if len(pod.Spec.Volumes) == 0 {
+		ops = append(ops, diffOp{Op: "add", Path: "/spec/volumes", Value: []v1.Volume{volume}})
+	} else {
+		ops = append(ops, diffOp{Op: "add", Path: "/spec/volumes/-", Value: volume})
+	}
+	mount := v1.VolumeMount{Name: dikastesVolumeName, MountPath: DikastesSocketDir}
+	mounts := fmt.Sprintf("/spec/containers/%d/volumeMounts", sidecar)
+	if len(pod.Spec.Containers[sidecar].VolumeMounts) == 0 {
+		ops = append(ops, diffOp{Op: "add", Path: mounts, Value: []v1.VolumeMount{mount}})
+	} else {
+		ops = append(ops, diffOp{Op: "add", Path: mounts + "/-", Value: mount})
+	}
+	if len(pod.Annotations) == 0 {
+		ops = append(ops, diffOp{Op: "add", Path: "/metadata/annotations",
+			Value: map[string]string{InjectedAnnotation: "true"}})
+	} else {
+		ops = append(ops, diffOp{Op: "add", Path: "/metadata/annotations/" + escapePointer(InjectedAnnotation),
+			Value: "true"})
+	}
+	return ops
+}
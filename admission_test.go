@@ -0,0 +1,76 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+	"k8s.io/api/admission/v1beta1"
+)
+
+const admissionPod = `{"metadata": {"name": "web", "annotations": {"a": "b"}}, "spec": {
+  "volumes": [{"name": "data", "emptyDir": {}}],
+  "containers": [{"name": "web"}, {"name": "istio-proxy"}]}}`
+
+func runAdmission(pod string) v1beta1.AdmissionReview {
+	body := `{"kind": "AdmissionReview", "apiVersion": "admission.k8s.io/v1beta1",
+		"request": {"uid": "1234", "operation": "CREATE", "object": ` + pod + `}}`
+	req := restful.NewRequest(httptest.NewRequest("POST", "/mutate-pods", strings.NewReader(body)))
+	rec := httptest.NewRecorder()
+	mutatePods(req, restful.NewResponse(rec))
+	var review v1beta1.AdmissionReview
+	Expect(json.Unmarshal(rec.Body.Bytes(), &review)).To(Succeed())
+	return review
+}
+
+func TestAdmissionPatch(t *testing.T) {
+	RegisterTestingT(t)
+
+	review := runAdmission(admissionPod)
+	Expect(review.Response.UID).To(BeEquivalentTo("1234"))
+	Expect(review.Response.Allowed).To(BeTrue())
+	Expect(*review.Response.PatchType).To(Equal(v1beta1.PatchTypeJSONPatch))
+	Expect(review.Response.Patch).To(MatchJSON(`[
+	  {"op": "add", "path": "/spec/volumes/-", "value": {"name": "dikastes-sock", "hostPath": {"path": "/var/run/dikastes"}}},
+	  {"op": "add", "path": "/spec/containers/1/volumeMounts", "value": [{"name": "dikastes-sock", "mountPath": "/var/run/dikastes"}]},
+	  {"op": "add", "path": "/metadata/annotations/authz.projectcalico.org~1dikastes-injected", "value": "true"}
+	]`))
+}
+
+func TestAdmissionNoPatch(t *testing.T) {
+	RegisterTestingT(t)
+
+	for _, pod := range []string{
+		// No sidecar.
+		`{"metadata": {"name": "web"}, "spec": {"containers": [{"name": "web"}]}}`,
+		// Already injected.
+		`{"metadata": {"name": "web"}, "spec": {"volumes": [{"name": "dikastes-sock"}], "containers": [{"name": "istio-proxy"}]}}`,
+		// Opted out.
+		`{"metadata": {"name": "web", "annotations": {"authz.projectcalico.org/inject": "false"}},
+		  "spec": {"containers": [{"name": "istio-proxy"}]}}`,
+		// Out of the mesh.
+		`{"metadata": {"name": "web", "annotations": {"sidecar.istio.io/inject": "false"}},
+		  "spec": {"containers": [{"name": "istio-proxy"}]}}`,
+	} {
+		review := runAdmission(pod)
+		Expect(review.Response.Allowed).To(BeTrue())
+		Expect(review.Response.Patch).To(BeEmpty())
+	}
+}
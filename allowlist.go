@@ -0,0 +1,140 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/emicklei/go-restful"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var unlistedNodeRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "unlisted_node_requests_total",
+	Help:      "Hook requests for service nodes not in --allowed-node-ids or --allowed-node-ips, by hook.",
+}, []string{"hook"})
+
+func init() {
+	prometheus.MustRegister(unlistedNodeRequests)
+}
+
+// nodeAllowlist limits the service nodes the webhook mutates config for, so that a caller spoofing node strings can
+// only affect the nodes listed.  It is nil unless --allowed-node-ids or --allowed-node-ips is set.
+var nodeAllowlist *nodeAllowlistConfig
+
+type nodeAllowlistConfig struct {
+	// ids are globs matched against the ID part of service nodes, or nil for any ID.
+	ids []string
+	// nets are the nets service node IPs must be in, or nil for any IP.
+	nets   []*net.IPNet
+	reject bool
+}
+
+// newNodeAllowlist parses --allowed-node-ids, comma separated globs, and --allowed-node-ips, comma separated IPs and
+// CIDRs.
+func newNodeAllowlist(ids, ips string, reject bool) (*nodeAllowlistConfig, error) {
+	a := &nodeAllowlistConfig{reject: reject}
+	for _, id := range strings.Split(ids, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		if _, err := path.Match(id, ""); err != nil {
+			return nil, fmt.Errorf("node ID %q: %v", id, err)
+		}
+		a.ids = append(a.ids, id)
+	}
+	for _, n := range strings.Split(ips, ",") {
+		if n = strings.TrimSpace(n); n == "" {
+			continue
+		}
+		if !strings.Contains(n, "/") {
+			if ip := net.ParseIP(n); ip != nil && ip.To4() != nil {
+				n += "/32"
+			} else {
+				n += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(n)
+		if err != nil {
+			return nil, err
+		}
+		a.nets = append(a.nets, ipNet)
+	}
+	if a.ids == nil && a.nets == nil {
+		return nil, fmt.Errorf("no node IDs or IPs")
+	}
+	return a, nil
+}
+
+// allows reports whether a service node is listed.  Nodes that cannot be parsed never are.
+func (a *nodeAllowlistConfig) allows(serviceNode string) bool {
+	c := strings.Split(serviceNode, serviceNodeSeparator)
+	if len(c) < 3 {
+		return false
+	}
+	if a.ids != nil && !a.allowsID(c[2]) {
+		return false
+	}
+	if a.nets != nil && !a.allowsIP(net.ParseIP(c[1])) {
+		return false
+	}
+	return true
+}
+
+func (a *nodeAllowlistConfig) allowsID(id string) bool {
+	for _, p := range a.ids {
+		if ok, _ := path.Match(p, id); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *nodeAllowlistConfig) allowsIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range a.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeAllowlisted returns a route filter that passes through unmodified, or rejects, requests for service nodes that
+// are not listed.
+func nodeAllowlisted(hook string) restful.FilterFunction {
+	return func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		serviceNode := req.PathParameter("serviceNode")
+		if nodeAllowlist == nil || nodeAllowlist.allows(serviceNode) {
+			chain.ProcessFilter(req, resp)
+			return
+		}
+		unlistedNodeRequests.WithLabelValues(hook).Inc()
+		errorLog.Warn(log.Fields{"hook": hook, "serviceNode": serviceNode}, "request for a service node not allowed")
+		if nodeAllowlist.reject {
+			resp.WriteErrorString(http.StatusForbidden, "service node is not allowed")
+			return
+		}
+		copyRequestToResponse(hook, resp, req)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewNodeAllowlist(t *testing.T) {
+	RegisterTestingT(t)
+
+	a, err := newNodeAllowlist("*.istio-system, app-*.default", "10.0.0.0/8, fd00::1,192.168.1.1", false)
+	Expect(err).To(BeNil())
+	Expect(a.ids).To(Equal([]string{"*.istio-system", "app-*.default"}))
+	Expect(a.nets).To(HaveLen(3))
+	Expect(a.nets[1].String()).To(Equal("fd00::1/128"))
+	Expect(a.nets[2].String()).To(Equal("192.168.1.1/32"))
+
+	for _, bad := range [][2]string{{"", ""}, {" , ", ""}, {"[", ""}, {"", "10.0.0.0/33"}, {"", "not-an-ip"}} {
+		_, err = newNodeAllowlist(bad[0], bad[1], false)
+		Expect(err).NotTo(BeNil(), "%v", bad)
+	}
+}
+
+func TestNodeAllowlistAllows(t *testing.T) {
+	RegisterTestingT(t)
+
+	byID, err := newNodeAllowlist("*.default", "", false)
+	Expect(err).To(BeNil())
+	Expect(byID.allows("sidecar~10.1.2.3~app-1.default~default.svc.cluster.local")).To(BeTrue())
+	Expect(byID.allows("sidecar~10.1.2.3~app-1.kube-system~kube-system.svc.cluster.local")).To(BeFalse())
+	Expect(byID.allows("sidecar~10.1.2.3")).To(BeFalse(), "no ID")
+
+	byIP, err := newNodeAllowlist("", "10.1.0.0/16", false)
+	Expect(err).To(BeNil())
+	Expect(byIP.allows("sidecar~10.1.2.3~app-1.default~default.svc.cluster.local")).To(BeTrue())
+	Expect(byIP.allows("sidecar~10.2.2.3~app-1.default~default.svc.cluster.local")).To(BeFalse())
+	Expect(byIP.allows("sidecar~bad~app-1.default~default.svc.cluster.local")).To(BeFalse())
+
+	both, err := newNodeAllowlist("*.default", "10.1.0.0/16", false)
+	Expect(err).To(BeNil())
+	Expect(both.allows("sidecar~10.1.2.3~app-1.default~default.svc.cluster.local")).To(BeTrue())
+	Expect(both.allows("sidecar~10.2.2.3~app-1.default~default.svc.cluster.local")).To(BeFalse())
+	Expect(both.allows("sidecar~10.1.2.3~app-1.other~other.svc.cluster.local")).To(BeFalse())
+}
+
+// allowlistRequest sends an LDS request for a sidecar on NODE_IP through the hooks.
+func allowlistRequest() *httptest.ResponseRecorder {
+	return serveHook(newHooks(), hookRequest("listeners", "sidecar", strings.NewReader(ldsWithUnknownFields)))
+}
+
+func TestNodeAllowlisted(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { nodeAllowlist = nil }()
+	mutated := allowlistRequest().Body.String()
+	Expect(mutated).NotTo(MatchJSON(ldsWithUnknownFields))
+
+	var err error
+	nodeAllowlist, err = newNodeAllowlist("", NODE_IP, false)
+	Expect(err).To(BeNil())
+	Expect(allowlistRequest().Body.String()).To(MatchJSON(mutated))
+
+	nodeAllowlist, err = newNodeAllowlist("", "192.0.2.0/24", false)
+	Expect(err).To(BeNil())
+	before := testutil.ToFloat64(unlistedNodeRequests.WithLabelValues("listeners"))
+	rec := allowlistRequest()
+	Expect(rec.Code).To(Equal(http.StatusOK))
+	Expect(rec.Body.String()).To(MatchJSON(ldsWithUnknownFields), "passed through unmodified")
+	Expect(testutil.ToFloat64(unlistedNodeRequests.WithLabelValues("listeners"))).To(Equal(before + 1))
+
+	nodeAllowlist.reject = true
+	Expect(allowlistRequest().Code).To(Equal(http.StatusForbidden))
+	Expect(testutil.ToFloat64(unlistedNodeRequests.WithLabelValues("listeners"))).To(Equal(before + 2))
+}
@@ -0,0 +1,312 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/projectcalico/libcalico-go/lib/selector"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+// ALPMutatorName is the name the --app-layer-policy mutator is registered under.
+const ALPMutatorName = "app-layer-policy"
+
+// The keys of the per route config an ApplicationLayerPolicy's route rules are translated into.  Values are comma
+// separated lists, with "*" for any.
+const (
+	ALPPoliciesKey        = "calico.policies"
+	ALPMethodsKey         = "calico.allow_methods"
+	ALPServiceAccountsKey = "calico.allow_service_accounts"
+)
+
+const alpPollInterval = 30 * time.Second
+
+// alpPath is where ApplicationLayerPolicies are listed.  libcalico-go has no client for them, so they are read from the
+// Calico API server with the core REST client, as EnvoyFilters are.
+const alpPath = "/apis/projectcalico.org/v3/applicationlayerpolicies"
+
+var errALPNotLoaded = errors.New("ApplicationLayerPolicies not yet loaded")
+
+// appLayerPolicies holds the ApplicationLayerPolicies whose route rules the RDS hook applies.  It is nil unless
+// --app-layer-policy is set.
+var appLayerPolicies *alpIndex
+
+// appLayerPolicy is a namespaced Calico ApplicationLayerPolicy.  Its route rules allow requests on the routes they
+// match from the given service accounts with the given methods, to the workloads its selector selects.
+type appLayerPolicy struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec struct {
+		Selector string     `json:"selector"`
+		Routes   []alpRoute `json:"routes"`
+	} `json:"spec"`
+
+	selector selector.Selector
+}
+
+// alpRoute is an allow rule for the routes it matches.  Unset fields match anything.
+type alpRoute struct {
+	// VirtualHost is a glob pattern matched against the name of the route's virtual host.
+	VirtualHost string `json:"virtualHost,omitempty"`
+	// Prefix matches routes whose path or prefix starts with it.
+	Prefix string `json:"prefix,omitempty"`
+	// Methods and ServiceAccounts are what the rule allows; none means any.
+	Methods         []string `json:"methods,omitempty"`
+	ServiceAccounts []string `json:"serviceAccounts,omitempty"`
+}
+
+type alpList struct {
+	Items []appLayerPolicy `json:"items"`
+}
+
+// parseALPs decodes a list of ApplicationLayerPolicies, in namespace and name order.
+func parseALPs(b []byte) ([]*appLayerPolicy, error) {
+	var list alpList
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, err
+	}
+	var policies []*appLayerPolicy
+	for i := range list.Items {
+		p := &list.Items[i]
+		key := p.Metadata.Namespace + "/" + p.Metadata.Name
+		var err error
+		if p.selector, err = selector.Parse(p.Spec.Selector); err != nil {
+			return nil, fmt.Errorf("ApplicationLayerPolicy %s: invalid selector: %v", key, err)
+		}
+		for _, r := range p.Spec.Routes {
+			if _, err := path.Match(r.VirtualHost, ""); err != nil {
+				return nil, fmt.Errorf("ApplicationLayerPolicy %s: invalid virtual host pattern %q", key, r.VirtualHost)
+			}
+		}
+		policies = append(policies, p)
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].Metadata.Namespace != policies[j].Metadata.Namespace {
+			return policies[i].Metadata.Namespace < policies[j].Metadata.Namespace
+		}
+		return policies[i].Metadata.Name < policies[j].Metadata.Name
+	})
+	return policies, nil
+}
+
+// alpIndex holds the ApplicationLayerPolicies, as last listed.
+type alpIndex struct {
+	mu       sync.RWMutex
+	policies []*appLayerPolicy
+	loaded   bool
+}
+
+func (x *alpIndex) replace(policies []*appLayerPolicy) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.policies = policies
+	x.loaded = true
+}
+
+// forPod returns the policies that select a pod, in namespace and name order.
+func (x *alpIndex) forPod(pod *corev1.Pod) []*appLayerPolicy {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	var selected []*appLayerPolicy
+	for _, p := range x.policies {
+		if p.Metadata.Namespace == pod.Namespace && p.selector.Evaluate(pod.Labels) {
+			selected = append(selected, p)
+		}
+	}
+	return selected
+}
+
+// run polls the ApplicationLayerPolicies for changes.  It never returns.
+func (x *alpIndex) run(client rest.Interface) {
+	for {
+		raw, err := client.Get().AbsPath(alpPath).Do().Raw()
+		if err == nil {
+			var policies []*appLayerPolicy
+			if policies, err = parseALPs(raw); err == nil {
+				x.replace(policies)
+			}
+		}
+		if err != nil {
+			log.WithField("err", err).Warn("Unable to load ApplicationLayerPolicies.")
+		}
+		time.Sleep(alpPollInterval)
+	}
+}
+
+// check is a readiness check that fails until the policies have been loaded, so that routes are not sent without the
+// config they call for meanwhile.
+func (x *alpIndex) check() error {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if !x.loaded {
+		return errALPNotLoaded
+	}
+	return nil
+}
+
+// alpMutator translates the route rules of the ApplicationLayerPolicies that select a sidecar's workload into per route
+// config on the routes they match, as a registered mutator.  The v1 route config has no per filter config, so it is
+// set in the routes' opaque_config, the per route metadata Envoy makes available to filters, alongside any Pilot set.
+type alpMutator struct {
+	mutator.Passthrough
+	policies *alpIndex
+}
+
+func (m *alpMutator) Name() string { return ALPMutatorName }
+
+// Routes adds the per route config of the policies that select the node's pod.  Only virtual hosts with a route that
+// a rule matches are re-encoded.
+func (m *alpMutator) Routes(req *mutator.Request, body []byte) ([]byte, []string, error) {
+	if !req.Node.IsSidecar() || pods == nil {
+		return body, nil, nil
+	}
+	pod, err := pods.byIP(req.Node.IP)
+	if err != nil {
+		reportError("routes", ErrorClassLookup, log.Fields{"ip": req.Node.IP, "err": err}, "failed to look up pod")
+	}
+	if pod == nil {
+		return body, nil, nil
+	}
+	policies := m.policies.forPod(pod)
+	if len(policies) == 0 {
+		return body, nil, nil
+	}
+	x, err := mutator.DecodeXDS(body, "virtual_hosts")
+	if err != nil {
+		return nil, nil, err
+	}
+	var changed []string
+	for i, item := range x.Items {
+		d := json.NewDecoder(bytes.NewReader(item))
+		d.UseNumber()
+		var vhost map[string]interface{}
+		if err := d.Decode(&vhost); err != nil {
+			return nil, nil, err
+		}
+		name, _ := vhost["name"].(string)
+		routes, _ := vhost["routes"].([]interface{})
+		patched := false
+		for j, r := range routes {
+			route, ok := r.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			cfg := alpRouteConfig(policies, name, route)
+			if cfg == nil {
+				continue
+			}
+			patch := map[string]interface{}{"opaque_config": cfg}
+			if mergeChanges(route, patch) {
+				routes[j] = mergePatch(route, patch)
+				patched = true
+			}
+		}
+		if !patched {
+			continue
+		}
+		if x.Items[i], err = json.Marshal(vhost); err != nil {
+			return nil, nil, err
+		}
+		changed = append(changed, "virtual_host/"+scriptResourceName(vhost, i))
+	}
+	if len(changed) == 0 {
+		return body, nil, nil
+	}
+	var buf bytes.Buffer
+	x.EncodeTo(&buf)
+	return buf.Bytes(), changed, nil
+}
+
+// alpRouteConfig returns the per route config for a route of the named virtual host: the policies with a rule that
+// matches it, and what those rules allow between them, or nil if no rule matches.
+func alpRouteConfig(policies []*appLayerPolicy, vhost string, route map[string]interface{}) map[string]interface{} {
+	routePath, _ := route["prefix"].(string)
+	if p, ok := route["path"].(string); ok {
+		routePath = p
+	}
+	var names []string
+	methods, accounts := newAllowed(), newAllowed()
+	for _, p := range policies {
+		matched := false
+		for _, r := range p.Spec.Routes {
+			if ok, _ := path.Match(r.VirtualHost, vhost); r.VirtualHost != "" && !ok {
+				continue
+			}
+			if !strings.HasPrefix(routePath, r.Prefix) {
+				continue
+			}
+			matched = true
+			methods.add(r.Methods)
+			accounts.add(r.ServiceAccounts)
+		}
+		if matched {
+			names = append(names, p.Metadata.Namespace+"/"+p.Metadata.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return map[string]interface{}{
+		ALPPoliciesKey:        strings.Join(names, ","),
+		ALPMethodsKey:         methods.String(),
+		ALPServiceAccountsKey: accounts.String(),
+	}
+}
+
+// allowed is the union of what several allow rules allow.  A rule that lists nothing allows anything.
+type allowed struct {
+	any    bool
+	values map[string]bool
+}
+
+func newAllowed() *allowed {
+	return &allowed{values: make(map[string]bool)}
+}
+
+func (a *allowed) add(values []string) {
+	if len(values) == 0 {
+		a.any = true
+	}
+	for _, v := range values {
+		a.values[v] = true
+	}
+}
+
+func (a *allowed) String() string {
+	if a.any {
+		return "*"
+	}
+	var values []string
+	for v := range a.values {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return strings.Join(values, ",")
+}
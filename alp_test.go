@@ -0,0 +1,108 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+const testALPs = `{"items": [
+	{"metadata": {"name": "reviews-api", "namespace": "testns"},
+	 "spec": {"selector": "app == 'web'", "routes": [
+	   {"virtualHost": "reviews*", "prefix": "/api", "methods": ["GET"], "serviceAccounts": ["web"]}]}},
+	{"metadata": {"name": "admin", "namespace": "testns"},
+	 "spec": {"selector": "all()", "routes": [{"prefix": "/api/admin", "methods": ["POST"]}]}},
+	{"metadata": {"name": "other", "namespace": "otherns"},
+	 "spec": {"selector": "all()", "routes": [{}]}}
+]}`
+
+func TestParseALPsInvalid(t *testing.T) {
+	RegisterTestingT(t)
+
+	_, err := parseALPs([]byte(`{"items": [{"metadata": {"name": "a"}, "spec": {"selector": "app ==="}}]}`))
+	Expect(err).NotTo(BeNil())
+	_, err = parseALPs([]byte(`{"items": [{"metadata": {"name": "a"}, "spec": {"routes": [{"virtualHost": "["}]}}]}`))
+	Expect(err).NotTo(BeNil())
+}
+
+func TestALPRoutes(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { pods = nil }()
+	pod := testPod("testpod", "1.2.3.4", corev1.PodRunning, nil)
+	pod.Labels = map[string]string{"app": "web"}
+	pods = newTestPodIndex(pod)
+	policies, err := parseALPs([]byte(testALPs))
+	Expect(err).To(BeNil())
+	x := &alpIndex{}
+	x.replace(policies)
+	Expect(x.forPod(pod)).To(HaveLen(2))
+	m := &alpMutator{policies: x}
+
+	body := `{"virtual_hosts": [
+		{"name": "reviews.testns.svc.cluster.local|http", "domains": ["reviews"], "routes": [
+			{"prefix": "/api/admin", "cluster": "out.reviews", "opaque_config": {"mixer_check": "on"}},
+			{"prefix": "/", "cluster": "out.reviews"}]},
+		{"name": "ratings.testns.svc.cluster.local|http", "domains": ["ratings"], "routes": [
+			{"prefix": "/", "cluster": "out.ratings"}]}]}`
+	req := &mutator.Request{Node: config.ParseNode(serviceNode("sidecar", "1.2.3.4"))}
+	out, changed, err := m.Routes(req, []byte(body))
+	Expect(err).To(BeNil())
+	Expect(changed).To(Equal([]string{"virtual_host/reviews.testns.svc.cluster.local|http"}))
+	Expect(out).To(MatchJSON(`{"virtual_hosts": [
+		{"name": "reviews.testns.svc.cluster.local|http", "domains": ["reviews"], "routes": [
+			{"prefix": "/api/admin", "cluster": "out.reviews", "opaque_config": {
+				"mixer_check": "on",
+				"calico.policies": "testns/admin,testns/reviews-api",
+				"calico.allow_methods": "GET,POST",
+				"calico.allow_service_accounts": "*"}},
+			{"prefix": "/", "cluster": "out.reviews"}]},
+		{"name": "ratings.testns.svc.cluster.local|http", "domains": ["ratings"], "routes": [
+			{"prefix": "/", "cluster": "out.ratings"}]}]}`))
+
+	// Applying them again changes nothing.
+	again, changed, err := m.Routes(req, out)
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeEmpty())
+	Expect(again).To(Equal(out))
+
+	// Nor are the routes of workloads no policy selects, or of other nodes, touched.
+	other := testPod("testpod", "1.2.3.4", corev1.PodRunning, nil)
+	other.Namespace = "emptyns"
+	pods = newTestPodIndex(other)
+	_, changed, err = m.Routes(req, []byte(body))
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeEmpty())
+	pods = newTestPodIndex(pod)
+	req = &mutator.Request{Node: config.ParseNode(serviceNode("router", "1.2.3.4"))}
+	_, changed, err = m.Routes(req, []byte(body))
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeEmpty())
+}
+
+func TestALPNotLoaded(t *testing.T) {
+	RegisterTestingT(t)
+
+	x := &alpIndex{}
+	Expect(x.check()).To(Equal(errALPNotLoaded))
+	x.replace(nil)
+	Expect(x.check()).To(BeNil())
+}
@@ -0,0 +1,266 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+// auditLog records the changes made by each hook request.  It is nil unless --audit-log is set.
+var auditLog *auditor
+
+type auditRecord struct {
+	Time           time.Time        `json:"time"`
+	Hook           string           `json:"hook"`
+	ServiceCluster string           `json:"serviceCluster,omitempty"`
+	ServiceNode    string           `json:"serviceNode,omitempty"`
+	Changes        []resourceChange `json:"changes"`
+	// Denied is set for requests denied by --deny-unexpected.
+	Denied *deniedRequest `json:"denied,omitempty"`
+}
+
+// resourceChange describes the modification of a single named xDS resource.
+type resourceChange struct {
+	Kind string   `json:"kind"`
+	Name string   `json:"name"`
+	Diff []diffOp `json:"diff"`
+}
+
+// diffOp is a single JSON Patch (RFC 6902) style operation.
+type diffOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// auditor writes audit records as JSON lines.
+type auditor struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newAuditor(w io.Writer) *auditor {
+	return &auditor{enc: json.NewEncoder(w)}
+}
+
+// openAuditLog opens the audit stream at path for appending, or stdout if path is "-".
+func openAuditLog(path string) (*auditor, error) {
+	if path == "-" {
+		return newAuditor(os.Stdout), nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return newAuditor(f), nil
+}
+
+func (a *auditor) record(rec auditRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err := a.enc.Encode(rec)
+	if err != nil {
+		errorLog.Error(log.Fields{"err": err}, "failed to write audit record")
+	}
+}
+
+// recordMutation writes an audit record for a hook request, given its config before and after the mutators ran and the
+// resources they changed, as <kind>/<name>.  Each resource is found in the config by the name it is reported by, so
+// that added and removed ones, such as the authz cluster or endpoints outside the network sets, are recorded too.
+func (a *auditor) recordMutation(req *restful.Request, hook mutator.Hook, before, after []byte, changed []string) {
+	rec := auditRecord{
+		Time:           time.Now(),
+		Hook:           string(hook),
+		ServiceCluster: req.PathParameter("serviceCluster"),
+		ServiceNode:    req.PathParameter("serviceNode"),
+		Changes:        []resourceChange{},
+	}
+	key := scriptResources[hook].key
+	was, now := resourcesByName(before, key), resourcesByName(after, key)
+	seen := make(map[string]bool, len(changed))
+	for _, c := range changed {
+		// A resource several mutators changed is reported by each of them.
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		parts := strings.SplitN(c, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		kind, name := parts[0], parts[1]
+		prev, hadPrev := was[name]
+		next, hasNext := now[name]
+		var ops []diffOp
+		switch {
+		case hadPrev && hasNext:
+			ops = diffJSON("", prev, next)
+		case hasNext:
+			ops = []diffOp{{Op: "add", Path: "", Value: next}}
+		case hadPrev:
+			ops = []diffOp{{Op: "remove", Path: ""}}
+		}
+		if len(ops) > 0 {
+			rec.Changes = append(rec.Changes, resourceChange{Kind: kind, Name: name, Diff: ops})
+		}
+	}
+	a.record(rec)
+}
+
+// resourcesByName decodes the resources a config has under key, with any secrets redacted, by the name changes to them
+// are reported by.
+func resourcesByName(body []byte, key string) map[string]interface{} {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil
+	}
+	items, _ := doc[key].([]interface{})
+	byName := make(map[string]interface{}, len(items))
+	for i, item := range items {
+		redactValue(item)
+		byName[scriptResourceName(item, i)] = item
+	}
+	return byName
+}
+
+// recordListeners writes an audit record for an LDS request streamed with --stream-arrays, given the raw listeners
+// before and after mutation.
+func (a *auditor) recordListeners(req *restful.Request, names []string, before, after []json.RawMessage) {
+	rec := auditRecord{
+		Time:           time.Now(),
+		Hook:           "listeners",
+		ServiceCluster: req.PathParameter("serviceCluster"),
+		ServiceNode:    req.PathParameter("serviceNode"),
+		Changes:        []resourceChange{},
+	}
+	for i, l := range after {
+		// Untouched listeners are passed through as they were.
+		if bytes.Equal(before[i], l) {
+			continue
+		}
+		ops := diffJSON("", snapshot(before[i]), snapshot(l))
+		if len(ops) > 0 {
+			rec.Changes = append(rec.Changes, resourceChange{Kind: "listener", Name: names[i], Diff: ops})
+		}
+	}
+	a.record(rec)
+}
+
+// snapshot returns the generic JSON form of v with any secrets redacted.
+func snapshot(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("<unencodable: %v>", err)
+	}
+	var out interface{}
+	json.Unmarshal(b, &out)
+	redactValue(out)
+	return out
+}
+
+// diffJSON returns the operations that turn a into b, both being generic decoded JSON values.  Arrays are diffed by
+// trimming their common prefix and suffix, which yields minimal output for the insertions the webhook makes.
+func diffJSON(path string, a, b interface{}) []diffOp {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		var ops []diffOp
+		for _, k := range sortedKeys(av) {
+			p := path + "/" + escapePointer(k)
+			if bval, ok := bv[k]; ok {
+				ops = append(ops, diffJSON(p, av[k], bval)...)
+			} else {
+				ops = append(ops, diffOp{Op: "remove", Path: p})
+			}
+		}
+		for _, k := range sortedKeys(bv) {
+			if _, ok := av[k]; !ok {
+				ops = append(ops, diffOp{Op: "add", Path: path + "/" + escapePointer(k), Value: bv[k]})
+			}
+		}
+		return ops
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		return diffArray(path, av, bv)
+	}
+	if reflect.DeepEqual(a, b) {
+		return nil
+	}
+	return []diffOp{{Op: "replace", Path: path, Value: b}}
+}
+
+func diffArray(path string, a, b []interface{}) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && reflect.DeepEqual(a[prefix], b[prefix]) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		reflect.DeepEqual(a[len(a)-1-suffix], b[len(b)-1-suffix]) {
+		suffix++
+	}
+	am := a[prefix : len(a)-suffix]
+	bm := b[prefix : len(b)-suffix]
+
+	var ops []diffOp
+	common := len(am)
+	if len(bm) < common {
+		common = len(bm)
+	}
+	for i := 0; i < common; i++ {
+		ops = append(ops, diffJSON(fmt.Sprintf("%s/%d", path, prefix+i), am[i], bm[i])...)
+	}
+	// Remove from the end so that earlier indices remain valid.
+	for i := len(am) - 1; i >= common; i-- {
+		ops = append(ops, diffOp{Op: "remove", Path: fmt.Sprintf("%s/%d", path, prefix+i)})
+	}
+	for i := common; i < len(bm); i++ {
+		ops = append(ops, diffOp{Op: "add", Path: fmt.Sprintf("%s/%d", path, prefix+i), Value: bm[i]})
+	}
+	return ops
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func escapePointer(s string) string {
+	return strings.Replace(strings.Replace(s, "~", "~0", -1), "/", "~1", -1)
+}
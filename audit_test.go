@@ -0,0 +1,168 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+	apiv3 "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+)
+
+func decodeJSON(s string) interface{} {
+	var out interface{}
+	err := json.Unmarshal([]byte(s), &out)
+	Expect(err).To(BeNil())
+	return out
+}
+
+func TestDiffJSON(t *testing.T) {
+	testCases := []struct {
+		Title    string
+		Before   string
+		After    string
+		Expected []diffOp
+	}{
+		{
+			Title:    "Equal",
+			Before:   `{"a": [1, 2], "b": "c"}`,
+			After:    `{"a": [1, 2], "b": "c"}`,
+			Expected: nil,
+		},
+		{
+			Title:    "Prepend",
+			Before:   `{"filters": [{"name": "cors"}]}`,
+			After:    `{"filters": [{"name": "authz"}, {"name": "cors"}]}`,
+			Expected: []diffOp{{Op: "add", Path: "/filters/0", Value: map[string]interface{}{"name": "authz"}}},
+		},
+		{
+			Title:  "Keys",
+			Before: `{"a": 1, "b/c": 2}`,
+			After:  `{"a": 3, "d": 4}`,
+			Expected: []diffOp{
+				{Op: "replace", Path: "/a", Value: float64(3)},
+				{Op: "remove", Path: "/b~1c"},
+				{Op: "add", Path: "/d", Value: float64(4)},
+			},
+		},
+		{
+			Title:    "Shrink",
+			Before:   `[1, 2, 3, 4]`,
+			After:    `[1, 4]`,
+			Expected: []diffOp{{Op: "remove", Path: "/2"}, {Op: "remove", Path: "/1"}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Title, func(t *testing.T) {
+			RegisterTestingT(t)
+			Expect(diffJSON("", decodeJSON(tc.Before), decodeJSON(tc.After))).To(Equal(tc.Expected))
+		})
+	}
+}
+
+func TestListenersAudit(t *testing.T) {
+	RegisterTestingT(t)
+
+	var buf bytes.Buffer
+	auditLog = newAuditor(&buf)
+	defer func() { auditLog = nil }()
+
+	ldsReq := ldsResponse{Listeners: []*v1.Listener{
+		{Name: "tcp_0.0.0.0_80"},
+		{Name: "tcp_" + NODE_IP + "_43", Filters: []*v1.NetworkFilter{{Name: v1.TCPProxyFilter}}},
+	}}
+	ldsBytes, err := json.Marshal(ldsReq)
+	Expect(err).To(BeNil())
+	req := newLDSRequest("sidecar", bytes.NewReader(ldsBytes))
+	recorder := httptest.NewRecorder()
+	listeners(req, restful.NewResponse(recorder))
+
+	var rec auditRecord
+	err = json.Unmarshal(buf.Bytes(), &rec)
+	Expect(err).To(BeNil())
+	Expect(rec.Hook).To(Equal("listeners"))
+	Expect(rec.Changes).To(HaveLen(1))
+	Expect(rec.Changes[0].Name).To(Equal("tcp_" + NODE_IP + "_43"))
+	Expect(rec.Changes[0].Diff).To(HaveLen(1))
+	Expect(rec.Changes[0].Diff[0].Op).To(Equal("add"))
+	Expect(rec.Changes[0].Diff[0].Path).To(Equal("/filters/0"))
+}
+
+func TestListenersDryRunNotAudited(t *testing.T) {
+	RegisterTestingT(t)
+
+	var buf bytes.Buffer
+	auditLog = newAuditor(&buf)
+	defer func() { auditLog = nil }()
+
+	ldsBytes, err := json.Marshal(ldsResponse{Listeners: []*v1.Listener{
+		{Name: "tcp_" + NODE_IP + "_43", Filters: []*v1.NetworkFilter{{Name: v1.TCPProxyFilter}}},
+	}})
+	Expect(err).To(BeNil())
+	req := newLDSRequest("sidecar", bytes.NewReader(ldsBytes))
+	req.Request.Header.Set(DryRunHeader, "true")
+	recorder := httptest.NewRecorder()
+	listeners(req, restful.NewResponse(recorder))
+
+	Expect(recorder.Header().Get(DryRunChangesHeader)).To(Equal("listener/tcp_" + NODE_IP + "_43"))
+	Expect(buf.Len()).To(BeZero())
+}
+
+func TestClustersAudit(t *testing.T) {
+	RegisterTestingT(t)
+
+	var buf bytes.Buffer
+	auditLog = newAuditor(&buf)
+	defer func() { auditLog, dikastes = nil, nil }()
+	dikastes = staticResolver("tcp://10.96.0.20:9000")
+
+	body := `{"clusters": [{"name": "in.80", "connect_timeout_ms": 1000, "type": "static", "lb_type": "round_robin"}]}`
+	clusters(newCDSRequest("sidecar", strings.NewReader(body)), restful.NewResponse(httptest.NewRecorder()))
+
+	var rec auditRecord
+	Expect(json.Unmarshal(buf.Bytes(), &rec)).To(Succeed())
+	Expect(rec.Hook).To(Equal("clusters"))
+	Expect(rec.Changes).To(HaveLen(1))
+	Expect(rec.Changes[0].Kind).To(Equal("cluster"))
+	Expect(rec.Changes[0].Name).To(Equal(AuthZClusterName))
+	Expect(rec.Changes[0].Diff).To(HaveLen(1))
+	Expect(rec.Changes[0].Diff[0].Op).To(Equal("add"))
+}
+
+func TestEndpointsAudit(t *testing.T) {
+	RegisterTestingT(t)
+
+	var buf bytes.Buffer
+	auditLog = newAuditor(&buf)
+	defer func() { auditLog, networkSets = nil, nil }()
+	networkSets, _ = newNetworkSetIndex("", "role == 'blocked'")
+	networkSets.replace([]apiv3.GlobalNetworkSet{testNetworkSet("blocked", "blocked", "10.0.5.0/24")})
+
+	body := `{"hosts": [{"ip_address": "10.0.0.1", "port": 80}, {"ip_address": "10.0.5.1", "port": 80}]}`
+	endpoints(newEDSRequest(strings.NewReader(body)), restful.NewResponse(httptest.NewRecorder()))
+
+	var rec auditRecord
+	Expect(json.Unmarshal(buf.Bytes(), &rec)).To(Succeed())
+	Expect(rec.Hook).To(Equal("endpoints"))
+	Expect(rec.Changes).To(Equal([]resourceChange{
+		{Kind: "endpoint", Name: "10.0.5.1", Diff: []diffOp{{Op: "remove", Path: ""}}},
+	}))
+}
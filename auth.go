@@ -0,0 +1,159 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const tokenReloadInterval = 30 * time.Second
+
+// tokenSecretKey is the key of the --auth-token-secret Secret holding the tokens.
+const tokenSecretKey = "token"
+
+// hookAuth checks the bearer tokens of hook callers.  It is nil unless --auth-token-file or --auth-token-secret is
+// set.
+var hookAuth *tokenAuth
+
+// tokenAuth holds the bearer tokens hook callers may present.  Each non-empty line of the source is a token, so that
+// a new token can be rolled out to the webhook before Pilot and the old one removed after.
+type tokenAuth struct {
+	mu     sync.RWMutex
+	tokens [][]byte
+	// source returns the current tokens.
+	source func() ([]byte, error)
+	name   string
+}
+
+func newTokenAuth(name string, source func() ([]byte, error)) *tokenAuth {
+	return &tokenAuth{name: name, source: source}
+}
+
+// fileSource reads a file, e.g. a mounted Secret.
+func fileSource(file string) func() ([]byte, error) {
+	return func() ([]byte, error) { return ioutil.ReadFile(file) }
+}
+
+// secretSource reads a key of a Secret.
+func secretSource(secrets typedcorev1.SecretInterface, name, key string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		s, err := secrets.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		b, ok := s.Data[key]
+		if !ok {
+			return nil, fmt.Errorf("secret has no %q key", key)
+		}
+		return b, nil
+	}
+}
+
+func parseTokens(b []byte) ([][]byte, error) {
+	var tokens [][]byte
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		if t := bytes.TrimSpace(line); len(t) > 0 {
+			tokens = append(tokens, t)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("no tokens")
+	}
+	return tokens, nil
+}
+
+// load replaces the tokens with those of the source.  The old tokens are kept if the source cannot be read or holds
+// none, so that a bad update does not lock Pilot out.
+func (a *tokenAuth) load() error {
+	b, err := a.source()
+	if err != nil {
+		return err
+	}
+	tokens, err := parseTokens(b)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.tokens) > 0 && !equalTokens(a.tokens, tokens) {
+		log.WithFields(log.Fields{"source": a.name, "tokens": len(tokens)}).Info("Reloaded hook auth tokens.")
+	}
+	a.tokens = tokens
+	return nil
+}
+
+func equalTokens(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// run polls the source for new tokens.  It never returns.
+func (a *tokenAuth) run() {
+	for {
+		time.Sleep(tokenReloadInterval)
+		if err := a.load(); err != nil {
+			log.WithFields(log.Fields{"source": a.name, "err": err}).Warn("Unable to reload hook auth tokens.")
+		}
+	}
+}
+
+// valid reports whether an Authorization header carries one of the tokens.  Every token is compared, in constant
+// time, so that the time taken does not reveal how much of a token was guessed.
+func (a *tokenAuth) valid(header string) bool {
+	const prefix = "Bearer "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return false
+	}
+	presented := []byte(strings.TrimSpace(header[len(prefix):]))
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	ok := 0
+	for _, t := range a.tokens {
+		ok |= subtle.ConstantTimeCompare(presented, t)
+	}
+	return ok == 1
+}
+
+// tokenAuthenticated rejects hook requests without a valid bearer token with a 401, before they are read.
+func tokenAuthenticated(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	if hookAuth == nil || hookAuth.valid(req.Request.Header.Get("Authorization")) {
+		chain.ProcessFilter(req, resp)
+		return
+	}
+	reportError(hookName(req.Request.URL.Path), ErrorClassAuth, log.Fields{"path": req.Request.URL.Path},
+		"Rejected hook request without a valid bearer token.")
+	resp.AddHeader("WWW-Authenticate", "Bearer")
+	resp.WriteErrorString(http.StatusUnauthorized, "invalid or missing bearer token")
+}
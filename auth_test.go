@@ -0,0 +1,118 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseTokens(t *testing.T) {
+	RegisterTestingT(t)
+
+	tokens, err := parseTokens([]byte("old\n\n  new  \n"))
+	Expect(err).To(BeNil())
+	Expect(tokens).To(Equal([][]byte{[]byte("old"), []byte("new")}))
+
+	_, err = parseTokens([]byte("\n \n"))
+	Expect(err).NotTo(BeNil())
+}
+
+func TestTokenAuthValid(t *testing.T) {
+	RegisterTestingT(t)
+
+	a := newTokenAuth("test", func() ([]byte, error) { return []byte("s3cret\nn3xt\n"), nil })
+	Expect(a.load()).To(Succeed())
+
+	Expect(a.valid("Bearer s3cret")).To(BeTrue())
+	Expect(a.valid("bearer n3xt")).To(BeTrue())
+	Expect(a.valid("Bearer s3cre")).To(BeFalse())
+	Expect(a.valid("Bearer s3crets")).To(BeFalse())
+	Expect(a.valid("Basic s3cret")).To(BeFalse())
+	Expect(a.valid("s3cret")).To(BeFalse())
+	Expect(a.valid("")).To(BeFalse())
+}
+
+func TestTokenAuthReload(t *testing.T) {
+	RegisterTestingT(t)
+
+	dir, err := ioutil.TempDir("", "auth")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "tokens")
+	Expect(ioutil.WriteFile(file, []byte("first\n"), 0600)).To(Succeed())
+
+	a := newTokenAuth(file, fileSource(file))
+	Expect(a.load()).To(Succeed())
+	Expect(a.valid("Bearer first")).To(BeTrue())
+
+	Expect(ioutil.WriteFile(file, []byte("second\n"), 0600)).To(Succeed())
+	Expect(a.load()).To(Succeed())
+	Expect(a.valid("Bearer first")).To(BeFalse())
+	Expect(a.valid("Bearer second")).To(BeTrue())
+
+	// A bad update keeps the old tokens.
+	Expect(ioutil.WriteFile(file, nil, 0600)).To(Succeed())
+	Expect(a.load()).NotTo(Succeed())
+	Expect(a.valid("Bearer second")).To(BeTrue())
+	Expect(os.Remove(file)).To(Succeed())
+	Expect(a.load()).NotTo(Succeed())
+	Expect(a.valid("Bearer second")).To(BeTrue())
+}
+
+// authRequest sends an LDS request through the hooks with the given Authorization header, if any.
+func authRequest(header string) *httptest.ResponseRecorder {
+	req := hookRequest("listeners", "sidecar", strings.NewReader(ldsWithUnknownFields))
+	if header != "" {
+		req.Header.Set("Authorization", header)
+	}
+	return serveHook(newHooks(), req)
+}
+
+func TestTokenAuthenticated(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(authRequest("").Code).To(Equal(http.StatusOK), "no auth unless enabled")
+
+	hookAuth = newTokenAuth("test", func() ([]byte, error) { return []byte("s3cret"), nil })
+	defer func() { hookAuth = nil }()
+	Expect(hookAuth.load()).To(Succeed())
+
+	before := testutil.ToFloat64(hookErrors.WithLabelValues("listeners", string(ErrorClassAuth)))
+	rec := authRequest("")
+	Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+	Expect(rec.Header().Get("WWW-Authenticate")).To(Equal("Bearer"))
+	Expect(authRequest("Bearer wrong").Code).To(Equal(http.StatusUnauthorized))
+	Expect(testutil.ToFloat64(hookErrors.WithLabelValues("listeners", string(ErrorClassAuth)))).To(Equal(before + 2))
+
+	Expect(authRequest("Bearer s3cret").Code).To(Equal(http.StatusOK))
+}
+
+func TestTokenAuthSourceError(t *testing.T) {
+	RegisterTestingT(t)
+
+	a := newTokenAuth("test", func() ([]byte, error) { return nil, errors.New("unavailable") })
+	Expect(a.load()).NotTo(Succeed())
+	Expect(a.valid("Bearer anything")).To(BeFalse())
+}
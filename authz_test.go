@@ -0,0 +1,135 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+// ldsWithUnknownFields has fields the v1 structs do not model at every level the webhook splices into.
+const ldsWithUnknownFields = `{"listeners":[` +
+	`{"name":"http_0.0.0.0_80","address":"tcp://0.0.0.0:80","custom":{"keep":true}},` +
+	`{"name":"http_` + NODE_IP + `_43","address":"tcp://` + NODE_IP + `:43","custom":1,"filters":[` +
+	`{"type":"read","name":"http_connection_manager","custom":2,"config":{"custom":3,"filters":[` +
+	`{"type":"decoder","name":"cors","config":{}}]}}]},` +
+	`{"name":"tcp_` + NODE_IP + `_3306","address":"tcp://` + NODE_IP + `:3306","custom":4,"filters":[` +
+	`{"type":"read","name":"tcp_proxy","config":{"stat_prefix":"tcp"}}]}` +
+	`],"custom":5}`
+
+func TestListenersPreserveUnknownFields(t *testing.T) {
+	RegisterTestingT(t)
+
+	req := newLDSRequest("sidecar", strings.NewReader(ldsWithUnknownFields))
+	recorder := httptest.NewRecorder()
+	listeners(req, restful.NewResponse(recorder))
+
+	var out struct {
+		Listeners []json.RawMessage `json:"listeners"`
+		Custom    int               `json:"custom"`
+	}
+	Expect(json.Unmarshal(recorder.Body.Bytes(), &out)).To(BeNil())
+	Expect(out.Custom).To(Equal(5))
+	Expect(out.Listeners).To(HaveLen(3))
+	// The outbound listener is passed through byte for byte.
+	Expect(string(out.Listeners[0])).To(Equal(`{"name":"http_0.0.0.0_80","address":"tcp://0.0.0.0:80","custom":{"keep":true}}`))
+
+	var hcm struct {
+		Custom  int `json:"custom"`
+		Filters []struct {
+			Custom int `json:"custom"`
+			Config struct {
+				Custom  int `json:"custom"`
+				Filters []struct {
+					Name string `json:"name"`
+				} `json:"filters"`
+			} `json:"config"`
+		} `json:"filters"`
+	}
+	Expect(json.Unmarshal(out.Listeners[1], &hcm)).To(BeNil())
+	Expect(hcm.Custom).To(Equal(1))
+	Expect(hcm.Filters[0].Custom).To(Equal(2))
+	Expect(hcm.Filters[0].Config.Custom).To(Equal(3))
+	Expect(hcm.Filters[0].Config.Filters).To(HaveLen(2))
+	Expect(hcm.Filters[0].Config.Filters[0].Name).To(Equal(AuthZFilterName))
+	Expect(hcm.Filters[0].Config.Filters[1].Name).To(Equal(v1.CORSFilter))
+
+	var tcp struct {
+		Custom  int `json:"custom"`
+		Filters []struct {
+			Name string `json:"name"`
+		} `json:"filters"`
+	}
+	Expect(json.Unmarshal(out.Listeners[2], &tcp)).To(BeNil())
+	Expect(tcp.Custom).To(Equal(4))
+	Expect(tcp.Filters).To(HaveLen(2))
+	Expect(tcp.Filters[0].Name).To(Equal(AuthZFilterName))
+	Expect(tcp.Filters[1].Name).To(Equal(v1.TCPProxyFilter))
+}
+
+func TestListenersBadListener(t *testing.T) {
+	RegisterTestingT(t)
+
+	req := newLDSRequest("sidecar", strings.NewReader(`{"listeners":[{"name":1}]}`))
+	recorder := httptest.NewRecorder()
+	listeners(req, restful.NewResponse(recorder))
+	Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+}
+
+func TestListenersParallelDeterministic(t *testing.T) {
+	RegisterTestingT(t)
+
+	body := benchLDS(mutator.ParallelListenersMin)
+	serve := func() string {
+		req := newLDSRequest("sidecar", bytes.NewReader(body))
+		recorder := httptest.NewRecorder()
+		listeners(req, restful.NewResponse(recorder))
+		Expect(statsFor(req).Injected).To(Equal(mutator.ParallelListenersMin))
+		return recorder.Body.String()
+	}
+	sequential := serve()
+
+	defer func() { hookAuthz.Parallelism = 1 }()
+	hookAuthz.Parallelism = 8
+	for i := 0; i < 5; i++ {
+		Expect(serve()).To(Equal(sequential))
+	}
+}
+
+func TestListenersParallelDecodeError(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { hookAuthz.Parallelism = 1 }()
+	hookAuthz.Parallelism = 8
+	items := make([]json.RawMessage, mutator.ParallelListenersMin)
+	for i := range items {
+		items[i] = json.RawMessage(`{"name":"tcp_10.0.0.1_80"}`)
+	}
+	items[40] = json.RawMessage(`{"name":1}`)
+	req := &mutator.Request{Node: config.ParseNode(serviceNode("sidecar", NODE_IP)), Profile: profileForNode(NODE_IP)}
+	_, _, err := hookAuthz.Listeners(req, []byte(`{"listeners":`+string(mutator.RawArray(items))+`}`))
+	Expect(err).NotTo(BeNil())
+}
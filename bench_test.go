@@ -0,0 +1,312 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+)
+
+// benchFixtures holds the payloads the benchmarks push, laid out as --capture-dir writes them, so that captures from a
+// real mesh can be copied in alongside the generated ones.  Request bodies may be gzipped.
+const benchFixtures = "testdata/bench"
+
+var updateFixtures = flag.Bool("update-fixtures", false, "regenerate the benchmark fixtures in "+benchFixtures)
+
+// benchFixture is one captured request.
+type benchFixture struct {
+	name string
+	hook string
+	path string
+	body []byte
+}
+
+func loadBenchFixtures(t testing.TB) []benchFixture {
+	captures, err := loadCaptures(benchFixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixtures []benchFixture
+	for _, c := range captures {
+		fixtures = append(fixtures, benchFixture{
+			name: c.name, hook: hookName(c.meta.Path), path: c.meta.Path, body: c.request,
+		})
+	}
+	return fixtures
+}
+
+// benchModes are the ways the webhook can be configured to mutate a hook's payloads.
+func benchModes(hook string) map[string]func() {
+	modes := map[string]func(){"buffered": func() {}}
+	if hook == "listeners" || hook == "clusters" {
+		modes["streamed"] = func() { streamArrays = true }
+	}
+	if hook == "listeners" {
+		modes["parallel"] = func() { hookAuthz.Parallelism = 4 }
+	}
+	for name, c := range jsonCodecs {
+		if name != "std" {
+			c := c
+			modes["codec="+name] = func() { setCodec(c) }
+		}
+	}
+	return modes
+}
+
+func resetBenchMode() {
+	streamArrays, hookAuthz.Parallelism = false, 1
+	setCodec(stdCodec{})
+}
+
+// serveFixture pushes a fixture through the whole webhook, filters included.
+func serveFixture(container *restful.Container, f benchFixture) *httptest.ResponseRecorder {
+	return serveHook(container, httptest.NewRequest("POST", f.path, bytes.NewReader(f.body)))
+}
+
+func TestBenchFixtures(t *testing.T) {
+	RegisterTestingT(t)
+
+	if *updateFixtures {
+		writeBenchFixtures(t)
+	}
+	defer func() { dikastes = nil }()
+	dikastes = staticResolver("tcp://10.96.0.20:9000")
+	container := newHooks()
+
+	fixtures := loadBenchFixtures(t)
+	Expect(fixtures).NotTo(BeEmpty())
+	for _, f := range fixtures {
+		var want string
+		for mode, setup := range benchModes(f.hook) {
+			setup()
+			rec := serveFixture(container, f)
+			resetBenchMode()
+			Expect(rec.Code).To(Equal(http.StatusOK), f.name+"/"+mode)
+			if want == "" {
+				want = rec.Body.String()
+				if f.hook == "listeners" || f.hook == "clusters" {
+					Expect(want).NotTo(MatchJSON(string(f.body)), f.name)
+				}
+				continue
+			}
+			// Every mode makes the same changes.
+			Expect(rec.Body.String()).To(MatchJSON(want), f.name+"/"+mode)
+		}
+	}
+}
+
+// BenchmarkFixtures pushes each fixture through the webhook in each mode that applies to its hook, e.g.
+//
+//	go test -run xxx -bench Fixtures/mesh1000-listeners -benchmem
+func BenchmarkFixtures(b *testing.B) {
+	defer func() { dikastes = nil }()
+	dikastes = staticResolver("tcp://10.96.0.20:9000")
+	container := newHooks()
+
+	for _, f := range loadBenchFixtures(b) {
+		for mode, setup := range benchModes(f.hook) {
+			b.Run(f.name+"/"+mode, func(b *testing.B) {
+				setup()
+				defer resetBenchMode()
+				b.SetBytes(int64(len(f.body)))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if rec := serveFixture(container, f); rec.Code != http.StatusOK {
+						b.Fatalf("status %d", rec.Code)
+					}
+				}
+			})
+		}
+	}
+}
+
+// writeBenchFixtures generates LDS, CDS and RDS payloads shaped like those Pilot sends a sidecar in a mesh of about a
+// thousand services.
+func writeBenchFixtures(t *testing.T) {
+	const services = 1000
+	sn := serviceNode("sidecar", NODE_IP)
+	for _, f := range []struct {
+		path string
+		body interface{}
+	}{
+		{fmt.Sprintf("/v1/listeners/%s/%s", SERVICE_CLUSTER, sn), fixtureLDS(services)},
+		{fmt.Sprintf("/v1/clusters/%s/%s", SERVICE_CLUSTER, sn), fixtureCDS(services)},
+		{fmt.Sprintf("/v1/routes/%s/%s/%s", "80", SERVICE_CLUSTER, sn), fixtureRDS(services)},
+	} {
+		body, err := json.Marshal(f.body)
+		Expect(err).To(BeNil())
+		var gz bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&gz, gzip.BestCompression)
+		zw.Write(body)
+		zw.Close()
+		meta, _ := json.Marshal(captureMeta{Method: "POST", Path: f.path, Status: http.StatusOK})
+		base := filepath.Join(benchFixtures, fmt.Sprintf("mesh%d-%s", services, hookName(f.path)))
+		Expect(os.MkdirAll(benchFixtures, 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(base+captureRequestSuffix+".gz", gz.Bytes(), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(base+captureMetaSuffix, meta, 0644)).To(Succeed())
+	}
+}
+
+type jsonObject map[string]interface{}
+
+func fixtureService(i int) (host string, port int) {
+	return fmt.Sprintf("svc-%d.ns-%d.svc.cluster.local", i, i%20), 8000 + i%50
+}
+
+func fixtureMixer(direction string) jsonObject {
+	return jsonObject{"type": "decoder", "name": "mixer", "config": jsonObject{
+		"mixer_attributes": jsonObject{
+			"destination.ip":  NODE_IP,
+			"destination.uid": "kubernetes://productpage-v1-5d7f8b7c5b-abcde.default",
+		},
+		"forward_attributes": jsonObject{"source.ip": NODE_IP},
+		"quota_name":         "RequestCount",
+		"v2": jsonObject{
+			"defaultDestinationService": "productpage.default.svc.cluster.local",
+			"forwardAttributes":         jsonObject{"attributes": jsonObject{"source.uid": jsonObject{"stringValue": "kubernetes://productpage"}}},
+			"mixerAttributes":           jsonObject{"attributes": jsonObject{"context.reporter.kind": jsonObject{"stringValue": direction}}},
+		},
+	}}
+}
+
+func fixtureHCM(prefix, route, direction string) jsonObject {
+	return jsonObject{"type": "read", "name": "http_connection_manager", "config": jsonObject{
+		"codec_type":          "auto",
+		"stat_prefix":         prefix,
+		"generate_request_id": true,
+		"use_remote_address":  false,
+		"tracing":             jsonObject{"operation_name": "ingress"},
+		"access_log":          []jsonObject{{"path": "/dev/stdout"}},
+		"rds":                 jsonObject{"cluster": "rds", "route_config_name": route, "refresh_delay_ms": 1000},
+		"filters": []jsonObject{
+			fixtureMixer(direction),
+			{"type": "decoder", "name": "cors", "config": jsonObject{}},
+			{"type": "decoder", "name": "fault", "config": jsonObject{}},
+			{"type": "decoder", "name": "router", "config": jsonObject{}},
+		},
+	}}
+}
+
+// fixtureLDS has the virtual listener, an inbound listener per port of the sidecar's own service and an outbound one
+// per service port in the mesh.
+func fixtureLDS(services int) jsonObject {
+	listeners := []jsonObject{{
+		"name": "virtual", "address": "tcp://0.0.0.0:15001", "use_original_dst": true,
+		"filters": []jsonObject{{"type": "read", "name": "tcp_proxy", "config": jsonObject{
+			"stat_prefix": "tcp", "route_config": jsonObject{"routes": []jsonObject{{"cluster": "orig-dst-cluster-tcp"}}},
+		}}},
+	}}
+	for port := 9080; port < 9090; port++ {
+		name := fmt.Sprintf("http_%s_%d", NODE_IP, port)
+		listeners = append(listeners, jsonObject{
+			"name": name, "address": fmt.Sprintf("tcp://%s:%d", NODE_IP, port), "bind_to_port": false,
+			"filters": []jsonObject{fixtureHCM(name, fmt.Sprint(port), "inbound")},
+		})
+	}
+	listeners = append(listeners, jsonObject{
+		"name": fmt.Sprintf("tcp_%s_3306", NODE_IP), "address": fmt.Sprintf("tcp://%s:3306", NODE_IP), "bind_to_port": false,
+		"filters": []jsonObject{{"type": "read", "name": "tcp_proxy", "config": jsonObject{
+			"stat_prefix": "tcp", "route_config": jsonObject{"routes": []jsonObject{{"cluster": "in.3306"}}},
+		}}},
+	})
+	for i := 0; i < services; i++ {
+		host, port := fixtureService(i)
+		addr := fmt.Sprintf("10.%d.%d.%d", 96+i/65536, (i/256)%256, i%256)
+		if i%5 == 4 {
+			listeners = append(listeners, jsonObject{
+				"name": fmt.Sprintf("tcp_%s_%d", addr, port), "address": fmt.Sprintf("tcp://%s:%d", addr, port),
+				"bind_to_port": false,
+				"filters": []jsonObject{{"type": "read", "name": "tcp_proxy", "config": jsonObject{
+					"stat_prefix": "tcp", "route_config": jsonObject{"routes": []jsonObject{{
+						"cluster":             fmt.Sprintf("out.%s|%d", host, port),
+						"destination_ip_list": []string{addr + "/32"},
+					}}},
+				}}},
+			})
+			continue
+		}
+		name := fmt.Sprintf("http_%s_%d", addr, port)
+		listeners = append(listeners, jsonObject{
+			"name": name, "address": fmt.Sprintf("tcp://%s:%d", addr, port), "bind_to_port": false,
+			"filters": []jsonObject{fixtureHCM(name, fmt.Sprint(port), "outbound")},
+		})
+	}
+	return jsonObject{"listeners": listeners}
+}
+
+// fixtureCDS has an inbound cluster per port of the sidecar's own service and an outbound one per service port in the
+// mesh, plus Pilot's own.
+func fixtureCDS(services int) jsonObject {
+	clusters := []jsonObject{
+		{"name": "rds", "connect_timeout_ms": 1000, "type": "strict_dns", "lb_type": "round_robin",
+			"hosts": []jsonObject{{"url": "tcp://istio-pilot.istio-system:15003"}}},
+		{"name": "mixer_server", "connect_timeout_ms": 1000, "type": "strict_dns", "lb_type": "round_robin",
+			"features": "http2", "circuit_breakers": jsonObject{"default": jsonObject{"max_pending_requests": 10000}},
+			"hosts": []jsonObject{{"url": "tcp://istio-policy.istio-system:15004"}}},
+	}
+	for port := 9080; port < 9090; port++ {
+		clusters = append(clusters, jsonObject{
+			"name": fmt.Sprintf("in.%d", port), "connect_timeout_ms": 1000, "type": "static", "lb_type": "round_robin",
+			"hosts": []jsonObject{{"url": fmt.Sprintf("tcp://127.0.0.1:%d", port)}},
+		})
+	}
+	for i := 0; i < services; i++ {
+		host, port := fixtureService(i)
+		c := jsonObject{
+			"name":               fmt.Sprintf("out.%s|%d", host, port),
+			"service_name":       fmt.Sprintf("%s|%d", host, port),
+			"connect_timeout_ms": 1000, "type": "sds", "lb_type": "round_robin",
+			"outlier_detection": jsonObject{"consecutive_5xx": 5, "interval_ms": 10000},
+		}
+		if i%3 == 0 {
+			c["features"] = "http2"
+		}
+		clusters = append(clusters, c)
+	}
+	return jsonObject{"clusters": clusters}
+}
+
+// fixtureRDS has a virtual host per service in the mesh.
+func fixtureRDS(services int) jsonObject {
+	var hosts []jsonObject
+	for i := 0; i < services; i++ {
+		host, port := fixtureService(i)
+		short := strings.SplitN(host, ".", 2)[0]
+		hosts = append(hosts, jsonObject{
+			"name":    fmt.Sprintf("%s|http", host),
+			"domains": []string{short, fmt.Sprintf("%s:%d", short, port), host, fmt.Sprintf("%s:%d", host, port)},
+			"routes": []jsonObject{{
+				"prefix": "/", "cluster": fmt.Sprintf("out.%s|%d", host, port), "timeout_ms": 0,
+				"decorator":     jsonObject{"operation": host + ":" + fmt.Sprint(port) + "/*"},
+				"opaque_config": jsonObject{"mixer_control": "{\"forward_attributes\":{}}"},
+			}},
+		})
+	}
+	return jsonObject{"validate_clusters": true, "virtual_hosts": hosts}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// maxPooledBuffer is the capacity above which buffers are dropped rather than pooled, so that one very large push does
+// not pin its memory for the life of the process.
+const maxPooledBuffer = 16 << 20
+
+// copyBufferSize is the size of the buffers passthrough bodies are streamed through.
+const copyBufferSize = 32 << 10
+
+// copyBuffers are the buffers passthrough bodies are streamed through.
+var copyBuffers = sync.Pool{New: func() interface{} {
+	b := make([]byte, copyBufferSize)
+	return &b
+}}
+
+// bufferPool holds the buffers hook bodies are read into and responses are encoded into.  When many sidecars
+// reconnect at once, e.g. after a Pilot restart, this saves growing a fresh buffer for every request.
+var bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// putBuffer returns a buffer to the pool.  Nothing may refer to its bytes afterwards.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// bodySizeHint is how much is allocated up front for request bodies of unknown size, set by --body-size-hint.
+var bodySizeHint int
+
+// readBody reads a request body, up to maxPayloadBytes, into a pooled buffer, which the caller must release with
+// putBuffer once it has finished with the bytes, including anything decoded from them as json.RawMessage.  size is the
+// body's Content-Length, or -1 if it is not known.
+func readBody(r io.Reader, size int64) (*bytes.Buffer, error) {
+	buf := getBuffer()
+	buf.Grow(preallocSize(size))
+	if _, err := buf.ReadFrom(limitBody(r)); err != nil {
+		putBuffer(buf)
+		return nil, err
+	}
+	return buf, nil
+}
+
+// preallocSize is how much to allocate for a body of the given Content-Length before reading it.  The length is only
+// trusted as far as the body could be pooled and is allowed, so that a client cannot make the webhook allocate memory
+// by claiming a large body and sending nothing.  Reading grows the buffer past it as needed.
+func preallocSize(size int64) int {
+	if size < 0 {
+		return bodySizeHint
+	}
+	if size > maxPooledBuffer {
+		size = maxPooledBuffer
+	}
+	if maxPayloadBytes > 0 && size > int64(maxPayloadBytes) {
+		size = int64(maxPayloadBytes)
+	}
+	// ReadFrom grows the buffer unless it has room for MinRead more bytes.
+	return int(size) + bytes.MinRead
+}
+
+// streamBody copies a request body to a response through a pooled buffer, without allocating.  It returns the bytes
+// written and either the error reading or the error writing that stopped it.
+func streamBody(w io.Writer, r io.Reader) (n int64, readErr, writeErr error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	for {
+		nr, err := r.Read(*buf)
+		if nr > 0 {
+			nw, err := w.Write((*buf)[:nr])
+			n += int64(nw)
+			if err != nil {
+				return n, nil, err
+			}
+		}
+		if err == io.EOF {
+			return n, nil, nil
+		}
+		if err != nil {
+			return n, err, nil
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestReadBody(t *testing.T) {
+	RegisterTestingT(t)
+
+	buf, err := readBody(strings.NewReader(`{"listeners":[]}`), -1)
+	Expect(err).To(BeNil())
+	Expect(buf.String()).To(Equal(`{"listeners":[]}`))
+	putBuffer(buf)
+	Expect(buf.Len()).To(Equal(0))
+}
+
+func TestPreallocSize(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func(hint, max int) { bodySizeHint, maxPayloadBytes = hint, max }(bodySizeHint, maxPayloadBytes)
+	bodySizeHint = 0
+	Expect(preallocSize(-1)).To(Equal(0))
+	bodySizeHint = 1 << 20
+	Expect(preallocSize(-1)).To(Equal(1 << 20))
+	Expect(preallocSize(100)).To(Equal(100 + bytes.MinRead))
+	// Claimed lengths are only trusted so far.
+	Expect(preallocSize(1 << 40)).To(Equal(maxPooledBuffer + bytes.MinRead))
+	maxPayloadBytes = 1000
+	Expect(preallocSize(1 << 40)).To(Equal(1000 + bytes.MinRead))
+}
+
+// onlyReader hides strings.Reader's WriterTo, as request bodies do not have one.
+type onlyReader struct {
+	r *strings.Reader
+}
+
+func (o onlyReader) Read(p []byte) (int, error) {
+	return o.r.Read(p)
+}
+
+func TestStreamBodyAllocs(t *testing.T) {
+	RegisterTestingT(t)
+
+	body := strings.Repeat("x", 3*copyBufferSize+1)
+	r := strings.NewReader(body)
+	var out bytes.Buffer
+	out.Grow(len(body))
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Reset(body)
+		out.Reset()
+		streamBody(&out, onlyReader{r})
+	})
+	Expect(allocs).To(Equal(0.0))
+	Expect(out.String()).To(Equal(body))
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestStreamBodyErrors(t *testing.T) {
+	RegisterTestingT(t)
+
+	n, readErr, writeErr := streamBody(failingWriter{}, strings.NewReader("x"))
+	Expect(n).To(Equal(int64(0)))
+	Expect(readErr).To(BeNil())
+	Expect(writeErr.Error()).To(Equal("broken pipe"))
+
+	n, readErr, writeErr = streamBody(&bytes.Buffer{}, &failedReader{errors.New("reset")})
+	Expect(readErr.Error()).To(Equal("reset"))
+	Expect(writeErr).To(BeNil())
+}
+
+func BenchmarkPassthrough(b *testing.B) {
+	body := benchLDS(20)
+	container := newHooks()
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		serveHook(container, hookRequest("listeners", "router", bytes.NewReader(body)))
+	}
+}
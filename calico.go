@@ -0,0 +1,328 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/projectcalico/libcalico-go/lib/apiconfig"
+	apiv3 "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/libcalico-go/lib/clientv3"
+	"github.com/projectcalico/libcalico-go/lib/options"
+	"github.com/projectcalico/libcalico-go/lib/watch"
+	log "github.com/sirupsen/logrus"
+)
+
+const calicoResyncDelay = 5 * time.Second
+
+// calicoDisconnectGrace is how long a Calico watch may fail before /readyz does.  Short failures are routine, e.g. when
+// a watch's revision has been compacted, and the indexes keep serving their last state meanwhile.
+const calicoDisconnectGrace = 30 * time.Second
+
+var errEndpointsNotSynced = errors.New("workload endpoints not yet synced")
+
+// calicoEndpoints indexes the IPs of Calico workload endpoints.  It is nil unless --calico-endpoints-only is set.
+var calicoEndpoints *endpointIndex
+
+// newCalicoClient returns a Calico datastore client using the given client config file, or the environment if it is
+// empty.
+func newCalicoClient(config string) (clientv3.Interface, error) {
+	cfg, err := apiconfig.LoadClientConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return clientv3.New(*cfg)
+}
+
+// calicoClients holds the Calico datastore client shared by the features that need it.  It is created on first use,
+// like kubeClients.
+type calicoClients struct {
+	config string
+
+	client clientv3.Interface
+}
+
+// Client returns the shared client, exiting if it cannot be created.
+func (c *calicoClients) Client() clientv3.Interface {
+	if c.client == nil {
+		client, err := newCalicoClient(c.config)
+		if err != nil {
+			log.WithField("err", err).Fatal("Unable to create Calico client.")
+		}
+		c.client = client
+	}
+	return c.client
+}
+
+// calicoHealth tracks whether each Calico watch, or the Typha connection, can currently reach the datastore.
+var calicoHealth = newDatastoreHealth()
+
+type datastoreHealth struct {
+	mu   sync.Mutex
+	down map[string]time.Time
+	now  func() time.Time
+}
+
+func newDatastoreHealth() *datastoreHealth {
+	return &datastoreHealth{down: make(map[string]time.Time), now: time.Now}
+}
+
+func (h *datastoreHealth) connected(resource string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.down, resource)
+}
+
+// disconnected records a failure, keeping the time of the first one since resource was last connected.
+func (h *datastoreHealth) disconnected(resource string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.down[resource]; !ok {
+		h.down[resource] = h.now()
+	}
+}
+
+// check is a readiness check that fails once any resource has been disconnected for longer than
+// calicoDisconnectGrace.
+func (h *datastoreHealth) check() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var failed []string
+	for resource, since := range h.down {
+		if d := h.now().Sub(since); d > calicoDisconnectGrace {
+			failed = append(failed, fmt.Sprintf("%s (%s)", resource, d.Round(time.Second)))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	sort.Strings(failed)
+	return fmt.Errorf("Calico datastore unreachable: %s", strings.Join(failed, ", "))
+}
+
+// runCalicoWatch keeps a local copy of a Calico resource up to date: list loads the current state and returns its
+// revision, then each event from a watch from that revision is passed to apply.  It starts over after any error and
+// never returns.
+func runCalicoWatch(
+	resource string,
+	list func(ctx context.Context) (string, error),
+	watchFrom func(ctx context.Context, opts options.ListOptions) (watch.Interface, error),
+	apply func(watch.Event) error,
+) {
+	for {
+		err := func() error {
+			ctx := context.Background()
+			revision, err := list(ctx)
+			if err != nil {
+				return err
+			}
+			calicoHealth.connected(resource)
+			log.WithField("resource", resource).Info("Synced Calico resources.")
+			w, err := watchFrom(ctx, options.ListOptions{ResourceVersion: revision})
+			if err != nil {
+				return err
+			}
+			defer w.Stop()
+			for e := range w.ResultChan() {
+				if err := apply(e); err != nil {
+					return err
+				}
+			}
+			return errors.New("watch closed")
+		}()
+		calicoHealth.disconnected(resource)
+		log.WithFields(log.Fields{"resource": resource, "err": err}).Warn("Calico watch failed; resyncing.")
+		time.Sleep(calicoResyncDelay)
+	}
+}
+
+// endpointIndex maps IPs to the workload endpoints that own them, kept up to date by watching the Calico datastore.
+type endpointIndex struct {
+	mu     sync.RWMutex
+	byIP   map[string]string
+	byKey  map[string][]string
+	labels map[string]map[string]string
+	synced bool
+}
+
+// endpointInfo is what the index knows about the workload endpoint with an IP.
+type endpointInfo struct {
+	Namespace string
+	Labels    map[string]string
+}
+
+func newEndpointIndex() *endpointIndex {
+	return &endpointIndex{
+		byIP:   make(map[string]string),
+		byKey:  make(map[string][]string),
+		labels: make(map[string]map[string]string),
+	}
+}
+
+func endpointKey(wep *apiv3.WorkloadEndpoint) string {
+	return wep.Namespace + "/" + wep.Name
+}
+
+// endpointIPs returns the addresses of a workload endpoint's IP networks.
+func endpointIPs(wep *apiv3.WorkloadEndpoint) []string {
+	var ips []string
+	for _, n := range wep.Spec.IPNetworks {
+		ip, _, err := net.ParseCIDR(n)
+		if err != nil {
+			ip = net.ParseIP(n)
+		}
+		if ip == nil {
+			log.WithFields(log.Fields{"endpoint": endpointKey(wep), "network": n}).Debug("Ignoring invalid IP network")
+			continue
+		}
+		ips = append(ips, ip.String())
+	}
+	return ips
+}
+
+// replace resets the index to the listed endpoints and marks it synced.
+func (x *endpointIndex) replace(weps []apiv3.WorkloadEndpoint) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.byIP = make(map[string]string)
+	x.byKey = make(map[string][]string)
+	x.labels = make(map[string]map[string]string)
+	for i := range weps {
+		x.set(&weps[i])
+	}
+	x.synced = true
+}
+
+func (x *endpointIndex) update(wep *apiv3.WorkloadEndpoint) {
+	x.updateKey(endpointKey(wep), endpointIPs(wep), wep.Labels)
+}
+
+func (x *endpointIndex) delete(wep *apiv3.WorkloadEndpoint) {
+	x.deleteKey(endpointKey(wep))
+}
+
+// updateKey adds or replaces an endpoint by key, which must start with its namespace and a "/".
+func (x *endpointIndex) updateKey(key string, ips []string, labels map[string]string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(key)
+	x.setKey(key, ips, labels)
+}
+
+func (x *endpointIndex) deleteKey(key string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(key)
+}
+
+func (x *endpointIndex) set(wep *apiv3.WorkloadEndpoint) {
+	x.setKey(endpointKey(wep), endpointIPs(wep), wep.Labels)
+}
+
+func (x *endpointIndex) setKey(key string, ips []string, labels map[string]string) {
+	x.byKey[key] = ips
+	x.labels[key] = labels
+	for _, ip := range ips {
+		x.byIP[ip] = key
+	}
+}
+
+// adopt replaces the index's contents with another's, which is discarded, and marks it synced.
+func (x *endpointIndex) adopt(o *endpointIndex) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.byIP, x.byKey, x.labels = o.byIP, o.byKey, o.labels
+	x.synced = true
+}
+
+func (x *endpointIndex) remove(key string) {
+	for _, ip := range x.byKey[key] {
+		// The IP may have been reused by a newer endpoint already.
+		if x.byIP[ip] == key {
+			delete(x.byIP, ip)
+		}
+	}
+	delete(x.byKey, key)
+	delete(x.labels, key)
+}
+
+// managed reports whether ip belongs to a Calico workload endpoint.
+func (x *endpointIndex) managed(ip string) bool {
+	_, ok := x.endpoint(ip)
+	return ok
+}
+
+// endpoint returns the namespace and labels of the workload endpoint with the given IP.
+func (x *endpointIndex) endpoint(ip string) (endpointInfo, bool) {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	key, ok := x.byIP[ip]
+	if !ok {
+		return endpointInfo{}, false
+	}
+	return endpointInfo{Namespace: key[:strings.Index(key, "/")], Labels: x.labels[key]}, true
+}
+
+// check is a readiness check that fails until the initial list has been loaded.
+func (x *endpointIndex) check() error {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if !x.synced {
+		return errEndpointsNotSynced
+	}
+	return nil
+}
+
+// run lists and then watches the workload endpoints.  It never returns.
+func (x *endpointIndex) run(client clientv3.Interface) {
+	runCalicoWatch("workload endpoints",
+		func(ctx context.Context) (string, error) {
+			list, err := client.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			if err != nil {
+				return "", err
+			}
+			x.replace(list.Items)
+			return list.ResourceVersion, nil
+		},
+		client.WorkloadEndpoints().Watch,
+		x.apply)
+}
+
+// apply updates the index from a watch event.
+func (x *endpointIndex) apply(e watch.Event) error {
+	switch e.Type {
+	case watch.Added, watch.Modified:
+		if wep, ok := e.Object.(*apiv3.WorkloadEndpoint); ok {
+			x.update(wep)
+		}
+	case watch.Deleted:
+		if wep, ok := e.Previous.(*apiv3.WorkloadEndpoint); ok {
+			x.delete(wep)
+		}
+	case watch.Error:
+		return e.Error
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+	apiv3 "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/libcalico-go/lib/watch"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testWEP(name string, ips ...string) *apiv3.WorkloadEndpoint {
+	return &apiv3.WorkloadEndpoint{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "testns"},
+		Spec:       apiv3.WorkloadEndpointSpec{IPNetworks: ips},
+	}
+}
+
+func TestEndpointIndex(t *testing.T) {
+	RegisterTestingT(t)
+
+	x := newEndpointIndex()
+	Expect(x.check()).To(Equal(errEndpointsNotSynced))
+	x.replace([]apiv3.WorkloadEndpoint{*testWEP("a", "10.0.0.1/32")})
+	Expect(x.check()).To(BeNil())
+	Expect(x.managed("10.0.0.1")).To(BeTrue())
+	Expect(x.managed("10.0.0.2")).To(BeFalse())
+
+	Expect(x.apply(watch.Event{Type: watch.Added, Object: testWEP("b", "10.0.0.2/32", "fd00::2/128")})).To(Succeed())
+	Expect(x.managed("10.0.0.2")).To(BeTrue())
+	Expect(x.managed("fd00:0::2")).To(BeTrue())
+
+	// An endpoint whose IP changes loses the old one.
+	Expect(x.apply(watch.Event{Type: watch.Modified, Object: testWEP("a", "10.0.0.3/32")})).To(Succeed())
+	Expect(x.managed("10.0.0.1")).To(BeFalse())
+	Expect(x.managed("10.0.0.3")).To(BeTrue())
+
+	Expect(x.apply(watch.Event{Type: watch.Deleted, Previous: testWEP("b", "10.0.0.2/32", "fd00::2/128")})).To(Succeed())
+	Expect(x.managed("10.0.0.2")).To(BeFalse())
+
+	err := errors.New("compacted")
+	Expect(x.apply(watch.Event{Type: watch.Error, Error: err})).To(Equal(err))
+}
+
+func TestEndpointIndexIPReuse(t *testing.T) {
+	RegisterTestingT(t)
+
+	x := newEndpointIndex()
+	x.update(testWEP("old", "10.0.0.1/32"))
+	x.update(testWEP("new", "10.0.0.1/32"))
+	// A late delete of the old endpoint must not remove the new one's IP.
+	x.delete(testWEP("old", "10.0.0.1/32"))
+	Expect(x.managed("10.0.0.1")).To(BeTrue())
+}
+
+func TestListenersNotCalico(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { calicoEndpoints = nil }()
+	calicoEndpoints = newEndpointIndex()
+	before := skipped(SkipNotCalico)
+
+	reqString := `{"listeners": [{"name": "tcp_3.4.5.6_76", "filters": []}]}`
+	req := newLDSRequest("sidecar", strings.NewReader(reqString))
+	recorder := httptest.NewRecorder()
+	listeners(req, restful.NewResponse(recorder))
+	Expect(recorder.Body.String()).To(Equal(reqString))
+	Expect(skipped(SkipNotCalico)).To(Equal(before + 1))
+}
+
+func TestDatastoreHealth(t *testing.T) {
+	RegisterTestingT(t)
+
+	now := time.Unix(1000, 0)
+	h := newDatastoreHealth()
+	h.now = func() time.Time { return now }
+
+	h.disconnected("workload endpoints")
+	now = now.Add(calicoDisconnectGrace)
+	h.disconnected("workload endpoints")
+	Expect(h.check()).To(BeNil())
+
+	now = now.Add(time.Second)
+	Expect(h.check().Error()).To(Equal("Calico datastore unreachable: workload endpoints (31s)"))
+
+	h.connected("workload endpoints")
+	Expect(h.check()).To(BeNil())
+}
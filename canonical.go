@@ -0,0 +1,90 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	log "github.com/sirupsen/logrus"
+)
+
+// canonicalJSON is set by --canonical-json, to write every hook response in canonical form.  Otherwise resources the
+// hooks pass through keep Pilot's formatting, and mutated ones are written in the order of the structs they are
+// decoded into.
+var canonicalJSON bool
+
+// canonicalize writes the JSON in body to buf in canonical form: compact, with the keys of every object sorted, and
+// numbers written as they were, so that the same config is always the same bytes.
+func canonicalize(buf *bytes.Buffer, body []byte) error {
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return err
+	}
+	if d.More() {
+		return errTrailingData
+	}
+	// Encoding/json sorts map keys, and escapes HTML as the codecs do.
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	buf.Write(b)
+	return nil
+}
+
+// bufferingWriter holds back a response body until the filter that installed it writes it.
+type bufferingWriter struct {
+	http.ResponseWriter
+	buf *bytes.Buffer
+}
+
+func (w *bufferingWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
+// canonicalized is a filter that rewrites successful hook responses in canonical form when --canonical-json
+// is set, so that golden tests, diffs, caches and Envoy's own change detection only ever see a change in the config
+// itself.  It runs inside the other filters, so that the history, captures and shadow comparisons see what is sent.
+func canonicalized(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	if !canonicalJSON {
+		chain.ProcessFilter(req, resp)
+		return
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	orig := resp.ResponseWriter
+	resp.ResponseWriter = &bufferingWriter{ResponseWriter: orig, buf: buf}
+
+	chain.ProcessFilter(req, resp)
+
+	resp.ResponseWriter = orig
+	body := buf.Bytes()
+	if resp.StatusCode() == http.StatusOK {
+		out := getBuffer()
+		defer putBuffer(out)
+		if err := canonicalize(out, body); err != nil {
+			log.WithFields(log.Fields{"path": req.Request.URL.Path, "err": err}).Debug(
+				"Response is not JSON; sending it as it is")
+		} else {
+			body = out.Bytes()
+		}
+	}
+	orig.Write(body)
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pkg/testutil"
+)
+
+func TestCanonicalize(t *testing.T) {
+	RegisterTestingT(t)
+
+	var buf bytes.Buffer
+	Expect(canonicalize(&buf, []byte(`{
+  "b": [1.50, 12345678901234567890, {"z": true, "a": null}],
+  "a": "<x>"
+}`))).To(Succeed())
+	Expect(buf.String()).To(Equal(`{"a":"\u003cx\u003e","b":[1.50,12345678901234567890,{"a":null,"z":true}]}`))
+
+	buf.Reset()
+	Expect(canonicalize(&buf, []byte(`{"a": 1} {}`))).To(Equal(errTrailingData))
+	Expect(canonicalize(&buf, []byte(`failed to read request`))).NotTo(Succeed())
+}
+
+func TestCanonicalized(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { canonicalJSON, dikastes = false, nil }()
+	dikastes = staticResolver(testutil.DikastesAddress)
+	container := newHooks()
+	cases, err := testutil.LoadGoldenCases(goldenFixtures)
+	Expect(err).To(BeNil())
+
+	for _, c := range cases {
+		canonicalJSON = false
+		rec := serveHook(container, httptest.NewRequest("POST", c.Path(), bytes.NewReader(c.Request)))
+		Expect(rec.Code).To(Equal(http.StatusOK), c.Name)
+		var want bytes.Buffer
+		Expect(canonicalize(&want, rec.Body.Bytes())).To(Succeed(), c.Name)
+
+		// Reordering and reindenting the request makes no difference to the response.
+		var reordered bytes.Buffer
+		Expect(canonicalize(&reordered, c.Request)).To(Succeed(), c.Name)
+		canonicalJSON = true
+		for _, req := range [][]byte{c.Request, reordered.Bytes()} {
+			rec = serveHook(container, httptest.NewRequest("POST", c.Path(), bytes.NewReader(req)))
+			Expect(rec.Code).To(Equal(http.StatusOK), c.Name)
+			Expect(rec.Body.String()).To(Equal(want.String()), c.Name)
+		}
+	}
+
+	// Errors are passed through.
+	rec := serveHook(container, hookRequest("listeners", "sidecar", strings.NewReader("{")))
+	Expect(rec.Code).To(Equal(http.StatusBadRequest))
+	Expect(rec.Body.String()).To(Equal("could not parse request JSON"))
+}
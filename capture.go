@@ -0,0 +1,142 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	captureRequestSuffix  = ".request.json"
+	captureResponseSuffix = ".response.json"
+	captureMetaSuffix     = ".meta.json"
+)
+
+// capturer writes a sample of raw hook payloads to disk.  It is nil unless --capture-dir is set.
+var capturer *payloadCapturer
+
+// captureMeta describes a captured exchange, enough to replay it.
+type captureMeta struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+}
+
+// payloadCapturer writes sampled request and response bodies to dir, keeping at most maxCaptures of them by deleting
+// the oldest.
+type payloadCapturer struct {
+	dir         string
+	rate        float64
+	maxCaptures int
+	sample      func() float64
+
+	mu       sync.Mutex
+	captures []string
+}
+
+func newPayloadCapturer(dir string, rate float64, maxCaptures int) (*payloadCapturer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	// Pick up captures from previous runs so they are rotated too.  Names sort by capture time.
+	metas, err := filepath.Glob(filepath.Join(dir, "*"+captureMetaSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(metas)
+	c := &payloadCapturer{dir: dir, rate: rate, maxCaptures: maxCaptures, sample: rand.Float64}
+	for _, m := range metas {
+		c.captures = append(c.captures, strings.TrimSuffix(m, captureMetaSuffix))
+	}
+	c.rotate()
+	return c, nil
+}
+
+// write stores one captured exchange and rotates out old ones.
+func (c *payloadCapturer) write(meta captureMeta, hook string, request, response []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	base := filepath.Join(c.dir, meta.Time.UTC().Format("20060102T150405.000000000")+"-"+hook)
+	m, _ := json.Marshal(meta)
+	for suffix, data := range map[string][]byte{
+		captureRequestSuffix:  request,
+		captureResponseSuffix: response,
+		captureMetaSuffix:     m,
+	} {
+		if err := ioutil.WriteFile(base+suffix, data, 0644); err != nil {
+			errorLog.Error(log.Fields{"err": err}, "failed to write payload capture")
+			return
+		}
+	}
+	c.captures = append(c.captures, base)
+	c.rotate()
+}
+
+func (c *payloadCapturer) rotate() {
+	for len(c.captures) > c.maxCaptures {
+		base := c.captures[0]
+		c.captures = c.captures[1:]
+		for _, suffix := range []string{captureRequestSuffix, captureResponseSuffix, captureMetaSuffix} {
+			os.Remove(base + suffix)
+		}
+	}
+}
+
+// hookName returns the hook a request path is for, e.g. "listeners" for /v1/listeners/...
+func hookName(path string) string {
+	c := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(c) < 2 {
+		return "unknown"
+	}
+	return c[1]
+}
+
+// capturePayloads is a filter that captures a sample of raw hook requests and responses.
+func capturePayloads(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	if capturer == nil || capturer.sample() >= capturer.rate {
+		chain.ProcessFilter(req, resp)
+		return
+	}
+	meta := captureMeta{Time: time.Now(), Method: req.Request.Method, Path: req.Request.URL.Path}
+	body, err := ioutil.ReadAll(limitBody(req.Request.Body))
+	if err != nil {
+		log.WithField("err", err).Debug("Unable to capture request body")
+	}
+	req.Request.Body = replayBody(body, err)
+	cw := &captureWriter{ResponseWriter: resp.ResponseWriter, max: math.MaxInt32}
+	resp.ResponseWriter = cw
+
+	chain.ProcessFilter(req, resp)
+
+	meta.Status = resp.StatusCode()
+	// Write after responding so sampled requests are not slowed by disk or redaction.
+	go func() {
+		capturer.write(meta, hookName(meta.Path), redactBody(body), redactBody(cw.buf.Bytes()))
+	}()
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func captureFiles(dir, suffix string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+suffix))
+	Expect(err).To(BeNil())
+	return files
+}
+
+func TestCapturePayloads(t *testing.T) {
+	RegisterTestingT(t)
+
+	dir, err := ioutil.TempDir("", "capture")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	capturer, err = newPayloadCapturer(dir, 1, 10)
+	Expect(err).To(BeNil())
+	defer func() { capturer = nil }()
+
+	serveHook(newHooks(), hookRequest("clusters", "sidecar", strings.NewReader("captured CDS")))
+
+	Eventually(func() []string { return captureFiles(dir, captureMetaSuffix) }).Should(HaveLen(1))
+	requests := captureFiles(dir, captureRequestSuffix)
+	Expect(requests).To(HaveLen(1))
+	Expect(requests[0]).To(HaveSuffix("-clusters" + captureRequestSuffix))
+	body, err := ioutil.ReadFile(requests[0])
+	Expect(err).To(BeNil())
+	Expect(string(body)).To(Equal("captured CDS"))
+}
+
+func TestCaptureRotation(t *testing.T) {
+	RegisterTestingT(t)
+
+	dir, err := ioutil.TempDir("", "capture")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	c, err := newPayloadCapturer(dir, 1, 2)
+	Expect(err).To(BeNil())
+
+	start := time.Unix(1000, 0)
+	for i := 0; i < 3; i++ {
+		c.write(captureMeta{Time: start.Add(time.Duration(i) * time.Second)}, "listeners", nil, nil)
+	}
+	metas := captureFiles(dir, captureMetaSuffix)
+	Expect(metas).To(HaveLen(2))
+	Expect(captureFiles(dir, captureResponseSuffix)).To(HaveLen(2))
+
+	// A new capturer picks up, and rotates, captures from before.
+	_, err = newPayloadCapturer(dir, 1, 1)
+	Expect(err).To(BeNil())
+	Expect(captureFiles(dir, captureMetaSuffix)).To(Equal(metas[1:]))
+}
+
+func TestHookName(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(hookName("/v1/listeners/a/b")).To(Equal("listeners"))
+	Expect(hookName("/v1/registration/svc")).To(Equal("registration"))
+	Expect(hookName("/")).To(Equal("unknown"))
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Faults chaos mode injects.
+const (
+	ChaosLatency  = "latency"
+	ChaosError    = "error"
+	ChaosTruncate = "truncate"
+)
+
+// chaos makes hook responses deliberately faulty, so that platform teams can check how Pilot and Envoy cope when the
+// webhook misbehaves.  It is nil unless one of the --chaos options is set.
+var chaos *chaosConfig
+
+var chaosInjections = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "chaos_injected_total",
+	Help:      "Number of faults injected into hook responses by chaos mode, by hook and fault.",
+}, []string{"hook", "fault"})
+
+func init() {
+	prometheus.MustRegister(chaosInjections)
+}
+
+// chaosConfig is the fraction of requests that get each fault.  A request may get latency and then an error or a
+// truncated response, but not both of those.
+type chaosConfig struct {
+	latency      time.Duration
+	latencyRate  float64
+	errorRate    float64
+	truncateRate float64
+	sample       func() float64
+}
+
+// newChaosConfig returns the config for the --chaos options, or nil if they inject nothing.
+func newChaosConfig(latency time.Duration, latencyRate, errorRate, truncateRate float64) (*chaosConfig, error) {
+	for _, r := range []float64{latencyRate, errorRate, truncateRate} {
+		if r < 0 || r > 1 {
+			return nil, fmt.Errorf("rate %v is not between 0 and 1", r)
+		}
+	}
+	if errorRate+truncateRate > 1 {
+		return nil, fmt.Errorf("error and truncate rates add up to more than 1")
+	}
+	if latency < 0 {
+		return nil, fmt.Errorf("negative latency %v", latency)
+	}
+	if (latency == 0 || latencyRate == 0) && errorRate == 0 && truncateRate == 0 {
+		return nil, nil
+	}
+	return &chaosConfig{
+		latency:      latency,
+		latencyRate:  latencyRate,
+		errorRate:    errorRate,
+		truncateRate: truncateRate,
+		sample:       rand.Float64,
+	}, nil
+}
+
+// truncatingWriter holds back the response body, so that only part of it is written.
+type truncatingWriter struct {
+	http.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (t *truncatingWriter) Write(b []byte) (int, error) {
+	return t.buf.Write(b)
+}
+
+// chaosInjected is a filter that injects the chaos mode faults.  Errors are a 503 without calling the
+// hook, and truncated responses are cut to half their length, as if the webhook died part way through writing them.
+func chaosInjected(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	if chaos == nil {
+		chain.ProcessFilter(req, resp)
+		return
+	}
+	hook := hookName(req.Request.URL.Path)
+	if chaos.latency > 0 && chaos.sample() < chaos.latencyRate {
+		chaosInjections.WithLabelValues(hook, ChaosLatency).Inc()
+		select {
+		case <-time.After(chaos.latency):
+		case <-req.Request.Context().Done():
+			return
+		}
+	}
+	s := chaos.sample()
+	switch {
+	case s < chaos.errorRate:
+		chaosInjections.WithLabelValues(hook, ChaosError).Inc()
+		log.WithField("path", req.Request.URL.Path).Debug("Chaos mode failing request.")
+		resp.WriteErrorString(http.StatusServiceUnavailable, "chaos mode injected failure")
+	case s < chaos.errorRate+chaos.truncateRate:
+		chaosInjections.WithLabelValues(hook, ChaosTruncate).Inc()
+		log.WithField("path", req.Request.URL.Path).Debug("Chaos mode truncating response.")
+		tw := &truncatingWriter{ResponseWriter: resp.ResponseWriter}
+		resp.ResponseWriter = tw
+		chain.ProcessFilter(req, resp)
+		tw.ResponseWriter.Write(tw.buf.Bytes()[:tw.buf.Len()/2])
+	default:
+		chain.ProcessFilter(req, resp)
+	}
+}
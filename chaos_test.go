@@ -0,0 +1,83 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestNewChaosConfig(t *testing.T) {
+	RegisterTestingT(t)
+
+	c, err := newChaosConfig(0, 1, 0, 0)
+	Expect(err).To(BeNil())
+	Expect(c).To(BeNil())
+	c, err = newChaosConfig(time.Second, 0, 0, 0)
+	Expect(err).To(BeNil())
+	Expect(c).To(BeNil())
+	c, err = newChaosConfig(time.Second, 0.5, 0.1, 0.2)
+	Expect(err).To(BeNil())
+	Expect(c).NotTo(BeNil())
+
+	for _, bad := range [][3]float64{{1.5, 0, 0}, {1, -0.1, 0}, {1, 0.6, 0.6}} {
+		_, err = newChaosConfig(time.Second, bad[0], bad[1], bad[2])
+		Expect(err).NotTo(BeNil(), "%v", bad)
+	}
+	_, err = newChaosConfig(-time.Second, 1, 0, 0)
+	Expect(err).NotTo(BeNil())
+}
+
+// chaosRequest sends an LDS request through the hooks with chaos mode always rolling the given sample.
+func chaosRequest(c *chaosConfig, sample float64) *httptest.ResponseRecorder {
+	chaos = c
+	chaos.sample = func() float64 { return sample }
+	return serveHook(newHooks(), hookRequest("listeners", "sidecar", strings.NewReader(ldsWithUnknownFields)))
+}
+
+func TestChaosMode(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { chaos = nil }()
+
+	rec := chaosRequest(&chaosConfig{errorRate: 0.5, truncateRate: 0.2}, 0.4)
+	Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+
+	rec = chaosRequest(&chaosConfig{errorRate: 0.5, truncateRate: 0.2}, 0.6)
+	Expect(rec.Code).To(Equal(http.StatusOK))
+	var v interface{}
+	Expect(json.Unmarshal(rec.Body.Bytes(), &v)).NotTo(Succeed(), "truncated response is not JSON")
+	truncated := rec.Body.String()
+
+	rec = chaosRequest(&chaosConfig{errorRate: 0.5, truncateRate: 0.2}, 0.9)
+	Expect(rec.Code).To(Equal(http.StatusOK))
+	Expect(json.Unmarshal(rec.Body.Bytes(), &v)).To(Succeed())
+	Expect(rec.Body.String()).To(HavePrefix(truncated))
+	Expect(len(truncated)).To(Equal(rec.Body.Len() / 2))
+
+	start := time.Now()
+	rec = chaosRequest(&chaosConfig{latency: 50 * time.Millisecond, latencyRate: 0.5}, 0.1)
+	Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+	Expect(rec.Code).To(Equal(http.StatusOK))
+	start = time.Now()
+	chaosRequest(&chaosConfig{latency: time.Second, latencyRate: 0.5}, 0.9)
+	Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkTimeout bounds each connection the check command makes.
+const checkTimeout = 5 * time.Second
+
+// diagnosis is the outcome of one of the check command's checks.  hint says what to do about a failure.
+type diagnosis struct {
+	name   string
+	detail string
+	err    error
+	hint   string
+}
+
+func (d diagnosis) String() string {
+	if d.err == nil {
+		return fmt.Sprintf("ok    %s: %s", d.name, d.detail)
+	}
+	s := fmt.Sprintf("FAIL  %s: %v", d.name, d.err)
+	if d.hint != "" {
+		s += "\n      " + d.hint
+	}
+	return s
+}
+
+// checkListenSocket checks that the webhook can serve on path: that the directory exists and sockets can be made in
+// it, and that any existing file there is a stale socket, which the webhook replaces.
+func checkListenSocket(path string) diagnosis {
+	d := diagnosis{name: "listen socket " + path}
+	dir := filepath.Dir(path)
+	if fi, err := os.Stat(dir); err != nil {
+		d.err, d.hint = err, "Mount the directory shared with Pilot, e.g. as a hostPath or emptyDir volume."
+		return d
+	} else if !fi.IsDir() {
+		d.err = fmt.Errorf("%s is not a directory", dir)
+		return d
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			d.err, d.hint = fmt.Errorf("%s exists and is not a socket", path), "Remove it, or choose another path."
+			return d
+		}
+		if conn, err := net.DialTimeout("unix", path, checkTimeout); err == nil {
+			conn.Close()
+			d.err, d.hint = fmt.Errorf("a process is already serving on %s", path),
+				"Stop the other webhook first; starting would replace its socket."
+			return d
+		}
+	}
+	// Make a socket alongside, as the webhook would, to check permissions.
+	probe := path + ".check"
+	os.Remove(probe)
+	lis, err := net.Listen("unix", probe)
+	if err != nil {
+		d.err, d.hint = err, "Run as a user that can write to "+dir+"."
+		return d
+	}
+	lis.Close()
+	os.Remove(probe)
+	d.detail = "can be created"
+	return d
+}
+
+// checkDikastesSocket checks that dikastes is serving on its socket, and that it is open to the sidecars.
+func checkDikastesSocket(path string, timeout time.Duration) []diagnosis {
+	d := diagnosis{name: "dikastes socket " + path}
+	fi, err := os.Stat(path)
+	if err != nil {
+		d.err, d.hint = err, "Check that dikastes runs on this node and --dikastes-socket matches its socket."
+		return []diagnosis{d}
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		d.err = fmt.Errorf("%s is not a socket", path)
+		return []diagnosis{d}
+	}
+	if fi.Mode().Perm()&0006 != 0006 {
+		d.err, d.hint = fmt.Errorf("mode %v does not let other users connect", fi.Mode().Perm()),
+			"Sidecars run as another user, so dikastes must make its socket world readable and writable."
+		return []diagnosis{d}
+	}
+	d.detail = fmt.Sprintf("mode %v", fi.Mode().Perm())
+	connect := diagnosis{name: "dikastes connect"}
+	if err := newDikastesProber(path, false, timeout).probe(); err != nil {
+		connect.err, connect.hint = err, "Dikastes may not be running, or may not have started listening yet."
+		return []diagnosis{d, connect}
+	}
+	connect.detail = "accepting connections"
+	health := diagnosis{name: "dikastes gRPC health"}
+	prober := newDikastesProber(path, true, timeout)
+	defer prober.close()
+	if err := prober.probe(); err != nil {
+		health.err, health.hint = err, "Dikastes is up but not serving; check its logs and its connection to Felix."
+		return []diagnosis{d, connect, health}
+	}
+	health.detail = "SERVING"
+	return []diagnosis{d, connect, health}
+}
+
+// checkDikastesAddress checks that a dikastes address, e.g. tcp://10.96.0.20:9000, accepts connections.
+func checkDikastesAddress(addr string) diagnosis {
+	d := diagnosis{name: "dikastes address " + addr}
+	u, err := url.Parse(addr)
+	if err != nil || u.Scheme != "tcp" || u.Host == "" {
+		d.err = fmt.Errorf("not a tcp://<host>:<port> address")
+		return d
+	}
+	conn, err := net.DialTimeout("tcp", u.Host, checkTimeout)
+	if err != nil {
+		d.err, d.hint = err, "Check the address, and that no network policy blocks the webhook's node from it."
+		return d
+	}
+	conn.Close()
+	d.detail = "accepting connections"
+	return d
+}
+
+// runChecks writes the diagnoses to out and returns how many failed.
+func runChecks(diagnoses []diagnosis, out io.Writer) int {
+	failed := 0
+	for _, d := range diagnoses {
+		fmt.Fprintln(out, d)
+		if d.err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+// check runs the check command, exiting 1 if any check fails.
+func check(arguments map[string]interface{}) {
+	diagnoses := []diagnosis{checkListenSocket(arguments["<path>"].(string))}
+	diagnoses = append(diagnoses, checkDikastesSocket(arguments["--dikastes-socket"].(string), checkTimeout)...)
+	if addr, ok := arguments["--dikastes-address"].(string); ok {
+		diagnoses = append(diagnoses, checkDikastesAddress(addr))
+	}
+	if runChecks(diagnoses, os.Stdout) > 0 {
+		os.Exit(1)
+	}
+}
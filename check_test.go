@@ -0,0 +1,112 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestCheckListenSocket(t *testing.T) {
+	RegisterTestingT(t)
+
+	dir, err := ioutil.TempDir("", "check")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "webhook.sock")
+
+	Expect(checkListenSocket(filepath.Join(dir, "missing", "webhook.sock")).err).NotTo(BeNil())
+	Expect(checkListenSocket(socket).err).To(BeNil())
+	_, err = os.Stat(socket + ".check")
+	Expect(os.IsNotExist(err)).To(BeTrue(), "the test socket is removed")
+
+	lis, err := net.Listen("unix", socket)
+	Expect(err).To(BeNil())
+	d := checkListenSocket(socket)
+	Expect(d.err.Error()).To(ContainSubstring("already serving"))
+
+	// A socket left behind by a webhook that has exited is fine, since the webhook replaces it.
+	lis.(*net.UnixListener).SetUnlinkOnClose(false)
+	lis.Close()
+	Expect(checkListenSocket(socket).err).To(BeNil())
+
+	Expect(os.Remove(socket)).To(Succeed())
+	Expect(ioutil.WriteFile(socket, nil, 0644)).To(Succeed())
+	Expect(checkListenSocket(socket).err.Error()).To(ContainSubstring("not a socket"))
+}
+
+func TestCheckDikastesSocket(t *testing.T) {
+	RegisterTestingT(t)
+
+	dir, err := ioutil.TempDir("", "check")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "dikastes.sock")
+
+	ds := checkDikastesSocket(socket, 100*time.Millisecond)
+	Expect(ds).To(HaveLen(1))
+	Expect(ds[0].err).NotTo(BeNil())
+
+	lis, err := net.Listen("unix", socket)
+	Expect(err).To(BeNil())
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	Expect(os.Chmod(socket, 0700)).To(Succeed())
+	ds = checkDikastesSocket(socket, 100*time.Millisecond)
+	Expect(ds).To(HaveLen(1))
+	Expect(ds[0].err.Error()).To(ContainSubstring("does not let other users connect"))
+
+	// Something is listening, but it is not serving gRPC health.
+	Expect(os.Chmod(socket, 0777)).To(Succeed())
+	ds = checkDikastesSocket(socket, 100*time.Millisecond)
+	Expect(ds).To(HaveLen(3))
+	Expect(ds[0].err).To(BeNil())
+	Expect(ds[1].err).To(BeNil())
+	Expect(ds[2].err).NotTo(BeNil())
+
+	var out bytes.Buffer
+	Expect(runChecks(ds, &out)).To(Equal(1))
+	Expect(out.String()).To(HavePrefix("ok    dikastes socket "))
+	Expect(out.String()).To(ContainSubstring("FAIL  dikastes gRPC health: "))
+	Expect(out.String()).To(ContainSubstring("\n      Dikastes is up but not serving"))
+}
+
+func TestCheckDikastesAddress(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(checkDikastesAddress("10.96.0.20:9000").err.Error()).To(ContainSubstring("tcp://"))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	addr := "tcp://" + lis.Addr().String()
+	Expect(checkDikastesAddress(addr).err).To(BeNil())
+	lis.Close()
+	Expect(checkDikastesAddress(addr).err).NotTo(BeNil())
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+// maxListenerNames bounds the listener name cache.  Once full it is simply cleared, as listener sets are mostly stable
+// and a clear costs one parse per listener.
+const maxListenerNames = 1 << 16
+
+// listenerName is what a listener's name and address say about it, which is most of what classifying it takes.
+type listenerName struct {
+	virtual bool
+	// addr is the IP in the listener's name.
+	addr string
+	// proto is the protocol in the listener's name, if any.  With protocol sniffing it comes from the filters instead.
+	proto Protocol
+	port  int
+}
+
+type listenerNameKey struct {
+	name     string
+	address  string
+	sniffing bool
+}
+
+// listenerNameCache memoizes parsing listener names and addresses, which Pilot repeats for every listener on every
+// push, though most listeners are unchanged between pushes.  The sniffing setting changes how names are parsed, so is
+// part of the key, and the cache is also cleared when it changes.
+type listenerNameCache struct {
+	mu      sync.RWMutex
+	entries map[listenerNameKey]listenerName
+}
+
+func newListenerNameCache() *listenerNameCache {
+	return &listenerNameCache{entries: make(map[listenerNameKey]listenerName)}
+}
+
+var listenerNames = newListenerNameCache()
+
+func (c *listenerNameCache) get(listener *v1.Listener, sniffing bool) listenerName {
+	key := listenerNameKey{name: listener.Name, address: listener.Address, sniffing: sniffing}
+	c.mu.RLock()
+	n, ok := c.entries[key]
+	c.mu.RUnlock()
+	if ok {
+		return n
+	}
+	n = parseListenerName(listener, sniffing)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxListenerNames {
+		c.entries = make(map[listenerNameKey]listenerName)
+	}
+	c.entries[key] = n
+	return n
+}
+
+// invalidate forgets every listener, e.g. when the sniffing setting changes.
+func (c *listenerNameCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[listenerNameKey]listenerName)
+}
+
+func parseListenerName(listener *v1.Listener, sniffing bool) listenerName {
+	if listener.Name == "virtual" {
+		return listenerName{virtual: true}
+	}
+	n := listenerName{port: listenerPort(listener)}
+	c := strings.Split(listener.Name, listenerNameSeparator)
+	if sniffing {
+		// Pilot names sniffed listeners <ip>_<port>, without a protocol.
+		if len(c) > 1 && (c[0] == "http" || c[0] == "tcp") {
+			c = c[1:]
+		}
+		n.addr = c[0]
+		return n
+	}
+	// Names without an address, which Pilot does not send, are treated as on no address, so outbound.
+	n.proto, n.addr, _ = mutator.ParseListenerName(listener.Name)
+	return n
+}
+
+// sniffingInbound reports whether Pilot sniffs the protocol of inbound listeners.
+func sniffingInbound() bool {
+	return protocolSniffing != nil && protocolSniffing.inboundEnabled()
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseListenerName(t *testing.T) {
+	RegisterTestingT(t)
+
+	for _, tc := range []struct {
+		listener v1.Listener
+		sniffing bool
+		want     listenerName
+	}{
+		{v1.Listener{Name: "virtual", Address: "tcp://0.0.0.0:15001"}, false, listenerName{virtual: true}},
+		{v1.Listener{Name: "http_1.2.3.4_80", Address: "tcp://1.2.3.4:8080"}, false,
+			listenerName{addr: "1.2.3.4", proto: HTTP, port: 8080}},
+		{v1.Listener{Name: "tcp_1.2.3.4_3306"}, false, listenerName{addr: "1.2.3.4", proto: TCP, port: 3306}},
+		{v1.Listener{Name: "http_1.2.3.4_80"}, true, listenerName{addr: "1.2.3.4", port: 80}},
+		{v1.Listener{Name: "1.2.3.4_80"}, true, listenerName{addr: "1.2.3.4", port: 80}},
+	} {
+		Expect(parseListenerName(&tc.listener, tc.sniffing)).To(Equal(tc.want), tc.listener.Name)
+	}
+}
+
+func TestListenerNameCache(t *testing.T) {
+	RegisterTestingT(t)
+
+	c := newListenerNameCache()
+	l := &v1.Listener{Name: "http_1.2.3.4_80"}
+	Expect(c.get(l, false)).To(Equal(listenerName{addr: "1.2.3.4", proto: HTTP, port: 80}))
+	Expect(c.get(l, true)).To(Equal(listenerName{addr: "1.2.3.4", port: 80}))
+	Expect(c.entries).To(HaveLen(2))
+
+	// The same name at another address is another listener.
+	Expect(c.get(&v1.Listener{Name: "http_1.2.3.4_80", Address: "tcp://1.2.3.4:81"}, false).port).To(Equal(81))
+
+	for i := 0; i < maxListenerNames; i++ {
+		c.get(&v1.Listener{Name: fmt.Sprintf("tcp_10.0.0.1_%d", i)}, false)
+	}
+	Expect(len(c.entries)).To(BeNumerically("<=", maxListenerNames))
+}
+
+func TestListenerNamesInvalidatedBySniffing(t *testing.T) {
+	RegisterTestingT(t)
+
+	s, err := newSniffingConfig("mesh")
+	Expect(err).To(BeNil())
+	listenerNames.get(&v1.Listener{Name: "http_1.2.3.4_80"}, false)
+	Expect(listenerNames.entries).NotTo(BeEmpty())
+
+	cm := &corev1.ConfigMap{Data: map[string]string{meshConfigKey: "enableProtocolSniffingForInbound: true\n"}}
+	Expect(s.apply(cm)).To(BeNil())
+	Expect(listenerNames.entries).To(BeEmpty())
+}
+
+func BenchmarkClassifyListener(b *testing.B) {
+	l := &v1.Listener{Name: "http_10.96.0.1_8080", Address: "tcp://10.96.0.1:8080"}
+	b.Run("parsed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			parseListenerName(l, false)
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			classifyListener(l, NODE_IP)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+)
+
+// listenerClassifier decides whether a sidecar's listener is inbound, outbound or the virtual listener, and whether it
+// is HTTP or TCP.  Pilots name listeners differently across Istio versions and forks, so --listener-classifier picks
+// the strategy that suits the deployment.
+type listenerClassifier interface {
+	classify(listener *v1.Listener, ip string) (Direction, Protocol)
+}
+
+// classifier is how listeners are classified, set by --listener-classifier.
+var classifier listenerClassifier = nameClassifier{}
+
+// listenerClassifiers are the strategies --listener-classifier can pick.
+var listenerClassifiers = map[string]listenerClassifier{
+	"name":     nameClassifier{},
+	"address":  addressClassifier{},
+	"metadata": metadataClassifier{},
+}
+
+func newListenerClassifier(name string) (listenerClassifier, error) {
+	c, ok := listenerClassifiers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown listener classifier %q; use name, address or metadata", name)
+	}
+	return c, nil
+}
+
+// classifyListener determines whether the listener is (inbound|outbound|virtual) and whether it is http or tcp protocol
+func classifyListener(listener *v1.Listener, ip string) (Direction, Protocol) {
+	return classifier.classify(listener, ip)
+}
+
+// nameClassifier classifies listeners by the names Pilot gives them, <protocol>_<ip>_<port>, or with protocol
+// sniffing <ip>_<port> and the protocol from the filters.
+type nameClassifier struct{}
+
+func (nameClassifier) classify(listener *v1.Listener, ip string) (Direction, Protocol) {
+	sniffing := sniffingInbound()
+	n := listenerNames.get(listener, sniffing)
+	if n.virtual {
+		return VIRTUAL, n.proto
+	}
+	proto := n.proto
+	if sniffing {
+		proto = listenerProtocol(listener)
+	}
+	if n.addr == ip {
+		return INBOUND, proto
+	}
+	if cniCompat != nil && cniCompat.inbound(n.addr, n.port, ip) {
+		return INBOUND, proto
+	}
+	return OUTBOUND, proto
+}
+
+// addressClassifier ignores listener names: listeners on the node's IP are inbound, the one using the original
+// destination is virtual, and the protocol comes from the filters.
+type addressClassifier struct{}
+
+func (addressClassifier) classify(listener *v1.Listener, ip string) (Direction, Protocol) {
+	proto := listenerProtocol(listener)
+	if isVirtualListener(listener) {
+		return VIRTUAL, proto
+	}
+	host, port := listenerAddress(listener)
+	if host == ip {
+		return INBOUND, proto
+	}
+	if cniCompat != nil && cniCompat.inbound(host, port, ip) {
+		return INBOUND, proto
+	}
+	return OUTBOUND, proto
+}
+
+// metadataClassifier takes listeners on the node's IP or the wildcard address to be inbound if the workload's pod
+// declares their port, as Istio builds inbound listeners from.  The protocol comes from the filters.  Pods that are
+// not in the pod index are classified by name.
+type metadataClassifier struct{}
+
+func (metadataClassifier) classify(listener *v1.Listener, ip string) (Direction, Protocol) {
+	pod, err := pods.byIP(ip)
+	if err != nil {
+		reportError("listeners", ErrorClassLookup, log.Fields{"ip": ip, "err": err}, "failed to look up pod")
+	}
+	if pod == nil {
+		return nameClassifier{}.classify(listener, ip)
+	}
+	proto := listenerProtocol(listener)
+	if isVirtualListener(listener) {
+		return VIRTUAL, proto
+	}
+	host, port := listenerAddress(listener)
+	if (host == ip || host == wildcardAddress) && port != 0 && podHasPort(pod, port) {
+		return INBOUND, proto
+	}
+	return OUTBOUND, proto
+}
+
+// isVirtualListener reports whether a listener is the one that redirects traffic to the others.
+func isVirtualListener(listener *v1.Listener) bool {
+	return listener.Name == "virtual" || listener.UseOriginalDst
+}
+
+// listenerAddress returns the IP and port a listener is on, from its address, or else its name.
+func listenerAddress(listener *v1.Listener) (string, int) {
+	host, p, err := net.SplitHostPort(strings.TrimPrefix(listener.Address, "tcp://"))
+	if err != nil {
+		n := listenerNames.get(listener, sniffingInbound())
+		return n.addr, n.port
+	}
+	port, _ := strconv.Atoi(p)
+	return host, port
+}
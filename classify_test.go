@@ -0,0 +1,109 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// classified returns the direction and protocol a classifier gives each listener, as "<direction>/<protocol>".
+func classified(c listenerClassifier, listeners ...*v1.Listener) []string {
+	var out []string
+	for _, l := range listeners {
+		d, p := c.classify(l, NODE_IP)
+		proto := "http"
+		if p == TCP {
+			proto = "tcp"
+		}
+		out = append(out, map[Direction]string{INBOUND: "inbound", OUTBOUND: "outbound", VIRTUAL: "virtual"}[d]+"/"+proto)
+	}
+	return out
+}
+
+var (
+	hcm = []*v1.NetworkFilter{{Name: v1.HTTPConnectionManager, Config: &v1.HTTPFilterConfig{}}}
+	// customNamed are listeners from a Pilot that names them its own way.
+	customNamed = []*v1.Listener{
+		{Name: "in-8080", Address: "tcp://" + NODE_IP + ":8080", Filters: hcm},
+		{Name: "in-3306", Address: "tcp://" + NODE_IP + ":3306"},
+		{Name: "out-80", Address: "tcp://10.96.0.10:80", Filters: hcm},
+		{Name: "redirect", Address: "tcp://0.0.0.0:15001", UseOriginalDst: true},
+	}
+)
+
+func TestNewListenerClassifier(t *testing.T) {
+	RegisterTestingT(t)
+
+	for _, name := range []string{"name", "address", "metadata"} {
+		c, err := newListenerClassifier(name)
+		Expect(err).To(BeNil())
+		Expect(c).To(Equal(listenerClassifiers[name]))
+	}
+	_, err := newListenerClassifier("guess")
+	Expect(err).NotTo(BeNil())
+}
+
+func TestAddressClassifier(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(classified(addressClassifier{}, customNamed...)).To(Equal([]string{
+		"inbound/http", "inbound/tcp", "outbound/http", "virtual/tcp",
+	}))
+	// The name classifier takes them all to be outbound.
+	Expect(classified(nameClassifier{}, customNamed...)).To(Equal([]string{
+		"outbound/http", "outbound/http", "outbound/http", "outbound/http",
+	}))
+	// Listeners without an address are classified by the address in their name.
+	Expect(classified(addressClassifier{}, &v1.Listener{Name: "tcp_" + NODE_IP + "_9000"})).To(Equal(
+		[]string{"inbound/tcp"}))
+}
+
+func TestMetadataClassifier(t *testing.T) {
+	RegisterTestingT(t)
+
+	pod := testPod("testpod", NODE_IP, corev1.PodRunning, nil)
+	pod.Spec.Containers = []corev1.Container{
+		{Name: "app", Ports: []corev1.ContainerPort{{ContainerPort: 8080}, {ContainerPort: 9000}}},
+	}
+	defer func() { pods = nil }()
+	pods = newTestPodIndex(pod)
+
+	listeners := append(customNamed, &v1.Listener{Name: "wild", Address: "tcp://0.0.0.0:9000"})
+	// 3306 is not a port of the pod.
+	Expect(classified(metadataClassifier{}, listeners...)).To(Equal([]string{
+		"inbound/http", "outbound/tcp", "outbound/http", "virtual/tcp", "inbound/tcp",
+	}))
+
+	// Listeners of pods that are not in the index are classified by name.
+	pods = newTestPodIndex()
+	Expect(classified(metadataClassifier{}, &v1.Listener{Name: "http_" + NODE_IP + "_8080"},
+		&v1.Listener{Name: "in-8080", Address: "tcp://" + NODE_IP + ":8080"})).To(Equal([]string{
+		"inbound/http", "outbound/http",
+	}))
+}
+
+func TestClassifyListenerStrategy(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { classifier = nameClassifier{} }()
+	classifier = addressClassifier{}
+	direction, proto := classifyListener(customNamed[1], NODE_IP)
+	Expect(direction).To(Equal(INBOUND))
+	Expect(proto).To(Equal(TCP))
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sort"
+
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+// jsonCodec encodes and decodes the JSON on the mutation path.
+type jsonCodec = mutator.Codec
+
+type stdCodec = mutator.StdCodec
+
+// jsonCodecs are the codecs --json-codec can select.  Others add themselves when built in, e.g. jsoniter by building
+// with -tags jsoniter.
+var jsonCodecs = map[string]jsonCodec{"std": stdCodec{}}
+
+// codec is the codec selected by --json-codec.
+var codec jsonCodec = stdCodec{}
+
+// setCodec selects the codec for the webhook and the mutations it delegates to.
+func setCodec(c jsonCodec) {
+	codec = c
+	mutator.SetCodec(c)
+}
+
+func jsonCodecNames() []string {
+	var names []string
+	for name := range jsonCodecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
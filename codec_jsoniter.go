@@ -0,0 +1,27 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build jsoniter
+// +build jsoniter
+
+package main
+
+import (
+	"github.com/json-iterator/go"
+)
+
+func init() {
+	// The standard library compatible config sorts map keys and escapes HTML as encoding/json does.
+	jsonCodecs["jsoniter"] = jsoniter.ConfigCompatibleWithStandardLibrary
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+// The conformance tests check every codec built in against encoding/json.  Run them with every codec built in:
+//
+//   go test -tags jsoniter -run Codec
+
+// codecValues are values whose encoding differs between JSON libraries unless they take care to match encoding/json.
+var codecValues = []interface{}{
+	mutator.NetworkFilter(profileFor(istioVersion{})),
+	mutator.HTTPFilter(hookProfile{FilterName: AuthZFilterName, GrpcService: true, FailOpen: true,
+		InitialMetadata: []headerValue{{Key: "x-node", Value: "a<b>&c"}}}),
+	mutator.Cluster(AuthZClusterName, "unix:///var/run/dikastes/dikastes.sock"),
+	map[string]interface{}{"z": 1, "a": []interface{}{nil, true, 1.5e300, " <\x00>"}, "m": map[string]int{"b": 1, "a": 2}},
+	json.RawMessage(`{"b" : 1, "a":[ 1 ]}`),
+	"\xff invalid utf-8",
+	[]byte("bytes"),
+}
+
+func TestCodecMarshalConformance(t *testing.T) {
+	RegisterTestingT(t)
+
+	for name, c := range jsonCodecs {
+		for _, v := range codecValues {
+			want, err := json.Marshal(v)
+			Expect(err).To(BeNil())
+			got, err := c.Marshal(v)
+			Expect(err).To(BeNil(), name)
+			Expect(string(got)).To(Equal(string(want)), name)
+		}
+	}
+}
+
+func TestCodecUnmarshalConformance(t *testing.T) {
+	RegisterTestingT(t)
+
+	bodies := [][]byte{[]byte(ldsWithUnknownFields), benchLDS(4), []byte(`{"listeners":null,"a":"é😀"}`)}
+	for _, f := range loadBenchFixtures(t) {
+		bodies = append(bodies, f.body)
+	}
+	for name, c := range jsonCodecs {
+		for _, body := range bodies {
+			var want, got map[string]json.RawMessage
+			Expect(json.Unmarshal(body, &want)).To(BeNil())
+			Expect(c.Unmarshal(body, &got)).To(BeNil(), name)
+			Expect(got).To(Equal(want), name)
+
+			var wantAny, gotAny interface{}
+			Expect(json.Unmarshal(body, &wantAny)).To(BeNil())
+			Expect(c.Unmarshal(body, &gotAny)).To(BeNil(), name)
+			Expect(gotAny).To(Equal(wantAny), name)
+		}
+		for _, bad := range []string{``, `{`, `{"a":1,}`, `[1 2]`, `{"a":1} x`} {
+			var v interface{}
+			Expect(c.Unmarshal([]byte(bad), &v)).NotTo(BeNil(), name+": "+bad)
+		}
+	}
+}
+
+// TestCodecResponses checks that every codec makes the webhook send exactly the same bytes.
+func TestCodecResponses(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { dikastes = nil; setCodec(stdCodec{}) }()
+	dikastes = staticResolver("tcp://10.96.0.20:9000")
+	container := newHooks()
+	for _, f := range loadBenchFixtures(t) {
+		setCodec(stdCodec{})
+		want := serveFixture(container, f).Body.String()
+		for name, c := range jsonCodecs {
+			// Setting the codec drops the snippets cached as marshaled by the last one.
+			setCodec(c)
+			rec := serveFixture(container, f)
+			Expect(rec.Code).To(Equal(200), f.name+"/"+name)
+			Expect(rec.Body.String()).To(Equal(want), f.name+"/"+name)
+		}
+	}
+}
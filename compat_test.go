@@ -0,0 +1,140 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pkg/testutil"
+)
+
+// compatFixtures holds a directory per Istio version, named for it, of captures of what that version's Pilot sends
+// the hooks.  Captures written by --capture-dir can be copied in as they are.
+const compatFixtures = "testdata/compat"
+
+// compatListenerName matches the listener names the webhook knows how to classify.
+var compatListenerName = regexp.MustCompile(`^(virtual|(http|tcp)_[0-9a-f.:]+_[0-9]+)$`)
+
+// compatNetworkFilters and compatHTTPFilters are the filters the webhook has been checked against.  A capture with any
+// other filter fails the matrix, so that a Pilot release that adds one gets looked at before users run into it.
+var compatNetworkFilters = map[string]bool{
+	"http_connection_manager": true,
+	"tcp_proxy":               true,
+	"mongo_proxy":             true,
+	"redis_proxy":             true,
+	"mixer":                   true,
+	AuthZFilterName:           true,
+}
+
+var compatHTTPFilters = map[string]bool{
+	"mixer":         true,
+	"cors":          true,
+	"fault":         true,
+	"router":        true,
+	"envoy.cors":    true,
+	"envoy.fault":   true,
+	"envoy.router":  true,
+	AuthZFilterName: true,
+}
+
+// expectKnownShape fails for listener names and filters the webhook has not been checked against.
+func expectKnownShape(version string, listeners []testutil.Listener) {
+	for _, l := range listeners {
+		Expect(compatListenerName.MatchString(l.Name)).To(BeTrue(), "Istio %s: new listener name format %q", version, l.Name)
+		for _, f := range l.Filters {
+			Expect(compatNetworkFilters[f.Name]).To(BeTrue(), "Istio %s: new network filter %q on %s", version, f.Name, l.Name)
+			if f.Name != "http_connection_manager" {
+				continue
+			}
+			for _, h := range f.HTTPFilters() {
+				Expect(compatHTTPFilters[h.Name]).To(BeTrue(), "Istio %s: new HTTP filter %q on %s", version, h.Name, l.Name)
+			}
+		}
+	}
+}
+
+func TestCompatibility(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { dikastes, istioVersions = nil, nil }()
+	dikastes = staticResolver("tcp://10.96.0.20:9000")
+	container := newHooks()
+	schemas, err := loadOutputSchemas()
+	Expect(err).To(BeNil())
+
+	dirs, err := filepath.Glob(filepath.Join(compatFixtures, "*"))
+	Expect(err).To(BeNil())
+	Expect(dirs).NotTo(BeEmpty())
+	for _, dir := range dirs {
+		version := filepath.Base(dir)
+		v, ok := parseIstioVersion(version)
+		Expect(ok).To(BeTrue(), dir)
+		profile := profileFor(v)
+		istioVersions, err = newVersionDetector(version)
+		Expect(err).To(BeNil())
+		captures, err := loadCaptures(dir)
+		Expect(err).To(BeNil())
+		Expect(captures).NotTo(BeEmpty(), dir)
+		for _, c := range captures {
+			t.Run(version+"/"+c.name, func(t *testing.T) {
+				RegisterTestingT(t)
+
+				path := strings.Split(c.meta.Path, "/")
+				Expect(len(path)).To(BeNumerically(">", 3))
+				hook, node := path[2], path[len(path)-1]
+				ip := strings.Split(node, serviceNodeSeparator)[1]
+
+				rec := serveHook(container, httptest.NewRequest(c.meta.Method, c.meta.Path, bytes.NewReader(c.request)))
+				Expect(rec.Code).To(Equal(http.StatusOK))
+				body, err := ioutil.ReadAll(rec.Body)
+				Expect(err).To(BeNil())
+				Expect(validateOutput(schemas, hook, body)).To(Succeed())
+
+				switch hook {
+				case "listeners":
+					listeners, err := testutil.DecodeListeners(c.request)
+					Expect(err).To(BeNil())
+					expectKnownShape(version, listeners)
+					_, err = testutil.CheckMutatedLDS(body, ip)
+					Expect(err).To(BeNil())
+					mutated, err := testutil.DecodeListeners(body)
+					Expect(err).To(BeNil())
+					for _, l := range mutated {
+						if !l.Inbound(ip) {
+							continue
+						}
+						configs := l.AuthzConfigs()
+						var cfg map[string]json.RawMessage
+						Expect(json.Unmarshal(configs[0], &cfg)).To(Succeed())
+						_, grpcService := cfg["grpc_service"]
+						Expect(grpcService).To(Equal(profile.GrpcService), "authz config on %s: %s", l.Name, configs[0])
+					}
+				case "clusters":
+					Expect(testutil.CheckAuthzCluster(body)).To(Succeed())
+				}
+			})
+		}
+	}
+}
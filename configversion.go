@@ -0,0 +1,46 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var configVersionBumps = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "config_version_bumps_total",
+	Help:      "Changes to state that mutations depend on but requests do not carry, by source.",
+}, []string{"source"})
+
+func init() {
+	prometheus.MustRegister(configVersionBumps)
+}
+
+// configVersion counts changes to the webhook's own config, i.e. state other than the request that a mutated response
+// depends on, such as the protocol sniffing setting or the DNS service's ports.  Cached responses are only served for
+// the version they were mutated at.
+var configVersion uint64
+
+func currentConfigVersion() uint64 {
+	return atomic.LoadUint64(&configVersion)
+}
+
+// bumpConfigVersion notes a change to the webhook's config, invalidating every cached response.
+func bumpConfigVersion(source string) {
+	atomic.AddUint64(&configVersion, 1)
+	configVersionBumps.WithLabelValues(source).Inc()
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"net"
+	"net/http"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pkg/testutil"
+)
+
+var (
+	conformanceTarget = flag.String("conformance-target", "",
+		"webhook to run the golden cases against: its socket, or a tcp://<host>:<port> address")
+	conformanceDikastesAddress = flag.String("conformance-dikastes-address", "",
+		"dikastes address the -conformance-target webhook adds the authz cluster with; clusters cases are skipped if unset")
+)
+
+// TestConformance runs the golden cases against a deployed webhook, so that a build or deployment can be checked to
+// behave as upstream does.  Without -conformance-target it runs them against the hooks in process, served over TCP.
+func TestConformance(t *testing.T) {
+	RegisterTestingT(t)
+
+	target, address := *conformanceTarget, *conformanceDikastesAddress
+	if target == "" {
+		defer func() { dikastes = nil }()
+		dikastes = staticResolver(testutil.DikastesAddress)
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		server := newHookServer()
+		go server.Serve(lis)
+		defer server.Close()
+		target, address = "tcp://"+lis.Addr().String(), testutil.DikastesAddress
+	}
+	r := newReplayer(target)
+	schemas, err := loadOutputSchemas()
+	Expect(err).To(BeNil())
+
+	cases, err := testutil.LoadGoldenCases(goldenFixtures)
+	Expect(err).To(BeNil())
+	for _, c := range cases {
+		c := c
+		t.Run(c.Hook+"/"+c.Name, func(t *testing.T) {
+			RegisterTestingT(t)
+
+			if c.Hook == "clusters" && address == "" {
+				t.Skip("needs -conformance-dikastes-address")
+			}
+			Expect(c.Golden).NotTo(BeNil(), "no golden response; run TestGolden with -update")
+			expected := bytes.Replace(c.Golden, []byte(testutil.DikastesAddress), []byte(address), -1)
+			res, err := r.replay(capture{
+				name:     c.Name,
+				meta:     captureMeta{Method: http.MethodPost, Path: c.Path(), Status: http.StatusOK},
+				request:  c.Request,
+				response: expected,
+			})
+			Expect(err).To(BeNil())
+			Expect(res.diffs).To(BeEmpty())
+			Expect(validateOutput(schemas, c.Hook, res.body)).To(Succeed())
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var partialPushes = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "partial_pushes_total",
+	Help:      "Nodes that were sent an injected authz filter over LDS without fetching CDS within the correlation window.",
+})
+
+func init() {
+	prometheus.MustRegister(partialPushes)
+}
+
+// correlator tracks the hook calls made for each node.  It is nil unless --correlation-window is set.
+var correlator *hookCorrelator
+
+// nodeCalls holds the last time each hook was called for a node.
+type nodeCalls struct {
+	lastLDS  time.Time
+	lastCDS  time.Time
+	lastRDS  time.Time
+	injected time.Time
+}
+
+// hookCorrelator spots nodes that were sent the authz filter but did not fetch clusters around the same time, in which
+// case Envoy may be pointing the filter at a cluster it does not have.
+type hookCorrelator struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu    sync.Mutex
+	nodes map[string]*nodeCalls
+}
+
+func newHookCorrelator(window time.Duration) *hookCorrelator {
+	return &hookCorrelator{window: window, now: time.Now, nodes: make(map[string]*nodeCalls)}
+}
+
+// observe records a successful hook call for a node.
+func (c *hookCorrelator) observe(serviceNode, hook string, injected bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, ok := c.nodes[serviceNode]
+	if !ok {
+		n = &nodeCalls{}
+		c.nodes[serviceNode] = n
+	}
+	now := c.now()
+	switch hook {
+	case "listeners":
+		n.lastLDS = now
+		// CDS normally precedes LDS, so a recent fetch counts.
+		if injected && now.Sub(n.lastCDS) > c.window && n.injected.IsZero() {
+			n.injected = now
+		}
+	case "clusters":
+		n.lastCDS = now
+		n.injected = time.Time{}
+	case "routes":
+		n.lastRDS = now
+	}
+}
+
+// sweep reports nodes whose injecting LDS went unmatched by CDS for a whole window, and forgets idle nodes.
+func (c *hookCorrelator) sweep() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for node, n := range c.nodes {
+		if !n.injected.IsZero() && now.Sub(n.injected) > c.window {
+			partialPushes.Inc()
+			log.WithFields(log.Fields{
+				"serviceNode": node,
+				"injected":    n.injected,
+				"lastCDS":     n.lastCDS,
+				"lastRDS":     n.lastRDS,
+			}).Warn("Node was sent the authz filter but did not fetch clusters.")
+			n.injected = time.Time{}
+		}
+		if n.injected.IsZero() && now.Sub(latest(n.lastLDS, n.lastCDS, n.lastRDS)) > c.window {
+			delete(c.nodes, node)
+		}
+	}
+}
+
+// run sweeps twice per window.  It never returns.
+func (c *hookCorrelator) run() {
+	for range time.Tick(c.window / 2) {
+		c.sweep()
+	}
+}
+
+func latest(times ...time.Time) time.Time {
+	var l time.Time
+	for _, t := range times {
+		if t.After(l) {
+			l = t
+		}
+	}
+	return l
+}
+
+// correlated returns a route filter that feeds successful calls of the named hook to the correlator.
+func correlated(hook string) restful.FilterFunction {
+	return func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		chain.ProcessFilter(req, resp)
+		if correlator != nil && resp.StatusCode() == http.StatusOK && !isDryRun(req) {
+			correlator.observe(req.PathParameter("serviceNode"), hook, statsFor(req).Injected > 0)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTestCorrelator() (*hookCorrelator, *time.Time) {
+	now := time.Unix(1000, 0)
+	c := newHookCorrelator(10 * time.Second)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestCorrelatorCDSBeforeLDS(t *testing.T) {
+	RegisterTestingT(t)
+
+	c, now := newTestCorrelator()
+	before := testutil.ToFloat64(partialPushes)
+	c.observe("a", "clusters", false)
+	*now = now.Add(time.Second)
+	c.observe("a", "listeners", true)
+	*now = now.Add(time.Minute)
+	c.sweep()
+	Expect(testutil.ToFloat64(partialPushes)).To(Equal(before))
+	Expect(c.nodes).To(BeEmpty())
+}
+
+func TestCorrelatorCDSAfterLDS(t *testing.T) {
+	RegisterTestingT(t)
+
+	c, now := newTestCorrelator()
+	before := testutil.ToFloat64(partialPushes)
+	c.observe("a", "listeners", true)
+	*now = now.Add(5 * time.Second)
+	c.observe("a", "clusters", false)
+	*now = now.Add(10 * time.Second)
+	c.sweep()
+	Expect(testutil.ToFloat64(partialPushes)).To(Equal(before))
+}
+
+func TestCorrelatorMissingCDS(t *testing.T) {
+	RegisterTestingT(t)
+
+	c, now := newTestCorrelator()
+	before := testutil.ToFloat64(partialPushes)
+	c.observe("a", "listeners", true)
+	c.observe("b", "listeners", false)
+	*now = now.Add(5 * time.Second)
+	c.sweep()
+	Expect(testutil.ToFloat64(partialPushes)).To(Equal(before))
+	*now = now.Add(6 * time.Second)
+	c.sweep()
+	Expect(testutil.ToFloat64(partialPushes)).To(Equal(before + 1))
+
+	// Reported once only.
+	c.sweep()
+	Expect(testutil.ToFloat64(partialPushes)).To(Equal(before + 1))
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+)
+
+// Reasons a request is denied by --deny-unexpected.
+const (
+	DeniedPath      = "path"
+	DeniedMethod    = "method"
+	DeniedMediaType = "media_type"
+	DeniedNode      = "node"
+)
+
+var deniedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "denied_requests_total",
+	Help:      "Unexpected hook requests denied by --deny-unexpected, by reason.",
+}, []string{"reason"})
+
+func init() {
+	prometheus.MustRegister(deniedRequests)
+}
+
+// denyUnexpected rejects requests for unknown paths, with unexpected methods, or for malformed service nodes with a
+// 403, rather than handling them as best it can.  It is set by --deny-unexpected.
+var denyUnexpected bool
+
+// nodeTypes are the types of service node Pilot asks for config for.
+var nodeTypes = map[string]bool{"sidecar": true, "ingress": true, "router": true}
+
+// deniedRequest is the audit record of a denied request.
+type deniedRequest struct {
+	Reason string `json:"reason"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Remote string `json:"remote,omitempty"`
+	Detail string `json:"detail"`
+}
+
+// checkServiceNode returns why a service node is malformed, or nil.  Unlike skipNode, which passes config for nodes
+// it does not understand through, it holds every type of node to the <type>~<ip>~<id>~<domain> form.
+func checkServiceNode(serviceNode string) error {
+	if strings.Count(serviceNode, config.ServiceNodeSeparator) != 3 {
+		return errors.New("service node does not have 4 parts")
+	}
+	n := config.ParseNode(serviceNode)
+	if !nodeTypes[n.Type] {
+		return fmt.Errorf("unknown node type %q", n.Type)
+	}
+	if net.ParseIP(n.IP) == nil {
+		return fmt.Errorf("invalid node IP %q", n.IP)
+	}
+	if n.ID == "" || n.Domain == "" {
+		return errors.New("empty node ID or domain")
+	}
+	if _, _, ok := n.Pod(); n.IsSidecar() && !ok {
+		return fmt.Errorf("sidecar ID %q is not <pod>.<namespace>", n.ID)
+	}
+	return nil
+}
+
+// deny counts, logs and audits a denied request, and answers it with a 403.
+func deny(req *restful.Request, resp *restful.Response, reason string, detail string) {
+	deniedRequests.WithLabelValues(reason).Inc()
+	r := req.Request
+	hook := "unknown"
+	for _, route := range hookRoutes {
+		if route.hook == hookName(r.URL.Path) {
+			hook = route.hook
+		}
+	}
+	errorLog.Warn(log.Fields{"reason": reason, "method": r.Method, "path": r.URL.Path, "remote": r.RemoteAddr,
+		"detail": detail}, "denied unexpected request")
+	if auditLog != nil {
+		auditLog.record(auditRecord{
+			Time:           time.Now(),
+			Hook:           hook,
+			ServiceCluster: req.PathParameter("serviceCluster"),
+			ServiceNode:    req.PathParameter("serviceNode"),
+			Changes:        []resourceChange{},
+			Denied: &deniedRequest{
+				Reason: reason,
+				Method: r.Method,
+				Path:   r.URL.Path,
+				Remote: r.RemoteAddr,
+				Detail: detail,
+			},
+		})
+	}
+	resp.WriteErrorString(http.StatusForbidden, "request denied")
+}
+
+// deniedUnexpected is the hook container's ServiceErrorHandler, called for requests no route matches.
+func deniedUnexpected(err restful.ServiceError, req *restful.Request, resp *restful.Response) {
+	if !denyUnexpected {
+		resp.WriteErrorString(err.Code, err.Message)
+		return
+	}
+	reason := DeniedPath
+	switch err.Code {
+	case http.StatusMethodNotAllowed:
+		reason = DeniedMethod
+	case http.StatusNotAcceptable, http.StatusUnsupportedMediaType:
+		reason = DeniedMediaType
+	}
+	deny(req, resp, reason, err.Message)
+}
+
+// nodeChecked returns a route filter that denies requests for malformed service nodes.
+func nodeChecked(hook string) restful.FilterFunction {
+	return func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		if !denyUnexpected {
+			chain.ProcessFilter(req, resp)
+			return
+		}
+		if err := checkServiceNode(req.PathParameter("serviceNode")); err != nil {
+			deny(req, resp, DeniedNode, err.Error())
+			return
+		}
+		chain.ProcessFilter(req, resp)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCheckServiceNode(t *testing.T) {
+	RegisterTestingT(t)
+
+	for _, good := range []string{
+		serviceNode("sidecar", NODE_IP),
+		"router~10.1.2.3~istio-ingressgateway-abc.istio-system~istio-system.svc.cluster.local",
+		"ingress~fd00::1~ingress~cluster.local",
+	} {
+		Expect(checkServiceNode(good)).To(Succeed(), good)
+	}
+	for _, bad := range []string{
+		"",
+		"sidecar~10.1.2.3~testpod.testns",
+		"sidecar~10.1.2.3~testpod.testns~testns.svc.cluster.local~extra",
+		"gateway~10.1.2.3~testpod.testns~testns.svc.cluster.local",
+		"sidecar~not-an-ip~testpod.testns~testns.svc.cluster.local",
+		"sidecar~10.1.2.3~~testns.svc.cluster.local",
+		"sidecar~10.1.2.3~testpod~testns.svc.cluster.local",
+	} {
+		Expect(checkServiceNode(bad)).NotTo(Succeed(), bad)
+	}
+}
+
+// denyRequest sends a request through the hook server.
+func denyRequest(method, path string) *httptest.ResponseRecorder {
+	return serveHook(newHookServer().Handler, httptest.NewRequest(method, path, strings.NewReader(ldsWithUnknownFields)))
+}
+
+func TestDenyUnexpected(t *testing.T) {
+	RegisterTestingT(t)
+
+	malformed := "/v1/listeners/" + SERVICE_CLUSTER + "/sidecar~bad~testpod.testns~testns.svc.cluster.local"
+	Expect(denyRequest("POST", "/v1/secrets/x").Code).To(Equal(http.StatusNotFound), "best effort unless enabled")
+	Expect(denyRequest("POST", malformed).Code).To(Equal(http.StatusOK))
+
+	var audit bytes.Buffer
+	denyUnexpected, auditLog = true, newAuditor(&audit)
+	defer func() { denyUnexpected, auditLog = false, nil }()
+
+	before := testutil.ToFloat64(deniedRequests.WithLabelValues(DeniedPath))
+	Expect(denyRequest("POST", "/v1/secrets/x").Code).To(Equal(http.StatusForbidden))
+	Expect(testutil.ToFloat64(deniedRequests.WithLabelValues(DeniedPath))).To(Equal(before + 1))
+
+	before = testutil.ToFloat64(deniedRequests.WithLabelValues(DeniedNode))
+	Expect(denyRequest("POST", malformed).Code).To(Equal(http.StatusForbidden))
+	Expect(testutil.ToFloat64(deniedRequests.WithLabelValues(DeniedNode))).To(Equal(before + 1))
+
+	path := "/v1/listeners/" + SERVICE_CLUSTER + "/" + serviceNode("sidecar", NODE_IP)
+	Expect(denyRequest("POST", path).Code).To(Equal(http.StatusOK))
+
+	lines := strings.Split(strings.TrimSpace(audit.String()), "\n")
+	Expect(lines).To(HaveLen(3), "denials and the mutation are audited")
+	var rec auditRecord
+	Expect(json.Unmarshal([]byte(lines[0]), &rec)).To(Succeed())
+	Expect(rec.Hook).To(Equal("unknown"))
+	Expect(rec.Denied).NotTo(BeNil())
+	Expect(rec.Denied.Reason).To(Equal(DeniedPath))
+	Expect(rec.Denied.Path).To(Equal("/v1/secrets/x"))
+	Expect(json.Unmarshal([]byte(lines[1]), &rec)).To(Succeed())
+	Expect(rec.Hook).To(Equal("listeners"))
+	Expect(rec.Denied.Reason).To(Equal(DeniedNode))
+	Expect(rec.Denied.Detail).To(ContainSubstring("invalid node IP"))
+	rec = auditRecord{}
+	Expect(json.Unmarshal([]byte(lines[2]), &rec)).To(Succeed())
+	Expect(rec.Denied).To(BeNil())
+}
+
+func TestDeniedUnexpectedReasons(t *testing.T) {
+	RegisterTestingT(t)
+
+	denyUnexpected = true
+	defer func() { denyUnexpected = false }()
+
+	for code, reason := range map[int]string{
+		http.StatusNotFound:             DeniedPath,
+		http.StatusMethodNotAllowed:     DeniedMethod,
+		http.StatusUnsupportedMediaType: DeniedMediaType,
+	} {
+		before := testutil.ToFloat64(deniedRequests.WithLabelValues(reason))
+		rec := httptest.NewRecorder()
+		req := restful.NewRequest(httptest.NewRequest("GET", "/v1/listeners", nil))
+		deniedUnexpected(restful.ServiceError{Code: code}, req, restful.NewResponse(rec))
+		Expect(rec.Code).To(Equal(http.StatusForbidden))
+		Expect(testutil.ToFloat64(deniedRequests.WithLabelValues(reason))).To(Equal(before+1), reason)
+	}
+}
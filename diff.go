@@ -0,0 +1,248 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// diffContext is the number of unchanged lines shown around each change in a unified diff.
+const diffContext = 3
+
+// lineEdit is a line of an edit script: kept (' '), deleted ('-') or inserted ('+').
+type lineEdit struct {
+	op   byte
+	line string
+}
+
+// diffLines returns the shortest edit script turning a into b, by Myers' algorithm.  Only the diagonals reached at
+// each step are kept for the backtrack, so memory grows with the square of the number of edits rather than with the
+// length of the input, which suits the few changes the webhook makes to large responses.
+func diffLines(a, b []string) []lineEdit {
+	n, m := len(a), len(b)
+	max := n + m
+	v := make([]int, 2*max+3)
+	offset := max + 1
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		done := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[offset+k-1] < v[offset+k+1] {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				done = true
+				break
+			}
+		}
+		if done {
+			break
+		}
+	}
+
+	var edits []lineEdit
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || k != d && prev[k-1+d] < prev[k+1+d] {
+			prevK = k + 1
+		}
+		prevX := prev[prevK+d]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			edits = append(edits, lineEdit{' ', a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			edits = append(edits, lineEdit{'+', b[y-1]})
+			y--
+		} else {
+			edits = append(edits, lineEdit{'-', a[x-1]})
+			x--
+		}
+	}
+	for ; x > 0; x-- {
+		edits = append(edits, lineEdit{' ', a[x-1]})
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// writeUnifiedDiff writes the edit script as a unified diff between the files named from and to.  It writes nothing
+// when there are no changes.
+func writeUnifiedDiff(out io.Writer, from, to string, edits []lineEdit) error {
+	var buf bytes.Buffer
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+		if buf.Len() == 0 {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", from, to)
+		}
+		// A hunk runs from the context before this change to the context after the last change within reach of it.
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end, kept := i, 0
+		for j := i; j < len(edits) && kept <= 2*diffContext; j++ {
+			if edits[j].op == ' ' {
+				kept++
+			} else {
+				end, kept = j+1, 0
+			}
+		}
+		if end += diffContext; end > len(edits) {
+			end = len(edits)
+		}
+		// Line numbers count the lines of each side before the hunk.
+		aLine, bLine := 1, 1
+		for _, e := range edits[:start] {
+			if e.op != '+' {
+				aLine++
+			}
+			if e.op != '-' {
+				bLine++
+			}
+		}
+		aLen, bLen := 0, 0
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				aLen++
+			}
+			if e.op != '-' {
+				bLen++
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%d,%d +%d,%d @@\n", aLine, aLen, bLine, bLen)
+		for _, e := range edits[start:end] {
+			buf.WriteByte(e.op)
+			buf.WriteString(e.line)
+			buf.WriteByte('\n')
+		}
+		i = end
+	}
+	_, err := out.Write(buf.Bytes())
+	return err
+}
+
+// indentJSON re-encodes a JSON document with sorted keys and one value per line, so that both sides of a diff are
+// laid out alike whatever order Pilot and the webhook wrote them in.
+func indentJSON(body []byte) ([]string, interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, nil, err
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	return strings.Split(string(b), "\n"), v, nil
+}
+
+// runDiff writes what the hook would change in the xDS response read from in for the node, either as a unified diff
+// or as a JSON Patch, and reports whether it would change anything.
+func runDiff(hook, node string, in io.Reader, out io.Writer, format string) (bool, error) {
+	if format != "unified" && format != "patch" {
+		return false, fmt.Errorf("unknown diff format %q: expected unified or patch", format)
+	}
+	before, err := ioutil.ReadAll(in)
+	if err != nil {
+		return false, err
+	}
+	var after bytes.Buffer
+	if err := runTransform(hook, node, bytes.NewReader(before), &after); err != nil {
+		return false, err
+	}
+	a, av, err := indentJSON(before)
+	if err != nil {
+		return false, fmt.Errorf("xDS response is not JSON: %v", err)
+	}
+	b, bv, err := indentJSON(after.Bytes())
+	if err != nil {
+		return false, fmt.Errorf("mutated response is not JSON: %v", err)
+	}
+	if format == "patch" {
+		ops := diffJSON("", av, bv)
+		if ops == nil {
+			ops = []diffOp{}
+		}
+		p, err := json.MarshalIndent(ops, "", "  ")
+		if err != nil {
+			return false, err
+		}
+		_, err = fmt.Fprintf(out, "%s\n", p)
+		return len(ops) > 0, err
+	}
+	edits := diffLines(a, b)
+	changed := false
+	for _, e := range edits {
+		if e.op != ' ' {
+			changed = true
+			break
+		}
+	}
+	return changed, writeUnifiedDiff(out, hook+" from Pilot", hook+" from webhook", edits)
+}
+
+// diff runs the diff command.  Like diff(1), it exits 1 if the webhook would change the response.
+func diff(arguments map[string]interface{}) {
+	if addr, ok := arguments["--dikastes-address"].(string); ok {
+		dikastes = staticResolver(addr)
+	}
+	in := os.Stdin
+	if file, ok := arguments["<file>"].(string); ok {
+		f, err := os.Open(file)
+		if err != nil {
+			log.WithFields(log.Fields{"file": file, "err": err}).Fatal("Unable to read xDS response.")
+		}
+		defer f.Close()
+		in = f
+	}
+	changed, err := runDiff(arguments["<hook>"].(string), arguments["<node>"].(string), in, os.Stdout,
+		arguments["--diff-format"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Unable to diff xDS response.")
+	}
+	if changed {
+		os.Exit(1)
+	}
+}
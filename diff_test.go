@@ -0,0 +1,161 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func applyEdits(edits []lineEdit) (a, b []string) {
+	for _, e := range edits {
+		if e.op != '+' {
+			a = append(a, e.line)
+		}
+		if e.op != '-' {
+			b = append(b, e.line)
+		}
+	}
+	return a, b
+}
+
+func TestDiffLines(t *testing.T) {
+	RegisterTestingT(t)
+
+	for _, c := range []struct {
+		a, b  string
+		edits int
+	}{
+		{"", "", 0},
+		{"a b c", "a b c", 0},
+		{"a b c", "a x b c", 1},
+		{"a b c", "a c", 1},
+		{"a b c d e", "x b c y e z", 5},
+		{"", "a b", 2},
+		{"a b", "", 2},
+	} {
+		a, b := strings.Fields(c.a), strings.Fields(c.b)
+		edits := diffLines(a, b)
+		gotA, gotB := applyEdits(edits)
+		Expect(strings.Join(gotA, " ")).To(Equal(c.a))
+		Expect(strings.Join(gotB, " ")).To(Equal(c.b))
+		n := 0
+		for _, e := range edits {
+			if e.op != ' ' {
+				n++
+			}
+		}
+		Expect(n).To(Equal(c.edits), c.a+" -> "+c.b)
+	}
+}
+
+func TestWriteUnifiedDiff(t *testing.T) {
+	RegisterTestingT(t)
+
+	var a []string
+	for i := 1; i <= 20; i++ {
+		a = append(a, string(rune('a'+i-1)))
+	}
+	b := append([]string(nil), a...)
+	b[1] = "B"
+	b = append(b[:15], append([]string{"new"}, b[15:]...)...)
+
+	var out bytes.Buffer
+	Expect(writeUnifiedDiff(&out, "old", "new", diffLines(a, b))).To(Succeed())
+	Expect(out.String()).To(Equal(`--- old
++++ new
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -13,6 +13,7 @@
+ m
+ n
+ o
++new
+ p
+ q
+ r
+`))
+
+	out.Reset()
+	Expect(writeUnifiedDiff(&out, "old", "new", diffLines(a, a))).To(Succeed())
+	Expect(out.Len()).To(Equal(0))
+}
+
+func TestDiffUnified(t *testing.T) {
+	RegisterTestingT(t)
+
+	var out bytes.Buffer
+	changed, err := runDiff("listeners", serviceNode("sidecar", NODE_IP), strings.NewReader(ldsWithUnknownFields),
+		&out, "unified")
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeTrue())
+	Expect(out.String()).To(HavePrefix("--- listeners from Pilot\n+++ listeners from webhook\n@@ "))
+	Expect(out.String()).To(ContainSubstring(`+                "name": "` + AuthZFilterName + `",`))
+	for _, line := range strings.Split(out.String(), "\n") {
+		Expect(line).NotTo(HavePrefix("-  "), "nothing is removed")
+	}
+
+	// Outbound only config is left alone.
+	out.Reset()
+	changed, err = runDiff("listeners", serviceNode("sidecar", NODE_IP), strings.NewReader(`{"listeners":[]}`),
+		&out, "unified")
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeFalse())
+	Expect(out.Len()).To(Equal(0))
+}
+
+func TestDiffPatch(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { dikastes = nil }()
+	dikastes = staticResolver("tcp://10.96.0.20:9000")
+	var out bytes.Buffer
+	changed, err := runDiff("clusters", serviceNode("sidecar", NODE_IP), strings.NewReader(`{"clusters":[]}`),
+		&out, "patch")
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeTrue())
+	var ops []diffOp
+	Expect(json.Unmarshal(out.Bytes(), &ops)).To(Succeed())
+	Expect(ops).To(HaveLen(1))
+	Expect(ops[0].Op).To(Equal("add"))
+	Expect(ops[0].Path).To(Equal("/clusters/0"))
+
+	out.Reset()
+	changed, err = runDiff("clusters", serviceNode("router", NODE_IP), strings.NewReader(`{"clusters":[]}`),
+		&out, "patch")
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeFalse())
+	Expect(out.String()).To(Equal("[]\n"))
+}
+
+func TestDiffErrors(t *testing.T) {
+	RegisterTestingT(t)
+
+	var out bytes.Buffer
+	_, err := runDiff("listeners", serviceNode("sidecar", NODE_IP), strings.NewReader(`{}`), &out, "context")
+	Expect(err).NotTo(BeNil())
+	_, err = runDiff("listeners", serviceNode("sidecar", NODE_IP), strings.NewReader(`{"listeners":[`), &out, "unified")
+	Expect(err).NotTo(BeNil())
+	Expect(out.Len()).To(Equal(0))
+}
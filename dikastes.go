@@ -0,0 +1,150 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var dikastesUp = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "dikastes_up",
+	Help:      "Whether the last probe of the dikastes socket succeeded.",
+})
+
+func init() {
+	prometheus.MustRegister(dikastesUp)
+}
+
+var errNotProbed = errors.New("dikastes has not been probed yet")
+
+// dikastesProber checks that the dikastes socket the injected filters point at is accepting connections and,
+// optionally, that dikastes reports itself healthy over the gRPC health protocol.
+type dikastesProber struct {
+	socket     string
+	grpcHealth bool
+	timeout    time.Duration
+
+	mu      sync.Mutex
+	lastErr error
+	// cc is the connection gRPC health checks are made on.  It is dialled for the first and kept for the prober's
+	// lifetime, since gRPC reconnects it whenever dikastes restarts.
+	cc *grpc.ClientConn
+}
+
+func newDikastesProber(socket string, grpcHealth bool, timeout time.Duration) *dikastesProber {
+	return &dikastesProber{socket: socket, grpcHealth: grpcHealth, timeout: timeout, lastErr: errNotProbed}
+}
+
+func (p *dikastesProber) probe() error {
+	if !p.grpcHealth {
+		conn, err := net.DialTimeout("unix", p.socket, p.timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	cc, err := p.conn()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	r, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+	if r.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("dikastes health is %v", r.Status)
+	}
+	return nil
+}
+
+// conn returns the connection for gRPC health checks, dialling it the first time.  The dial does not block: the
+// connection is made in the background, and health checks fail while it is down.
+func (p *dikastesProber) conn() (*grpc.ClientConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cc != nil {
+		return p.cc, nil
+	}
+	cc, err := grpc.Dial(p.socket,
+		grpc.WithInsecure(),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}))
+	if err != nil {
+		return nil, err
+	}
+	p.cc = cc
+	return cc, nil
+}
+
+// close closes the health check connection, if one was dialled.
+func (p *dikastesProber) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cc != nil {
+		p.cc.Close()
+		p.cc = nil
+	}
+}
+
+// update probes dikastes and records the result.
+func (p *dikastesProber) update() {
+	err := p.probe()
+	p.mu.Lock()
+	changed := (err == nil) != (p.lastErr == nil)
+	p.lastErr = err
+	p.mu.Unlock()
+
+	if err != nil {
+		dikastesUp.Set(0)
+		if changed {
+			log.WithFields(log.Fields{"socket": p.socket, "err": err}).Warn("Dikastes is down.")
+		}
+	} else {
+		dikastesUp.Set(1)
+		if changed {
+			log.WithField("socket", p.socket).Info("Dikastes is up.")
+		}
+	}
+}
+
+// run probes dikastes every interval.  The first probe is left to the caller, to make before registering check, so
+// that readiness reflects dikastes from the start rather than failing until the first probe.  It never returns.
+func (p *dikastesProber) run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		p.update()
+	}
+}
+
+// check is a readiness check reporting the last probe result.
+func (p *dikastesProber) check() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastErr
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestDikastesProbe(t *testing.T) {
+	RegisterTestingT(t)
+
+	dir, err := ioutil.TempDir("", "dikastes")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "dikastes.sock")
+
+	p := newDikastesProber(socket, false, time.Second)
+	Expect(p.check()).To(Equal(errNotProbed))
+
+	p.update()
+	Expect(p.check()).ToNot(BeNil())
+	Expect(testutil.ToFloat64(dikastesUp)).To(Equal(0.0))
+
+	lis, err := net.Listen("unix", socket)
+	Expect(err).To(BeNil())
+	defer lis.Close()
+	p.update()
+	Expect(p.check()).To(BeNil())
+	Expect(testutil.ToFloat64(dikastesUp)).To(Equal(1.0))
+}
+
+type servingHealth struct {
+	healthpb.UnimplementedHealthServer
+}
+
+func (servingHealth) Check(context.Context, *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func TestDikastesGRPCProbeKeepsConnection(t *testing.T) {
+	RegisterTestingT(t)
+
+	dir, err := ioutil.TempDir("", "dikastes")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "dikastes.sock")
+	lis, err := net.Listen("unix", socket)
+	Expect(err).To(BeNil())
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, servingHealth{})
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	p := newDikastesProber(socket, true, time.Second)
+	defer p.close()
+	Expect(p.probe()).To(Succeed())
+	cc := p.cc
+	Expect(cc).NotTo(BeNil())
+	Expect(p.probe()).To(Succeed())
+	Expect(p.cc).To(BeIdenticalTo(cc))
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// namespacePlaceholder is replaced with the workload's namespace in the --dikastes-*-template options.
+const namespacePlaceholder = "{namespace}"
+
+// namespaceDikastes gives each namespace its own authz cluster, for deployments running a dikastes per namespace.  It
+// is nil unless --dikastes-per-namespace is set.
+var namespaceDikastes *dikastesTemplates
+
+type dikastesTemplates struct {
+	cluster string
+	socket  string
+}
+
+func newDikastesTemplates(cluster, socket string) (*dikastesTemplates, error) {
+	if !strings.Contains(cluster, namespacePlaceholder) {
+		return nil, fmt.Errorf("cluster template %q does not contain %s", cluster, namespacePlaceholder)
+	}
+	if !strings.Contains(socket, namespacePlaceholder) {
+		return nil, fmt.Errorf("socket template %q does not contain %s", socket, namespacePlaceholder)
+	}
+	return &dikastesTemplates{cluster: cluster, socket: socket}, nil
+}
+
+func (t *dikastesTemplates) clusterName(namespace string) string {
+	return strings.Replace(t.cluster, namespacePlaceholder, namespace, -1)
+}
+
+// address returns the authz cluster host for the namespace's dikastes socket.
+func (t *dikastesTemplates) address(namespace string) string {
+	return "unix://" + strings.Replace(t.socket, namespacePlaceholder, namespace, -1)
+}
+
+// authzClusterFor returns the name of the authz cluster the service node's filter should use.  That is the shared
+// AuthZClusterName unless dikastes runs per namespace and the node's namespace is known.
+func authzClusterFor(serviceNode string) string {
+	if namespaceDikastes != nil {
+		if _, namespace, ok := podFromServiceNode(serviceNode); ok {
+			return namespaceDikastes.clusterName(namespace)
+		}
+	}
+	return AuthZClusterName
+}
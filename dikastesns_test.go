@@ -0,0 +1,63 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+)
+
+func TestNewDikastesTemplates(t *testing.T) {
+	RegisterTestingT(t)
+
+	_, err := newDikastesTemplates("calico.dikastes", "/var/run/dikastes/{namespace}/dikastes.sock")
+	Expect(err).NotTo(BeNil())
+	_, err = newDikastesTemplates("calico.dikastes.{namespace}", "/var/run/dikastes/dikastes.sock")
+	Expect(err).NotTo(BeNil())
+}
+
+func TestPerNamespaceDikastes(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { namespaceDikastes = nil }()
+	namespaceDikastes, _ = newDikastesTemplates("calico.dikastes.{namespace}", "/var/run/dikastes/{namespace}/dikastes.sock")
+
+	req := newCDSRequest("sidecar", strings.NewReader(`{"clusters": []}`))
+	rec := httptest.NewRecorder()
+	clusters(req, restful.NewResponse(rec))
+	var cds cdsResponse
+	Expect(json.Unmarshal(rec.Body.Bytes(), &cds)).To(Succeed())
+	Expect(cds.Clusters).To(HaveLen(1))
+	Expect(cds.Clusters[0].Name).To(Equal("calico.dikastes.testns"))
+	Expect(cds.Clusters[0].Hosts[0].URL).To(Equal("unix:///var/run/dikastes/testns/dikastes.sock"))
+
+	lds, _ := json.Marshal(ldsResponse{Listeners: []*v1.Listener{{
+		Name:    "tcp_" + NODE_IP + "_76",
+		Filters: []*v1.NetworkFilter{{Name: v1.TCPProxyFilter}},
+	}}})
+	req = newLDSRequest("sidecar", bytes.NewReader(lds))
+	rec = httptest.NewRecorder()
+	listeners(req, restful.NewResponse(rec))
+	Expect(rec.Body.String()).To(ContainSubstring(`"cluster_name":"calico.dikastes.testns"`))
+
+	Expect(authzClusterFor("sidecar~" + NODE_IP + "~unknown")).To(Equal(AuthZClusterName))
+}
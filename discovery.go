@@ -0,0 +1,145 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// dikastesContainerName is the container in the calico-node DaemonSet that runs dikastes.
+const dikastesContainerName = "dikastes"
+
+// dikastesPortName is the Service port used for dikastes if there is more than one.
+const dikastesPortName = "grpc"
+
+var errDiscoveryNotSynced = errors.New("dikastes discovery not yet synced")
+
+// dikastes resolves where a node's dikastes is listening, so that the authz cluster can be added to CDS.  It is nil
+// unless --dikastes-discovery is set, in which case CDS is passed through and the cluster must be configured some other
+// way.
+var dikastes dikastesResolver
+
+type dikastesResolver interface {
+	// address returns the host URL of dikastes for the node with the given IP, e.g. tcp://10.0.0.1:9000.
+	address(ip string) (string, error)
+	check() error
+}
+
+// serviceResolver points every node at a Service in front of dikastes.
+type serviceResolver struct {
+	key    string
+	store  cache.Store
+	synced cache.InformerSynced
+}
+
+func newServiceResolver(key string, informer cache.SharedIndexInformer) *serviceResolver {
+	return &serviceResolver{key: key, store: informer.GetStore(), synced: informer.HasSynced}
+}
+
+func (s *serviceResolver) address(ip string) (string, error) {
+	obj, ok, err := s.store.GetByKey(s.key)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("service %s not found", s.key)
+	}
+	svc := obj.(*v1.Service)
+	if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == "None" {
+		return "", fmt.Errorf("service %s has no cluster IP", s.key)
+	}
+	if len(svc.Spec.Ports) == 0 {
+		return "", fmt.Errorf("service %s has no ports", s.key)
+	}
+	port := svc.Spec.Ports[0].Port
+	for _, p := range svc.Spec.Ports {
+		if p.Name == dikastesPortName {
+			port = p.Port
+		}
+	}
+	return fmt.Sprintf("tcp://%s:%d", svc.Spec.ClusterIP, port), nil
+}
+
+func (s *serviceResolver) check() error {
+	if !s.synced() {
+		return errDiscoveryNotSynced
+	}
+	return nil
+}
+
+// daemonSetResolver points each node at the dikastes on its own host, as deployed by the calico-node DaemonSet.  If
+// the dikastes container has a host port the node is given its host's IP and that port; otherwise dikastes is
+// assumed to be reachable on the socket mounted into the pod.
+type daemonSetResolver struct {
+	key    string
+	socket string
+	store  cache.Store
+	synced cache.InformerSynced
+	pods   *podIndex
+}
+
+func newDaemonSetResolver(key, socket string, informer cache.SharedIndexInformer, pods *podIndex) *daemonSetResolver {
+	return &daemonSetResolver{key: key, socket: socket, store: informer.GetStore(), synced: informer.HasSynced, pods: pods}
+}
+
+func (d *daemonSetResolver) address(ip string) (string, error) {
+	obj, ok, err := d.store.GetByKey(d.key)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("daemonset %s not found", d.key)
+	}
+	ds := obj.(*appsv1.DaemonSet)
+	var hostPort int32
+	found := false
+	for _, c := range ds.Spec.Template.Spec.Containers {
+		if c.Name != dikastesContainerName {
+			continue
+		}
+		found = true
+		for _, p := range c.Ports {
+			if p.HostPort != 0 {
+				hostPort = p.HostPort
+			}
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("daemonset %s has no %s container", d.key, dikastesContainerName)
+	}
+	if hostPort == 0 {
+		return "unix://" + d.socket, nil
+	}
+	pod, err := d.pods.byIP(ip)
+	if err != nil {
+		return "", err
+	}
+	if pod == nil || pod.Status.HostIP == "" {
+		return "", fmt.Errorf("no host IP known for pod %s", ip)
+	}
+	return fmt.Sprintf("tcp://%s:%d", pod.Status.HostIP, hostPort), nil
+}
+
+func (d *daemonSetResolver) check() error {
+	if !d.synced() {
+		return errDiscoveryNotSynced
+	}
+	return nil
+}
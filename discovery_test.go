@@ -0,0 +1,124 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+func TestServiceResolver(t *testing.T) {
+	RegisterTestingT(t)
+
+	store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, nil)
+	r := &serviceResolver{key: "kube-system/dikastes", store: store, synced: func() bool { return true }}
+	_, err := r.address(NODE_IP)
+	Expect(err).NotTo(BeNil())
+
+	store.Add(&v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "dikastes", Namespace: "kube-system"},
+		Spec: v1.ServiceSpec{ClusterIP: "10.96.0.20", Ports: []v1.ServicePort{
+			{Name: "metrics", Port: 9091},
+			{Name: "grpc", Port: 9000},
+		}},
+	})
+	Expect(r.address(NODE_IP)).To(Equal("tcp://10.96.0.20:9000"))
+}
+
+func TestDaemonSetResolver(t *testing.T) {
+	RegisterTestingT(t)
+
+	pod := testPod("testpod", NODE_IP, v1.PodRunning, nil)
+	pod.Status.HostIP = "192.168.0.5"
+	store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, nil)
+	r := &daemonSetResolver{
+		key:    "kube-system/calico-node",
+		socket: "/var/run/dikastes/dikastes.sock",
+		store:  store,
+		synced: func() bool { return true },
+		pods:   newTestPodIndex(pod),
+	}
+	ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "calico-node", Namespace: "kube-system"}}
+	ds.Spec.Template.Spec.Containers = []v1.Container{{Name: "calico-node"}}
+	store.Add(ds)
+	_, err := r.address(NODE_IP)
+	Expect(err).NotTo(BeNil())
+
+	ds.Spec.Template.Spec.Containers = append(ds.Spec.Template.Spec.Containers, v1.Container{Name: "dikastes"})
+	Expect(r.address(NODE_IP)).To(Equal("unix:///var/run/dikastes/dikastes.sock"))
+
+	ds.Spec.Template.Spec.Containers[1].Ports = []v1.ContainerPort{{ContainerPort: 9000, HostPort: 9000}}
+	Expect(r.address(NODE_IP)).To(Equal("tcp://192.168.0.5:9000"))
+	_, err = r.address("9.9.9.9")
+	Expect(err).NotTo(BeNil())
+}
+
+func TestClustersAddsAuthzCluster(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { dikastes = nil }()
+	dikastes = staticResolver("tcp://10.96.0.20:9000")
+
+	body := `{"clusters": [{"name": "in.80", "connect_timeout_ms": 1000, "type": "static", "lb_type": "round_robin"}]}`
+	req := newCDSRequest("sidecar", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	clusters(req, restful.NewResponse(rec))
+	Expect(rec.Code).To(Equal(http.StatusOK))
+	Expect(statsFor(req).ClustersAdded).To(Equal(1))
+
+	var cds cdsResponse
+	Expect(json.Unmarshal(rec.Body.Bytes(), &cds)).To(Succeed())
+	Expect(cds.Clusters).To(HaveLen(2))
+	Expect(cds.Clusters[1].Name).To(Equal(AuthZClusterName))
+	Expect(cds.Clusters[1].Hosts[0].URL).To(Equal("tcp://10.96.0.20:9000"))
+
+	// Adding it again changes nothing.
+	raw, err := mutator.DecodeXDS(rec.Body.Bytes(), "clusters")
+	Expect(err).To(BeNil())
+	items, changed, err := mutator.UpsertAuthzCluster(raw.Items, AuthZClusterName, "tcp://10.96.0.20:9000")
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeFalse())
+	items, changed, err = mutator.UpsertAuthzCluster(items, AuthZClusterName, "tcp://10.96.0.21:9000")
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeTrue())
+	Expect(items).To(HaveLen(2))
+}
+
+func TestClustersDryRun(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { dikastes = nil }()
+	dikastes = staticResolver("tcp://10.96.0.20:9000")
+
+	body := `{"clusters": []}`
+	req := newCDSRequest("sidecar", strings.NewReader(body))
+	req.Request.Header.Set(DryRunHeader, "true")
+	rec := httptest.NewRecorder()
+	clusters(req, restful.NewResponse(rec))
+	Expect(rec.Body.String()).To(Equal(body))
+	Expect(rec.Header().Get(DryRunChangesHeader)).To(Equal("cluster/" + AuthZClusterName))
+}
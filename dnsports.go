@@ -0,0 +1,92 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+var errDNSServiceNotSynced = errors.New("DNS service not yet synced")
+
+// dnsPorts holds the ports of the cluster DNS service, so that DNS over TCP is never sent through a fail closed authz
+// filter.  It is nil unless --exclude-dns-ports is set.
+var dnsPorts *dnsPortSet
+
+// dnsPortSet reads the TCP ports of a Service, kube-dns by default, which CoreDNS deployments keep the name of.
+type dnsPortSet struct {
+	key    string
+	store  cache.Store
+	synced cache.InformerSynced
+}
+
+func newDNSPortSet(key string, informer cache.SharedIndexInformer) *dnsPortSet {
+	s := &dnsPortSet{key: key, store: informer.GetStore(), synced: informer.HasSynced}
+	// Cached responses were mutated with the old ports.
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    s.changed,
+		UpdateFunc: func(_, obj interface{}) { s.changed(obj) },
+		DeleteFunc: s.changed,
+	})
+	return s
+}
+
+// changed bumps the config version when the DNS service is changed.
+func (s *dnsPortSet) changed(obj interface{}) {
+	if key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj); err == nil && key == s.key {
+		bumpConfigVersion("dns-ports")
+	}
+}
+
+// excluded reports whether port is one of the service's TCP ports or numeric target ports.  Inbound listeners are on
+// the pod's port, which is the target port.
+func (d *dnsPortSet) excluded(port int) bool {
+	obj, ok, err := d.store.GetByKey(d.key)
+	if err != nil || !ok {
+		return false
+	}
+	for _, p := range obj.(*corev1.Service).Spec.Ports {
+		if p.Protocol != "" && p.Protocol != corev1.ProtocolTCP {
+			continue
+		}
+		if int(p.Port) == port || p.TargetPort.Type == intstr.Int && int(p.TargetPort.IntVal) == port {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *dnsPortSet) check() error {
+	if !d.synced() {
+		return errDNSServiceNotSynced
+	}
+	return nil
+}
+
+// listenerPort returns the port a listener binds, from its tcp://<ip>:<port> address or else the end of its name.
+func listenerPort(listener *v1.Listener) int {
+	p, ok := mutator.ListenerPort(listener.Name, listener.Address)
+	if !ok && debugEnabled() {
+		log.WithField("name", listener.Name).Debug("Unable to find listener port")
+	}
+	return p
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestListenerPort(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(listenerPort(&v1.Listener{Address: "tcp://1.2.3.4:5353", Name: "tcp_1.2.3.4_80"})).To(Equal(5353))
+	Expect(listenerPort(&v1.Listener{Name: "tcp_1.2.3.4_53"})).To(Equal(53))
+	Expect(listenerPort(&v1.Listener{Name: "virtual"})).To(Equal(0))
+}
+
+func TestDNSPortsExcluded(t *testing.T) {
+	RegisterTestingT(t)
+
+	store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, nil)
+	defer func() { dnsPorts = nil }()
+	dnsPorts = &dnsPortSet{key: "kube-system/kube-dns", store: store, synced: func() bool { return true }}
+	store.Add(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-dns", Namespace: "kube-system"},
+		Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+			{Name: "dns", Protocol: corev1.ProtocolUDP, Port: 53, TargetPort: intstr.FromInt(5300)},
+			{Name: "dns-tcp", Protocol: corev1.ProtocolTCP, Port: 53, TargetPort: intstr.FromInt(5353)},
+		}},
+	})
+	Expect(dnsPorts.excluded(53)).To(BeTrue())
+	Expect(dnsPorts.excluded(5353)).To(BeTrue())
+	Expect(dnsPorts.excluded(5300)).To(BeFalse())
+
+	dns := v1.Listener{Name: "tcp_1.2.3.4_5353", Filters: []*v1.NetworkFilter{{Name: v1.TCPProxyFilter}}}
+	updateListener(&dns, "1.2.3.4")
+	Expect(dns.Filters).To(HaveLen(1))
+
+	other := v1.Listener{Name: "tcp_1.2.3.4_3306", Filters: []*v1.NetworkFilter{{Name: v1.TCPProxyFilter}}}
+	updateListener(&other, "1.2.3.4")
+	Expect(other.Filters).To(HaveLen(2))
+}
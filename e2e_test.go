@@ -0,0 +1,125 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pilottest"
+	"github.com/projectcalico/pilot-webhook/pkg/testutil"
+)
+
+// startHookServer serves the hooks on a socket in a new directory, as main does, and returns a Pilot for it.
+func startHookServer(t *testing.T) (*pilottest.Pilot, func()) {
+	dir, err := ioutil.TempDir("", "e2e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "webhook.sock")
+	lis := withSocketBuffers(openSocket(socket), 1<<20, 1<<20)
+	server := newHookServer()
+	go server.Serve(lis)
+	return pilottest.New(socket), func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// expectMutatedPush checks a push to a sidecar: the authz filter is on exactly its inbound listeners, the authz
+// cluster is added, and routes and endpoints are passed through.
+func expectMutatedPush(s *pilottest.Sidecar, push *pilottest.Push) {
+	Expect(push.Listeners.Status).To(Equal(http.StatusOK))
+	inbound, err := testutil.CheckMutatedLDS(push.Listeners.Body, s.IP)
+	Expect(err).To(BeNil())
+	Expect(inbound).To(Equal(len(s.HTTPPorts) + len(s.TCPPorts)))
+
+	Expect(push.Clusters.Status).To(Equal(http.StatusOK))
+	Expect(testutil.CheckAuthzCluster(push.Clusters.Body)).To(Succeed())
+
+	for rc, resp := range push.Routes {
+		Expect(resp.Status).To(Equal(http.StatusOK))
+		Expect(resp.Body).To(Equal(s.RDS(rc)), rc)
+	}
+	for _, svc := range s.Mesh {
+		resp := push.Endpoints[svc.Key()]
+		Expect(resp.Status).To(Equal(http.StatusOK))
+		Expect(resp.Body).To(Equal(svc.EDS()), svc.Key())
+	}
+}
+
+func TestEndToEnd(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { dikastes = nil }()
+	dikastes = staticResolver("tcp://10.96.0.20:9000")
+	pilot, stop := startHookServer(t)
+	defer stop()
+
+	mesh := pilottest.NewMesh(40)
+	for mode, setup := range benchModes("listeners") {
+		setup()
+		// Sidecars connect at once, and Pilot then pushes to each again with its config unchanged.
+		sidecars := make([]*pilottest.Sidecar, 8)
+		pushes := make([][2]*pilottest.Push, len(sidecars))
+		errs := make([]error, len(sidecars))
+		var wg sync.WaitGroup
+		for i := range sidecars {
+			sidecars[i] = pilottest.NewSidecar(fmt.Sprintf("10.2.0.%d", i+1), mesh)
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if pushes[i][0], errs[i] = pilot.Push(sidecars[i]); errs[i] == nil {
+					pushes[i][1], errs[i] = pilot.Push(sidecars[i])
+				}
+			}(i)
+		}
+		wg.Wait()
+		for i, s := range sidecars {
+			Expect(errs[i]).To(BeNil(), mode)
+			expectMutatedPush(s, pushes[i][0])
+			Expect(pushes[i][1].Listeners.Body).To(Equal(pushes[i][0].Listeners.Body), mode)
+			Expect(pushes[i][1].Clusters.Body).To(Equal(pushes[i][0].Clusters.Body), mode)
+		}
+		resetBenchMode()
+	}
+}
+
+func TestEndToEndBadRequests(t *testing.T) {
+	RegisterTestingT(t)
+
+	pilot, stop := startHookServer(t)
+	defer stop()
+
+	s := pilottest.NewSidecar("10.2.0.1", pilottest.NewMesh(1))
+	resp, err := pilot.Listeners(s.Node(), []byte(`{"listeners":[`))
+	Expect(err).To(BeNil())
+	Expect(resp.Status).To(Equal(http.StatusBadRequest))
+
+	// Nodes that are not sidecars, or whose IDs are malformed, are passed through.
+	for _, node := range []string{"router~10.2.0.1~gw.istio-system~istio-system.svc.cluster.local", "sidecar"} {
+		resp, err = pilot.Listeners(node, s.LDS())
+		Expect(err).To(BeNil())
+		Expect(resp.Status).To(Equal(http.StatusOK))
+		Expect(resp.Body).To(Equal(s.LDS()), node)
+	}
+}
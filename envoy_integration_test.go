@@ -0,0 +1,200 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build envoy
+// +build envoy
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+)
+
+// envoyRunCommand runs Envoy with a config, as -envoy-command validates one.  It must share the host's network, so
+// that Envoy and the test can reach each other on 127.0.0.1.
+var envoyRunCommand = flag.String("envoy-run-command", "envoy -c {config}",
+	"command that runs Envoy with the config in {config}")
+
+// envoyAdmin is the admin address envoyBootstrap configures.
+const envoyAdmin = "http://127.0.0.1:15000"
+
+// integrationNode is the sidecar the integration test mutates config for.  Its IP is loopback, so that Envoy's
+// inbound listener can bind to it.
+const integrationNode = "sidecar~127.0.0.1~integration.default~default.svc.cluster.local"
+
+// rawCodec passes gRPC messages through as bytes, so that the fake authz server needs no generated code.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error)      { return *(v.(*[]byte)), nil }
+func (rawCodec) Unmarshal(data []byte, v interface{}) error { *(v.(*[]byte)) = data; return nil }
+func (rawCodec) String() string                             { return "raw" }
+
+// Encoded ext_authz CheckResponses: an OK status, and PERMISSION_DENIED.
+var (
+	checkAllowed = []byte{}
+	checkDenied  = []byte{0x0a, 0x02, 0x08, 0x07}
+)
+
+// fakeAuthz is an ext_authz server that denies requests whose CheckRequest mentions its deny string, e.g. in the
+// HTTP path, and allows the rest.
+type fakeAuthz struct {
+	deny   []byte
+	checks int32
+}
+
+func (f *fakeAuthz) handle(srv interface{}, stream grpc.ServerStream) error {
+	var req []byte
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	atomic.AddInt32(&f.checks, 1)
+	resp := checkAllowed
+	if bytes.Contains(req, f.deny) {
+		resp = checkDenied
+	}
+	return stream.SendMsg(&resp)
+}
+
+// freePort returns a loopback port that nothing is listening on.
+func freePort() (int, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port, nil
+}
+
+// integrationXDS returns the LDS and CDS Pilot would send the integration sidecar: an inbound HTTP listener on port,
+// routed to the upstream.
+func integrationXDS(port int, upstream string) (lds, cds string) {
+	cluster := fmt.Sprintf("in.%d", port)
+	lds = fmt.Sprintf(`{"listeners": [
+  {"name": "http_127.0.0.1_%[1]d", "address": "tcp://127.0.0.1:%[1]d", "bind_to_port": true, "filters": [
+    {"type": "read", "name": "http_connection_manager", "config": {
+      "codec_type": "auto", "stat_prefix": "http",
+      "route_config": {"virtual_hosts": [
+        {"name": "inbound", "domains": ["*"], "routes": [{"prefix": "/", "cluster": "%[2]s"}]}]},
+      "filters": [{"type": "decoder", "name": "router", "config": {}}]}}]}
+]}`, port, cluster)
+	cds = fmt.Sprintf(`{"clusters": [
+  {"name": "%s", "connect_timeout_ms": 1000, "type": "static", "lb_type": "round_robin",
+   "hosts": [{"url": "tcp://%s"}]}
+]}`, cluster, upstream)
+	return lds, cds
+}
+
+// startEnvoy runs Envoy with the config until the returned stop func is called.
+func startEnvoy(config []byte) (stop func(), err error) {
+	dir, args, err := writeEnvoyConfig(config, *envoyRunCommand)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return func() {
+		// docker run passes the signal on to Envoy.
+		cmd.Process.Signal(syscall.SIGTERM)
+		cmd.Wait()
+		os.RemoveAll(dir)
+	}, nil
+}
+
+func httpGet(url string) (int, string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), err
+}
+
+// TestEnvoyIntegration runs a real Envoy with a sidecar's mutated listeners and clusters, and checks that it goes live
+// and asks dikastes, played by a fake authz server, whether to allow each request.  This catches config that
+// validates but that Envoy rejects or misapplies once running.
+func TestEnvoyIntegration(t *testing.T) {
+	RegisterTestingT(t)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "upstream")
+	}))
+	defer upstream.Close()
+
+	authzLis, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	authz := &fakeAuthz{deny: []byte("/denied")}
+	server := grpc.NewServer(grpc.CustomCodec(rawCodec{}), grpc.UnknownServiceHandler(authz.handle))
+	go server.Serve(authzLis)
+	defer server.Stop()
+
+	defer func() { dikastes = nil }()
+	dikastes = staticResolver("tcp://" + authzLis.Addr().String())
+	port, err := freePort()
+	Expect(err).To(BeNil())
+	ldsIn, cdsIn := integrationXDS(port, strings.TrimPrefix(upstream.URL, "http://"))
+	lds := callHook("listeners", listeners, SERVICE_CLUSTER, integrationNode, strings.NewReader(ldsIn))
+	cds := callHook("clusters", clusters, SERVICE_CLUSTER, integrationNode, strings.NewReader(cdsIn))
+	Expect(lds.Code).To(Equal(http.StatusOK))
+	Expect(cds.Code).To(Equal(http.StatusOK))
+	Expect(lds.Body.String()).To(ContainSubstring(AuthZFilterName))
+
+	config, err := envoyBootstrap(lds.Body.Bytes(), cds.Body.Bytes())
+	Expect(err).To(BeNil())
+	stop, err := startEnvoy(config)
+	Expect(err).To(BeNil())
+	defer stop()
+
+	// Envoy reports itself live once its listeners and clusters are initialized.
+	Eventually(func() string {
+		_, info, _ := httpGet(envoyAdmin + "/server_info")
+		return info
+	}, time.Minute, time.Second).Should(ContainSubstring(" live "))
+
+	listener := fmt.Sprintf("http://127.0.0.1:%d", port)
+	Eventually(func() error {
+		code, body, err := httpGet(listener + "/allowed")
+		if err != nil {
+			return err
+		}
+		if code != http.StatusOK || body != "upstream" {
+			return fmt.Errorf("status %d: %s", code, body)
+		}
+		return nil
+	}, 10*time.Second, 100*time.Millisecond).Should(Succeed())
+	code, _, err := httpGet(listener + "/denied")
+	Expect(err).To(BeNil())
+	Expect(code).To(Equal(http.StatusForbidden))
+	Expect(atomic.LoadInt32(&authz.checks)).To(BeNumerically(">=", 2))
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build envoy
+// +build envoy
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pilottest"
+)
+
+// envoyCommand validates an Envoy config.  {config} is replaced by the config file's path and {dir} by its directory,
+// e.g. to mount it into a container.  The sidecars' config has Istio's filters, so Istio's build of Envoy is needed.
+var envoyCommand = flag.String("envoy-command", "envoy --mode validate -c {config}",
+	"command that validates the Envoy config in {config}")
+
+// envoyBootstrap is a v1 static config holding a sidecar's mutated listeners and clusters, as if they had been
+// fetched by LDS and CDS.
+func envoyBootstrap(listeners, clusters []byte) ([]byte, error) {
+	var lds struct {
+		Listeners []json.RawMessage `json:"listeners"`
+	}
+	var cds struct {
+		Clusters []json.RawMessage `json:"clusters"`
+	}
+	if err := json.Unmarshal(listeners, &lds); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(clusters, &cds); err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"listeners": lds.Listeners,
+		"admin":     map[string]interface{}{"access_log_path": "/dev/null", "address": "tcp://127.0.0.1:15000"},
+		"cluster_manager": map[string]interface{}{
+			"clusters": cds.Clusters,
+			// The outbound clusters are discovered by SDS, which is Pilot.
+			"sds": map[string]interface{}{
+				"cluster": map[string]interface{}{
+					"name": "sds", "connect_timeout_ms": 1000, "type": "strict_dns", "lb_type": "round_robin",
+					"hosts": []map[string]string{{"url": "tcp://istio-pilot.istio-system:15003"}},
+				},
+				"refresh_delay_ms": 1000,
+			},
+		},
+	})
+}
+
+// writeEnvoyConfig writes an Envoy config to a new temporary directory, and returns the command line for it from a
+// command template such as -envoy-command.
+func writeEnvoyConfig(config []byte, command string) (dir string, args []string, err error) {
+	dir, err = ioutil.TempDir("", "envoy")
+	if err != nil {
+		return "", nil, err
+	}
+	path := filepath.Join(dir, "envoy.json")
+	if err := ioutil.WriteFile(path, config, 0644); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+	return dir, strings.Fields(strings.NewReplacer("{config}", path, "{dir}", dir).Replace(command)), nil
+}
+
+func validateEnvoyConfig(config []byte) error {
+	dir, args, err := writeEnvoyConfig(config, *envoyCommand)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	return nil
+}
+
+// TestEnvoyAcceptsMutatedConfig checks that Envoy accepts a sidecar's listeners and clusters once mutated, in each
+// mutation mode.
+func TestEnvoyAcceptsMutatedConfig(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { dikastes = nil }()
+	dikastes = staticResolver("tcp://10.96.0.20:9000")
+	s := pilottest.NewSidecar("10.2.0.1", pilottest.NewMesh(20))
+	for mode, setup := range benchModes("listeners") {
+		setup()
+		lds := callHook("listeners", listeners, SERVICE_CLUSTER, s.Node(), bytes.NewReader(s.LDS()))
+		cds := callHook("clusters", clusters, SERVICE_CLUSTER, s.Node(), bytes.NewReader(s.CDS()))
+		resetBenchMode()
+		Expect(lds.Code).To(Equal(http.StatusOK), mode)
+		Expect(cds.Code).To(Equal(http.StatusOK), mode)
+		Expect(lds.Body.String()).To(ContainSubstring(AuthZFilterName), mode)
+
+		config, err := envoyBootstrap(lds.Body.Bytes(), cds.Body.Bytes())
+		Expect(err).To(BeNil())
+		Expect(validateEnvoyConfig(config)).To(Succeed(), mode)
+	}
+}
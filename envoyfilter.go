@@ -0,0 +1,202 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/rest"
+
+	"github.com/projectcalico/pilot-webhook/pkg/extauthz"
+	extauthzv2 "github.com/projectcalico/pilot-webhook/pkg/extauthz/v2"
+)
+
+const envoyFilterResyncInterval = time.Minute
+
+// The EnvoyFilter types mirror the networking.istio.io/v1alpha3 resource closely enough to generate it, without
+// depending on the Istio release that defines it.
+
+type envoyFilter struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Metadata   envoyFilterMeta `json:"metadata"`
+	Spec       envoyFilterSpec `json:"spec"`
+}
+
+type envoyFilterMeta struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type envoyFilterSpec struct {
+	WorkloadLabels map[string]string  `json:"workloadLabels,omitempty"`
+	Filters        []envoyFilterPatch `json:"filters"`
+}
+
+type envoyFilterPatch struct {
+	ListenerMatch  listenerMatch  `json:"listenerMatch"`
+	InsertPosition insertPosition `json:"insertPosition"`
+	FilterType     string         `json:"filterType"`
+	FilterName     string         `json:"filterName"`
+	FilterConfig   interface{}    `json:"filterConfig"`
+}
+
+type listenerMatch struct {
+	ListenerType     string `json:"listenerType"`
+	ListenerProtocol string `json:"listenerProtocol"`
+}
+
+type insertPosition struct {
+	Index string `json:"index"`
+}
+
+// generateEnvoyFilter returns an EnvoyFilter that makes the same change as the LDS hook: the authz filter first on
+// every inbound HTTP and TCP listener.  EnvoyFilters cannot add clusters, so the filter talks gRPC to the dikastes
+// socket directly instead of through the authz cluster.
+func generateEnvoyFilter(name, namespace, socket string, workloadLabels map[string]string) envoyFilter {
+	opts := extauthz.Options{FilterName: AuthZFilterName, TargetURI: "unix:" + socket}
+	return envoyFilter{
+		APIVersion: "networking.istio.io/v1alpha3",
+		Kind:       "EnvoyFilter",
+		Metadata:   envoyFilterMeta{Name: name, Namespace: namespace},
+		Spec: envoyFilterSpec{
+			WorkloadLabels: workloadLabels,
+			Filters: []envoyFilterPatch{
+				{
+					ListenerMatch:  listenerMatch{ListenerType: "SIDECAR_INBOUND", ListenerProtocol: "HTTP"},
+					InsertPosition: insertPosition{Index: "FIRST"},
+					FilterType:     "HTTP",
+					FilterName:     AuthZFilterName,
+					FilterConfig:   extauthzv2.HTTPFilter(opts).Config,
+				},
+				{
+					ListenerMatch:  listenerMatch{ListenerType: "SIDECAR_INBOUND", ListenerProtocol: "TCP"},
+					InsertPosition: insertPosition{Index: "FIRST"},
+					FilterType:     "NETWORK",
+					FilterName:     AuthZFilterName,
+					FilterConfig:   extauthzv2.NetworkFilter(opts).Config,
+				},
+			},
+		},
+	}
+}
+
+// writeEnvoyFilter writes the EnvoyFilter as JSON, which kubectl apply accepts as well as YAML.
+func writeEnvoyFilter(w io.Writer, f envoyFilter) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// envoyFilterStore reads and writes EnvoyFilters, so that syncing can be tested without an API server.
+type envoyFilterStore interface {
+	// get returns the EnvoyFilter, or nil if there is none.
+	get(namespace, name string) (*envoyFilter, error)
+	create(f envoyFilter) error
+	update(f envoyFilter) error
+}
+
+// applyEnvoyFilter creates the EnvoyFilter, or updates it if its spec differs from the generated one.  It returns
+// whether anything was written.
+func applyEnvoyFilter(store envoyFilterStore, f envoyFilter) (bool, error) {
+	existing, err := store.get(f.Metadata.Namespace, f.Metadata.Name)
+	if err != nil {
+		return false, err
+	}
+	if existing == nil {
+		return true, store.create(f)
+	}
+	// Compare as JSON, which has the same form whichever side was decoded.
+	want, _ := json.Marshal(f.Spec)
+	have, _ := json.Marshal(existing.Spec)
+	if bytes.Equal(want, have) {
+		return false, nil
+	}
+	f.Metadata.ResourceVersion = existing.Metadata.ResourceVersion
+	return true, store.update(f)
+}
+
+// syncEnvoyFilter keeps the EnvoyFilter applied, putting it back if it is changed or deleted, until stop is closed.
+func syncEnvoyFilter(store envoyFilterStore, f envoyFilter, stop <-chan struct{}) {
+	ticker := time.NewTicker(envoyFilterResyncInterval)
+	defer ticker.Stop()
+	for {
+		fields := log.Fields{"namespace": f.Metadata.Namespace, "name": f.Metadata.Name}
+		if changed, err := applyEnvoyFilter(store, f); err != nil {
+			fields["err"] = err
+			errorLog.Error(fields, "failed to apply EnvoyFilter")
+		} else if changed {
+			log.WithFields(fields).Info("Applied EnvoyFilter.")
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// restEnvoyFilterStore stores EnvoyFilters through the Kubernetes API.  There is no typed client for Istio's
+// resources, so it uses the core REST client with absolute paths.
+type restEnvoyFilterStore struct {
+	client rest.Interface
+}
+
+func envoyFilterPath(namespace string, name ...string) string {
+	p := "/apis/networking.istio.io/v1alpha3/namespaces/" + namespace + "/envoyfilters"
+	for _, n := range name {
+		p += "/" + n
+	}
+	return p
+}
+
+func (s restEnvoyFilterStore) get(namespace, name string) (*envoyFilter, error) {
+	raw, err := s.client.Get().AbsPath(envoyFilterPath(namespace, name)).Do().Raw()
+	if errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var f envoyFilter
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (s restEnvoyFilterStore) create(f envoyFilter) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return s.client.Post().AbsPath(envoyFilterPath(f.Metadata.Namespace)).Body(b).Do().Error()
+}
+
+func (s restEnvoyFilterStore) update(f envoyFilter) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return s.client.Put().AbsPath(envoyFilterPath(f.Metadata.Namespace, f.Metadata.Name)).Body(b).Do().Error()
+}
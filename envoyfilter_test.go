@@ -0,0 +1,92 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestGenerateEnvoyFilter(t *testing.T) {
+	RegisterTestingT(t)
+
+	var b bytes.Buffer
+	f := generateEnvoyFilter("calico-authz", "istio-system", "/var/run/dikastes/dikastes.sock", map[string]string{"app": "web"})
+	Expect(writeEnvoyFilter(&b, f)).To(Succeed())
+	grpc := `{"google_grpc": {"target_uri": "unix:/var/run/dikastes/dikastes.sock", "stat_prefix": "envoy.ext_authz"}}`
+	Expect(b.String()).To(MatchJSON(`{
+	  "apiVersion": "networking.istio.io/v1alpha3",
+	  "kind": "EnvoyFilter",
+	  "metadata": {"name": "calico-authz", "namespace": "istio-system"},
+	  "spec": {
+	    "workloadLabels": {"app": "web"},
+	    "filters": [
+	      {"listenerMatch": {"listenerType": "SIDECAR_INBOUND", "listenerProtocol": "HTTP"},
+	       "insertPosition": {"index": "FIRST"}, "filterType": "HTTP", "filterName": "envoy.ext_authz",
+	       "filterConfig": {"grpc_service": ` + grpc + `}},
+	      {"listenerMatch": {"listenerType": "SIDECAR_INBOUND", "listenerProtocol": "TCP"},
+	       "insertPosition": {"index": "FIRST"}, "filterType": "NETWORK", "filterName": "envoy.ext_authz",
+	       "filterConfig": {"stat_prefix": "envoy.ext_authz", "grpc_service": ` + grpc + `}}
+	    ]
+	  }
+	}`))
+}
+
+type fakeEnvoyFilterStore struct {
+	filters map[string]envoyFilter
+	writes  int
+}
+
+func (s *fakeEnvoyFilterStore) get(namespace, name string) (*envoyFilter, error) {
+	f, ok := s.filters[namespace+"/"+name]
+	if !ok {
+		return nil, nil
+	}
+	return &f, nil
+}
+
+func (s *fakeEnvoyFilterStore) create(f envoyFilter) error {
+	s.writes++
+	f.Metadata.ResourceVersion = "1"
+	s.filters[f.Metadata.Namespace+"/"+f.Metadata.Name] = f
+	return nil
+}
+
+func (s *fakeEnvoyFilterStore) update(f envoyFilter) error {
+	s.writes++
+	s.filters[f.Metadata.Namespace+"/"+f.Metadata.Name] = f
+	return nil
+}
+
+func TestApplyEnvoyFilter(t *testing.T) {
+	RegisterTestingT(t)
+
+	store := &fakeEnvoyFilterStore{filters: map[string]envoyFilter{}}
+	f := generateEnvoyFilter("calico-authz", "istio-system", "/var/run/dikastes/dikastes.sock", nil)
+	changed, err := applyEnvoyFilter(store, f)
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeTrue())
+
+	changed, _ = applyEnvoyFilter(store, f)
+	Expect(changed).To(BeFalse())
+	Expect(store.writes).To(Equal(1))
+
+	f = generateEnvoyFilter("calico-authz", "istio-system", "/var/run/dikastes/other.sock", nil)
+	changed, _ = applyEnvoyFilter(store, f)
+	Expect(changed).To(BeTrue())
+	Expect(store.filters["istio-system/calico-authz"].Metadata.ResourceVersion).To(Equal("1"))
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// errorClass distinguishes bad input from Pilot from failures in the webhook itself, so they can be alerted on
+// separately.
+type errorClass string
+
+const (
+	// ErrorClassRead is a failure reading the request body.
+	ErrorClassRead errorClass = "read"
+	// ErrorClassParse is a request body that is not valid xDS JSON.
+	ErrorClassParse errorClass = "parse"
+	// ErrorClassValidation is well-formed xDS that is inconsistent, e.g. an HTTP listener with no connection manager.
+	ErrorClassValidation errorClass = "validation"
+	// ErrorClassEncode is a failure encoding the mutated response, which indicates a webhook bug.
+	ErrorClassEncode errorClass = "encode"
+	// ErrorClassSchema is a mutated response that does not match the xDS schema, which also indicates a webhook bug.
+	ErrorClassSchema errorClass = "schema"
+	// ErrorClassWrite is a failure writing the response back to Pilot.
+	ErrorClassWrite errorClass = "write"
+	// ErrorClassTooLarge is a request body over --max-payload-bytes.
+	ErrorClassTooLarge errorClass = "too_large"
+	// ErrorClassLookup is a failure looking up workload state needed to decide how to mutate.
+	ErrorClassLookup errorClass = "lookup"
+	// ErrorClassMutator is a failure in a registered mutator.
+	ErrorClassMutator errorClass = "mutator"
+	// ErrorClassAuth is a hook request without a valid bearer token.
+	ErrorClassAuth errorClass = "auth"
+)
+
+var hookErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "errors_total",
+	Help:      "Errors handling hook requests, by hook and error class.",
+}, []string{"hook", "class"})
+
+func init() {
+	prometheus.MustRegister(hookErrors)
+}
+
+// reportError counts a classified hook error and logs it, subject to rate limiting.  It returns whether the error was
+// logged.
+func reportError(hook string, class errorClass, fields log.Fields, msg string) bool {
+	hookErrors.WithLabelValues(hook, string(class)).Inc()
+	fields["hook"] = hook
+	fields["errorClass"] = class
+	return errorLog.Error(fields, msg)
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+)
+
+func TestErrorClasses(t *testing.T) {
+	RegisterTestingT(t)
+
+	parse := hookErrors.WithLabelValues("listeners", string(ErrorClassParse))
+	validation := hookErrors.WithLabelValues("listeners", string(ErrorClassValidation))
+	parseBefore := testutil.ToFloat64(parse)
+	validationBefore := testutil.ToFloat64(validation)
+
+	listeners(newLDSRequest("sidecar", strings.NewReader("not JSON")), restful.NewResponse(httptest.NewRecorder()))
+	Expect(testutil.ToFloat64(parse)).To(Equal(parseBefore + 1))
+
+	ldsBytes, err := json.Marshal(ldsResponse{Listeners: []*v1.Listener{{Name: "http_" + NODE_IP + "_80"}}})
+	Expect(err).To(BeNil())
+	listeners(newLDSRequest("sidecar", bytes.NewReader(ldsBytes)), restful.NewResponse(httptest.NewRecorder()))
+	Expect(testutil.ToFloat64(validation)).To(Equal(validationBefore + 1))
+	Expect(testutil.ToFloat64(parse)).To(Equal(parseBefore + 1))
+}
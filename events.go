@@ -0,0 +1,59 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const eventComponent = "calico-pilot-webhook"
+
+// Event reasons for mutation failures.
+const (
+	ReasonFailedDecode   = "FailedDecode"
+	ReasonFailedMutation = "FailedMutation"
+	ReasonFailedEncode   = "FailedEncode"
+)
+
+// events records Kubernetes Events against workloads.  It is nil unless --kube-events is set.
+var events record.EventRecorder
+
+// newEventRecorder returns a recorder that sends events to the API server.  The broadcaster aggregates and rate limits
+// repeated events itself.
+func newEventRecorder(client kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: eventComponent})
+}
+
+// emitFailureEvent records a Warning event against the pod identified by serviceNode, so that mutation failures show
+// up in `kubectl describe` for the affected workload.
+func emitFailureEvent(serviceNode, reason, message string) {
+	if events == nil {
+		return
+	}
+	name, namespace, ok := podFromServiceNode(serviceNode)
+	if !ok {
+		log.WithField("serviceNode", serviceNode).Debug("Unable to determine pod for event")
+		return
+	}
+	ref := &v1.ObjectReference{Kind: "Pod", APIVersion: "v1", Name: name, Namespace: namespace}
+	events.Event(ref, v1.EventTypeWarning, reason, message)
+}
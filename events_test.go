@@ -0,0 +1,69 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestPodFromServiceNode(t *testing.T) {
+	testCases := []struct {
+		ServiceNode string
+		Name        string
+		Namespace   string
+		OK          bool
+	}{
+		{"sidecar~10.0.0.1~reviews-v1-5d4d.bookinfo~bookinfo.svc.cluster.local", "reviews-v1-5d4d", "bookinfo", true},
+		{"sidecar~10.0.0.1~a.b.c~c.svc.cluster.local", "a.b", "c", true},
+		{"sidecar~10.0.0.1~nodots~default.svc.cluster.local", "", "", false},
+		{"sidecar~10.0.0.1", "", "", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.ServiceNode, func(t *testing.T) {
+			RegisterTestingT(t)
+			name, namespace, ok := podFromServiceNode(tc.ServiceNode)
+			Expect(ok).To(Equal(tc.OK))
+			Expect(name).To(Equal(tc.Name))
+			Expect(namespace).To(Equal(tc.Namespace))
+		})
+	}
+}
+
+func TestFailureEvents(t *testing.T) {
+	RegisterTestingT(t)
+
+	fake := record.NewFakeRecorder(10)
+	events = fake
+	defer func() { events = nil }()
+
+	ldsReq := ldsResponse{Listeners: []*v1.Listener{{Name: "http_" + NODE_IP + "_80"}}}
+	ldsBytes, err := json.Marshal(ldsReq)
+	Expect(err).To(BeNil())
+	listeners(newLDSRequest("sidecar", bytes.NewReader(ldsBytes)), restful.NewResponse(httptest.NewRecorder()))
+	Expect(fake.Events).To(HaveLen(1))
+	Expect(<-fake.Events).To(ContainSubstring(ReasonFailedMutation))
+
+	listeners(newLDSRequest("sidecar", strings.NewReader("not JSON")), restful.NewResponse(httptest.NewRecorder()))
+	Expect(<-fake.Events).To(ContainSubstring(ReasonFailedDecode))
+}
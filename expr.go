@@ -0,0 +1,710 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// The script expression language is a subset of CEL, enough to match resources: literals (strings, numbers, true,
+// false, null and lists), variables, field access with . and [], the operators ! && || == != < <= > >= + - and in, the
+// functions size and has, and the string methods startsWith, endsWith, contains and matches.  JSON numbers are
+// compared as doubles.
+
+// exprEnv is the variables an expression is evaluated with.
+type exprEnv map[string]interface{}
+
+// expr is a compiled expression.
+type expr func(env exprEnv) (interface{}, error)
+
+// compileExpr parses an expression.
+func compileExpr(src string) (expr, error) {
+	p := &exprParser{src: src}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return e, nil
+}
+
+// evalBool evaluates an expression that must be a bool.
+func (e expr) evalBool(env exprEnv) (bool, error) {
+	v, err := e(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression is a %s, not a bool", typeName(v))
+	}
+	return b, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// str is the value of a string literal, and num of a number.
+	str string
+	num float64
+}
+
+type exprParser struct {
+	src string
+	pos int
+	tok token
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// exprOps are the operators and punctuation, longest first so that <= is not read as <.
+var exprOps = []string{"&&", "||", "==", "!=", "<=", ">=", "!", "<", ">", "+", "-", ".", ",", "(", ")", "[", "]"}
+
+// next reads the next token.
+func (p *exprParser) next() error {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokEOF}
+		return nil
+	}
+	start := p.pos
+	c := p.src[p.pos]
+	switch {
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || unicode.IsLetter(rune(p.src[p.pos])) ||
+			unicode.IsDigit(rune(p.src[p.pos]))) {
+			p.pos++
+		}
+		p.tok = token{kind: tokIdent, text: p.src[start:p.pos]}
+	case unicode.IsDigit(rune(c)):
+		for p.pos < len(p.src) && (unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '.' ||
+			p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+			p.pos++
+		}
+		n, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return p.errorf("invalid number %q", p.src[start:p.pos])
+		}
+		p.tok = token{kind: tokNumber, text: p.src[start:p.pos], num: n}
+	case c == '"' || c == '\'':
+		p.pos++
+		for p.pos < len(p.src) && p.src[p.pos] != c {
+			if p.src[p.pos] == '\\' {
+				p.pos++
+			}
+			p.pos++
+		}
+		if p.pos >= len(p.src) {
+			return p.errorf("unterminated string")
+		}
+		p.pos++
+		text := p.src[start:p.pos]
+		quoted := text
+		if c == '\'' {
+			quoted = `"` + strings.Replace(strings.Replace(text[1:len(text)-1], `\'`, `'`, -1), `"`, `\"`, -1) + `"`
+		}
+		s, err := strconv.Unquote(quoted)
+		if err != nil {
+			return p.errorf("invalid string %s", text)
+		}
+		p.tok = token{kind: tokString, text: text, str: s}
+	default:
+		for _, op := range exprOps {
+			if strings.HasPrefix(p.src[p.pos:], op) {
+				p.pos += len(op)
+				p.tok = token{kind: tokOp, text: op}
+				return nil
+			}
+		}
+		return p.errorf("unexpected %q", string(c))
+	}
+	return nil
+}
+
+// accept reads the current token if it is the operator or keyword op.
+func (p *exprParser) accept(op string) (bool, error) {
+	if (p.tok.kind == tokOp || p.tok.kind == tokIdent) && p.tok.text == op {
+		return true, p.next()
+	}
+	return false, nil
+}
+
+func (p *exprParser) expect(op string) error {
+	ok, err := p.accept(op)
+	if err == nil && !ok {
+		err = p.errorf("expected %q", op)
+	}
+	return err
+}
+
+func (p *exprParser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if ok, err := p.accept("||"); err != nil || !ok {
+			return left, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(env exprEnv) (interface{}, error) {
+			// As in CEL, either side being true makes the result true, even if the other is an error.
+			lv, lerr := l.evalBool(env)
+			if lerr == nil && lv {
+				return true, nil
+			}
+			rv, rerr := right.evalBool(env)
+			if rerr == nil && rv {
+				return true, nil
+			}
+			if lerr != nil {
+				return nil, lerr
+			}
+			return false, rerr
+		}
+	}
+}
+
+func (p *exprParser) parseAnd() (expr, error) {
+	left, err := p.parseRelation()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if ok, err := p.accept("&&"); err != nil || !ok {
+			return left, err
+		}
+		right, err := p.parseRelation()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(env exprEnv) (interface{}, error) {
+			// As in CEL, either side being false makes the result false, even if the other is an error.
+			lv, lerr := l.evalBool(env)
+			if lerr == nil && !lv {
+				return false, nil
+			}
+			rv, rerr := right.evalBool(env)
+			if rerr == nil && !rv {
+				return false, nil
+			}
+			if lerr != nil {
+				return nil, lerr
+			}
+			return true, rerr
+		}
+	}
+}
+
+func (p *exprParser) parseRelation() (expr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">", "in"} {
+		ok, err := p.accept(op)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return binary(op, left, right), nil
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAdditive() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.tok.text
+		if p.tok.kind != tokOp || op != "+" && op != "-" {
+			return left, nil
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binary(op, left, right)
+	}
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	for _, op := range []string{"!", "-"} {
+		ok, err := p.accept(op)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if op == "!" {
+			return func(env exprEnv) (interface{}, error) {
+				b, err := operand.evalBool(env)
+				return !b, err
+			}, nil
+		}
+		return binary("-", func(exprEnv) (interface{}, error) { return 0.0, nil }, operand), nil
+	}
+	return p.parseMember()
+}
+
+func (p *exprParser) parseMember() (expr, error) {
+	e, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if ok, err := p.accept("."); err != nil {
+			return nil, err
+		} else if ok {
+			if p.tok.kind != tokIdent {
+				return nil, p.errorf("expected a field or method name")
+			}
+			name := p.tok.text
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if ok, err := p.accept("("); err != nil {
+				return nil, err
+			} else if ok {
+				args, err := p.parseArgs()
+				if err != nil {
+					return nil, err
+				}
+				if e, err = method(name, e, args); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			e = field(e, constant(name))
+			continue
+		}
+		if ok, err := p.accept("["); err != nil {
+			return nil, err
+		} else if ok {
+			index, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			e = field(e, index)
+			continue
+		}
+		return e, nil
+	}
+}
+
+// parseArgs parses call arguments after the opening parenthesis.
+func (p *exprParser) parseArgs() ([]expr, error) {
+	var args []expr
+	if ok, err := p.accept(")"); err != nil || ok {
+		return args, err
+	}
+	for {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if ok, err := p.accept(","); err != nil {
+			return nil, err
+		} else if !ok {
+			return args, p.expect(")")
+		}
+	}
+}
+
+func constant(v interface{}) expr {
+	return func(exprEnv) (interface{}, error) { return v, nil }
+}
+
+func (p *exprParser) parsePrimary() (expr, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokNumber:
+		return constant(tok.num), p.next()
+	case tokString:
+		return constant(tok.str), p.next()
+	case tokIdent:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		switch tok.text {
+		case "true":
+			return constant(true), nil
+		case "false":
+			return constant(false), nil
+		case "null":
+			return constant(nil), nil
+		case "size", "has":
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+			if tok.text == "has" {
+				return p.parseHas()
+			}
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			if len(args) != 1 {
+				return nil, p.errorf("size takes one argument")
+			}
+			return method("size", args[0], nil)
+		}
+		return func(env exprEnv) (interface{}, error) {
+			v, ok := env[tok.text]
+			if !ok {
+				return nil, fmt.Errorf("undeclared variable %q", tok.text)
+			}
+			return v, nil
+		}, nil
+	case tokOp:
+		if ok, err := p.accept("("); err != nil {
+			return nil, err
+		} else if ok {
+			e, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		}
+		if ok, err := p.accept("["); err != nil {
+			return nil, err
+		} else if ok {
+			var items []expr
+			for {
+				if ok, err := p.accept("]"); err != nil || ok {
+					break
+				}
+				item, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+				if ok, err := p.accept(","); err != nil {
+					return nil, err
+				} else if !ok {
+					if err := p.expect("]"); err != nil {
+						return nil, err
+					}
+					break
+				}
+			}
+			return func(env exprEnv) (interface{}, error) {
+				list := make([]interface{}, len(items))
+				for i, item := range items {
+					v, err := item(env)
+					if err != nil {
+						return nil, err
+					}
+					list[i] = v
+				}
+				return list, nil
+			}, nil
+		}
+	}
+	return nil, p.errorf("unexpected %q", tok.text)
+}
+
+// parseHas parses the argument of has(), which must be a field access, and checks for the field rather than reading
+// it.
+func (p *exprParser) parseHas() (expr, error) {
+	arg, err := p.parseMember()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return func(env exprEnv) (interface{}, error) {
+		_, err := arg(env)
+		if _, missing := err.(errNoSuchKey); missing {
+			return false, nil
+		}
+		return err == nil, err
+	}, nil
+}
+
+// errNoSuchKey is reading a field an object does not have.
+type errNoSuchKey string
+
+func (e errNoSuchKey) Error() string { return fmt.Sprintf("no such key %q", string(e)) }
+
+func field(e, index expr) expr {
+	return func(env exprEnv) (interface{}, error) {
+		v, err := e(env)
+		if err != nil {
+			return nil, err
+		}
+		i, err := index(env)
+		if err != nil {
+			return nil, err
+		}
+		switch v := v.(type) {
+		case map[string]interface{}:
+			k, ok := i.(string)
+			if !ok {
+				return nil, fmt.Errorf("objects are indexed by strings, not %s", typeName(i))
+			}
+			f, ok := v[k]
+			if !ok {
+				return nil, errNoSuchKey(k)
+			}
+			return f, nil
+		case []interface{}:
+			n, ok := number(i)
+			if !ok || n != float64(int(n)) || n < 0 || int(n) >= len(v) {
+				return nil, fmt.Errorf("invalid list index %v", i)
+			}
+			return v[int(n)], nil
+		}
+		return nil, fmt.Errorf("cannot index a %s", typeName(v))
+	}
+}
+
+func method(name string, recv expr, args []expr) (expr, error) {
+	if name == "size" {
+		return func(env exprEnv) (interface{}, error) {
+			v, err := recv(env)
+			if err != nil {
+				return nil, err
+			}
+			switch v := v.(type) {
+			case string:
+				return float64(len([]rune(v))), nil
+			case []interface{}:
+				return float64(len(v)), nil
+			case map[string]interface{}:
+				return float64(len(v)), nil
+			}
+			return nil, fmt.Errorf("cannot take the size of a %s", typeName(v))
+		}, nil
+	}
+	var test func(s, arg string) (bool, error)
+	switch name {
+	case "startsWith":
+		test = func(s, arg string) (bool, error) { return strings.HasPrefix(s, arg), nil }
+	case "endsWith":
+		test = func(s, arg string) (bool, error) { return strings.HasSuffix(s, arg), nil }
+	case "contains":
+		test = func(s, arg string) (bool, error) { return strings.Contains(s, arg), nil }
+	case "matches":
+		test = regexpMatcher()
+	default:
+		return nil, fmt.Errorf("unknown method %q", name)
+	}
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s takes one argument", name)
+	}
+	return func(env exprEnv) (interface{}, error) {
+		v, err := recv(env)
+		if err != nil {
+			return nil, err
+		}
+		a, err := args[0](env)
+		if err != nil {
+			return nil, err
+		}
+		s, ok1 := v.(string)
+		arg, ok2 := a.(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("%s needs strings, not %s and %s", name, typeName(v), typeName(a))
+		}
+		return test(s, arg)
+	}, nil
+}
+
+// regexpMatcher returns a test for matches(), which compiles each distinct pattern once.  Expressions are shared by
+// concurrent requests, so the cache is locked.
+func regexpMatcher() func(s, pattern string) (bool, error) {
+	var mu sync.Mutex
+	cache := make(map[string]*regexp.Regexp)
+	return func(s, pattern string) (bool, error) {
+		mu.Lock()
+		re, ok := cache[pattern]
+		mu.Unlock()
+		if !ok {
+			var err error
+			if re, err = regexp.Compile(pattern); err != nil {
+				return false, err
+			}
+			mu.Lock()
+			cache[pattern] = re
+			mu.Unlock()
+		}
+		return re.MatchString(s), nil
+	}
+}
+
+func binary(op string, left, right expr) expr {
+	return func(env exprEnv) (interface{}, error) {
+		l, err := left(env)
+		if err != nil {
+			return nil, err
+		}
+		r, err := right(env)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "==":
+			return exprEqual(l, r), nil
+		case "!=":
+			return !exprEqual(l, r), nil
+		case "in":
+			list, ok := r.([]interface{})
+			if !ok {
+				if m, isMap := r.(map[string]interface{}); isMap {
+					k, isString := l.(string)
+					_, found := m[k]
+					return isString && found, nil
+				}
+				return nil, fmt.Errorf("cannot look in a %s", typeName(r))
+			}
+			for _, item := range list {
+				if exprEqual(l, item) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case "+":
+			if ls, ok := l.(string); ok {
+				if rs, ok := r.(string); ok {
+					return ls + rs, nil
+				}
+			}
+		}
+		ln, lok := number(l)
+		rn, rok := number(r)
+		if lok && rok {
+			switch op {
+			case "<":
+				return ln < rn, nil
+			case "<=":
+				return ln <= rn, nil
+			case ">":
+				return ln > rn, nil
+			case ">=":
+				return ln >= rn, nil
+			case "+":
+				return ln + rn, nil
+			case "-":
+				return ln - rn, nil
+			}
+		}
+		ls, lok := l.(string)
+		rs, rok := r.(string)
+		if lok && rok {
+			switch op {
+			case "<":
+				return ls < rs, nil
+			case "<=":
+				return ls <= rs, nil
+			case ">":
+				return ls > rs, nil
+			case ">=":
+				return ls >= rs, nil
+			}
+		}
+		return nil, fmt.Errorf("no %s operator for %s and %s", op, typeName(l), typeName(r))
+	}
+}
+
+// number returns a JSON or literal number as a float64.
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func exprEqual(l, r interface{}) bool {
+	if ln, ok := number(l); ok {
+		rn, ok := number(r)
+		return ok && ln == rn
+	}
+	return reflect.DeepEqual(l, r)
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	case float64, json.Number:
+		return "number"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func evalExpr(src string, env exprEnv) (interface{}, error) {
+	e, err := compileExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	return e(env)
+}
+
+func TestExprEval(t *testing.T) {
+	RegisterTestingT(t)
+
+	env := exprEnv{
+		"resource": map[string]interface{}{
+			"name":    "10.0.0.1_8080",
+			"port":    json.Number("8080"),
+			"filters": []interface{}{map[string]interface{}{"name": "http_connection_manager"}},
+		},
+		"node": map[string]interface{}{"type": "sidecar", "domain": "ns.svc.cluster.local"},
+	}
+	for src, want := range map[string]interface{}{
+		`resource.name == "10.0.0.1_8080"`:                        true,
+		`resource["name"].startsWith('10.')`:                      true,
+		`resource.name.endsWith("_8080") && resource.port > 8000`: true,
+		`resource.port in [80, 8080]`:                             true,
+		`node.type != "sidecar" || node.domain.contains("ns.")`:   true,
+		`resource.filters[0].name.matches("^http_")`:              true,
+		`size(resource.filters) == 1`:                             true,
+		`has(resource.name) && !has(resource.address)`:            true,
+		`"name" in resource`:                                      true,
+		`resource.port + 1`:                                       8081.0,
+		`-resource.port < 0`:                                      true,
+		`"a" + 'b'`:                                               "ab",
+		`(1 + 2) - 3 == 0`:                                        true,
+		`null == null`:                                            true,
+		`"b" >= "a"`:                                              true,
+	} {
+		v, err := evalExpr(src, env)
+		Expect(err).To(BeNil(), src)
+		Expect(v).To(Equal(want), src)
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	RegisterTestingT(t)
+
+	env := exprEnv{"resource": map[string]interface{}{"name": "x"}}
+	for _, src := range []string{`resource.name ==`, `"unterminated`, `resource.name.frob("x")`, `1 $ 2`, `(1`} {
+		_, err := compileExpr(src)
+		Expect(err).NotTo(BeNil(), src)
+	}
+	for _, src := range []string{`resource.missing == "x"`, `nothing`, `resource.name < 1`, `resource[0]`} {
+		_, err := evalExpr(src, env)
+		Expect(err).NotTo(BeNil(), src)
+	}
+	// As in CEL, a decided && or || ignores an error on the other side.
+	v, err := evalExpr(`resource.missing == "x" || resource.name == "x"`, env)
+	Expect(err).To(BeNil())
+	Expect(v).To(Equal(true))
+	v, err = evalExpr(`resource.missing == "x" && false`, env)
+	Expect(err).To(BeNil())
+	Expect(v).To(Equal(false))
+
+	e, err := compileExpr(`resource.name`)
+	Expect(err).To(BeNil())
+	_, err = e.evalBool(env)
+	Expect(err.Error()).To(ContainSubstring("not a bool"))
+}
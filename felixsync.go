@@ -0,0 +1,97 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+const felixNodeIndex = "felixNode"
+
+var failOpenInjections = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "fail_open_injections_total",
+	Help:      "LDS requests given a fail open authz filter because Felix was not ready on the node.",
+})
+
+func init() {
+	prometheus.MustRegister(failOpenInjections)
+}
+
+// felixSync gates enforcement on Felix's policy sync, which feeds dikastes, being up on the workload's node.  It is nil
+// unless --felix-sync-gating is set.
+var felixSync *felixSyncGate
+
+// felixSyncGate finds the calico-node pod on a workload's node.  Felix only reports ready once it has synced with the
+// datastore, so a ready calico-node pod is taken to mean policy sync is established.
+type felixSyncGate struct {
+	selector labels.Selector
+	indexer  cache.Indexer
+}
+
+func newFelixSyncGate(selector string, informer cache.SharedIndexInformer) (*felixSyncGate, error) {
+	sel, err := labels.Parse(selector)
+	if err != nil {
+		return nil, err
+	}
+	g := &felixSyncGate{selector: sel}
+	if err := informer.AddIndexers(cache.Indexers{felixNodeIndex: g.indexNode}); err != nil {
+		return nil, err
+	}
+	g.indexer = informer.GetIndexer()
+	return g, nil
+}
+
+// indexNode indexes the calico-node pods by the node they run on.
+func (g *felixSyncGate) indexNode(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok || pod.Spec.NodeName == "" || !g.selector.Matches(labels.Set(pod.Labels)) {
+		return nil, nil
+	}
+	return []string{pod.Spec.NodeName}, nil
+}
+
+// established reports whether Felix is ready on the node running the pod with the given IP.  A pod that cannot be
+// found is treated as not established, since enforcing without policy would fail every request.
+func (g *felixSyncGate) established(ip string) bool {
+	pod, err := pods.byIP(ip)
+	if err != nil || pod == nil || pod.Spec.NodeName == "" {
+		return false
+	}
+	objs, err := g.indexer.ByIndex(felixNodeIndex, pod.Spec.NodeName)
+	if err != nil {
+		return false
+	}
+	for _, obj := range objs {
+		if podReady(obj.(*v1.Pod)) {
+			return true
+		}
+	}
+	log.WithFields(log.Fields{"ip": ip, "node": pod.Spec.NodeName}).Debug("Felix not ready on node")
+	return false
+}
+
+func podReady(pod *v1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == v1.PodReady {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
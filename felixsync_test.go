@@ -0,0 +1,87 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+func calicoNodePod(name, node string, ready corev1.ConditionStatus) *corev1.Pod {
+	pod := testPod(name, "", corev1.PodRunning, nil)
+	pod.Namespace = "kube-system"
+	pod.Labels = map[string]string{"k8s-app": "calico-node"}
+	pod.Spec.NodeName = node
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}}
+	return pod
+}
+
+func newTestFelixSyncGate(objs ...*corev1.Pod) *felixSyncGate {
+	sel, _ := labels.Parse("k8s-app=calico-node")
+	g := &felixSyncGate{selector: sel}
+	g.indexer = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+		podIPIndex:     indexPodIP,
+		felixNodeIndex: g.indexNode,
+	})
+	for _, o := range objs {
+		g.indexer.Add(o)
+	}
+	pods = &podIndex{indexer: g.indexer, synced: func() bool { return true }}
+	return g
+}
+
+func TestFelixSyncEstablished(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { pods = nil }()
+	onReady := testPod("ready", "10.0.0.1", corev1.PodRunning, nil)
+	onReady.Spec.NodeName = "node1"
+	onUnready := testPod("unready", "10.0.0.2", corev1.PodRunning, nil)
+	onUnready.Spec.NodeName = "node2"
+	onMissing := testPod("missing", "10.0.0.3", corev1.PodRunning, nil)
+	onMissing.Spec.NodeName = "node3"
+	g := newTestFelixSyncGate(onReady, onUnready, onMissing,
+		calicoNodePod("calico-node-1", "node1", corev1.ConditionTrue),
+		calicoNodePod("calico-node-2", "node2", corev1.ConditionFalse),
+	)
+
+	Expect(g.established("10.0.0.1")).To(BeTrue())
+	Expect(g.established("10.0.0.2")).To(BeFalse())
+	Expect(g.established("10.0.0.3")).To(BeFalse())
+	Expect(g.established("10.0.0.4")).To(BeFalse())
+}
+
+func TestUpdateListenerFailOpen(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() {
+		pods = nil
+		felixSync = nil
+	}()
+	felixSync = newTestFelixSyncGate()
+	l := v1.Listener{
+		Name:    "tcp_1.2.3.4_76",
+		Filters: []*v1.NetworkFilter{{Name: v1.TCPProxyFilter}},
+	}
+	updateListener(&l, "1.2.3.4")
+	Expect(l.Filters[0].Config.(*mutator.AuthzFilterConfig).FailureModeAllow).To(BeTrue())
+}
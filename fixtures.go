@@ -0,0 +1,133 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// fixtureNodeIP is the IP of the sidecar the golden tests call the hooks for.  Exported requests are rewritten to be
+// for it, so that their inbound listeners are still inbound.
+const fixtureNodeIP = "3.4.5.6"
+
+var ipv4Literal = regexp.MustCompile(`[0-9]+(\.[0-9]+){3}`)
+
+// fixtureFromCapture returns the golden case a captured request becomes: the hook, the case name and the request,
+// redacted and rewritten for fixtureNodeIP.  skip says why a capture that cannot become a case is left out.
+func fixtureFromCapture(c capture) (hook, name string, body []byte, skip string) {
+	path := strings.Split(strings.TrimPrefix(c.meta.Path, "/"), "/")
+	hook = hookName(c.meta.Path)
+	body = redactBody(c.request)
+	switch hook {
+	case "registration":
+		if len(path) != 3 {
+			return "", "", nil, "malformed path"
+		}
+		// Golden registration cases are named for the service.
+		name, err := url.PathUnescape(path[2])
+		if err != nil || strings.ContainsAny(name, `/\`) {
+			return "", "", nil, "malformed service name"
+		}
+		return hook, name, body, ""
+	case "listeners", "clusters", "routes":
+	default:
+		return "", "", nil, "not a hook with golden tests"
+	}
+	node, err := url.PathUnescape(path[len(path)-1])
+	if err != nil {
+		return "", "", nil, "malformed path"
+	}
+	c2 := strings.Split(node, serviceNodeSeparator)
+	if len(c2) < 2 || c2[0] != "sidecar" {
+		// Golden cases are all for a sidecar, and only sidecars are mutated.
+		return "", "", nil, "not for a sidecar"
+	}
+	ip := c2[1]
+	body = ipv4Literal.ReplaceAllFunc(body, func(b []byte) []byte {
+		if string(b) == ip {
+			return []byte(fixtureNodeIP)
+		}
+		return b
+	})
+	return hook, "captured-" + strings.TrimSuffix(c.name, "-"+hook), body, ""
+}
+
+// runExportFixtures writes the newest max captures in dir as golden cases in outDir, writing a line per capture to
+// out, and returns how many it wrote.  Existing cases are not overwritten.  The golden responses are left for the
+// golden tests' -update to write, since the hooks' output depends on what the tests enable.
+func runExportFixtures(dir, outDir string, max int, out io.Writer) (int, error) {
+	captures, err := loadCaptures(dir)
+	if err != nil {
+		return 0, err
+	}
+	if max > 0 && len(captures) > max {
+		captures = captures[len(captures)-max:]
+	}
+	written := 0
+	for _, c := range captures {
+		hook, name, body, skip := fixtureFromCapture(c)
+		if skip != "" {
+			fmt.Fprintf(out, "%s: skipped, %s\n", c.name, skip)
+			continue
+		}
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, body, "", "  "); err != nil {
+			fmt.Fprintf(out, "%s: skipped, request is not JSON\n", c.name)
+			continue
+		}
+		indented.WriteByte('\n')
+		path := filepath.Join(outDir, hook, name+".json")
+		if _, err := os.Stat(path); err == nil {
+			fmt.Fprintf(out, "%s: skipped, %s exists\n", c.name, path)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return written, err
+		}
+		if err := ioutil.WriteFile(path, indented.Bytes(), 0644); err != nil {
+			return written, err
+		}
+		written++
+		fmt.Fprintf(out, "%s: wrote %s\n", c.name, path)
+	}
+	return written, nil
+}
+
+// exportFixtures runs the export-fixtures command.
+func exportFixtures(arguments map[string]interface{}) {
+	max, err := strconv.Atoi(arguments["--fixtures-max"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --fixtures-max.")
+	}
+	written, err := runExportFixtures(arguments["<dir>"].(string), arguments["--fixtures-out"].(string), max, os.Stdout)
+	if err != nil {
+		log.WithField("err", err).Fatal("Unable to export fixtures.")
+	}
+	if written > 0 {
+		fmt.Println("Write the golden responses with: go test -run Golden -update")
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pilottest"
+	"github.com/projectcalico/pilot-webhook/pkg/testutil"
+)
+
+func TestExportFixtures(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(fixtureNodeIP).To(Equal(NODE_IP))
+
+	dir, err := ioutil.TempDir("", "captures")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	outDir, err := ioutil.TempDir("", "fixtures")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(outDir)
+
+	rec, err := newPayloadCapturer(dir, 1, 10)
+	Expect(err).To(BeNil())
+	mesh := pilottest.NewMesh(3)
+	sidecar := pilottest.NewSidecar("10.2.0.1", mesh)
+	router := pilottest.NewSidecar("10.2.0.2", mesh)
+	start := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i, c := range []struct {
+		path string
+		body []byte
+	}{
+		{"/v1/listeners/istio-proxy/" + sidecar.Node(), sidecar.LDS()},
+		{"/v1/listeners/istio-proxy/" + strings.Replace(router.Node(), "sidecar", "router", 1), router.LDS()},
+		{"/v1/registration/" + mesh[0].Key(), mesh[0].EDS()},
+		{"/v1/clusters/istio-proxy/" + sidecar.Node(), sidecar.CDS()},
+	} {
+		meta := captureMeta{Time: start.Add(time.Duration(i) * time.Second), Method: "POST", Path: c.path, Status: 200}
+		rec.write(meta, hookName(c.path), c.body, c.body)
+	}
+
+	var out bytes.Buffer
+	written, err := runExportFixtures(dir, outDir, 3, &out)
+	Expect(err).To(BeNil())
+	Expect(written).To(Equal(2))
+	Expect(out.String()).To(ContainSubstring("-listeners: skipped, not for a sidecar\n"))
+	Expect(out.String()).NotTo(ContainSubstring("T120000.000000000"), "only the newest 3 are exported")
+
+	b, err := ioutil.ReadFile(filepath.Join(outDir, "registration", mesh[0].Key()+".json"))
+	Expect(err).To(BeNil())
+	Expect(b).To(MatchJSON(mesh[0].EDS()))
+
+	cds := filepath.Join(outDir, "clusters", "captured-20180601T120003.000000000.json")
+	b, err = ioutil.ReadFile(cds)
+	Expect(err).To(BeNil())
+	Expect(b).To(MatchJSON(strings.Replace(string(sidecar.CDS()), "10.2.0.1", NODE_IP, -1)))
+
+	// Exporting again leaves the cases alone.
+	Expect(ioutil.WriteFile(cds, []byte("{}"), 0644)).To(Succeed())
+	out.Reset()
+	written, err = runExportFixtures(dir, outDir, 0, &out)
+	Expect(err).To(BeNil())
+	Expect(written).To(Equal(1))
+	Expect(out.String()).To(ContainSubstring("exists\n"))
+	b, err = ioutil.ReadFile(cds)
+	Expect(err).To(BeNil())
+	Expect(string(b)).To(Equal("{}"))
+
+	// The exported sidecar's inbound listeners are inbound for the golden tests' node.
+	b, err = ioutil.ReadFile(filepath.Join(outDir, "listeners", "captured-20180601T120000.000000000.json"))
+	Expect(err).To(BeNil())
+	Expect(string(b)).NotTo(ContainSubstring("10.2.0.1"))
+	resp := serveHook(newHooks(), hookRequest("listeners", "sidecar", bytes.NewReader(b)))
+	Expect(resp.Code).To(Equal(http.StatusOK))
+	inbound, err := testutil.CheckMutatedLDS(resp.Body.Bytes(), NODE_IP)
+	Expect(err).To(BeNil())
+	Expect(inbound).To(Equal(len(sidecar.HTTPPorts) + len(sidecar.TCPPorts)))
+}
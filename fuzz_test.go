@@ -0,0 +1,95 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/projectcalico/pilot-webhook/pkg/testutil"
+)
+
+// fuzzNodes are service node IDs to seed the fuzzers with, well formed and not.
+var fuzzNodes = []string{
+	serviceNode("sidecar", NODE_IP),
+	serviceNode("router", NODE_IP),
+	"sidecar~" + NODE_IP,
+	"sidecar~~~",
+	"sidecar~not-an-ip~pod.ns~ns.svc.cluster.local",
+	"~",
+	"",
+}
+
+// addFuzzSeeds seeds a fuzzer with the golden requests for the hook, truncated copies of them, and deeply nested
+// filters, for each of the fuzzNodes.
+func addFuzzSeeds(f *testing.F, hook string) {
+	bodies := [][]byte{
+		[]byte(`{}`),
+		[]byte(`null`),
+		[]byte(`{"listeners":[{"name":"http_` + NODE_IP + `_80","filters":[{"name":"http_connection_manager","config":` +
+			strings.Repeat(`{"filters":[`, 64) + strings.Repeat(`]}`, 64) + `}]}]}`),
+	}
+	cases, err := testutil.LoadGoldenCases(goldenFixtures)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, c := range cases {
+		if c.Hook == hook {
+			bodies = append(bodies, c.Request, c.Request[:len(c.Request)/2])
+		}
+	}
+	for _, node := range fuzzNodes {
+		for _, body := range bodies {
+			f.Add(node, body, false)
+		}
+	}
+	f.Add(fuzzNodes[0], []byte(ldsWithUnknownFields), true)
+}
+
+// fuzzHook calls a hook with any node and body, buffered or streamed, and checks that it neither panics nor answers
+// with anything but the config, mutated or not, or a client error.
+func fuzzHook(f *testing.F, hook string) {
+	addFuzzSeeds(f, hook)
+	handler := transformHooks[hook]
+	f.Fuzz(func(t *testing.T, node string, body []byte, streamed bool) {
+		defer func() { dikastes, streamArrays = nil, false }()
+		dikastes = staticResolver("tcp://10.96.0.20:9000")
+		streamArrays = streamed
+
+		rec := callHook(hook, handler, SERVICE_CLUSTER, node, bytes.NewReader(body))
+		switch rec.Code {
+		case http.StatusOK:
+			// Bodies the hook cannot parse may be passed through untouched, but any it changed must still be JSON.
+			if !bytes.Equal(rec.Body.Bytes(), body) && !json.Valid(rec.Body.Bytes()) {
+				t.Fatalf("invalid JSON response %q to %q", rec.Body.String(), body)
+			}
+		case http.StatusBadRequest:
+		default:
+			t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func FuzzListeners(f *testing.F) { fuzzHook(f, "listeners") }
+
+func FuzzClusters(f *testing.F) { fuzzHook(f, "clusters") }
+
+func FuzzRoutes(f *testing.F) { fuzzHook(f, "routes") }
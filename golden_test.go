@@ -0,0 +1,70 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pkg/testutil"
+)
+
+// goldenFixtures holds a directory per hook, named as in the hook's path, of <case>.json requests and the
+// <case>.golden.json responses expected for them.  Adding a case is a matter of dropping in a request and running the
+// tests with -update.
+const goldenFixtures = "testdata/golden"
+
+var updateGolden = flag.Bool("update", false, "rewrite the expected responses in "+goldenFixtures)
+
+func TestGolden(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { dikastes = nil }()
+	dikastes = staticResolver(testutil.DikastesAddress)
+	container := newHooks()
+	schemas, err := loadOutputSchemas()
+	Expect(err).To(BeNil())
+
+	cases, err := testutil.LoadGoldenCases(goldenFixtures)
+	Expect(err).To(BeNil())
+	for _, c := range cases {
+		c := c
+		t.Run(c.Hook+"/"+c.Name, func(t *testing.T) {
+			RegisterTestingT(t)
+
+			rec := serveHook(container, httptest.NewRequest("POST", c.Path(), bytes.NewReader(c.Request)))
+			Expect(rec.Code).To(Equal(http.StatusOK))
+
+			if *updateGolden {
+				// Indented, so that changes to the goldens review well.
+				var out bytes.Buffer
+				Expect(json.Indent(&out, bytes.TrimSpace(rec.Body.Bytes()), "", "  ")).To(Succeed())
+				out.WriteByte('\n')
+				Expect(ioutil.WriteFile(c.GoldenFile, out.Bytes(), 0644)).To(Succeed())
+				c.Golden = out.Bytes()
+			}
+			Expect(c.Golden).NotTo(BeNil(), "no golden response; run the tests with -update")
+			Expect(rec.Body.String()).To(MatchJSON(c.Golden))
+			Expect(validateOutput(schemas, c.Hook, rec.Body.Bytes())).To(Succeed())
+		})
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/emicklei/go-restful"
+	log "github.com/sirupsen/logrus"
+)
+
+// gzipWriters are reused across responses, since each holds sizeable compression state.  Config is pushed often and
+// is latency sensitive, so speed is favoured over ratio.
+var gzipWriters = sync.Pool{New: func() interface{} {
+	w, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+	return w
+}}
+
+// gzipNegotiated is a filter that decompresses gzip request bodies, and compresses responses for clients
+// that accept gzip.  It runs before the other filters, so they and the handlers only ever see plain JSON.
+func gzipNegotiated(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	switch enc := req.Request.Header.Get("Content-Encoding"); enc {
+	case "", "identity":
+	case "gzip":
+		zr, err := gzip.NewReader(req.Request.Body)
+		if err != nil {
+			reportError(hookName(req.Request.URL.Path), ErrorClassRead, log.Fields{"err": err},
+				"failed to decompress body")
+			resp.WriteErrorString(http.StatusBadRequest, "could not decompress request body")
+			return
+		}
+		defer zr.Close()
+		req.Request.Body = ioutil.NopCloser(zr)
+		req.Request.Header.Del("Content-Encoding")
+		req.Request.ContentLength = -1
+	default:
+		resp.WriteErrorString(http.StatusUnsupportedMediaType, "unsupported Content-Encoding "+enc)
+		return
+	}
+	if !acceptsGzip(req.Request.Header.Get("Accept-Encoding")) {
+		chain.ProcessFilter(req, resp)
+		return
+	}
+	zw := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(zw)
+	zw.Reset(resp.ResponseWriter)
+	resp.Header().Set("Content-Encoding", "gzip")
+	resp.Header().Add("Vary", "Accept-Encoding")
+	orig := resp.ResponseWriter
+	resp.ResponseWriter = &gzipResponseWriter{ResponseWriter: orig, zw: zw}
+
+	chain.ProcessFilter(req, resp)
+
+	resp.ResponseWriter = orig
+	if err := zw.Close(); err != nil {
+		log.WithField("err", err).Debug("Failed to finish gzip response")
+	}
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	zw *gzip.Writer
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	return w.zw.Write(b)
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows a gzip response.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.TrimSpace(params[0])
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		accepted := true
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				q, err := strconv.ParseFloat(p[2:], 64)
+				accepted = err == nil && q > 0
+			}
+		}
+		return accepted
+	}
+	return false
+}
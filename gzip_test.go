@@ -0,0 +1,86 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func gzipped(s string) *bytes.Buffer {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	zw.Close()
+	return &buf
+}
+
+func TestGzipRoundTrip(t *testing.T) {
+	RegisterTestingT(t)
+
+	container := newHooks()
+
+	req := hookRequest("clusters", "sidecar", gzipped(`{"clusters":[]}`))
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := serveHook(container, req)
+	Expect(rec.Code).To(Equal(http.StatusOK))
+	Expect(rec.Header().Get("Content-Encoding")).To(Equal("gzip"))
+	zr, err := gzip.NewReader(rec.Body)
+	Expect(err).To(BeNil())
+	body, err := ioutil.ReadAll(zr)
+	Expect(err).To(BeNil())
+	Expect(string(body)).To(Equal(`{"clusters":[]}`))
+
+	// Plain responses for clients that do not ask for gzip.
+	req = hookRequest("clusters", "sidecar", gzipped(`{"clusters":[]}`))
+	req.Header.Set("Content-Encoding", "gzip")
+	rec = serveHook(container, req)
+	Expect(rec.Header().Get("Content-Encoding")).To(Equal(""))
+	Expect(rec.Body.String()).To(Equal(`{"clusters":[]}`))
+}
+
+func TestGzipBadRequests(t *testing.T) {
+	RegisterTestingT(t)
+
+	container := newHooks()
+
+	req := hookRequest("clusters", "sidecar", strings.NewReader(`{"clusters":[]}`))
+	req.Header.Set("Content-Encoding", "gzip")
+	rec := serveHook(container, req)
+	Expect(rec.Code).To(Equal(http.StatusBadRequest))
+
+	req = hookRequest("clusters", "sidecar", strings.NewReader(`{"clusters":[]}`))
+	req.Header.Set("Content-Encoding", "br")
+	rec = serveHook(container, req)
+	Expect(rec.Code).To(Equal(http.StatusUnsupportedMediaType))
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(acceptsGzip("")).To(BeFalse())
+	Expect(acceptsGzip("gzip")).To(BeTrue())
+	Expect(acceptsGzip("deflate, gzip;q=0.5")).To(BeTrue())
+	Expect(acceptsGzip("gzip;q=0")).To(BeFalse())
+	Expect(acceptsGzip("*")).To(BeTrue())
+	Expect(acceptsGzip("identity")).To(BeFalse())
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	log "github.com/sirupsen/logrus"
+)
+
+const redacted = "<redacted>"
+
+// sensitiveHeaders are never kept in the request history.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// history keeps the most recent hook exchanges for the debug endpoint.  It is nil unless --debug-history is set.
+var history *exchangeRing
+
+// exchange is a recorded hook request and its response.
+type exchange struct {
+	Time              time.Time         `json:"time"`
+	Method            string            `json:"method"`
+	Path              string            `json:"path"`
+	Headers           map[string]string `json:"headers,omitempty"`
+	Status            int               `json:"status"`
+	Duration          string            `json:"duration"`
+	Request           string            `json:"request"`
+	RequestTruncated  bool              `json:"requestTruncated,omitempty"`
+	Response          string            `json:"response"`
+	ResponseTruncated bool              `json:"responseTruncated,omitempty"`
+}
+
+// exchangeRing is a fixed size ring buffer of exchanges.  Bodies are capped at maxBody bytes each so the memory it
+// holds is bounded regardless of payload size.
+type exchangeRing struct {
+	maxBody int
+
+	mu   sync.Mutex
+	buf  []exchange
+	next int
+	full bool
+}
+
+func newExchangeRing(size, maxBody int) *exchangeRing {
+	return &exchangeRing{maxBody: maxBody, buf: make([]exchange, size)}
+}
+
+func (r *exchangeRing) add(e exchange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buf[r.next] = e
+	r.next = (r.next + 1) % len(r.buf)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns the recorded exchanges, oldest first.
+func (r *exchangeRing) list() []exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]exchange{}, r.buf[:r.next]...)
+	}
+	return append(append([]exchange{}, r.buf[r.next:]...), r.buf[:r.next]...)
+}
+
+// truncate caps body at the ring's body limit.
+func (r *exchangeRing) truncate(body []byte) (string, bool) {
+	if len(body) > r.maxBody {
+		return string(body[:r.maxBody]), true
+	}
+	return string(body), false
+}
+
+// captureWriter tees the first max bytes written to the response.
+type captureWriter struct {
+	http.ResponseWriter
+	max int
+	buf bytes.Buffer
+	n   int
+}
+
+func (c *captureWriter) Write(b []byte) (int, error) {
+	if room := c.max - c.buf.Len(); room > 0 {
+		if len(b) < room {
+			room = len(b)
+		}
+		c.buf.Write(b[:room])
+	}
+	c.n += len(b)
+	return c.ResponseWriter.Write(b)
+}
+
+// recordExchange is a filter that records each hook request and response in the history.
+func recordExchange(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	if history == nil {
+		chain.ProcessFilter(req, resp)
+		return
+	}
+	start := time.Now()
+	body, err := ioutil.ReadAll(limitBody(req.Request.Body))
+	if err != nil {
+		// Let the handler deal with the broken body.
+		log.WithField("err", err).Debug("Unable to record request body")
+	}
+	req.Request.Body = replayBody(body, err)
+	// The whole response is captured so that it is redacted before it is truncated, as the request is: a secret cut
+	// short would no longer parse or match the redaction patterns.
+	cw := &captureWriter{ResponseWriter: resp.ResponseWriter, max: math.MaxInt32}
+	resp.ResponseWriter = cw
+
+	chain.ProcessFilter(req, resp)
+
+	e := exchange{
+		Time:     start,
+		Method:   req.Request.Method,
+		Path:     req.Request.URL.Path,
+		Headers:  make(map[string]string),
+		Status:   resp.StatusCode(),
+		Duration: time.Since(start).String(),
+	}
+	for k := range req.Request.Header {
+		if sensitiveHeaders[k] {
+			e.Headers[k] = redacted
+		} else {
+			e.Headers[k] = req.Request.Header.Get(k)
+		}
+	}
+	e.Request, e.RequestTruncated = history.truncate(redactBody(body))
+	e.Response, e.ResponseTruncated = history.truncate(redactBody(cw.buf.Bytes()))
+	history.add(e)
+}
+
+// recentRequests returns the request history, oldest first.
+func recentRequests(req *restful.Request, resp *restful.Response) {
+	if history == nil {
+		resp.WriteErrorString(http.StatusNotFound, "request history is disabled; set --debug-history")
+		return
+	}
+	resp.WriteEntity(history.list())
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+)
+
+func TestExchangeRing(t *testing.T) {
+	RegisterTestingT(t)
+
+	r := newExchangeRing(2, 10)
+	Expect(r.list()).To(BeEmpty())
+	r.add(exchange{Path: "a"})
+	Expect(r.list()).To(Equal([]exchange{{Path: "a"}}))
+	r.add(exchange{Path: "b"})
+	r.add(exchange{Path: "c"})
+	Expect(r.list()).To(Equal([]exchange{{Path: "b"}, {Path: "c"}}))
+}
+
+func TestRecordExchange(t *testing.T) {
+	RegisterTestingT(t)
+
+	history = newExchangeRing(5, 4)
+	defer func() { history = nil }()
+
+	req := hookRequest("routes", "sidecar", strings.NewReader("recorded RDS"))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := serveHook(newHooks(), req)
+	Expect(rec.Body.String()).To(Equal("recorded RDS"))
+
+	rec = httptest.NewRecorder()
+	recentRequests(restful.NewRequest(httptest.NewRequest("GET", "/debug/requests", nil)), restful.NewResponse(rec))
+	Expect(rec.Code).To(Equal(http.StatusOK))
+	var exchanges []exchange
+	err := json.Unmarshal(rec.Body.Bytes(), &exchanges)
+	Expect(err).To(BeNil())
+	Expect(exchanges).To(HaveLen(1))
+	e := exchanges[0]
+	Expect(e.Path).To(HavePrefix("/v1/routes/"))
+	Expect(e.Status).To(Equal(http.StatusOK))
+	Expect(e.Headers["Authorization"]).To(Equal(redacted))
+	Expect(e.Request).To(Equal("reco"))
+	Expect(e.RequestTruncated).To(BeTrue())
+	Expect(e.Response).To(Equal("reco"))
+	Expect(e.ResponseTruncated).To(BeTrue())
+}
+
+func TestRecordExchangeRedactsBeforeTruncating(t *testing.T) {
+	RegisterTestingT(t)
+
+	history = newExchangeRing(5, 16)
+	defer func() { history = nil }()
+
+	body := `{"token": "abcdefghijklmnop"}`
+	rec := serveHook(newHooks(), hookRequest("routes", "sidecar", strings.NewReader(body)))
+	Expect(rec.Body.String()).To(Equal(body))
+
+	e := history.list()[0]
+	Expect(e.Request).To(Equal(`{"token":"<redac`))
+	Expect(e.Response).To(Equal(e.Request))
+	Expect(e.ResponseTruncated).To(BeTrue())
+}
+
+func TestRecentRequestsDisabled(t *testing.T) {
+	RegisterTestingT(t)
+
+	rec := httptest.NewRecorder()
+	recentRequests(restful.NewRequest(httptest.NewRequest("GET", "/debug/requests", nil)), restful.NewResponse(rec))
+	Expect(rec.Code).To(Equal(http.StatusNotFound))
+}
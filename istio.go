@@ -0,0 +1,210 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+)
+
+const istioVersionProbeInterval = 30 * time.Second
+
+var errIstioVersionUnknown = errors.New("Istio version not yet probed")
+
+var istioVersionPattern = regexp.MustCompile(`(\d+)\.(\d+)`)
+
+// istioVersion is the major and minor version of Istio, which is all that config shapes vary by.  The zero value means
+// the version is unknown.
+type istioVersion struct {
+	Major int
+	Minor int
+}
+
+func (v istioVersion) known() bool {
+	return v != istioVersion{}
+}
+
+func (v istioVersion) atLeast(major, minor int) bool {
+	return v.Major > major || v.Major == major && v.Minor >= minor
+}
+
+func (v istioVersion) String() string {
+	if !v.known() {
+		return "unknown"
+	}
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// parseIstioVersion finds the first major.minor version in s, so that it accepts plain versions, image tags and the
+// output of Pilot's /version endpoint alike.
+func parseIstioVersion(s string) (istioVersion, bool) {
+	m := istioVersionPattern.FindStringSubmatch(s)
+	if m == nil {
+		return istioVersion{}, false
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	return istioVersion{Major: major, Minor: minor}, true
+}
+
+// hookProfile is how the config the webhook adds differs between sidecars.
+type hookProfile = config.Profile
+
+// profileFor returns the profile for a version.  An unknown version gets the profile the webhook has always used.
+func profileFor(v istioVersion) hookProfile {
+	return config.ProfileFor(v.Major, v.Minor)
+}
+
+// istioVersions works out which version of Istio each sidecar runs, so that one webhook can serve a mesh part way
+// through an upgrade.  It is nil unless --istio-version or --watch-pods is set.
+var istioVersions *versionDetector
+
+// versionDetector takes the version from the sidecar's image tag where the pod is known, falling back to Pilot's.
+type versionDetector struct {
+	lock     sync.Mutex
+	fallback istioVersion
+	probeURL string
+}
+
+// newVersionDetector returns a detector falling back to the given version, or to the version reported by the given
+// Pilot /version URL.  Either may be empty.
+func newVersionDetector(fallback string) (*versionDetector, error) {
+	d := &versionDetector{}
+	if strings.HasPrefix(fallback, "http://") || strings.HasPrefix(fallback, "https://") {
+		d.probeURL = fallback
+	} else if fallback != "" {
+		v, ok := parseIstioVersion(fallback)
+		if !ok {
+			return nil, fmt.Errorf("no version in %q", fallback)
+		}
+		d.fallback = v
+	}
+	return d, nil
+}
+
+// versionFor returns the Istio version of the sidecar with the given IP.
+func (d *versionDetector) versionFor(ip string) istioVersion {
+	if pods != nil {
+		if pod, err := pods.byIP(ip); err == nil && pod != nil {
+			for _, c := range pod.Spec.Containers {
+				if c.Name != sidecarContainerName {
+					continue
+				}
+				if i := strings.LastIndex(c.Image, ":"); i >= 0 {
+					if v, ok := parseIstioVersion(c.Image[i+1:]); ok {
+						return v
+					}
+				}
+			}
+		}
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.fallback
+}
+
+func (d *versionDetector) probe() error {
+	resp, err := http.Get(d.probeURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", d.probeURL, resp.Status)
+	}
+	v, ok := parseIstioVersion(string(body))
+	if !ok {
+		return fmt.Errorf("no version in response from %s", d.probeURL)
+	}
+	d.lock.Lock()
+	d.fallback = v
+	d.lock.Unlock()
+	log.WithFields(log.Fields{"url": d.probeURL, "version": v}).Info("Detected Pilot's Istio version.")
+	return nil
+}
+
+// run probes Pilot until it reports its version.  Pilot is not upgraded in place, so once is enough.
+func (d *versionDetector) run() {
+	if d.probeURL == "" {
+		return
+	}
+	for {
+		err := d.probe()
+		if err == nil {
+			return
+		}
+		log.WithFields(log.Fields{"url": d.probeURL, "err": err}).Warn("Unable to probe Pilot's Istio version.")
+		time.Sleep(istioVersionProbeInterval)
+	}
+}
+
+// check is a readiness check that fails until Pilot's version has been probed, so that sidecars are not given config
+// for the wrong version.
+func (d *versionDetector) check() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.probeURL != "" && !d.fallback.known() {
+		return errIstioVersionUnknown
+	}
+	return nil
+}
+
+// profileForNode returns the profile for the sidecar with the given IP, counting it if it fails open.
+func profileForNode(ip string) hookProfile {
+	profile := nodeProfile(ip)
+	if profile.FailOpen {
+		failOpenInjections.Inc()
+	}
+	return profile
+}
+
+// nodeProfile returns the profile for the sidecar with the given IP.
+func nodeProfile(ip string) hookProfile {
+	var v istioVersion
+	if istioVersions != nil {
+		v = istioVersions.versionFor(ip)
+	}
+	profile := profileFor(v)
+	if propagateLabels && profile.GrpcService {
+		profile.InitialMetadata = workloadMetadata(ip)
+	}
+	if workloadTiers != nil && profile.GrpcService {
+		profile.InitialMetadata = append(profile.InitialMetadata, tierMetadata(ip)...)
+	}
+	if pods != nil {
+		if pod, err := pods.byIP(ip); err == nil && pod != nil {
+			profile.ExcludedPorts = podExcludedInboundPorts(pod)
+		}
+	}
+	if felixSync != nil && !felixSync.established(ip) {
+		profile.FailOpen = true
+	}
+	return profile
+}
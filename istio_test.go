@@ -0,0 +1,100 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+func TestParseIstioVersion(t *testing.T) {
+	RegisterTestingT(t)
+
+	for s, expected := range map[string]istioVersion{
+		"0.7.1":                  {0, 7},
+		"1.0.2":                  {1, 0},
+		"release-0.8-20180515":   {0, 8},
+		"Version: 0.8.0\nGitRev": {0, 8},
+	} {
+		v, ok := parseIstioVersion(s)
+		Expect(ok).To(BeTrue())
+		Expect(v).To(Equal(expected))
+	}
+	_, ok := parseIstioVersion("latest")
+	Expect(ok).To(BeFalse())
+}
+
+func TestProfileFor(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(profileFor(istioVersion{})).To(Equal(hookProfile{FilterName: AuthZFilterName, ClusterName: AuthZClusterName}))
+	Expect(profileFor(istioVersion{0, 7})).To(Equal(hookProfile{FilterName: AuthZFilterName, ClusterName: AuthZClusterName}))
+	Expect(profileFor(istioVersion{0, 8})).To(Equal(hookProfile{FilterName: AuthZFilterName, ClusterName: AuthZClusterName, GrpcService: true}))
+	Expect(profileFor(istioVersion{1, 0})).To(Equal(hookProfile{FilterName: AuthZFilterName, ClusterName: AuthZClusterName, GrpcService: true, Deprecated: true}))
+}
+
+func TestVersionForSidecarImage(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { pods = nil }()
+	pod := testPod("testpod", NODE_IP, corev1.PodRunning, nil)
+	pod.Spec.Containers = []corev1.Container{{Name: "web", Image: "web:2.3"}, {Name: "istio-proxy", Image: "docker.io/istio/proxy:0.8.0"}}
+	pods = newTestPodIndex(pod)
+	d, err := newVersionDetector("0.7")
+	Expect(err).To(BeNil())
+
+	Expect(d.versionFor(NODE_IP)).To(Equal(istioVersion{0, 8}))
+	Expect(d.versionFor("9.9.9.9")).To(Equal(istioVersion{0, 7}))
+}
+
+func TestVersionProbe(t *testing.T) {
+	RegisterTestingT(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Version: 0.8.0\nGitRevision: 6f9f420f0c7119ff4fa6a1966a6f6d89b1b4db84\n")
+	}))
+	defer srv.Close()
+	d, err := newVersionDetector(srv.URL)
+	Expect(err).To(BeNil())
+	Expect(d.check()).To(Equal(errIstioVersionUnknown))
+
+	Expect(d.probe()).To(Succeed())
+	Expect(d.check()).To(BeNil())
+	Expect(d.versionFor(NODE_IP)).To(Equal(istioVersion{0, 8}))
+}
+
+func TestUpdateListenerGrpcService(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { istioVersions = nil }()
+	istioVersions, _ = newVersionDetector("0.8.0")
+	l := v1.Listener{
+		Name:    "tcp_1.2.3.4_76",
+		Filters: []*v1.NetworkFilter{{Name: v1.TCPProxyFilter}},
+	}
+	updateListener(&l, "1.2.3.4")
+	Expect(l.Filters[0].Config).To(Equal(&mutator.AuthzFilterConfig{
+		StatPrefix:  AuthZFilterName,
+		GrpcService: &mutator.GrpcServiceConfig{EnvoyGrpc: &mutator.GrpcClusterConfig{ClusterName: AuthZClusterName}},
+	}))
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// istioInitContainerName is the init container that sets up traffic redirection in pods without a CNI plugin to do
+// it.
+const istioInitContainerName = "istio-init"
+
+// wildcardAddress is the address in the name of listeners that are not bound to a particular IP.
+const wildcardAddress = "0.0.0.0"
+
+// cniCompat adjusts listener classification for pods whose traffic is redirected by Istio CNI, or Calico's
+// equivalent, rather than istio-init.  It is nil unless --cni-compat is "on" or "auto".
+var cniCompat *cniCompatibility
+
+// cniCompatibility recognises the inbound listeners of CNI redirected pods, which Pilot may bind to the wildcard
+// address rather than the pod's IP, making them look outbound.  A wildcard listener is taken to be inbound if it is on
+// one of the pod's container ports.
+type cniCompatibility struct {
+	// auto only applies the rules to pods without an istio-init container.
+	auto bool
+}
+
+func newCNICompatibility(mode string) (*cniCompatibility, error) {
+	switch mode {
+	case "off":
+		return nil, nil
+	case "on":
+		return &cniCompatibility{}, nil
+	case "auto":
+		return &cniCompatibility{auto: true}, nil
+	}
+	return nil, fmt.Errorf("unknown CNI compatibility mode %q", mode)
+}
+
+// podUsesCNI reports whether the pod has a sidecar but no istio-init container, so its traffic must be redirected by
+// a CNI plugin.
+func podUsesCNI(pod *corev1.Pod) bool {
+	for _, c := range pod.Spec.InitContainers {
+		if c.Name == istioInitContainerName {
+			return false
+		}
+	}
+	for _, c := range pod.Spec.Containers {
+		if c.Name == sidecarContainerName {
+			return true
+		}
+	}
+	return false
+}
+
+// podHasPort reports whether any of the pod's containers declares the TCP port.
+func podHasPort(pod *corev1.Pod, port int) bool {
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if int(p.ContainerPort) == port && (p.Protocol == "" || p.Protocol == corev1.ProtocolTCP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// inbound reports whether a listener on addr that is not on the node's IP is nevertheless one of its inbound
+// listeners.
+func (c *cniCompatibility) inbound(addr string, port int, ip string) bool {
+	if addr != wildcardAddress || port == 0 {
+		return false
+	}
+	pod, err := pods.byIP(ip)
+	if err != nil {
+		reportError("listeners", ErrorClassLookup, log.Fields{"ip": ip, "err": err}, "failed to look up pod")
+		return false
+	}
+	if pod == nil || c.auto && !podUsesCNI(pod) {
+		return false
+	}
+	return podHasPort(pod, port)
+}
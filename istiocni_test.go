@@ -0,0 +1,54 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestCNICompatClassification(t *testing.T) {
+	RegisterTestingT(t)
+
+	pod := testPod("testpod", NODE_IP, corev1.PodRunning, nil)
+	pod.Spec.Containers = []corev1.Container{
+		{Name: "app", Ports: []corev1.ContainerPort{{ContainerPort: 8080}}},
+		{Name: sidecarContainerName},
+	}
+	defer func() { pods, cniCompat = nil, nil }()
+	pods = newTestPodIndex(pod)
+	cniCompat, _ = newCNICompatibility("auto")
+
+	wildcard := &v1.Listener{Name: "http_0.0.0.0_8080"}
+	direction, proto := classifyListener(wildcard, NODE_IP)
+	Expect(direction).To(Equal(INBOUND))
+	Expect(proto).To(Equal(HTTP))
+	direction, _ = classifyListener(&v1.Listener{Name: "http_0.0.0.0_80"}, NODE_IP)
+	Expect(direction).To(Equal(OUTBOUND))
+
+	// Pods set up by istio-init keep the usual rules in auto mode, but not when it is on.
+	pod.Spec.InitContainers = []corev1.Container{{Name: istioInitContainerName}}
+	direction, _ = classifyListener(wildcard, NODE_IP)
+	Expect(direction).To(Equal(OUTBOUND))
+	cniCompat, _ = newCNICompatibility("on")
+	direction, _ = classifyListener(wildcard, NODE_IP)
+	Expect(direction).To(Equal(INBOUND))
+
+	_, err := newCNICompatibility("maybe")
+	Expect(err).NotTo(BeNil())
+}
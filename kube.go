@@ -0,0 +1,101 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+)
+
+// kubeClients holds the Kubernetes client and informers shared by the features that need them.  They are created on
+// first use, so nothing talks to Kubernetes unless a feature that needs it is enabled.
+type kubeClients struct {
+	kubeconfig string
+
+	client    kubernetes.Interface
+	informers informers.SharedInformerFactory
+	synced    int32
+}
+
+var errInformersNotSynced = errors.New("Kubernetes informer caches not yet synced")
+
+// Client returns the shared client, exiting if it cannot be created.
+func (k *kubeClients) Client() kubernetes.Interface {
+	if k.client == nil {
+		client, err := newKubeClient(k.kubeconfig)
+		if err != nil {
+			log.WithField("err", err).Fatal("Unable to create Kubernetes client.")
+		}
+		k.client = client
+	}
+	return k.client
+}
+
+// Informers returns the shared informer factory.  Informers obtained from it run once start is called.
+func (k *kubeClients) Informers() informers.SharedInformerFactory {
+	if k.informers == nil {
+		k.informers = informers.NewSharedInformerFactory(k.Client(), 0)
+	}
+	return k.informers
+}
+
+// start runs any informers that have been requested, and waits in the background for their caches to sync.
+func (k *kubeClients) start(stop <-chan struct{}) {
+	if k.informers != nil {
+		k.informers.Start(stop)
+		go k.waitForSync(stop)
+	}
+}
+
+func (k *kubeClients) waitForSync(stop <-chan struct{}) {
+	for typ, ok := range k.informers.WaitForCacheSync(stop) {
+		if !ok {
+			log.WithField("type", typ).Warn("Informer cache did not sync.")
+			return
+		}
+	}
+	atomic.StoreInt32(&k.synced, 1)
+	log.Info("Synced Kubernetes informer caches.")
+}
+
+// check is a readiness check that fails until every started informer has synced its cache.
+func (k *kubeClients) check() error {
+	if k.informers != nil && atomic.LoadInt32(&k.synced) == 0 {
+		return errInformersNotSynced
+	}
+	return nil
+}
+
+// newKubeClient returns a Kubernetes client using the given kubeconfig, or the in-cluster config if it is empty.
+func newKubeClient(kubeconfig string) (kubernetes.Interface, error) {
+	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(cfg)
+}
+
+// podFromServiceNode extracts the pod name and namespace from an Istio service node, whose ID component is of the
+// form <pod>.<namespace>.
+func podFromServiceNode(serviceNode string) (name, namespace string, ok bool) {
+	return config.ParseNode(serviceNode).Pod()
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	apiv3 "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/libcalico-go/lib/clientv3"
+	"github.com/projectcalico/libcalico-go/lib/options"
+	"github.com/projectcalico/libcalico-go/lib/selector"
+	"github.com/projectcalico/libcalico-go/lib/watch"
+	log "github.com/sirupsen/logrus"
+)
+
+var errPoliciesNotSynced = errors.New("policies not yet synced")
+
+// l7Policies tracks the Calico policies that need dikastes to enforce them.  It is nil unless --require-l7-policy is
+// set.
+var l7Policies *policyIndex
+
+// defaultTier is the tier of every policy read from the datastore, since libcalico-go has no other tiers.
+const defaultTier = "default"
+
+// indexedPolicy is a policy in the index.  namespace is "" for global policies, and l7 is set if it has application
+// layer ingress rules.
+type indexedPolicy struct {
+	namespace string
+	tier      string
+	selector  selector.Selector
+	l7        bool
+}
+
+// policyIndex holds the policies with application layer rules, i.e. rules only dikastes can enforce, so that workloads
+// that no such policy applies to can be spared the authz round trip on every request.  If all is set it holds every
+// policy, for their tiers.
+type policyIndex struct {
+	mu       sync.RWMutex
+	policies map[string]indexedPolicy
+	synced   map[string]bool
+	all      bool
+}
+
+func newPolicyIndex() *policyIndex {
+	return &policyIndex{policies: make(map[string]indexedPolicy), synced: make(map[string]bool)}
+}
+
+// hasL7Rules reports whether any ingress rule matches on something only dikastes can see: HTTP attributes or the
+// peer's service account.
+func hasL7Rules(types []apiv3.PolicyType, ingress []apiv3.Rule) bool {
+	if len(types) > 0 {
+		found := false
+		for _, t := range types {
+			found = found || t == apiv3.PolicyTypeIngress
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, r := range ingress {
+		if r.HTTP != nil || r.Source.ServiceAccounts != nil || r.Destination.ServiceAccounts != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// set adds or replaces a policy, or removes it if it has no application layer rules.
+func (p *policyIndex) set(key, namespace, sel string, types []apiv3.PolicyType, ingress []apiv3.Rule) {
+	p.setSelector(key, defaultTier, namespace, sel, hasL7Rules(types, ingress))
+}
+
+// setSelector adds or replaces a policy that has application layer rules, or removes one that does not unless the
+// index holds all policies.
+func (p *policyIndex) setSelector(key, tier, namespace, sel string, l7 bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.policies, key)
+	if !l7 && !p.all {
+		return
+	}
+	parsed, err := selector.Parse(sel)
+	if err != nil {
+		log.WithFields(log.Fields{"policy": key, "err": err}).Warn("Ignoring policy with invalid selector")
+		return
+	}
+	p.policies[key] = indexedPolicy{namespace: namespace, tier: tier, selector: parsed, l7: l7}
+}
+
+func (p *policyIndex) remove(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.policies, key)
+}
+
+func (p *policyIndex) setNetworkPolicy(np *apiv3.NetworkPolicy) {
+	p.set(np.Namespace+"/"+np.Name, np.Namespace, np.Spec.Selector, np.Spec.Types, np.Spec.Ingress)
+}
+
+func (p *policyIndex) setGlobalNetworkPolicy(gnp *apiv3.GlobalNetworkPolicy) {
+	p.set(gnp.Name, "", gnp.Spec.Selector, gnp.Spec.Types, gnp.Spec.Ingress)
+}
+
+// reset removes the policies of one kind ahead of a fresh list.  Global policy keys have no namespace.
+func (p *policyIndex) reset(global bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, policy := range p.policies {
+		if (policy.namespace == "") == global {
+			delete(p.policies, key)
+		}
+	}
+}
+
+// adopt replaces the index's policies with another's, which is discarded, and marks every kind synced.
+func (p *policyIndex) adopt(o *policyIndex) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.policies = o.policies
+	p.synced["networkpolicies"] = true
+	p.synced["globalnetworkpolicies"] = true
+}
+
+func (p *policyIndex) markSynced(resource string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.synced[resource] = true
+}
+
+// selects reports whether any application layer policy applies to a workload endpoint.
+func (p *policyIndex) selects(ep endpointInfo) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, policy := range p.policies {
+		if policy.l7 && policy.appliesTo(ep) {
+			return true
+		}
+	}
+	return false
+}
+
+// tiers returns the tiers of the policies that apply to a workload endpoint, in no particular order.
+func (p *policyIndex) tiers(ep endpointInfo) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	seen := make(map[string]bool)
+	var tiers []string
+	for _, policy := range p.policies {
+		if !seen[policy.tier] && policy.appliesTo(ep) {
+			seen[policy.tier] = true
+			tiers = append(tiers, policy.tier)
+		}
+	}
+	return tiers
+}
+
+func (policy indexedPolicy) appliesTo(ep endpointInfo) bool {
+	if policy.namespace != "" && policy.namespace != ep.Namespace {
+		return false
+	}
+	return policy.selector.Evaluate(ep.Labels)
+}
+
+// check is a readiness check that fails until both kinds of policy have been listed.
+func (p *policyIndex) check() error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if !p.synced["networkpolicies"] || !p.synced["globalnetworkpolicies"] {
+		return errPoliciesNotSynced
+	}
+	return nil
+}
+
+// applyNetworkPolicy updates the index from a NetworkPolicy watch event.
+func (p *policyIndex) applyNetworkPolicy(e watch.Event) error {
+	switch e.Type {
+	case watch.Added, watch.Modified:
+		if np, ok := e.Object.(*apiv3.NetworkPolicy); ok {
+			p.setNetworkPolicy(np)
+		}
+	case watch.Deleted:
+		if np, ok := e.Previous.(*apiv3.NetworkPolicy); ok {
+			p.remove(np.Namespace + "/" + np.Name)
+		}
+	case watch.Error:
+		return e.Error
+	}
+	return nil
+}
+
+// applyGlobalNetworkPolicy updates the index from a GlobalNetworkPolicy watch event.
+func (p *policyIndex) applyGlobalNetworkPolicy(e watch.Event) error {
+	switch e.Type {
+	case watch.Added, watch.Modified:
+		if gnp, ok := e.Object.(*apiv3.GlobalNetworkPolicy); ok {
+			p.setGlobalNetworkPolicy(gnp)
+		}
+	case watch.Deleted:
+		if gnp, ok := e.Previous.(*apiv3.GlobalNetworkPolicy); ok {
+			p.remove(gnp.Name)
+		}
+	case watch.Error:
+		return e.Error
+	}
+	return nil
+}
+
+// run watches both kinds of policy.  It never returns.
+func (p *policyIndex) run(client clientv3.Interface) {
+	go runCalicoWatch("networkpolicies",
+		func(ctx context.Context) (string, error) {
+			list, err := client.NetworkPolicies().List(ctx, options.ListOptions{})
+			if err != nil {
+				return "", err
+			}
+			p.reset(false)
+			for i := range list.Items {
+				p.setNetworkPolicy(&list.Items[i])
+			}
+			p.markSynced("networkpolicies")
+			return list.ResourceVersion, nil
+		},
+		client.NetworkPolicies().Watch,
+		p.applyNetworkPolicy)
+	runCalicoWatch("globalnetworkpolicies",
+		func(ctx context.Context) (string, error) {
+			list, err := client.GlobalNetworkPolicies().List(ctx, options.ListOptions{})
+			if err != nil {
+				return "", err
+			}
+			p.reset(true)
+			for i := range list.Items {
+				p.setGlobalNetworkPolicy(&list.Items[i])
+			}
+			p.markSynced("globalnetworkpolicies")
+			return list.ResourceVersion, nil
+		},
+		client.GlobalNetworkPolicies().Watch,
+		p.applyGlobalNetworkPolicy)
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	apiv3 "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/libcalico-go/lib/watch"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var httpRule = apiv3.Rule{Action: apiv3.Allow, HTTP: &apiv3.HTTPMatch{Methods: []string{"GET"}}}
+
+func TestHasL7Rules(t *testing.T) {
+	RegisterTestingT(t)
+
+	saRule := apiv3.Rule{Action: apiv3.Allow, Source: apiv3.EntityRule{
+		ServiceAccounts: &apiv3.ServiceAccountMatch{Names: []string{"frontend"}}}}
+	Expect(hasL7Rules(nil, []apiv3.Rule{{Action: apiv3.Allow}})).To(BeFalse())
+	Expect(hasL7Rules(nil, []apiv3.Rule{{Action: apiv3.Allow}, httpRule})).To(BeTrue())
+	Expect(hasL7Rules(nil, []apiv3.Rule{saRule})).To(BeTrue())
+	Expect(hasL7Rules([]apiv3.PolicyType{apiv3.PolicyTypeEgress}, []apiv3.Rule{httpRule})).To(BeFalse())
+}
+
+func TestPolicySelects(t *testing.T) {
+	RegisterTestingT(t)
+
+	p := newPolicyIndex()
+	Expect(p.check()).To(Equal(errPoliciesNotSynced))
+	np := &apiv3.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "testns"},
+		Spec:       apiv3.NetworkPolicySpec{Selector: "app == 'web'", Ingress: []apiv3.Rule{httpRule}},
+	}
+	Expect(p.applyNetworkPolicy(watch.Event{Type: watch.Added, Object: np})).To(Succeed())
+
+	web := map[string]string{"app": "web"}
+	Expect(p.selects(endpointInfo{Namespace: "testns", Labels: web})).To(BeTrue())
+	Expect(p.selects(endpointInfo{Namespace: "other", Labels: web})).To(BeFalse())
+	Expect(p.selects(endpointInfo{Namespace: "testns", Labels: map[string]string{"app": "db"}})).To(BeFalse())
+
+	gnp := &apiv3.GlobalNetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "all"},
+		Spec:       apiv3.GlobalNetworkPolicySpec{Selector: "all()", Ingress: []apiv3.Rule{httpRule}},
+	}
+	Expect(p.applyGlobalNetworkPolicy(watch.Event{Type: watch.Added, Object: gnp})).To(Succeed())
+	Expect(p.selects(endpointInfo{Namespace: "other"})).To(BeTrue())
+
+	// Dropping the application layer rules takes a policy out of the index.
+	gnp2 := *gnp
+	gnp2.Spec.Ingress = []apiv3.Rule{{Action: apiv3.Allow}}
+	Expect(p.applyGlobalNetworkPolicy(watch.Event{Type: watch.Modified, Object: &gnp2})).To(Succeed())
+	Expect(p.selects(endpointInfo{Namespace: "other"})).To(BeFalse())
+
+	Expect(p.applyNetworkPolicy(watch.Event{Type: watch.Deleted, Previous: np})).To(Succeed())
+	Expect(p.selects(endpointInfo{Namespace: "testns", Labels: web})).To(BeFalse())
+
+	p.markSynced("networkpolicies")
+	p.markSynced("globalnetworkpolicies")
+	Expect(p.check()).To(BeNil())
+}
+
+func TestNoL7PolicySkip(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { calicoEndpoints, l7Policies = nil, nil }()
+	calicoEndpoints = newEndpointIndex()
+	wep := testWEP("testpod", NODE_IP+"/32")
+	wep.Labels = map[string]string{"app": "web"}
+	calicoEndpoints.update(wep)
+	l7Policies = newPolicyIndex()
+
+	sn := serviceNode("sidecar", NODE_IP)
+	Expect(skipNode(SERVICE_CLUSTER, sn, "sidecar", NODE_IP)).To(Equal(SkipNoL7Policy))
+	l7Policies.setNetworkPolicy(&apiv3.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "testns"},
+		Spec:       apiv3.NetworkPolicySpec{Selector: "app == 'web'", Ingress: []apiv3.Rule{httpRule}},
+	})
+	Expect(skipNode(SERVICE_CLUSTER, sn, "sidecar", NODE_IP)).To(Equal(skipReason("")))
+}
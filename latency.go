@@ -0,0 +1,82 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// slowRequestThreshold is the hook duration above which a request is counted and logged as slow.  0 disables.
+var slowRequestThreshold time.Duration
+
+var requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: metricsNamespace,
+	Name:      "request_duration_seconds",
+	Help:      "Time taken to handle hook requests, by hook and service cluster.",
+	Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+}, []string{"hook", "service_cluster"})
+
+var slowRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "slow_requests_total",
+	Help:      "Hook requests slower than the slow request threshold, by hook.",
+}, []string{"hook"})
+
+func init() {
+	prometheus.MustRegister(requestDuration, slowRequests)
+}
+
+// observeLatency records how long a hook call took.  Slow calls are counted and logged with the node, the trace ID and
+// a breakdown of the handler's steps; this client library predates exemplars, so the log line is what links the
+// metric to a trace.
+func observeLatency(req *restful.Request, hook, cluster string, d time.Duration) {
+	requestDuration.WithLabelValues(hook, cluster).Observe(d.Seconds())
+	if slowRequestThreshold <= 0 || d < slowRequestThreshold {
+		return
+	}
+	slowRequests.WithLabelValues(hook).Inc()
+	if profiler != nil {
+		profiler.requestSlow()
+	}
+	fields := log.Fields{
+		"hook":        hook,
+		"serviceNode": req.PathParameter("serviceNode"),
+		"duration":    d,
+		"threshold":   slowRequestThreshold,
+	}
+	if steps := statsFor(req).Steps; len(steps) > 0 {
+		fields["steps"] = formatSteps(steps)
+	}
+	if sc := trace.SpanContextFromContext(req.Request.Context()); sc.IsValid() {
+		fields["traceID"] = sc.TraceID().String()
+	}
+	errorLog.Warn(fields, "Slow hook request")
+}
+
+// formatSteps renders step timings as e.g. "decode=1.2ms mutate=300µs".
+func formatSteps(steps []stepTiming) string {
+	parts := make([]string, len(steps))
+	for i, s := range steps {
+		parts[i] = fmt.Sprintf("%s=%s", s.Name, s.Duration)
+	}
+	return strings.Join(parts, " ")
+}
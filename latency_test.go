@@ -0,0 +1,102 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+	apiv3 "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestFormatSteps(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(formatSteps([]stepTiming{
+		{Name: "decode", Duration: 2 * time.Millisecond},
+		{Name: "mutate", Duration: 300 * time.Microsecond},
+	})).To(Equal("decode=2ms mutate=300µs"))
+}
+
+func TestSlowRequests(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func(old time.Duration, oldLog *sampledLogger) {
+		slowRequestThreshold, errorLog = old, oldLog
+	}(slowRequestThreshold, errorLog)
+	errorLog = newSampledLogger(1, time.Hour)
+	slowRequestThreshold = time.Second
+
+	req := restful.NewRequest(httptest.NewRequest("POST", "/v1/listeners/c/n", nil))
+	statsFor(req).Steps = []stepTiming{{Name: "decode", Duration: time.Second}}
+	before := testutil.ToFloat64(slowRequests.WithLabelValues("routes"))
+
+	observeLatency(req, "routes", "", time.Millisecond)
+	Expect(testutil.ToFloat64(slowRequests.WithLabelValues("routes"))).To(Equal(before))
+	Expect(errorLog.windows).To(BeEmpty())
+
+	observeLatency(req, "routes", "", 2*time.Second)
+	Expect(testutil.ToFloat64(slowRequests.WithLabelValues("routes"))).To(Equal(before + 1))
+	Expect(errorLog.windows).To(HaveKey("Slow hook request"))
+}
+
+func TestStepRecordsTiming(t *testing.T) {
+	RegisterTestingT(t)
+
+	stats := &hookStats{}
+	req := httptest.NewRequest("POST", "/", nil)
+	s := startStep(req.Context(), stats, "decode")
+	s.End()
+	endWithError(startStep(req.Context(), stats, "encode"), errNoHTTPConnectionManager)
+
+	Expect(stats.Steps).To(HaveLen(2))
+	Expect(stats.Steps[0].Name).To(Equal("decode"))
+	Expect(stats.Steps[1].Name).To(Equal("encode"))
+}
+
+func stepNames(req *restful.Request) []string {
+	var names []string
+	for _, s := range statsFor(req).Steps {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func TestHookSteps(t *testing.T) {
+	RegisterTestingT(t)
+
+	req := newLDSRequest("sidecar", bytes.NewReader(benchLDS(1)))
+	listeners(req, restful.NewResponse(httptest.NewRecorder()))
+	Expect(stepNames(req)).To(Equal([]string{"read", "decode", "classify", "mutate", "encode", "validate"}))
+
+	defer func() { dikastes = nil }()
+	dikastes = staticResolver("tcp://10.96.0.20:9000")
+	req = newCDSRequest("sidecar", strings.NewReader(`{"clusters":[]}`))
+	clusters(req, restful.NewResponse(httptest.NewRecorder()))
+	Expect(stepNames(req)).To(Equal([]string{"read", "decode", "mutate", "encode", "validate"}))
+
+	defer func() { networkSets = nil }()
+	networkSets, _ = newNetworkSetIndex("", "role == 'blocked'")
+	networkSets.replace([]apiv3.GlobalNetworkSet{testNetworkSet("blocked", "blocked", "10.0.5.0/24")})
+	req = newEDSRequest(strings.NewReader(`{"hosts":[{"ip_address":"10.0.5.1","port":80}]}`))
+	endpoints(req, restful.NewResponse(httptest.NewRecorder()))
+	Expect(stepNames(req)).To(Equal([]string{"read", "decode", "mutate", "encode"}))
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+var leader = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "leader",
+	Help:      "1 if this replica runs the controller style tasks, 0 if another replica holds the leader lease.",
+})
+
+func init() {
+	prometheus.MustRegister(leader)
+}
+
+// leaderTask is a controller style feature that only one replica should run, unlike the hooks, which every replica
+// serves.
+type leaderTask struct {
+	name string
+	run  func(stop <-chan struct{})
+}
+
+var leaderTasks []leaderTask
+
+// registerLeaderTask adds a task to run once this replica leads.  Tasks must be registered before they are started.
+func registerLeaderTask(name string, run func(stop <-chan struct{})) {
+	leaderTasks = append(leaderTasks, leaderTask{name: name, run: run})
+}
+
+// runLeaderTasks starts every task, each of which must return once stop is closed.
+func runLeaderTasks(stop <-chan struct{}) {
+	leader.Set(1)
+	for _, t := range leaderTasks {
+		log.WithField("task", t.name).Info("Starting leader task.")
+		go t.run(stop)
+	}
+}
+
+// electLeader runs the leader tasks whenever this replica holds the lease on the lock ConfigMap.  It never returns.
+func electLeader(client kubernetes.Interface, recorder record.EventRecorder, namespace, name, identity string) {
+	lock, err := resourcelock.New(resourcelock.ConfigMapsResourceLock, namespace, name, client.CoreV1(),
+		resourcelock.ResourceLockConfig{Identity: identity, EventRecorder: recorder})
+	if err != nil {
+		log.WithField("err", err).Fatal("Unable to create leader election lock.")
+	}
+	for {
+		leaderelection.RunOrDie(leaderelection.LeaderElectionConfig{
+			Lock:          lock,
+			LeaseDuration: leaseDuration,
+			RenewDeadline: renewDeadline,
+			RetryPeriod:   retryPeriod,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(stop <-chan struct{}) {
+					log.WithField("identity", identity).Info("Became leader.")
+					runLeaderTasks(stop)
+				},
+				OnStoppedLeading: func() {
+					leader.Set(0)
+					log.WithField("identity", identity).Warn("Lost leadership.")
+				},
+			},
+		})
+	}
+}
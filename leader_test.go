@@ -0,0 +1,44 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRunLeaderTasks(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { leaderTasks = nil }()
+	stopped := make(chan string, 2)
+	for _, name := range []string{"a", "b"} {
+		name := name
+		registerLeaderTask(name, func(stop <-chan struct{}) {
+			<-stop
+			stopped <- name
+		})
+	}
+	stop := make(chan struct{})
+	runLeaderTasks(stop)
+	Expect(testutil.ToFloat64(leader)).To(Equal(1.0))
+	Consistently(stopped).ShouldNot(Receive())
+
+	close(stop)
+	Eventually(stopped).Should(Receive())
+	Eventually(stopped).Should(Receive())
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// lintRule is a piece of config that an Envoy version deprecates.  Envoy versions are held as istioVersions, since
+// they too are compared by major and minor version only.
+type lintRule struct {
+	// Kind is what the rule applies to: "network" or "http" filter names, or "authz" filter config fields.
+	Kind string
+	// Name is the filter name or field.
+	Name string
+	// Since is the first Envoy version that deprecates it.
+	Since istioVersion
+	// Use is what to use instead.
+	Use string
+}
+
+// lintRules are the deprecations lint knows of, covering the filters Pilot's v1 config uses and the config the
+// webhook adds.  Envoy 1.7 deprecated filter names without the envoy. prefix.
+var lintRules = []lintRule{
+	{Kind: "network", Name: "http_connection_manager", Since: istioVersion{1, 7}, Use: "envoy.http_connection_manager"},
+	{Kind: "network", Name: "tcp_proxy", Since: istioVersion{1, 7}, Use: "envoy.tcp_proxy"},
+	{Kind: "network", Name: "mongo_proxy", Since: istioVersion{1, 7}, Use: "envoy.mongo_proxy"},
+	{Kind: "network", Name: "redis_proxy", Since: istioVersion{1, 7}, Use: "envoy.redis_proxy"},
+	{Kind: "network", Name: "ratelimit", Since: istioVersion{1, 7}, Use: "envoy.ratelimit"},
+	{Kind: "network", Name: "client_ssl_auth", Since: istioVersion{1, 7}, Use: "envoy.client_ssl_auth"},
+	{Kind: "http", Name: "router", Since: istioVersion{1, 7}, Use: "envoy.router"},
+	{Kind: "http", Name: "cors", Since: istioVersion{1, 7}, Use: "envoy.cors"},
+	{Kind: "http", Name: "fault", Since: istioVersion{1, 7}, Use: "envoy.fault"},
+	{Kind: "http", Name: "buffer", Since: istioVersion{1, 7}, Use: "envoy.buffer"},
+	{Kind: "http", Name: "lua", Since: istioVersion{1, 7}, Use: "envoy.lua"},
+	{Kind: "http", Name: "rate_limit", Since: istioVersion{1, 7}, Use: "envoy.rate_limit"},
+	{Kind: "http", Name: "health_check", Since: istioVersion{1, 7}, Use: "envoy.health_check"},
+	{Kind: "http", Name: "grpc_web", Since: istioVersion{1, 7}, Use: "envoy.grpc_web"},
+	{Kind: "http", Name: "grpc_json_transcoder", Since: istioVersion{1, 7}, Use: "envoy.grpc_json_transcoder"},
+	{Kind: "http", Name: "grpc_http1_bridge", Since: istioVersion{1, 7}, Use: "envoy.grpc_http1_bridge"},
+	{Kind: "authz", Name: "grpc_cluster", Since: istioVersion{1, 7}, Use: "grpc_service, which --istio-version 0.8 " +
+		"or later configures"},
+}
+
+// lintFinding is a use of deprecated config.
+type lintFinding struct {
+	Listener string
+	Rule     lintRule
+}
+
+func (f lintFinding) String() string {
+	what := map[string]string{"network": "network filter", "http": "HTTP filter", "authz": "authz filter field"}
+	return fmt.Sprintf("%s: %s %q is deprecated since Envoy %v; use %s", f.Listener, what[f.Rule.Kind], f.Rule.Name,
+		f.Rule.Since, f.Rule.Use)
+}
+
+type lintFilter struct {
+	Name   string          `json:"name"`
+	Config json.RawMessage `json:"config"`
+}
+
+// lintLDS returns the deprecated config in an LDS response for an Envoy of the target version.
+func lintLDS(body []byte, target istioVersion) ([]lintFinding, error) {
+	var lds struct {
+		Listeners []struct {
+			Name    string       `json:"name"`
+			Filters []lintFilter `json:"filters"`
+		} `json:"listeners"`
+	}
+	if err := json.Unmarshal(body, &lds); err != nil {
+		return nil, err
+	}
+	var findings []lintFinding
+	check := func(listener, kind, name string) {
+		for _, r := range lintRules {
+			if r.Kind == kind && r.Name == name && target.atLeast(r.Since.Major, r.Since.Minor) {
+				findings = append(findings, lintFinding{Listener: listener, Rule: r})
+			}
+		}
+	}
+	checkAuthz := func(listener string, f lintFilter) {
+		if f.Name != AuthZFilterName {
+			return
+		}
+		var fields map[string]json.RawMessage
+		json.Unmarshal(f.Config, &fields)
+		for field := range fields {
+			check(listener, "authz", field)
+		}
+	}
+	for _, l := range lds.Listeners {
+		for _, f := range l.Filters {
+			check(l.Name, "network", f.Name)
+			checkAuthz(l.Name, f)
+			if f.Name != "http_connection_manager" && f.Name != "envoy.http_connection_manager" {
+				continue
+			}
+			var hcm struct {
+				Filters []lintFilter `json:"filters"`
+			}
+			json.Unmarshal(f.Config, &hcm)
+			for _, h := range hcm.Filters {
+				check(l.Name, "http", h.Name)
+				checkAuthz(l.Name, h)
+			}
+		}
+	}
+	return findings, nil
+}
+
+// runLint writes a line to out for each use of config deprecated by the target Envoy version in the LDS response read
+// from in, after mutating it as the listeners hook would for node if it is set, and returns the number found.
+func runLint(in io.Reader, out io.Writer, node string, target istioVersion) (int, error) {
+	body, err := ioutil.ReadAll(in)
+	if err != nil {
+		return 0, err
+	}
+	if node != "" {
+		var mutated bytes.Buffer
+		if err := runTransform("listeners", node, bytes.NewReader(body), &mutated); err != nil {
+			return 0, err
+		}
+		body = mutated.Bytes()
+	}
+	findings, err := lintLDS(body, target)
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range findings {
+		fmt.Fprintln(out, f)
+	}
+	return len(findings), nil
+}
+
+// lint runs the lint command.  It exits 1 if it finds deprecated config.
+func lint(arguments map[string]interface{}) {
+	target, ok := parseIstioVersion(arguments["--envoy-version"].(string))
+	if !ok {
+		log.WithField("version", arguments["--envoy-version"]).Fatal("Invalid --envoy-version.")
+	}
+	in := os.Stdin
+	if file, ok := arguments["<file>"].(string); ok {
+		f, err := os.Open(file)
+		if err != nil {
+			log.WithFields(log.Fields{"file": file, "err": err}).Fatal("Unable to read xDS response.")
+		}
+		defer f.Close()
+		in = f
+	}
+	node, _ := arguments["--lint-node"].(string)
+	n, err := runLint(in, os.Stdout, node, target)
+	if err != nil {
+		log.WithField("err", err).Fatal("Unable to lint xDS response.")
+	}
+	if n > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pilottest"
+)
+
+func TestLintLDS(t *testing.T) {
+	RegisterTestingT(t)
+
+	lds := pilottest.NewSidecar(NODE_IP, pilottest.NewMesh(2)).LDS()
+	findings, err := lintLDS(lds, istioVersion{1, 6})
+	Expect(err).To(BeNil())
+	Expect(findings).To(BeEmpty())
+
+	findings, err = lintLDS(lds, istioVersion{1, 7})
+	Expect(err).To(BeNil())
+	Expect(findings).NotTo(BeEmpty())
+	names := map[string]bool{}
+	for _, f := range findings {
+		names[f.Rule.Name] = true
+	}
+	Expect(names).To(HaveKey("http_connection_manager"))
+	Expect(names).To(HaveKey("tcp_proxy"))
+	Expect(names).To(HaveKey("router"))
+
+	findings, err = lintLDS([]byte(`{"listeners":[{"name":"http_3.4.5.6_80","filters":[
+		{"name":"envoy.http_connection_manager","config":{"filters":[{"name":"envoy.router","config":{}}]}}]}]}`),
+		istioVersion{1, 7})
+	Expect(err).To(BeNil())
+	Expect(findings).To(BeEmpty())
+
+	_, err = lintLDS([]byte(`{"listeners":`), istioVersion{1, 7})
+	Expect(err).NotTo(BeNil())
+}
+
+func TestLintMutated(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { istioVersions = nil }()
+	lds := `{"listeners":[{"name":"tcp_3.4.5.6_3306","address":"tcp://3.4.5.6:3306","filters":[
+		{"name":"envoy.tcp_proxy","config":{}}]}]}`
+
+	// Raw, there is nothing deprecated.
+	var out bytes.Buffer
+	n, err := runLint(strings.NewReader(lds), &out, "", istioVersion{1, 7})
+	Expect(err).To(BeNil())
+	Expect(n).To(Equal(0))
+	Expect(out.Len()).To(Equal(0))
+
+	// Mutated for a sidecar of unknown version, the authz filter has the older grpc_cluster config.
+	n, err = runLint(strings.NewReader(lds), &out, serviceNode("sidecar", NODE_IP), istioVersion{1, 7})
+	Expect(err).To(BeNil())
+	Expect(n).To(Equal(1))
+	Expect(out.String()).To(Equal(`tcp_3.4.5.6_3306: authz filter field "grpc_cluster" is deprecated since Envoy 1.7; ` +
+		"use grpc_service, which --istio-version 0.8 or later configures\n"))
+
+	istioVersions, err = newVersionDetector("0.8")
+	Expect(err).To(BeNil())
+	out.Reset()
+	n, err = runLint(strings.NewReader(lds), &out, serviceNode("sidecar", NODE_IP), istioVersion{1, 7})
+	Expect(err).To(BeNil())
+	Expect(n).To(Equal(0))
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/pilot-webhook/pilottest"
+)
+
+// loadTestPercentiles are the latency percentiles loadtest reports.
+var loadTestPercentiles = []float64{50, 90, 99}
+
+// loadTest sends synthetic hook requests to a webhook from several goroutines at once.
+type loadTest struct {
+	pilot *pilottest.Pilot
+	// hooks are the hooks called, in turn: listeners and clusters.
+	hooks []string
+	// nodes are the sidecars requests are made for, and payloads the bodies sent to each hook, one per sidecar,
+	// generated up front so that generating them is not timed.
+	nodes    []string
+	payloads map[string][][]byte
+	// concurrency is the number of requests in flight at once, and requests the total sent.
+	concurrency int
+	requests    int
+}
+
+// newLoadTest returns a load test of sidecars in a mesh of services, which sets the size of each payload.
+func newLoadTest(pilot *pilottest.Pilot, hooks []string, services, sidecars, concurrency,
+	requests int) (*loadTest, error) {
+	if services < 1 || sidecars < 1 || concurrency < 1 || requests < 1 {
+		return nil, fmt.Errorf("services, sidecars, concurrency and requests must be at least 1")
+	}
+	lt := &loadTest{pilot: pilot, hooks: hooks, payloads: make(map[string][][]byte), concurrency: concurrency,
+		requests: requests}
+	mesh := pilottest.NewMesh(services)
+	for i := 0; i < sidecars; i++ {
+		s := pilottest.NewSidecar(fmt.Sprintf("10.%d.%d.%d", 1+(i>>16), i>>8&0xff, i&0xff), mesh)
+		lt.nodes = append(lt.nodes, s.Node())
+		for _, hook := range hooks {
+			switch hook {
+			case "listeners":
+				lt.payloads[hook] = append(lt.payloads[hook], s.LDS())
+			case "clusters":
+				lt.payloads[hook] = append(lt.payloads[hook], s.CDS())
+			default:
+				return nil, fmt.Errorf("unknown hook %q: expected listeners or clusters", hook)
+			}
+		}
+	}
+	return lt, nil
+}
+
+// loadTestResult is what one hook saw over a load test.
+type loadTestResult struct {
+	hook      string
+	latencies []time.Duration
+	bytes     int64
+	errors    int
+}
+
+// percentile returns the nearest rank percentile of the sorted latencies.
+func (r *loadTestResult) percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p/100*float64(len(r.latencies)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return r.latencies[i]
+}
+
+func (lt *loadTest) call(i int) (string, int, time.Duration, error) {
+	hook := lt.hooks[i%len(lt.hooks)]
+	sidecar := i / len(lt.hooks) % len(lt.nodes)
+	body := lt.payloads[hook][sidecar]
+	start := time.Now()
+	var resp *pilottest.Response
+	var err error
+	if hook == "listeners" {
+		resp, err = lt.pilot.Listeners(lt.nodes[sidecar], body)
+	} else {
+		resp, err = lt.pilot.Clusters(lt.nodes[sidecar], body)
+	}
+	d := time.Since(start)
+	if err == nil && resp.Status != http.StatusOK {
+		err = fmt.Errorf("status %d", resp.Status)
+	}
+	return hook, len(body), d, err
+}
+
+// run sends the requests and returns the results for each hook, with their latencies sorted, and how long it took.
+func (lt *loadTest) run() ([]*loadTestResult, time.Duration) {
+	results := make(map[string]*loadTestResult)
+	for _, hook := range lt.hooks {
+		results[hook] = &loadTestResult{hook: hook}
+	}
+	var lock sync.Mutex
+	var next int64 = -1
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < lt.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= lt.requests {
+					return
+				}
+				hook, size, d, err := lt.call(i)
+				lock.Lock()
+				r := results[hook]
+				r.latencies = append(r.latencies, d)
+				r.bytes += int64(size)
+				if err != nil {
+					r.errors++
+					if r.errors == 1 {
+						log.WithFields(log.Fields{"hook": hook, "err": err}).Warn("Load test request failed.")
+					}
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	var out []*loadTestResult
+	for _, hook := range lt.hooks {
+		r := results[hook]
+		sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+		out = append(out, r)
+	}
+	return out, elapsed
+}
+
+// writeLoadTestReport writes a table of the latencies seen by each hook.
+func writeLoadTestReport(out io.Writer, results []*loadTestResult, elapsed time.Duration) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprint(w, "HOOK\tREQUESTS\tERRORS\tAVG BODY")
+	for _, p := range loadTestPercentiles {
+		fmt.Fprintf(w, "\tP%g", p)
+	}
+	fmt.Fprint(w, "\tMAX\n")
+	total := 0
+	for _, r := range results {
+		n := len(r.latencies)
+		total += n
+		var avg int64
+		if n > 0 {
+			avg = r.bytes / int64(n)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%dB", r.hook, n, r.errors, avg)
+		for _, p := range loadTestPercentiles {
+			fmt.Fprintf(w, "\t%v", r.percentile(p).Round(time.Microsecond))
+		}
+		fmt.Fprintf(w, "\t%v\n", r.percentile(100).Round(time.Microsecond))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(out, "\n%d requests in %v, %.1f/s\n", total, elapsed.Round(time.Millisecond),
+		float64(total)/elapsed.Seconds())
+	return err
+}
+
+// loadtest runs the loadtest command.  It exits 1 if any request failed.
+func loadtest(arguments map[string]interface{}) {
+	ints := make(map[string]int)
+	for _, flag := range []string{"--loadtest-services", "--loadtest-sidecars", "--loadtest-concurrency",
+		"--loadtest-requests"} {
+		n, err := strconv.Atoi(arguments[flag].(string))
+		if err != nil {
+			log.WithField("err", err).Fatalf("Invalid %s.", flag)
+		}
+		ints[flag] = n
+	}
+	lt, err := newLoadTest(pilottest.New(arguments["<path>"].(string)),
+		strings.Split(arguments["--loadtest-hooks"].(string), ","), ints["--loadtest-services"],
+		ints["--loadtest-sidecars"], ints["--loadtest-concurrency"], ints["--loadtest-requests"])
+	if err != nil {
+		log.WithField("err", err).Fatal("Unable to set up load test.")
+	}
+	results, elapsed := lt.run()
+	if err := writeLoadTestReport(os.Stdout, results, elapsed); err != nil {
+		log.WithField("err", err).Fatal("Unable to write load test report.")
+	}
+	for _, r := range results {
+		if r.errors > 0 {
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pilottest"
+)
+
+func TestLoadTest(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { dikastes = nil }()
+	dikastes = staticResolver("tcp://10.96.0.20:9000")
+	pilot, stop := startHookServer(t)
+	defer stop()
+
+	lt, err := newLoadTest(pilot, []string{"listeners", "clusters"}, 10, 3, 4, 50)
+	Expect(err).To(BeNil())
+	Expect(lt.nodes).To(HaveLen(3))
+	results, elapsed := lt.run()
+	Expect(results).To(HaveLen(2))
+	for _, r := range results {
+		Expect(r.latencies).To(HaveLen(25), r.hook)
+		Expect(r.errors).To(Equal(0), r.hook)
+		Expect(r.percentile(50)).To(BeNumerically("<=", r.percentile(99)))
+	}
+
+	var out bytes.Buffer
+	Expect(writeLoadTestReport(&out, results, elapsed)).To(Succeed())
+	lines := strings.Split(out.String(), "\n")
+	Expect(strings.Fields(lines[0])).To(Equal([]string{"HOOK", "REQUESTS", "ERRORS", "AVG", "BODY", "P50", "P90",
+		"P99", "MAX"}))
+	Expect(strings.Fields(lines[1])[:3]).To(Equal([]string{"listeners", "25", "0"}))
+	Expect(strings.Fields(lines[2])[:3]).To(Equal([]string{"clusters", "25", "0"}))
+	Expect(out.String()).To(ContainSubstring("\n50 requests in "))
+}
+
+func TestLoadTestErrors(t *testing.T) {
+	RegisterTestingT(t)
+
+	// Nothing serves on the socket, so every request fails.
+	lt, err := newLoadTest(pilottest.New("/nonexistent/webhook.sock"), []string{"listeners"}, 1, 1, 2, 4)
+	Expect(err).To(BeNil())
+	results, _ := lt.run()
+	Expect(results[0].errors).To(Equal(4))
+
+	_, err = newLoadTest(pilottest.New(""), []string{"routes"}, 1, 1, 1, 1)
+	Expect(err).NotTo(BeNil())
+	_, err = newLoadTest(pilottest.New(""), []string{"listeners"}, 1, 1, 0, 1)
+	Expect(err).NotTo(BeNil())
+}
+
+func TestPercentile(t *testing.T) {
+	RegisterTestingT(t)
+
+	r := &loadTestResult{}
+	Expect(r.percentile(50)).To(Equal(time.Duration(0)))
+	for i := 1; i <= 100; i++ {
+		r.latencies = append(r.latencies, time.Duration(i)*time.Millisecond)
+	}
+	Expect(r.percentile(50)).To(Equal(50 * time.Millisecond))
+	Expect(r.percentile(99)).To(Equal(99 * time.Millisecond))
+	Expect(r.percentile(100)).To(Equal(100 * time.Millisecond))
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultErrorLogBurst = 10
+const defaultErrorLogInterval = time.Minute
+
+// errorLog rate limits the error logs on the per-request hot paths.
+var errorLog = newSampledLogger(defaultErrorLogBurst, defaultErrorLogInterval)
+
+// debugEnabled reports whether Debug logs are written, so that the per-request hot paths can skip building them.
+func debugEnabled() bool {
+	return log.GetLevel() >= log.DebugLevel
+}
+
+// sampledLogger rate limits repeated error logs.  Each distinct message is logged at most burst times per interval;
+// further occurrences are counted and reported in a single summary line once the interval is over.
+type sampledLogger struct {
+	burst    int
+	interval time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	windows map[string]*logWindow
+}
+
+type logWindow struct {
+	start      time.Time
+	logged     int
+	suppressed int
+}
+
+func newSampledLogger(burst int, interval time.Duration) *sampledLogger {
+	return &sampledLogger{
+		burst:    burst,
+		interval: interval,
+		now:      time.Now,
+		windows:  make(map[string]*logWindow),
+	}
+}
+
+// Error logs msg at Error level unless the message has exceeded its budget for the current interval.  It returns
+// whether the message was logged, so callers can skip any follow-on output for suppressed errors.
+func (s *sampledLogger) Error(fields log.Fields, msg string) bool {
+	if !s.allow(msg) {
+		return false
+	}
+	log.WithFields(fields).Error(msg)
+	return true
+}
+
+// Warn is like Error, but logs at Warning level.
+func (s *sampledLogger) Warn(fields log.Fields, msg string) bool {
+	if !s.allow(msg) {
+		return false
+	}
+	log.WithFields(fields).Warn(msg)
+	return true
+}
+
+func (s *sampledLogger) allow(msg string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	w, ok := s.windows[msg]
+	if !ok || now.Sub(w.start) >= s.interval {
+		if ok {
+			reportSuppressed(msg, w)
+		}
+		w = &logWindow{start: now}
+		s.windows[msg] = w
+	}
+	if w.logged < s.burst {
+		w.logged++
+		return true
+	}
+	w.suppressed++
+	return false
+}
+
+// flush reports and forgets any windows that have expired, so summaries are emitted even once a storm has stopped.
+func (s *sampledLogger) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for msg, w := range s.windows {
+		if now.Sub(w.start) >= s.interval {
+			reportSuppressed(msg, w)
+			delete(s.windows, msg)
+		}
+	}
+}
+
+// run periodically flushes expired windows.  It never returns.
+func (s *sampledLogger) run() {
+	for range time.Tick(s.interval) {
+		s.flush()
+	}
+}
+
+func reportSuppressed(msg string, w *logWindow) {
+	if w.suppressed == 0 {
+		return
+	}
+	log.WithFields(log.Fields{
+		"error":      msg,
+		"suppressed": w.suppressed,
+		"since":      w.start,
+	}).Warn("Suppressed repeated error logs")
+}
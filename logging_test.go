@@ -0,0 +1,91 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+func newTestSampledLogger(burst int) (*sampledLogger, *time.Time) {
+	now := time.Unix(1000, 0)
+	s := newSampledLogger(burst, time.Minute)
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func TestSampledLoggerBurst(t *testing.T) {
+	RegisterTestingT(t)
+
+	s, _ := newTestSampledLogger(2)
+	Expect(s.allow("boom")).To(BeTrue())
+	Expect(s.allow("boom")).To(BeTrue())
+	Expect(s.allow("boom")).To(BeFalse())
+	Expect(s.allow("boom")).To(BeFalse())
+	Expect(s.windows["boom"].suppressed).To(Equal(2))
+
+	// Other messages have their own budget.
+	Expect(s.allow("bang")).To(BeTrue())
+}
+
+func TestSampledLoggerNewInterval(t *testing.T) {
+	RegisterTestingT(t)
+
+	s, now := newTestSampledLogger(1)
+	Expect(s.allow("boom")).To(BeTrue())
+	Expect(s.allow("boom")).To(BeFalse())
+	*now = now.Add(time.Minute)
+	Expect(s.allow("boom")).To(BeTrue())
+	Expect(s.windows["boom"].suppressed).To(Equal(0))
+}
+
+func TestSampledLoggerFlush(t *testing.T) {
+	RegisterTestingT(t)
+
+	s, now := newTestSampledLogger(1)
+	s.allow("boom")
+	s.allow("boom")
+	s.flush()
+	Expect(s.windows).To(HaveKey("boom"))
+	*now = now.Add(2 * time.Minute)
+	s.flush()
+	Expect(s.windows).To(BeEmpty())
+}
+
+func TestListenersOutcomeBatchesDebug(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer log.SetLevel(log.GetLevel())
+	log.SetLevel(log.InfoLevel)
+	o := newListenersOutcome("sidecar~1.2.3.4~a.b~b.svc.cluster.local", &hookStats{})
+	o.add(nil, mutator.ListenerResult{Name: "http_10.0.0.1_80", Skip: SkipOutbound})
+	Expect(o.skipped).To(BeNil())
+
+	log.SetLevel(log.DebugLevel)
+	o = newListenersOutcome("sidecar~1.2.3.4~a.b~b.svc.cluster.local", &hookStats{})
+	req := &mutator.Request{Node: config.ParseNode(serviceNode("sidecar", NODE_IP)), Profile: profileFor(istioVersion{})}
+	req.OnListener = o.add
+	_, _, err := hookAuthz.Listeners(req, benchLDS(2))
+	Expect(err).To(BeNil())
+	Expect(o.skipped).To(Equal(map[skipReason]int{SkipOutbound: 8}))
+	Expect(o.changed).To(HaveLen(2))
+	Expect(o.stats.Listeners).To(Equal(10))
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// meshes holds per cluster settings for a webhook serving several clusters or meshes.  It is nil unless --meshes is
+// set.
+var meshes *meshTable
+
+// nodeSelector selects nodes by their service cluster and the DNS domain in their service node, for meshes and rules
+// alike.  A node must match every field that is set; unset fields match anything.
+type nodeSelector struct {
+	// ServiceClusters are glob patterns matched against the service cluster.  The node must match one of them.
+	ServiceClusters []string `json:"serviceClusters,omitempty"`
+	// Domain matches service nodes whose DNS domain is or ends with it, e.g. cluster2.local.
+	Domain string `json:"domain,omitempty"`
+}
+
+// validate returns an error if a service cluster pattern is malformed.
+func (s *nodeSelector) validate() error {
+	for _, p := range s.ServiceClusters {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid service cluster pattern %q", p)
+		}
+	}
+	return nil
+}
+
+// empty reports whether the selector has no fields set, and so matches every node.
+func (s *nodeSelector) empty() bool {
+	return len(s.ServiceClusters) == 0 && s.Domain == ""
+}
+
+// matches reports whether a node with a service cluster and DNS domain matches every field that is set.
+func (s *nodeSelector) matches(serviceCluster, domain string) bool {
+	if s.Domain != "" && domain != s.Domain && !strings.HasSuffix(domain, "."+s.Domain) {
+		return false
+	}
+	if len(s.ServiceClusters) == 0 {
+		return true
+	}
+	for _, p := range s.ServiceClusters {
+		if ok, _ := path.Match(p, serviceCluster); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// meshConfig is the settings for one cluster or mesh.  Nodes are matched by its selector, and take the settings of
+// the first mesh they match.
+type meshConfig struct {
+	Name string `json:"name"`
+	nodeSelector
+	// DikastesAddress is the authz cluster's host, as a tcp:// or unix:// URL.  It overrides --dikastes-discovery.
+	DikastesAddress string `json:"dikastesAddress,omitempty"`
+	// Inject set to false leaves the mesh's sidecars alone.
+	Inject *bool `json:"inject,omitempty"`
+	// ExcludeNamespaces are namespaces in the mesh that are never injected.
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+}
+
+type meshTable struct {
+	Meshes []meshConfig `json:"meshes"`
+}
+
+func loadMeshes(file string) (*meshTable, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseMeshes(b)
+}
+
+func parseMeshes(b []byte) (*meshTable, error) {
+	var t meshTable
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, err
+	}
+	for _, m := range t.Meshes {
+		if m.empty() {
+			return nil, fmt.Errorf("mesh %q matches every node; set serviceClusters or domain", m.Name)
+		}
+		if err := m.validate(); err != nil {
+			return nil, fmt.Errorf("mesh %q: %v", m.Name, err)
+		}
+		if m.DikastesAddress != "" && !strings.HasPrefix(m.DikastesAddress, "tcp://") &&
+			!strings.HasPrefix(m.DikastesAddress, "unix://") {
+			return nil, fmt.Errorf("mesh %q: dikastesAddress must be a tcp:// or unix:// URL", m.Name)
+		}
+	}
+	return &t, nil
+}
+
+// forNode returns the settings for a node, or nil if it is in none of the meshes.
+func (t *meshTable) forNode(serviceCluster, serviceNode string) *meshConfig {
+	var domain string
+	if c := strings.Split(serviceNode, serviceNodeSeparator); len(c) >= 4 {
+		domain = c[3]
+	}
+	for i := range t.Meshes {
+		if m := &t.Meshes[i]; m.matches(serviceCluster, domain) {
+			return m
+		}
+	}
+	return nil
+}
+
+// meshFor returns the settings for a node, or nil if there are none.
+func meshFor(serviceCluster, serviceNode string) *meshConfig {
+	if meshes == nil {
+		return nil
+	}
+	return meshes.forNode(serviceCluster, serviceNode)
+}
+
+// skip returns why the mesh's settings leave a node alone, or "".
+func (m *meshConfig) skip(serviceNode string) skipReason {
+	if m.Inject != nil && !*m.Inject {
+		return SkipMeshDisabled
+	}
+	if len(m.ExcludeNamespaces) > 0 {
+		_, namespace, _ := podFromServiceNode(serviceNode)
+		for _, ns := range m.ExcludeNamespaces {
+			if ns == namespace {
+				return SkipNamespaceNotSelected
+			}
+		}
+	}
+	return ""
+}
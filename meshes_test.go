@@ -0,0 +1,91 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+)
+
+const testMeshes = `{"meshes": [
+  {"name": "east", "serviceClusters": ["test*"], "dikastesAddress": "tcp://10.0.0.9:9000",
+   "excludeNamespaces": ["istio-system"]},
+  {"name": "west", "domain": "west.local", "inject": false}
+]}`
+
+func TestParseMeshesInvalid(t *testing.T) {
+	RegisterTestingT(t)
+
+	for _, body := range []string{
+		`{"meshes": [{"name": "none"}]}`,
+		`{"meshes": [{"name": "bad", "serviceClusters": ["["]}]}`,
+		`{"meshes": [{"name": "bad", "domain": "x.local", "dikastesAddress": "10.0.0.9:9000"}]}`,
+		`not JSON`,
+	} {
+		_, err := parseMeshes([]byte(body))
+		Expect(err).NotTo(BeNil(), body)
+	}
+}
+
+func TestMeshForNode(t *testing.T) {
+	RegisterTestingT(t)
+
+	m, err := parseMeshes([]byte(testMeshes))
+	Expect(err).To(BeNil())
+	Expect(m.forNode("testcluster", serviceNode("sidecar", NODE_IP)).Name).To(Equal("east"))
+	Expect(m.forNode("other", "sidecar~1.2.3.4~a.b~b.svc.west.local").Name).To(Equal("west"))
+	Expect(m.forNode("other", serviceNode("sidecar", NODE_IP))).To(BeNil())
+}
+
+func TestMeshMatchesEveryField(t *testing.T) {
+	RegisterTestingT(t)
+
+	m, err := parseMeshes([]byte(`{"meshes": [{"name": "east", "serviceClusters": ["test*"], "domain": "east.local"}]}`))
+	Expect(err).To(BeNil())
+	Expect(m.forNode("testcluster", "sidecar~1.2.3.4~a.b~b.svc.east.local").Name).To(Equal("east"))
+	Expect(m.forNode("testcluster", "sidecar~1.2.3.4~a.b~b.svc.west.local")).To(BeNil())
+	Expect(m.forNode("other", "sidecar~1.2.3.4~a.b~b.svc.east.local")).To(BeNil())
+}
+
+func TestMeshSkip(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { meshes = nil }()
+	meshes, _ = parseMeshes([]byte(testMeshes))
+	Expect(skipNode("other", "sidecar~1.2.3.4~a.b~b.svc.west.local", "sidecar", "1.2.3.4")).To(Equal(SkipMeshDisabled))
+	Expect(skipNode(SERVICE_CLUSTER, "sidecar~1.2.3.4~a.istio-system~istio-system.svc.cluster.local", "sidecar",
+		"1.2.3.4")).To(Equal(SkipNamespaceNotSelected))
+	Expect(skipNode(SERVICE_CLUSTER, serviceNode("sidecar", NODE_IP), "sidecar", NODE_IP)).To(Equal(skipReason("")))
+}
+
+func TestClustersUsesMeshDikastesAddress(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { meshes = nil }()
+	meshes, _ = parseMeshes([]byte(testMeshes))
+
+	req := newCDSRequest("sidecar", strings.NewReader(`{"clusters": []}`))
+	rec := httptest.NewRecorder()
+	clusters(req, restful.NewResponse(rec))
+	var cds cdsResponse
+	Expect(json.Unmarshal(rec.Body.Bytes(), &cds)).To(Succeed())
+	Expect(len(cds.Clusters)).To(Equal(1))
+	Expect(cds.Clusters[0].Hosts[0].URL).To(Equal("tcp://10.0.0.9:9000"))
+}
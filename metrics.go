@@ -0,0 +1,72 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "sync"
+
+// metricsNamespace prefixes all of the webhook's Prometheus metrics.  Metrics are declared alongside the code that
+// updates them and are served on the admin address at /metrics.
+const metricsNamespace = "pilot_webhook"
+
+// otherServiceCluster is the label value for service clusters that are not given their own.
+const otherServiceCluster = "other"
+
+// serviceClusterLabels caps the service_cluster label values.  It is set up from the --metrics-service-cluster* flags.
+var serviceClusterLabels = newClusterLabeler(nil, 20)
+
+// clusterLabeler maps service clusters to metric label values.  With an allowlist only the listed clusters get their
+// own value; otherwise the first limit clusters seen do.  The rest share otherServiceCluster, so that a large or
+// churning set of meshes cannot blow up the number of series.
+type clusterLabeler struct {
+	allowed map[string]bool
+	limit   int
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func newClusterLabeler(allowed []string, limit int) *clusterLabeler {
+	l := &clusterLabeler{limit: limit, seen: make(map[string]bool)}
+	if len(allowed) > 0 {
+		l.allowed = make(map[string]bool)
+		for _, c := range allowed {
+			l.allowed[c] = true
+		}
+	}
+	return l
+}
+
+// label returns the label value for a service cluster.  EDS requests have no service cluster and are labelled "".
+func (l *clusterLabeler) label(cluster string) string {
+	if cluster == "" {
+		return ""
+	}
+	if l.allowed != nil {
+		if l.allowed[cluster] {
+			return cluster
+		}
+		return otherServiceCluster
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.seen[cluster] {
+		return cluster
+	}
+	if len(l.seen) < l.limit {
+		l.seen[cluster] = true
+		return cluster
+	}
+	return otherServiceCluster
+}
@@ -0,0 +1,40 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestClusterLabelLimit(t *testing.T) {
+	RegisterTestingT(t)
+
+	l := newClusterLabeler(nil, 2)
+	Expect(l.label("a")).To(Equal("a"))
+	Expect(l.label("b")).To(Equal("b"))
+	Expect(l.label("c")).To(Equal(otherServiceCluster))
+	Expect(l.label("a")).To(Equal("a"))
+	Expect(l.label("")).To(Equal(""))
+}
+
+func TestClusterLabelAllowlist(t *testing.T) {
+	RegisterTestingT(t)
+
+	l := newClusterLabeler([]string{"mesh1", "mesh2"}, 0)
+	Expect(l.label("mesh2")).To(Equal("mesh2"))
+	Expect(l.label("mesh3")).To(Equal(otherServiceCluster))
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emicklei/go-restful"
+)
+
+// middleware is a named stage of the pipeline each hook request goes through before its handler.  Each is a no-op
+// unless its feature is enabled, so stages are only left out of the pipeline to reorder or bypass them.
+type middleware struct {
+	name string
+	// feature is the feature that turns the stage on, or "" if it always runs.
+	feature string
+	// hooks are the hooks the stage applies to, or nil for all of them.
+	hooks []string
+	// filter returns the stage's filter for a hook.
+	filter func(hook string) restful.FilterFunction
+}
+
+func (m middleware) appliesTo(hook string) bool {
+	if m.hooks == nil {
+		return true
+	}
+	for _, h := range m.hooks {
+		if h == hook {
+			return true
+		}
+	}
+	return false
+}
+
+// forAllHooks adapts a filter that does not depend on the hook.
+func forAllHooks(f restful.FilterFunction) func(string) restful.FilterFunction {
+	return func(string) restful.FilterFunction { return f }
+}
+
+// middlewares are the pipeline stages, in their default order, outermost first.  Callers are authenticated before
+// anything else is done for them, and then rate limited.  Gzip runs early so that the others only ever see plain JSON, and canonicalization
+// runs inside the history, captures, output sinks and shadow comparisons so that they see what is sent.
+var middlewares = []middleware{
+	{name: "token-auth", feature: "token-auth", filter: forAllHooks(tokenAuthenticated)},
+	{name: "deny-unexpected", feature: "deny-unexpected", hooks: []string{"listeners", "clusters", "routes"},
+		filter: nodeChecked},
+	{name: "rate-limit", feature: "rate-limit", filter: rateLimited},
+	{name: "chaos", feature: "chaos", filter: forAllHooks(chaosInjected)},
+	{name: "gzip", filter: forAllHooks(gzipNegotiated)},
+	{name: "signing", feature: "signing", filter: forAllHooks(signed)},
+	{name: "history", feature: "debug-history", filter: forAllHooks(recordExchange)},
+	{name: "capture", feature: "capture", filter: forAllHooks(capturePayloads)},
+	{name: "sinks", feature: "output-sinks", filter: forAllHooks(publishedToSinks)},
+	{name: "shadow", feature: "shadow", filter: forAllHooks(shadowCompared)},
+	{name: "canonical", feature: "canonical-json", filter: forAllHooks(canonicalized)},
+	{name: "metrics", filter: summarized},
+	{name: "tracing", feature: "tracing", filter: traced},
+	{name: "node-local", feature: "node-local", hooks: []string{"listeners", "clusters", "routes"},
+		filter: nodeLocalChecked},
+	{name: "node-allowlist", feature: "node-allowlist", hooks: []string{"listeners", "clusters", "routes"},
+		filter: nodeAllowlisted},
+	{name: "correlation", feature: "correlation", hooks: []string{"listeners", "clusters", "routes"}, filter: correlated},
+	{name: "node-cache", feature: "node-cache", hooks: []string{"listeners", "clusters"}, filter: cacheServed},
+	{name: "workers", feature: "mutation-workers", hooks: []string{"listeners", "clusters"}, filter: pooled},
+}
+
+// authStages decide whether a caller is served at all, and observingStages record or forward what callers send.  Auth
+// stages must come before observing ones, so that requests that are refused are never recorded or sent on.
+var (
+	authStages      = map[string]bool{"token-auth": true, "deny-unexpected": true}
+	observingStages = map[string]bool{"history": true, "capture": true, "sinks": true, "shadow": true}
+)
+
+// pipeline is the stages hook requests go through, set by --middleware.
+var pipeline = middlewares
+
+func middlewareNames(stages []middleware) []string {
+	var names []string
+	for _, m := range stages {
+		names = append(names, m.name)
+	}
+	return names
+}
+
+// parsePipeline parses --middleware: the names of the stages to run, in order, or "all" for every stage in the
+// default order.
+func parsePipeline(s string) ([]middleware, error) {
+	if s == "all" {
+		return middlewares, nil
+	}
+	var stages []middleware
+	seen := map[string]bool{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if seen[name] {
+			return nil, fmt.Errorf("stage %q is listed twice", name)
+		}
+		seen[name] = true
+		found := false
+		for _, m := range middlewares {
+			if m.name == name {
+				stages = append(stages, m)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown stage %q; stages are %s", name,
+				strings.Join(middlewareNames(middlewares), ", "))
+		}
+	}
+	if err := checkAuthOutermost(stages); err != nil {
+		return nil, err
+	}
+	return stages, nil
+}
+
+// checkAuthOutermost returns an error if an auth stage comes after an observing stage.
+func checkAuthOutermost(stages []middleware) error {
+	observed := ""
+	for _, m := range stages {
+		if observed != "" && authStages[m.name] {
+			return fmt.Errorf("stage %q must come before %q, so that requests it refuses are not recorded", m.name,
+				observed)
+		}
+		if observed == "" && observingStages[m.name] {
+			observed = m.name
+		}
+	}
+	return nil
+}
+
+// checkPipeline returns an error if a stage whose feature is enabled is left out of the pipeline, since the feature
+// would silently do nothing.  Stages that always run, such as gzip and metrics, may be left out.
+func checkPipeline(stages []middleware, enabled func(feature string) bool) error {
+	included := map[string]bool{}
+	for _, m := range stages {
+		included[m.name] = true
+	}
+	for _, m := range middlewares {
+		if m.feature != "" && !included[m.name] && enabled(m.feature) {
+			return fmt.Errorf("stage %q is left out, but %s is enabled", m.name, m.feature)
+		}
+	}
+	return nil
+}
+
+// hookRoute is a route Pilot calls a hook on.
+type hookRoute struct {
+	hook    string
+	path    string
+	handler restful.RouteFunction
+}
+
+var hookRoutes = []hookRoute{
+	{"listeners", "/v1/listeners/{serviceCluster}/{serviceNode}", listeners},
+	{"clusters", "/v1/clusters/{serviceCluster}/{serviceNode}", clusters},
+	{"routes", "/v1/routes/{routeConfigName}/{serviceCluster}/{serviceNode}", routes},
+	{"endpoints", "/v1/registration/{serviceName}", endpoints},
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestParsePipeline(t *testing.T) {
+	RegisterTestingT(t)
+
+	stages, err := parsePipeline("all")
+	Expect(err).To(BeNil())
+	Expect(middlewareNames(stages)).To(Equal(middlewareNames(middlewares)))
+
+	stages, err = parsePipeline("workers, gzip")
+	Expect(err).To(BeNil())
+	Expect(middlewareNames(stages)).To(Equal([]string{"workers", "gzip"}))
+
+	_, err = parsePipeline("gzip,auth")
+	Expect(err.Error()).To(ContainSubstring(`unknown stage "auth"`))
+	_, err = parsePipeline("gzip,gzip")
+	Expect(err.Error()).To(ContainSubstring("listed twice"))
+}
+
+func TestPipelineAuthOutermost(t *testing.T) {
+	RegisterTestingT(t)
+
+	_, err := parsePipeline("token-auth,rate-limit,capture,sinks")
+	Expect(err).To(BeNil())
+	_, err = parsePipeline("capture,token-auth")
+	Expect(err.Error()).To(ContainSubstring(`stage "token-auth" must come before "capture"`))
+	_, err = parsePipeline("token-auth,shadow,deny-unexpected")
+	Expect(err.Error()).To(ContainSubstring(`stage "deny-unexpected" must come before "shadow"`))
+}
+
+func TestCheckPipeline(t *testing.T) {
+	RegisterTestingT(t)
+
+	enabled := func(features ...string) func(string) bool {
+		return func(f string) bool {
+			for _, e := range features {
+				if e == f {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	Expect(checkPipeline(middlewares, enabled("token-auth", "mutation-workers"))).To(Succeed())
+
+	stages, err := parsePipeline("gzip,metrics,workers")
+	Expect(err).To(BeNil())
+	Expect(checkPipeline(stages, enabled("mutation-workers"))).To(Succeed())
+	err = checkPipeline(stages, enabled("mutation-workers", "node-allowlist"))
+	Expect(err.Error()).To(ContainSubstring(`stage "node-allowlist" is left out`))
+
+	// Stages that always run may be left out.
+	stages, err = parsePipeline("token-auth")
+	Expect(err).To(BeNil())
+	Expect(checkPipeline(stages, enabled("token-auth"))).To(Succeed())
+}
+
+func TestMiddlewareAppliesTo(t *testing.T) {
+	RegisterTestingT(t)
+
+	for _, m := range middlewares {
+		switch m.name {
+		case "node-cache", "workers":
+			Expect(m.appliesTo("routes")).To(BeFalse(), m.name)
+			Expect(m.appliesTo("clusters")).To(BeTrue(), m.name)
+		case "deny-unexpected", "node-local", "node-allowlist", "correlation":
+			Expect(m.appliesTo("endpoints")).To(BeFalse(), m.name)
+			Expect(m.appliesTo("routes")).To(BeTrue(), m.name)
+		default:
+			Expect(m.appliesTo("endpoints")).To(BeTrue(), m.name)
+		}
+	}
+}
+
+// TestPipelineStagesLeftOut checks that a stage left out of the pipeline does not run.  Startup rejects leaving out
+// the stage of an enabled feature, so this only happens in tests.
+func TestPipelineStagesLeftOut(t *testing.T) {
+	RegisterTestingT(t)
+
+	serve := func() string {
+		return serveHook(newHooks(), hookRequest("routes", "sidecar", strings.NewReader(`{"b": 1, "a": 2}`))).Body.String()
+	}
+	defer func() { canonicalJSON, pipeline = false, middlewares }()
+	canonicalJSON = true
+	Expect(serve()).To(Equal(`{"a":2,"b":1}`))
+
+	var err error
+	pipeline, err = parsePipeline("gzip,metrics,tracing")
+	Expect(err).To(BeNil())
+	Expect(serve()).To(Equal(`{"b": 1, "a": 2}`))
+}
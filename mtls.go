@@ -0,0 +1,60 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// hookTLSConfig returns the config for serving the hooks over mTLS: callers must present a certificate signed by the
+// client CA and, with a verifier, a SPIFFE ID it allows.
+func hookTLSConfig(certFile, keyFile, clientCAFile string, verifier *spiffeVerifier) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates in client CA file")
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}
+	if verifier != nil {
+		config.VerifyPeerCertificate = verifier.verifyPeerCertificate
+	}
+	return config, nil
+}
+
+// serveHooksTLS serves the hooks over mTLS on addr, alongside the unix socket.
+func serveHooksTLS(addr string, config *tls.Config) error {
+	s := newHookServer()
+	s.Addr = addr
+	s.TLSConfig = config
+	log.WithField("listen", addr).Info("Serving hooks over mTLS.")
+	return s.ListenAndServeTLS("", "")
+}
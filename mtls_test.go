@@ -0,0 +1,99 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+// writePEM writes a certificate, and its key if keyFile is set, to files.
+func writePEM(c *testCert, certFile, keyFile string) {
+	Expect(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600)).
+		To(Succeed())
+	if keyFile != "" {
+		der, err := x509.MarshalECPrivateKey(c.key)
+		Expect(err).To(BeNil())
+		Expect(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)).
+			To(Succeed())
+	}
+}
+
+func TestHookTLSConfig(t *testing.T) {
+	RegisterTestingT(t)
+
+	dir, err := ioutil.TempDir("", "mtls")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+
+	ca := newTestCert("ca", nil)
+	writePEM(newTestCert("webhook", ca), certFile, keyFile)
+	writePEM(ca, caFile, "")
+
+	_, err = hookTLSConfig(certFile, keyFile, filepath.Join(dir, "missing"), nil)
+	Expect(err).NotTo(BeNil())
+	_, err = hookTLSConfig(certFile, keyFile, keyFile, nil)
+	Expect(err).NotTo(BeNil())
+
+	verifier, err := newSPIFFEVerifier("cluster.local", "istio-system/istio-pilot-service-account")
+	Expect(err).To(BeNil())
+	config, err := hookTLSConfig(certFile, keyFile, caFile, verifier)
+	Expect(err).To(BeNil())
+
+	s := httptest.NewUnstartedServer(newHookServer().Handler)
+	s.TLS = config
+	s.StartTLS()
+	defer s.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	post := func(client *testCert) (int, error) {
+		tlsConfig := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+		if client != nil {
+			tlsConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{client.der}, PrivateKey: client.key}}
+		}
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		path := "/v1/listeners/" + SERVICE_CLUSTER + "/" + serviceNode("sidecar", NODE_IP)
+		resp, err := c.Post(s.URL+path, "application/json", strings.NewReader(ldsWithUnknownFields))
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	code, err := post(newTestCert("pilot", ca, "spiffe://cluster.local/ns/istio-system/sa/istio-pilot-service-account"))
+	Expect(err).To(BeNil())
+	Expect(code).To(Equal(http.StatusOK))
+
+	_, err = post(newTestCert("other", ca, "spiffe://cluster.local/ns/default/sa/default"))
+	Expect(err).NotTo(BeNil(), "identity not allowed")
+	_, err = post(newTestCert("untrusted", newTestCert("other-ca", nil),
+		"spiffe://cluster.local/ns/istio-system/sa/istio-pilot-service-account"))
+	Expect(err).NotTo(BeNil(), "certificate not signed by the client CA")
+	_, err = post(nil)
+	Expect(err).NotTo(BeNil(), "no client certificate")
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	log "github.com/sirupsen/logrus"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+// hookAuthz is the authz mutator the hooks run: it classifies listeners as --listener-classifier, --protocol-sniffing
+// and --cni-compat say, and leaves TCP listeners on the cluster DNS service's ports alone for --exclude-dns-ports.
+// Felix sync gating, per namespace dikastes and excluded ports shape the profile it is passed.  It takes the place of
+// the unconfigured one in mutator.Default, so it runs first, as part of the registry, wherever the registry runs.
+var hookAuthz = &mutator.Authz{
+	Classify: func(req *mutator.Request, listener *v1.Listener) (Direction, Protocol) {
+		return classifyListener(listener, req.Node.IP)
+	},
+	Skip: func(req *mutator.Request, listener *v1.Listener, proto Protocol, port int) skipReason {
+		if proto == TCP && dnsPorts != nil && dnsPorts.excluded(port) {
+			return SkipExcludedPort
+		}
+		return ""
+	},
+	Parallelism: 1,
+}
+
+func init() {
+	if err := mutator.Default.Replace(hookAuthz); err != nil {
+		panic(err)
+	}
+}
+
+// extraMutators returns the names of the mutators registered to run after the authz mutator.
+func extraMutators() []string {
+	var names []string
+	for _, m := range mutator.Default.Mutators() {
+		if m.Name() != mutator.AuthzName {
+			names = append(names, m.Name())
+		}
+	}
+	return names
+}
+
+// onlyAuthz reports whether the authz mutator is the only one registered, in which case the hooks it leaves alone can
+// be passed through without being read, and LDS and CDS can be streamed.
+func onlyAuthz() bool {
+	return len(mutator.Default.Mutators()) == 1
+}
+
+// mutatorRequest describes a hook request to the mutators.  profile is the node's, for the hooks called per node, and
+// addr the dikastes address the authz cluster is added with, for CDS.  The mutators' steps are traced as the hook's.
+func mutatorRequest(req *restful.Request, profile hookProfile, addr string) *mutator.Request {
+	r := &mutator.Request{
+		ServiceCluster:  req.PathParameter("serviceCluster"),
+		Service:         req.PathParameter("serviceName"),
+		Profile:         profile,
+		DikastesAddress: addr,
+		StartStep:       stepStarter(req.Request.Context(), statsFor(req)),
+	}
+	if serviceNode := req.PathParameter("serviceNode"); serviceNode != "" {
+		r.Node = config.ParseNode(serviceNode)
+	}
+	return r
+}
+
+// sidecarProfile returns the profile of the service node, if it is a sidecar.
+func sidecarProfile(serviceNode string) hookProfile {
+	node := config.ParseNode(serviceNode)
+	if !node.IsSidecar() {
+		return hookProfile{}
+	}
+	profile := nodeProfile(node.IP)
+	profile.ClusterName = authzClusterFor(serviceNode)
+	return profile
+}
+
+// applyMutators runs the registered mutators on a hook's config.  If one after the authz mutator fails, it is reported
+// and the config is returned as the mutators before it left it, so that a broken mutator never costs a workload its
+// authz filter.  Only errors from the authz mutator, which cannot decode the config, are returned.
+func applyMutators(hook mutator.Hook, mreq *mutator.Request, cb mutator.Callbacks, body []byte) ([]byte, []string,
+	error) {
+	out, changed, err := mutator.Default.ApplyWithCallbacks(cb, hook, mreq, body)
+	if merr, ok := err.(*mutator.Error); ok && merr.Mutator != mutator.AuthzName {
+		reportError(string(hook), ErrorClassMutator, log.Fields{"mutator": merr.Mutator, "err": merr.Err},
+			"registered mutator failed; sending config without its changes or those of the mutators after it")
+		return merr.Body, merr.Changed, nil
+	}
+	return out, changed, err
+}
+
+// mutateBody answers a hook request that its handler has nothing of its own to do for by running the registered
+// mutators on it, in read and mutate steps.
+func mutateBody(hook mutator.Hook, req *restful.Request, resp *restful.Response, mreq *mutator.Request) {
+	ctx, stats := req.Request.Context(), statsFor(req)
+	span := startStep(ctx, stats, "read")
+	in, err := readBody(req.Request.Body, req.Request.ContentLength)
+	if err != nil {
+		endWithError(span, err)
+		if rejectOversized(string(hook), resp, err) {
+			return
+		}
+		reportError(string(hook), ErrorClassRead, log.Fields{"err": err}, "failed to read")
+		resp.WriteErrorString(http.StatusInternalServerError, "failed to read request")
+		return
+	}
+	defer putBuffer(in)
+	body := in.Bytes()
+	span.End()
+	span = startStep(ctx, stats, "mutate")
+	out, changed, err := applyMutators(hook, mreq, mutator.Callbacks{}, body)
+	if err != nil {
+		endWithError(span, err)
+		reportError(string(hook), ErrorClassParse, log.Fields{"err": err}, "failed to decode JSON")
+		resp.WriteErrorString(http.StatusBadRequest, "could not parse request JSON")
+		return
+	}
+	span.End()
+	writeMutated(hook, req, resp, body, out, changed)
+}
+
+// writeMutated writes a hook's mutated config, auditing the changes, or for a dry run the request body with the
+// changes in a header.
+func writeMutated(hook mutator.Hook, req *restful.Request, resp *restful.Response, body, out []byte, changed []string) {
+	if isDryRun(req) {
+		resp.AddHeader(DryRunChangesHeader, summarizeChanges(changed))
+		resp.Write(body)
+		return
+	}
+	if auditLog != nil {
+		auditLog.recordMutation(req, hook, body, out, changed)
+	}
+	resp.Write(out)
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+// markingMutator adds a "marked" field to route configs, naming the node, or fails if err is set.
+type markingMutator struct {
+	mutator.Passthrough
+	err error
+}
+
+func (markingMutator) Name() string { return "marking" }
+
+func (m markingMutator) Listeners(req *mutator.Request, body []byte) ([]byte, []string, error) {
+	if m.err != nil {
+		return nil, nil, m.err
+	}
+	return body, nil, nil
+}
+
+func (m markingMutator) Routes(req *mutator.Request, body []byte) ([]byte, []string, error) {
+	if m.err != nil {
+		return nil, nil, m.err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, nil, err
+	}
+	fields["marked"], _ = json.Marshal(req.Node.IP)
+	return mutator.RawObject(fields), []string{"route/marked"}, nil
+}
+
+func serveRoutes(dryRun bool) *httptest.ResponseRecorder {
+	req := hookRequest("routes", "sidecar", strings.NewReader(`{"virtual_hosts":[]}`))
+	if dryRun {
+		req.Header.Set(DryRunHeader, "true")
+	}
+	return serveHook(newHooks(), req)
+}
+
+func TestHooksRunRegisteredMutators(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(serveRoutes(false).Body.String()).To(Equal(`{"virtual_hosts":[]}`))
+
+	Expect(mutator.Register(markingMutator{})).To(Succeed())
+	defer mutator.Default.Unregister("marking")
+	Expect(extraMutators()).To(Equal([]string{"marking"}))
+
+	rec := serveRoutes(false)
+	Expect(rec.Code).To(Equal(http.StatusOK))
+	Expect(rec.Body.String()).To(Equal(`{"marked":"` + NODE_IP + `","virtual_hosts":[]}`))
+
+	rec = serveRoutes(true)
+	Expect(rec.Body.String()).To(Equal(`{"virtual_hosts":[]}`))
+	Expect(rec.Header().Get(DryRunChangesHeader)).To(Equal("route/marked"))
+}
+
+func TestHooksMutatorFailure(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(mutator.Register(markingMutator{err: errors.New("broken")})).To(Succeed())
+	defer mutator.Default.Unregister("marking")
+
+	// The config is sent without the failed mutator's changes.
+	rec := serveRoutes(false)
+	Expect(rec.Code).To(Equal(http.StatusOK))
+	Expect(rec.Body.String()).To(Equal(`{"virtual_hosts":[]}`))
+}
+
+func TestHookAuthzIsRegistered(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(mutator.Default.Mutators()).To(HaveLen(1))
+	Expect(mutator.Default.Mutators()[0]).To(BeIdenticalTo(hookAuthz))
+	Expect(onlyAuthz()).To(BeTrue())
+}
+
+func TestHooksMutatorFailureKeepsAuthz(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(mutator.Register(markingMutator{err: errors.New("broken")})).To(Succeed())
+	defer mutator.Default.Unregister("marking")
+
+	// The listeners are sent as the authz mutator left them.
+	req := newLDSRequest("sidecar", bytes.NewReader(benchLDS(1)))
+	rec := httptest.NewRecorder()
+	listeners(req, restful.NewResponse(rec))
+	Expect(rec.Code).To(Equal(http.StatusOK))
+	Expect(statsFor(req).Injected).To(Equal(1))
+	Expect(rec.Body.String()).To(ContainSubstring(AuthZFilterName))
+}
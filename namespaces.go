@@ -0,0 +1,62 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+var errNamespacesNotSynced = errors.New("namespaces not yet synced")
+
+// namespaces limits injection to the namespaces matching a label selector.  It is nil unless --namespace-selector is
+// set, in which case every namespace is enabled.
+var namespaces *namespaceSelector
+
+// namespaceSelector matches namespaces from an informer's cache against a label selector.
+type namespaceSelector struct {
+	selector labels.Selector
+	store    cache.Store
+	synced   cache.InformerSynced
+}
+
+func newNamespaceSelector(selector string, informer cache.SharedIndexInformer) (*namespaceSelector, error) {
+	sel, err := labels.Parse(selector)
+	if err != nil {
+		return nil, err
+	}
+	return &namespaceSelector{selector: sel, store: informer.GetStore(), synced: informer.HasSynced}, nil
+}
+
+// enabled reports whether the named namespace matches the selector.  Namespaces that are not in the cache yet are not
+// enabled, so that rolling out the selector never injects more than intended.
+func (n *namespaceSelector) enabled(namespace string) bool {
+	obj, ok, err := n.store.GetByKey(namespace)
+	if err != nil || !ok {
+		return false
+	}
+	return n.selector.Matches(labels.Set(obj.(*v1.Namespace).Labels))
+}
+
+// check is a readiness check that fails until the namespace cache has synced.
+func (n *namespaceSelector) check() error {
+	if !n.synced() {
+		return errNamespacesNotSynced
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestNamespaceSelector(selector string, objs ...*v1.Namespace) *namespaceSelector {
+	sel, err := labels.Parse(selector)
+	Expect(err).To(BeNil())
+	store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, nil)
+	for _, o := range objs {
+		store.Add(o)
+	}
+	return &namespaceSelector{selector: sel, store: store, synced: func() bool { return true }}
+}
+
+func testNamespace(name string, labels map[string]string) *v1.Namespace {
+	return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func TestNamespaceSelector(t *testing.T) {
+	RegisterTestingT(t)
+
+	n := newTestNamespaceSelector("calico-authz=enabled",
+		testNamespace("testns", map[string]string{"calico-authz": "enabled"}),
+		testNamespace("other", nil),
+	)
+	Expect(n.enabled("testns")).To(BeTrue())
+	Expect(n.enabled("other")).To(BeFalse())
+	Expect(n.enabled("missing")).To(BeFalse())
+}
+
+func TestNamespaceSkip(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { namespaces = nil }()
+	namespaces = newTestNamespaceSelector("calico-authz=enabled", testNamespace("testns", nil))
+	sn := serviceNode("sidecar", NODE_IP)
+	Expect(skipNode(SERVICE_CLUSTER, sn, "sidecar", NODE_IP)).To(Equal(SkipNamespaceNotSelected))
+
+	namespaces = newTestNamespaceSelector("calico-authz=enabled",
+		testNamespace("testns", map[string]string{"calico-authz": "enabled"}))
+	Expect(skipNode(SERVICE_CLUSTER, sn, "sidecar", NODE_IP)).To(Equal(skipReason("")))
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	apiv3 "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/libcalico-go/lib/clientv3"
+	"github.com/projectcalico/libcalico-go/lib/options"
+	"github.com/projectcalico/libcalico-go/lib/selector"
+	"github.com/projectcalico/libcalico-go/lib/watch"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var errNetworkSetsNotSynced = errors.New("global network sets not yet synced")
+
+var endpointsFiltered = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "endpoints_filtered_total",
+	Help:      "EDS hosts removed because GlobalNetworkSets do not allow their IP.",
+})
+
+func init() {
+	prometheus.MustRegister(endpointsFiltered)
+}
+
+// networkSets filters EDS hosts by the CIDRs of Calico GlobalNetworkSets.  It is nil unless --eds-allow-networksets or
+// --eds-deny-networksets is set.
+var networkSets *networkSetIndex
+
+// networkSetIndex holds the nets of every GlobalNetworkSet, kept up to date by watching the Calico datastore.  Sets
+// matching the deny selector remove hosts; if there is an allow selector, only hosts in a set matching it are kept.
+type networkSetIndex struct {
+	allow selector.Selector
+	deny  selector.Selector
+
+	mu     sync.RWMutex
+	sets   map[string]networkSet
+	synced bool
+}
+
+type networkSet struct {
+	labels map[string]string
+	nets   []*net.IPNet
+}
+
+// newNetworkSetIndex returns an index filtering with the given selectors, either of which may be empty.
+func newNetworkSetIndex(allow, deny string) (*networkSetIndex, error) {
+	x := &networkSetIndex{sets: make(map[string]networkSet)}
+	var err error
+	if allow != "" {
+		if x.allow, err = selector.Parse(allow); err != nil {
+			return nil, err
+		}
+	}
+	if deny != "" {
+		if x.deny, err = selector.Parse(deny); err != nil {
+			return nil, err
+		}
+	}
+	return x, nil
+}
+
+func parseNets(gns *apiv3.GlobalNetworkSet) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, n := range gns.Spec.Nets {
+		_, ipNet, err := net.ParseCIDR(n)
+		if err != nil {
+			log.WithFields(log.Fields{"networkSet": gns.Name, "net": n}).Debug("Ignoring invalid net")
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+func (x *networkSetIndex) replace(list []apiv3.GlobalNetworkSet) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.sets = make(map[string]networkSet)
+	for i := range list {
+		x.sets[list[i].Name] = networkSet{labels: list[i].Labels, nets: parseNets(&list[i])}
+	}
+	x.synced = true
+}
+
+func (x *networkSetIndex) set(gns *apiv3.GlobalNetworkSet) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.sets[gns.Name] = networkSet{labels: gns.Labels, nets: parseNets(gns)}
+}
+
+func (x *networkSetIndex) remove(name string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	delete(x.sets, name)
+}
+
+// permits reports whether a host with the given IP may stay in EDS.  Until the sets have synced every host is
+// permitted, so that a slow datastore never empties a cluster.
+func (x *networkSetIndex) permits(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return true
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if !x.synced {
+		return true
+	}
+	allowed := x.allow == nil
+	for _, s := range x.sets {
+		if !s.contains(addr) {
+			continue
+		}
+		if x.deny != nil && x.deny.Evaluate(s.labels) {
+			return false
+		}
+		if x.allow != nil && x.allow.Evaluate(s.labels) {
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+func (s networkSet) contains(ip net.IP) bool {
+	for _, n := range s.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// check is a readiness check that fails until the network sets have synced.
+func (x *networkSetIndex) check() error {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if !x.synced {
+		return errNetworkSetsNotSynced
+	}
+	return nil
+}
+
+// apply updates the index from a watch event.
+func (x *networkSetIndex) apply(e watch.Event) error {
+	switch e.Type {
+	case watch.Added, watch.Modified:
+		if gns, ok := e.Object.(*apiv3.GlobalNetworkSet); ok {
+			x.set(gns)
+		}
+	case watch.Deleted:
+		if gns, ok := e.Previous.(*apiv3.GlobalNetworkSet); ok {
+			x.remove(gns.Name)
+		}
+	case watch.Error:
+		return e.Error
+	}
+	return nil
+}
+
+// run keeps the index in sync with the datastore.  It never returns.
+func (x *networkSetIndex) run(client clientv3.Interface) {
+	runCalicoWatch("globalnetworksets",
+		func(ctx context.Context) (string, error) {
+			list, err := client.GlobalNetworkSets().List(ctx, options.ListOptions{})
+			if err != nil {
+				return "", err
+			}
+			x.replace(list.Items)
+			return list.ResourceVersion, nil
+		},
+		client.GlobalNetworkSets().Watch,
+		x.apply)
+}
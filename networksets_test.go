@@ -0,0 +1,85 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+	apiv3 "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testNetworkSet(name, role string, nets ...string) apiv3.GlobalNetworkSet {
+	return apiv3.GlobalNetworkSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"role": role}},
+		Spec:       apiv3.GlobalNetworkSetSpec{Nets: nets},
+	}
+}
+
+func TestNetworkSetPermits(t *testing.T) {
+	RegisterTestingT(t)
+
+	x, err := newNetworkSetIndex("role == 'trusted'", "role == 'blocked'")
+	Expect(err).To(BeNil())
+	Expect(x.permits("10.0.0.1")).To(BeTrue())
+	Expect(x.check()).To(Equal(errNetworkSetsNotSynced))
+
+	x.replace([]apiv3.GlobalNetworkSet{
+		testNetworkSet("trusted", "trusted", "10.0.0.0/16"),
+		testNetworkSet("blocked", "blocked", "10.0.5.0/24", "not a cidr"),
+	})
+	Expect(x.check()).To(BeNil())
+	Expect(x.permits("10.0.0.1")).To(BeTrue())
+	Expect(x.permits("10.0.5.1")).To(BeFalse())
+	Expect(x.permits("10.1.0.1")).To(BeFalse())
+
+	x.remove("blocked")
+	Expect(x.permits("10.0.5.1")).To(BeTrue())
+}
+
+func TestNetworkSetDenyOnly(t *testing.T) {
+	RegisterTestingT(t)
+
+	x, _ := newNetworkSetIndex("", "role == 'blocked'")
+	x.replace([]apiv3.GlobalNetworkSet{testNetworkSet("blocked", "blocked", "10.0.5.0/24")})
+	Expect(x.permits("10.1.0.1")).To(BeTrue())
+	Expect(x.permits("10.0.5.1")).To(BeFalse())
+}
+
+func TestEndpointsFiltered(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { networkSets = nil }()
+	networkSets, _ = newNetworkSetIndex("", "role == 'blocked'")
+	networkSets.replace([]apiv3.GlobalNetworkSet{testNetworkSet("blocked", "blocked", "10.0.5.0/24")})
+
+	body := `{"hosts": [{"ip_address": "10.0.0.1", "port": 80, "tags": {"az": "a"}}, {"ip_address": "10.0.5.1", "port": 80}]}`
+	req := newEDSRequest(strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	endpoints(req, restful.NewResponse(rec))
+	Expect(rec.Body.String()).To(MatchJSON(`{"hosts": [{"ip_address": "10.0.0.1", "port": 80, "tags": {"az": "a"}}]}`))
+	Expect(statsFor(req).EndpointsRemoved).To(Equal(1))
+
+	req = newEDSRequest(strings.NewReader(body))
+	req.Request.Header.Set(DryRunHeader, "true")
+	rec = httptest.NewRecorder()
+	endpoints(req, restful.NewResponse(rec))
+	Expect(rec.Body.String()).To(Equal(body))
+	Expect(rec.Header().Get(DryRunChangesHeader)).To(Equal("endpoint/10.0.5.1"))
+}
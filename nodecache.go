@@ -0,0 +1,155 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/emicklei/go-restful"
+)
+
+// servedConfigs holds the last response served to each node.  It is nil unless --node-cache-size is set.
+var servedConfigs *nodeCache
+
+// servedConfig is the last response body served for one hook to one node.
+type servedConfig struct {
+	Time time.Time
+	Body []byte
+}
+
+// nodeSummary describes the cached configs for a node on the introspection endpoint.
+type nodeSummary struct {
+	ServiceNode string               `json:"serviceNode"`
+	Hooks       map[string]hookEntry `json:"hooks"`
+}
+
+type hookEntry struct {
+	Time  time.Time `json:"time"`
+	Bytes int       `json:"bytes"`
+}
+
+type nodeEntry struct {
+	serviceNode string
+	configs     map[string]servedConfig
+}
+
+// nodeCache keeps the last served config per node and hook, evicting the least recently updated node once size nodes
+// are cached.
+type nodeCache struct {
+	size int
+
+	mu    sync.Mutex
+	order *list.List
+	nodes map[string]*list.Element
+}
+
+func newNodeCache(size int) *nodeCache {
+	return &nodeCache{size: size, order: list.New(), nodes: make(map[string]*list.Element)}
+}
+
+func (c *nodeCache) store(serviceNode, hook string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.nodes[serviceNode]
+	if ok {
+		c.order.MoveToFront(el)
+	} else {
+		el = c.order.PushFront(&nodeEntry{serviceNode: serviceNode, configs: make(map[string]servedConfig)})
+		c.nodes[serviceNode] = el
+		if c.order.Len() > c.size {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.nodes, oldest.Value.(*nodeEntry).serviceNode)
+		}
+	}
+	el.Value.(*nodeEntry).configs[hook] = servedConfig{Time: time.Now(), Body: body}
+}
+
+func (c *nodeCache) get(serviceNode, hook string) (servedConfig, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.nodes[serviceNode]
+	if !ok {
+		return servedConfig{}, false
+	}
+	cfg, ok := el.Value.(*nodeEntry).configs[hook]
+	return cfg, ok
+}
+
+// summaries lists the cached nodes, sorted by service node.
+func (c *nodeCache) summaries() []nodeSummary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make([]nodeSummary, 0, len(c.nodes))
+	for _, el := range c.nodes {
+		e := el.Value.(*nodeEntry)
+		s := nodeSummary{ServiceNode: e.serviceNode, Hooks: make(map[string]hookEntry)}
+		for hook, cfg := range e.configs {
+			s.Hooks[hook] = hookEntry{Time: cfg.Time, Bytes: len(cfg.Body)}
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ServiceNode < out[j].ServiceNode })
+	return out
+}
+
+// cacheServed returns a route filter that stores successful responses from the named hook in the node cache.
+func cacheServed(hook string) restful.FilterFunction {
+	return func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		if servedConfigs == nil || isDryRun(req) {
+			chain.ProcessFilter(req, resp)
+			return
+		}
+		cw := &captureWriter{ResponseWriter: resp.ResponseWriter, max: math.MaxInt32}
+		resp.ResponseWriter = cw
+		chain.ProcessFilter(req, resp)
+		if resp.StatusCode() == http.StatusOK {
+			servedConfigs.store(req.PathParameter("serviceNode"), hook, redactBody(cw.buf.Bytes()))
+		}
+	}
+}
+
+// servedNodes lists the nodes with cached configs.
+func servedNodes(req *restful.Request, resp *restful.Response) {
+	if servedConfigs == nil {
+		resp.WriteErrorString(http.StatusNotFound, "node cache is disabled; set --node-cache-size")
+		return
+	}
+	resp.WriteEntity(servedConfigs.summaries())
+}
+
+// servedNodeConfig returns the last config served to a node by a hook, as it was sent but with secrets redacted.
+func servedNodeConfig(req *restful.Request, resp *restful.Response) {
+	if servedConfigs == nil {
+		resp.WriteErrorString(http.StatusNotFound, "node cache is disabled; set --node-cache-size")
+		return
+	}
+	cfg, ok := servedConfigs.get(req.PathParameter("serviceNode"), req.PathParameter("hook"))
+	if !ok {
+		resp.WriteErrorString(http.StatusNotFound, "no config served to this node by this hook")
+		return
+	}
+	resp.AddHeader("Last-Modified", cfg.Time.UTC().Format(http.TimeFormat))
+	resp.AddHeader("Content-Type", restful.MIME_JSON)
+	resp.Write(cfg.Body)
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+)
+
+func TestNodeCacheEviction(t *testing.T) {
+	RegisterTestingT(t)
+
+	c := newNodeCache(2)
+	c.store("a", "listeners", []byte("1"))
+	c.store("b", "listeners", []byte("2"))
+	c.store("a", "clusters", []byte("3"))
+	c.store("c", "listeners", []byte("4"))
+
+	_, ok := c.get("b", "listeners")
+	Expect(ok).To(BeFalse())
+	cfg, ok := c.get("a", "listeners")
+	Expect(ok).To(BeTrue())
+	Expect(cfg.Body).To(Equal([]byte("1")))
+	summaries := c.summaries()
+	Expect(summaries).To(HaveLen(2))
+	Expect(summaries[0].ServiceNode).To(Equal("a"))
+	Expect(summaries[0].Hooks).To(HaveLen(2))
+}
+
+func TestCacheServed(t *testing.T) {
+	RegisterTestingT(t)
+
+	servedConfigs = newNodeCache(10)
+	defer func() { servedConfigs = nil }()
+	sn := serviceNode("sidecar", NODE_IP)
+	serveHook(newHooks(), hookRequest("clusters", "sidecar", strings.NewReader(`{"clusters":[]}`)))
+
+	req := restful.NewRequest(httptest.NewRequest("GET", "/debug/nodes/"+sn+"/clusters", nil))
+	req.PathParameters()["serviceNode"] = sn
+	req.PathParameters()["hook"] = "clusters"
+	rec := httptest.NewRecorder()
+	servedNodeConfig(req, restful.NewResponse(rec))
+	Expect(rec.Code).To(Equal(http.StatusOK))
+	Expect(rec.Body.String()).To(Equal(`{"clusters":[]}`))
+
+	req.PathParameters()["hook"] = "listeners"
+	rec = httptest.NewRecorder()
+	servedNodeConfig(req, restful.NewResponse(rec))
+	Expect(rec.Code).To(Equal(http.StatusNotFound))
+}
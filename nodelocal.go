@@ -0,0 +1,92 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/emicklei/go-restful"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var crossNodeRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "cross_node_requests_total",
+	Help:      "Hook requests for service nodes whose pod is not on this node, or could not be found.",
+}, []string{"hook", "reason"})
+
+func init() {
+	prometheus.MustRegister(crossNodeRequests)
+}
+
+// nodeLocal checks that hook requests are for pods on this node, when the webhook runs as a DaemonSet.  It is nil
+// unless --node-local is set.
+var nodeLocal *nodeLocalValidator
+
+type nodeLocalValidator struct {
+	node   string
+	reject bool
+}
+
+func newNodeLocalValidator(node string, reject bool) *nodeLocalValidator {
+	return &nodeLocalValidator{node: node, reject: reject}
+}
+
+// nodeOf returns the node the pod with the given IP is scheduled on, or "" if the pod is not known.
+func nodeOf(ip string) string {
+	pod, err := pods.byIP(ip)
+	if err != nil || pod == nil {
+		return ""
+	}
+	return pod.Spec.NodeName
+}
+
+// nodeLocalChecked returns a route filter that flags, or rejects, requests for service nodes on other nodes.  A pod
+// that is not in the cache yet is only flagged, since it may just have started.
+func nodeLocalChecked(hook string) restful.FilterFunction {
+	return func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		if nodeLocal == nil {
+			chain.ProcessFilter(req, resp)
+			return
+		}
+		serviceNode := req.PathParameter("serviceNode")
+		c := strings.Split(serviceNode, serviceNodeSeparator)
+		if len(c) < 2 {
+			chain.ProcessFilter(req, resp)
+			return
+		}
+		node := nodeOf(c[1])
+		if node == nodeLocal.node {
+			chain.ProcessFilter(req, resp)
+			return
+		}
+		fields := log.Fields{"hook": hook, "serviceNode": serviceNode, "node": nodeLocal.node, "podNode": node}
+		if node == "" {
+			crossNodeRequests.WithLabelValues(hook, "unknown").Inc()
+			errorLog.Warn(fields, "request for a service node whose pod is not known")
+			chain.ProcessFilter(req, resp)
+			return
+		}
+		crossNodeRequests.WithLabelValues(hook, "remote").Inc()
+		errorLog.Warn(fields, "request for a service node on another node")
+		if nodeLocal.reject {
+			resp.WriteErrorString(http.StatusForbidden, "service node is not on this node")
+			return
+		}
+		chain.ProcessFilter(req, resp)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/api/core/v1"
+)
+
+func postClusters(ip string) *httptest.ResponseRecorder {
+	url := fmt.Sprintf("http://unix/v1/clusters/%s/%s", SERVICE_CLUSTER, serviceNode("sidecar", ip))
+	return serveHook(newHooks(), httptest.NewRequest("POST", url, strings.NewReader(`{"clusters":[]}`)))
+}
+
+func TestNodeLocal(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() {
+		pods = nil
+		nodeLocal = nil
+	}()
+	local := testPod("local", "10.0.0.1", v1.PodRunning, nil)
+	local.Spec.NodeName = "node1"
+	remote := testPod("remote", "10.0.0.2", v1.PodRunning, nil)
+	remote.Spec.NodeName = "node2"
+	pods = newTestPodIndex(local, remote)
+
+	nodeLocal = newNodeLocalValidator("node1", false)
+	remoteBefore := testutil.ToFloat64(crossNodeRequests.WithLabelValues("clusters", "remote"))
+	unknownBefore := testutil.ToFloat64(crossNodeRequests.WithLabelValues("clusters", "unknown"))
+	Expect(postClusters("10.0.0.1").Code).To(Equal(http.StatusOK))
+	Expect(postClusters("10.0.0.2").Code).To(Equal(http.StatusOK))
+	Expect(postClusters("10.0.0.3").Code).To(Equal(http.StatusOK))
+	Expect(testutil.ToFloat64(crossNodeRequests.WithLabelValues("clusters", "remote"))).To(Equal(remoteBefore + 1))
+	Expect(testutil.ToFloat64(crossNodeRequests.WithLabelValues("clusters", "unknown"))).To(Equal(unknownBefore + 1))
+
+	nodeLocal = newNodeLocalValidator("node1", true)
+	Expect(postClusters("10.0.0.1").Code).To(Equal(http.StatusOK))
+	Expect(postClusters("10.0.0.2").Code).To(Equal(http.StatusForbidden))
+	Expect(postClusters("10.0.0.3").Code).To(Equal(http.StatusOK))
+}
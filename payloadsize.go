@@ -0,0 +1,140 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// largePayloadBytes is the body size above which a warning is logged.  0 disables the warning.
+var largePayloadBytes int
+
+// maxPayloadBytes is the largest request body that is read into memory.  0 means no limit.
+var maxPayloadBytes int
+
+// payloadBuckets run from 1KiB to 256MiB.
+var payloadBuckets = prometheus.ExponentialBuckets(1024, 4, 10)
+
+var requestBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: metricsNamespace,
+	Name:      "request_bytes",
+	Help:      "Size of hook request bodies, by hook and service cluster.",
+	Buckets:   payloadBuckets,
+}, []string{"hook", "service_cluster"})
+
+var responseBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: metricsNamespace,
+	Name:      "response_bytes",
+	Help:      "Size of hook response bodies, by hook and service cluster.",
+	Buckets:   payloadBuckets,
+}, []string{"hook", "service_cluster"})
+
+func init() {
+	prometheus.MustRegister(requestBytes, responseBytes)
+}
+
+// observePayloadSizes records the body sizes of a hook call and warns if either is over the large payload threshold.
+// Payloads grow with the mesh, so the warning gives notice before they hit Pilot's or the webhook's limits.
+func observePayloadSizes(hook, cluster, serviceNode string, in, out int) {
+	requestBytes.WithLabelValues(hook, cluster).Observe(float64(in))
+	responseBytes.WithLabelValues(hook, cluster).Observe(float64(out))
+	if largePayloadBytes <= 0 || (in <= largePayloadBytes && out <= largePayloadBytes) {
+		return
+	}
+	errorLog.Warn(log.Fields{
+		"hook":          hook,
+		"serviceNode":   serviceNode,
+		"requestBytes":  in,
+		"responseBytes": out,
+		"threshold":     largePayloadBytes,
+	}, "Large hook payload")
+}
+
+// payloadTooLargeError is returned when reading a request body past maxPayloadBytes.
+type payloadTooLargeError struct {
+	limit int
+}
+
+func (e *payloadTooLargeError) Error() string {
+	return fmt.Sprintf("request body exceeds the %d byte limit", e.limit)
+}
+
+// limitBody limits a request body to maxPayloadBytes.  Reading past the limit fails with a payloadTooLargeError,
+// after discarding the rest of the body unread into memory, so that the peer is not left blocked writing it.
+func limitBody(r io.Reader) io.Reader {
+	if maxPayloadBytes <= 0 {
+		return r
+	}
+	return &limitedBody{r: r, remaining: maxPayloadBytes}
+}
+
+type limitedBody struct {
+	r         io.Reader
+	remaining int
+	err       error
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if len(p) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.r.Read(p)
+	if n > b.remaining {
+		io.Copy(ioutil.Discard, b.r)
+		b.err = &payloadTooLargeError{limit: maxPayloadBytes}
+		return b.remaining, b.err
+	}
+	b.remaining -= n
+	return n, err
+}
+
+// replayBody returns a body that yields what a filter already read or, if reading it failed, the same error, so that
+// the handler fails the same way before writing anything.
+func replayBody(body []byte, err error) io.ReadCloser {
+	if err != nil {
+		return ioutil.NopCloser(&failedReader{err})
+	}
+	return ioutil.NopCloser(bytes.NewReader(body))
+}
+
+type failedReader struct {
+	err error
+}
+
+func (r *failedReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// rejectOversized responds 413 if err is from reading a body past maxPayloadBytes, and returns whether it did.
+func rejectOversized(hook string, resp *restful.Response, err error) bool {
+	tooLarge, ok := err.(*payloadTooLargeError)
+	if !ok {
+		return false
+	}
+	reportError(hook, ErrorClassTooLarge, log.Fields{"limit": tooLarge.limit}, "request body too large")
+	resp.WriteErrorString(http.StatusRequestEntityTooLarge, tooLarge.Error())
+	return true
+}
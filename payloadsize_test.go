@@ -0,0 +1,102 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+)
+
+func TestLargePayloadWarning(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func(old int, oldLog *sampledLogger) { largePayloadBytes, errorLog = old, oldLog }(largePayloadBytes, errorLog)
+	errorLog = newSampledLogger(1, time.Hour)
+	largePayloadBytes = 100
+
+	observePayloadSizes("listeners", "", "node", 10, 10)
+	Expect(errorLog.windows).To(BeEmpty())
+
+	observePayloadSizes("listeners", "", "node", 10, 101)
+	Expect(errorLog.windows).To(HaveKey("Large hook payload"))
+	Expect(errorLog.windows["Large hook payload"].logged).To(Equal(1))
+}
+
+func TestLargePayloadWarningDisabled(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func(old int, oldLog *sampledLogger) { largePayloadBytes, errorLog = old, oldLog }(largePayloadBytes, errorLog)
+	errorLog = newSampledLogger(1, time.Hour)
+	largePayloadBytes = 0
+
+	observePayloadSizes("clusters", "", "node", 1<<30, 1<<30)
+	Expect(errorLog.windows).To(BeEmpty())
+}
+
+func TestLimitBody(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func(old int) { maxPayloadBytes = old }(maxPayloadBytes)
+	maxPayloadBytes = 4
+
+	buf, err := readBody(strings.NewReader("1234"), 4)
+	Expect(err).To(BeNil())
+	Expect(buf.String()).To(Equal("1234"))
+
+	r := strings.NewReader("123456789")
+	_, err = readBody(r, -1)
+	Expect(err.Error()).To(Equal("request body exceeds the 4 byte limit"))
+	// The rest was discarded.
+	Expect(r.Len()).To(Equal(0))
+}
+
+func TestListenersTooLarge(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func(old int) { maxPayloadBytes = old }(maxPayloadBytes)
+	maxPayloadBytes = 10
+
+	for _, nodeType := range []string{"sidecar", "router"} {
+		req := newLDSRequest(nodeType, strings.NewReader(`{"listeners":[]}`))
+		rec := httptest.NewRecorder()
+		listeners(req, restful.NewResponse(rec))
+		if nodeType == "sidecar" {
+			Expect(rec.Code).To(Equal(http.StatusRequestEntityTooLarge))
+		} else {
+			// Passed through without being read into memory.
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(Equal(`{"listeners":[]}`))
+		}
+	}
+}
+
+// Filters that read the body first pass the failure on to the handler.
+func TestReplayBodyFailure(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func(old int) { maxPayloadBytes = old }(maxPayloadBytes)
+	maxPayloadBytes = 10
+	defer func() { history = nil }()
+	history = newExchangeRing(1, 100)
+
+	rec := serveHook(newHooks(), hookRequest("clusters", "sidecar", strings.NewReader(`{"clusters":[]}`)))
+	Expect(rec.Code).To(Equal(http.StatusRequestEntityTooLarge))
+}
@@ -0,0 +1,227 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilottest
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type object map[string]interface{}
+
+// Service is a service in the mesh, with one port.
+type Service struct {
+	Name      string
+	Namespace string
+	Port      int
+	// HTTP services are routed by RDS, others by a TCP listener on the service's ClusterIP.
+	HTTP      bool
+	ClusterIP string
+	Endpoints []string
+}
+
+// Host is the service's FQDN.
+func (s Service) Host() string {
+	return fmt.Sprintf("%s.%s.svc.cluster.local", s.Name, s.Namespace)
+}
+
+// Key is the service's EDS service name, and the suffix of its outbound cluster's name.
+func (s Service) Key() string {
+	return fmt.Sprintf("%s|%d", s.Host(), s.Port)
+}
+
+// EDS is the service's endpoints, as Pilot sends them to the EDS hook.
+func (s Service) EDS() []byte {
+	hosts := []object{}
+	for _, ep := range s.Endpoints {
+		hosts = append(hosts, object{"ip_address": ep, "port": s.Port})
+	}
+	return marshal(object{"hosts": hosts})
+}
+
+// NewMesh returns n services spread over five namespaces.  Every fourth is TCP, the rest HTTP.
+func NewMesh(n int) []Service {
+	mesh := make([]Service, n)
+	for i := range mesh {
+		mesh[i] = Service{
+			Name:      fmt.Sprintf("svc-%d", i),
+			Namespace: fmt.Sprintf("ns-%d", i%5),
+			Port:      8000 + i%10,
+			HTTP:      i%4 != 3,
+			ClusterIP: fmt.Sprintf("10.96.%d.%d", i/256, i%256),
+			Endpoints: []string{
+				fmt.Sprintf("10.1.%d.%d", i/128, 2*(i%128)), fmt.Sprintf("10.1.%d.%d", i/128, 2*(i%128)+1),
+			},
+		}
+	}
+	return mesh
+}
+
+// Sidecar is the Envoy of a pod in the mesh.
+type Sidecar struct {
+	IP        string
+	Pod       string
+	Namespace string
+	// HTTPPorts and TCPPorts are the pod's own ports, which get inbound listeners.
+	HTTPPorts []int
+	TCPPorts  []int
+	// Mesh is the services the sidecar can reach.
+	Mesh []Service
+}
+
+// NewSidecar returns a sidecar for a pod on ip in the default namespace, serving HTTP on 9080 and TCP on 3306.
+func NewSidecar(ip string, mesh []Service) *Sidecar {
+	return &Sidecar{
+		IP:        ip,
+		Pod:       "pod-" + strings.Replace(ip, ".", "-", -1),
+		Namespace: "default",
+		HTTPPorts: []int{9080},
+		TCPPorts:  []int{3306},
+		Mesh:      mesh,
+	}
+}
+
+// Node is the sidecar's service node ID.
+func (s *Sidecar) Node() string {
+	return fmt.Sprintf("sidecar~%s~%s.%s~%s.svc.cluster.local", s.IP, s.Pod, s.Namespace, s.Namespace)
+}
+
+// LDS has the virtual listener, an inbound listener per port of the pod, an outbound HTTP listener per port of the
+// mesh's HTTP services, and an outbound TCP listener per TCP service.
+func (s *Sidecar) LDS() []byte {
+	listeners := []object{{
+		"name": "virtual", "address": "tcp://0.0.0.0:15001", "bind_to_port": true, "use_original_dst": true,
+		"filters": []object{tcpProxy("orig-dst-cluster-tcp")},
+	}}
+	for _, port := range s.HTTPPorts {
+		name := fmt.Sprintf("http_%s_%d", s.IP, port)
+		hcm := httpConnectionManager(name)
+		hcm["config"].(object)["route_config"] = object{"virtual_hosts": []object{{
+			"name": fmt.Sprintf("inbound|%d", port), "domains": []string{"*"},
+			"routes": []object{{"prefix": "/", "cluster": fmt.Sprintf("in.%d", port)}},
+		}}}
+		listeners = append(listeners, listener(name, s.IP, port, hcm))
+	}
+	for _, port := range s.TCPPorts {
+		listeners = append(listeners, listener(fmt.Sprintf("tcp_%s_%d", s.IP, port), s.IP, port,
+			tcpProxy(fmt.Sprintf("in.%d", port))))
+	}
+	for _, rc := range s.RouteConfigs() {
+		port, _ := strconv.Atoi(rc)
+		name := fmt.Sprintf("http_0.0.0.0_%d", port)
+		hcm := httpConnectionManager(name)
+		hcm["config"].(object)["rds"] = object{"cluster": "rds", "route_config_name": rc, "refresh_delay_ms": 1000}
+		listeners = append(listeners, listener(name, "0.0.0.0", port, hcm))
+	}
+	for _, svc := range s.Mesh {
+		if !svc.HTTP {
+			listeners = append(listeners, listener(fmt.Sprintf("tcp_%s_%d", svc.ClusterIP, svc.Port), svc.ClusterIP,
+				svc.Port, tcpProxy("out."+svc.Key())))
+		}
+	}
+	return marshal(object{"listeners": listeners})
+}
+
+// CDS has the RDS cluster, an inbound cluster per port of the pod, and an outbound cluster per service.
+func (s *Sidecar) CDS() []byte {
+	clusters := []object{{
+		"name": "rds", "connect_timeout_ms": 1000, "type": "strict_dns", "lb_type": "round_robin",
+		"hosts": []object{{"url": "tcp://istio-pilot.istio-system:15003"}},
+	}}
+	for _, port := range append(append([]int(nil), s.HTTPPorts...), s.TCPPorts...) {
+		clusters = append(clusters, object{
+			"name": fmt.Sprintf("in.%d", port), "connect_timeout_ms": 1000, "type": "static", "lb_type": "round_robin",
+			"hosts": []object{{"url": fmt.Sprintf("tcp://127.0.0.1:%d", port)}},
+		})
+	}
+	for _, svc := range s.Mesh {
+		clusters = append(clusters, object{
+			"name": "out." + svc.Key(), "service_name": svc.Key(), "connect_timeout_ms": 1000, "type": "sds",
+			"lb_type": "round_robin", "outlier_detection": object{"consecutive_5xx": 5, "interval_ms": 10000},
+		})
+	}
+	return marshal(object{"clusters": clusters})
+}
+
+// RouteConfigs are the names of the route configs the sidecar's outbound HTTP listeners fetch, one per port.
+func (s *Sidecar) RouteConfigs() []string {
+	ports := map[int]bool{}
+	for _, svc := range s.Mesh {
+		if svc.HTTP {
+			ports[svc.Port] = true
+		}
+	}
+	var rcs []int
+	for port := range ports {
+		rcs = append(rcs, port)
+	}
+	sort.Ints(rcs)
+	names := make([]string, len(rcs))
+	for i, port := range rcs {
+		names[i] = strconv.Itoa(port)
+	}
+	return names
+}
+
+// RDS has a virtual host per HTTP service on the route config's port.
+func (s *Sidecar) RDS(routeConfig string) []byte {
+	hosts := []object{}
+	for _, svc := range s.Mesh {
+		if !svc.HTTP || strconv.Itoa(svc.Port) != routeConfig {
+			continue
+		}
+		hosts = append(hosts, object{
+			"name":    svc.Host() + "|http",
+			"domains": []string{svc.Name, fmt.Sprintf("%s:%d", svc.Name, svc.Port), svc.Host(), svc.ClusterIP},
+			"routes":  []object{{"prefix": "/", "cluster": "out." + svc.Key(), "timeout_ms": 0}},
+		})
+	}
+	return marshal(object{"validate_clusters": true, "virtual_hosts": hosts})
+}
+
+func listener(name, ip string, port int, filter object) object {
+	return object{
+		"name": name, "address": fmt.Sprintf("tcp://%s:%d", ip, port), "bind_to_port": false,
+		"filters": []object{filter},
+	}
+}
+
+func httpConnectionManager(prefix string) object {
+	return object{"type": "read", "name": "http_connection_manager", "config": object{
+		"codec_type": "auto", "stat_prefix": prefix, "generate_request_id": true,
+		"filters": []object{
+			{"type": "decoder", "name": "mixer", "config": object{"mixer_attributes": object{}}},
+			{"type": "decoder", "name": "cors", "config": object{}},
+			{"type": "decoder", "name": "router", "config": object{}},
+		},
+	}}
+}
+
+func tcpProxy(cluster string) object {
+	return object{"type": "read", "name": "tcp_proxy", "config": object{
+		"stat_prefix": "tcp", "route_config": object{"routes": []object{{"cluster": cluster}}},
+	}}
+}
+
+func marshal(v object) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
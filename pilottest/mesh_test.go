@@ -0,0 +1,54 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilottest
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestSidecarConfig(t *testing.T) {
+	RegisterTestingT(t)
+
+	mesh := NewMesh(8)
+	s := NewSidecar("10.2.0.1", mesh)
+	Expect(s.Node()).To(Equal("sidecar~10.2.0.1~pod-10-2-0-1.default~default.svc.cluster.local"))
+
+	var lds struct {
+		Listeners []struct {
+			Name string `json:"name"`
+		} `json:"listeners"`
+	}
+	Expect(json.Unmarshal(s.LDS(), &lds)).To(Succeed())
+	// The virtual listener, two inbound, one outbound HTTP listener per port and one per TCP service.
+	Expect(lds.Listeners).To(HaveLen(1 + 2 + 6 + 2))
+	Expect(lds.Listeners[1].Name).To(Equal("http_10.2.0.1_9080"))
+
+	var cds struct {
+		Clusters []json.RawMessage `json:"clusters"`
+	}
+	Expect(json.Unmarshal(s.CDS(), &cds)).To(Succeed())
+	Expect(cds.Clusters).To(HaveLen(1 + 2 + 8))
+
+	Expect(s.RouteConfigs()).To(Equal([]string{"8000", "8001", "8002", "8004", "8005", "8006"}))
+	var rds struct {
+		VirtualHosts []json.RawMessage `json:"virtual_hosts"`
+	}
+	Expect(json.Unmarshal(s.RDS("8000"), &rds)).To(Succeed())
+	Expect(rds.VirtualHosts).To(HaveLen(1))
+	Expect(json.Valid(mesh[0].EDS())).To(BeTrue())
+}
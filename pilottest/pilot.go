@@ -0,0 +1,122 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pilottest emulates Istio Pilot calling the webhook's hooks over its Unix socket, so that tests can exercise
+// the whole serving stack with the requests, and sequences of requests, that Pilot makes.
+package pilottest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Pilot calls the hooks of a webhook serving on a Unix socket.
+type Pilot struct {
+	// Cluster is the service cluster Pilot passes to the hooks.
+	Cluster string
+
+	client *http.Client
+}
+
+// New returns a Pilot that calls the webhook serving on socket.
+func New(socket string) *Pilot {
+	return &Pilot{
+		Cluster: "istio-proxy",
+		client: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}},
+	}
+}
+
+// Response is the webhook's answer to a hook request.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Listeners calls the LDS hook for the node.
+func (p *Pilot) Listeners(node string, body []byte) (*Response, error) {
+	return p.post(fmt.Sprintf("/v1/listeners/%s/%s", url.PathEscape(p.Cluster), url.PathEscape(node)), body)
+}
+
+// Clusters calls the CDS hook for the node.
+func (p *Pilot) Clusters(node string, body []byte) (*Response, error) {
+	return p.post(fmt.Sprintf("/v1/clusters/%s/%s", url.PathEscape(p.Cluster), url.PathEscape(node)), body)
+}
+
+// Routes calls the RDS hook for one of the node's route configs.
+func (p *Pilot) Routes(routeConfig, node string, body []byte) (*Response, error) {
+	return p.post(fmt.Sprintf("/v1/routes/%s/%s/%s", url.PathEscape(routeConfig), url.PathEscape(p.Cluster),
+		url.PathEscape(node)), body)
+}
+
+// Endpoints calls the EDS hook for a service, e.g. "web.default.svc.cluster.local|http".
+func (p *Pilot) Endpoints(service string, body []byte) (*Response, error) {
+	return p.post("/v1/registration/"+url.PathEscape(service), body)
+}
+
+func (p *Pilot) post(path string, body []byte) (*Response, error) {
+	resp, err := p.client.Post("http://pilot-webhook"+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{Status: resp.StatusCode, Header: resp.Header, Body: b}, nil
+}
+
+// Push is the responses to one push of a sidecar's config.
+type Push struct {
+	Clusters  *Response
+	Endpoints map[string]*Response
+	Listeners *Response
+	Routes    map[string]*Response
+}
+
+// Push sends a sidecar's whole config in the order Pilot's v1 discovery serves an Envoy that has just connected: CDS,
+// then EDS for each outbound cluster, then LDS, then RDS for each route config the listeners name.  It stops at the
+// first request that fails to be sent, but not at error statuses.
+func (p *Pilot) Push(s *Sidecar) (*Push, error) {
+	push := &Push{Endpoints: make(map[string]*Response), Routes: make(map[string]*Response)}
+	var err error
+	if push.Clusters, err = p.Clusters(s.Node(), s.CDS()); err != nil {
+		return push, err
+	}
+	for _, svc := range s.Mesh {
+		if push.Endpoints[svc.Key()], err = p.Endpoints(svc.Key(), svc.EDS()); err != nil {
+			return push, err
+		}
+	}
+	if push.Listeners, err = p.Listeners(s.Node(), s.LDS()); err != nil {
+		return push, err
+	}
+	for _, rc := range s.RouteConfigs() {
+		if push.Routes[rc], err = p.Routes(rc, s.Node(), s.RDS(rc)); err != nil {
+			return push, err
+		}
+	}
+	return push, nil
+}
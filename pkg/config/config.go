@@ -0,0 +1,109 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config has the names, node IDs and per node settings that the webhook's hooks and the packages embedding its
+// mutations share.
+package config
+
+import "strings"
+
+const (
+	// AuthzFilterName is the name the authz filter is registered under in Envoy.
+	AuthzFilterName = "envoy.ext_authz"
+	// AuthzClusterName is the cluster the authz filter sends its checks to.
+	AuthzClusterName = "calico.dikastes"
+	// DryRunHeader set to true on a hook request asks for the changes to be reported rather than applied.
+	DryRunHeader = "X-Calico-Dry-Run"
+	// DryRunChangesHeader lists the resources a dry run would have changed, or "none".
+	DryRunChangesHeader = "X-Calico-Dry-Run-Changes"
+	// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of a signed hook response's body.
+	SignatureHeader = "X-Calico-Signature"
+	// ServiceNodeSeparator separates the parts of an Istio service node.
+	ServiceNodeSeparator = "~"
+)
+
+// Node is an Istio service node, as Pilot passes it to the hooks: <type>~<ip>~<id>~<domain>, where the ID of a
+// sidecar is <pod>.<namespace>.
+type Node struct {
+	Type   string
+	IP     string
+	ID     string
+	Domain string
+}
+
+// ParseNode splits a service node into its parts.  Missing parts are left empty.
+func ParseNode(serviceNode string) Node {
+	c := strings.SplitN(serviceNode, ServiceNodeSeparator, 4)
+	for len(c) < 4 {
+		c = append(c, "")
+	}
+	return Node{Type: c[0], IP: c[1], ID: c[2], Domain: c[3]}
+}
+
+// String returns the service node.
+func (n Node) String() string {
+	return strings.Join([]string{n.Type, n.IP, n.ID, n.Domain}, ServiceNodeSeparator)
+}
+
+// IsSidecar reports whether the node is a sidecar, the only type of node the authz filter is added for.
+func (n Node) IsSidecar() bool {
+	return n.Type == "sidecar"
+}
+
+// Pod returns the name and namespace of a sidecar's pod, from its ID.
+func (n Node) Pod() (name, namespace string, ok bool) {
+	i := strings.LastIndex(n.ID, ".")
+	if i <= 0 || i == len(n.ID)-1 {
+		return "", "", false
+	}
+	return n.ID[:i], n.ID[i+1:], true
+}
+
+// HeaderValue is a gRPC metadata entry.
+type HeaderValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Profile is how the authz filter is configured for a node, which depends on its Istio version and the features
+// enabled.
+type Profile struct {
+	// FilterName is the name the authz filter is registered under in the sidecar's Envoy.
+	FilterName string
+	// ClusterName is the authz cluster the filter sends its checks to.
+	ClusterName string
+	// GrpcService configures the filter with a v2 style grpc_service rather than a grpc_cluster, which proxies from
+	// 0.8 expect.
+	GrpcService bool
+	// Deprecated is set for versions whose Pilot no longer calls the webhook hooks, so they need an EnvoyFilter.
+	Deprecated bool
+	// FailOpen lets requests through when dikastes cannot be reached, for nodes where it may not have policy yet.
+	FailOpen bool
+	// InitialMetadata is sent to dikastes with each check.  Only the grpc_service config can carry it.
+	InitialMetadata []HeaderValue
+	// ExcludedPorts are inbound ports whose traffic bypasses the sidecar, so are left alone.
+	ExcludedPorts map[int]bool
+}
+
+// ProfileFor returns the profile for an Istio version.  An unknown version, 0.0, gets the profile the webhook has
+// always used.
+func ProfileFor(major, minor int) Profile {
+	atLeast := func(ma, mi int) bool { return major > ma || major == ma && minor >= mi }
+	return Profile{
+		FilterName:  AuthzFilterName,
+		ClusterName: AuthzClusterName,
+		GrpcService: atLeast(0, 8),
+		Deprecated:  atLeast(1, 0),
+	}
+}
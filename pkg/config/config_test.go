@@ -0,0 +1,54 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestParseNode(t *testing.T) {
+	RegisterTestingT(t)
+
+	n := ParseNode("sidecar~10.0.0.1~web-1.default~default.svc.cluster.local")
+	Expect(n).To(Equal(Node{Type: "sidecar", IP: "10.0.0.1", ID: "web-1.default", Domain: "default.svc.cluster.local"}))
+	Expect(n.String()).To(Equal("sidecar~10.0.0.1~web-1.default~default.svc.cluster.local"))
+	Expect(n.IsSidecar()).To(BeTrue())
+	name, namespace, ok := n.Pod()
+	Expect(ok).To(BeTrue())
+	Expect(name).To(Equal("web-1"))
+	Expect(namespace).To(Equal("default"))
+
+	n = ParseNode("router~10.0.0.2")
+	Expect(n.IsSidecar()).To(BeFalse())
+	Expect(n.ID).To(Equal(""))
+	_, _, ok = n.Pod()
+	Expect(ok).To(BeFalse())
+	_, _, ok = ParseNode("sidecar~10.0.0.1~web.~d").Pod()
+	Expect(ok).To(BeFalse())
+}
+
+func TestProfileFor(t *testing.T) {
+	RegisterTestingT(t)
+
+	p := ProfileFor(0, 0)
+	Expect(p.FilterName).To(Equal(AuthzFilterName))
+	Expect(p.ClusterName).To(Equal(AuthzClusterName))
+	Expect(p.GrpcService).To(BeFalse())
+	Expect(ProfileFor(0, 8).GrpcService).To(BeTrue())
+	Expect(ProfileFor(0, 8).Deprecated).To(BeFalse())
+	Expect(ProfileFor(1, 0).Deprecated).To(BeTrue())
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package extauthz builds Calico's ext_authz filter and the dikastes cluster it checks requests with, so that the
+// webhook, dikastes, the operator and docs tooling all generate the same Envoy config.  The configs for each Envoy API
+// version are in their own package: v1 for the JSON the v1 xDS hooks serve, and v2 for EnvoyFilters and v2 xDS.
+package extauthz
+
+import (
+	"time"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+)
+
+// Options describes the filter and cluster to build.  The zero value is the filter as the webhook has always added
+// it, checking requests through the authz cluster.
+type Options struct {
+	// FilterName is the name the filter is registered under in Envoy.  It defaults to config.AuthzFilterName.
+	FilterName string
+	// ClusterName is the cluster the filter sends its checks to.  It defaults to config.AuthzClusterName.
+	ClusterName string
+	// TargetURI, if set, has the filter call dikastes directly with Google gRPC at this URI, e.g.
+	// unix:/var/run/dikastes/dikastes.sock, rather than through the cluster.  Only v2 configs can.
+	TargetURI string
+	// GrpcService configures a v1 filter with a grpc_service rather than a grpc_cluster, which proxies from Istio 0.8
+	// expect.  v2 filters always have a grpc_service.
+	GrpcService bool
+	// FailureModeAllow lets requests through if dikastes cannot be reached.
+	FailureModeAllow bool
+	// InitialMetadata is sent to dikastes with each check.  Only a grpc_service can carry it.
+	InitialMetadata []config.HeaderValue
+	// Timeout bounds each check.  Only v2 configs can set it; Envoy's default is 200ms.
+	Timeout time.Duration
+}
+
+// OptionsFor returns the options for a node's profile.
+func OptionsFor(profile config.Profile) Options {
+	return Options{
+		FilterName:       profile.FilterName,
+		ClusterName:      profile.ClusterName,
+		GrpcService:      profile.GrpcService,
+		FailureModeAllow: profile.FailOpen,
+		InitialMetadata:  profile.InitialMetadata,
+	}
+}
+
+// WithDefaults returns the options with the default filter and cluster names filled in.
+func (o Options) WithDefaults() Options {
+	if o.FilterName == "" {
+		o.FilterName = config.AuthzFilterName
+	}
+	if o.ClusterName == "" {
+		o.ClusterName = config.AuthzClusterName
+	}
+	return o
+}
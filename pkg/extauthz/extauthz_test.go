@@ -0,0 +1,51 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package extauthz
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+)
+
+func TestOptionsFor(t *testing.T) {
+	RegisterTestingT(t)
+
+	p := config.ProfileFor(1, 0)
+	p.FailOpen = true
+	p.InitialMetadata = []config.HeaderValue{{Key: "k", Value: "v"}}
+	Expect(OptionsFor(p)).To(Equal(Options{
+		FilterName:       config.AuthzFilterName,
+		ClusterName:      config.AuthzClusterName,
+		GrpcService:      true,
+		FailureModeAllow: true,
+		InitialMetadata:  p.InitialMetadata,
+	}))
+}
+
+func TestWithDefaults(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(Options{}.WithDefaults()).To(Equal(Options{
+		FilterName:  config.AuthzFilterName,
+		ClusterName: config.AuthzClusterName,
+	}))
+	Expect(Options{FilterName: "f", ClusterName: "c"}.WithDefaults()).To(Equal(Options{
+		FilterName:  "f",
+		ClusterName: "c",
+	}))
+}
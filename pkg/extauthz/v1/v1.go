@@ -0,0 +1,90 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package v1 builds the ext_authz filter and dikastes cluster in the shapes of Envoy's v1 API, which the webhook's
+// hooks serve.
+package v1
+
+import (
+	envoyv1 "istio.io/istio/pilot/pkg/proxy/envoy/v1"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+	"github.com/projectcalico/pilot-webhook/pkg/extauthz"
+)
+
+// FilterConfig is the config of the ext_authz filter, as a network or an HTTP filter.
+type FilterConfig struct {
+	StatPrefix  string             `json:"stat_prefix,omitempty"`
+	GrpcCluster *GrpcClusterConfig `json:"grpc_cluster,omitempty"`
+	GrpcService *GrpcServiceConfig `json:"grpc_service,omitempty"`
+	// FailureModeAllow lets requests through if the authz service cannot be reached.
+	FailureModeAllow bool `json:"failure_mode_allow,omitempty"`
+}
+
+type GrpcClusterConfig struct {
+	ClusterName string `json:"cluster_name"`
+}
+
+type GrpcServiceConfig struct {
+	EnvoyGrpc       *GrpcClusterConfig   `json:"envoy_grpc"`
+	InitialMetadata []config.HeaderValue `json:"initial_metadata,omitempty"`
+}
+
+func (*FilterConfig) IsNetworkFilterConfig() {}
+
+// Config returns the filter's config.  Network filters have a stat prefix, and HTTP filters do not.
+func Config(o extauthz.Options, statPrefix string) *FilterConfig {
+	o = o.WithDefaults()
+	cfg := &FilterConfig{StatPrefix: statPrefix, FailureModeAllow: o.FailureModeAllow}
+	cluster := &GrpcClusterConfig{ClusterName: o.ClusterName}
+	if o.GrpcService {
+		cfg.GrpcService = &GrpcServiceConfig{EnvoyGrpc: cluster, InitialMetadata: o.InitialMetadata}
+	} else {
+		cfg.GrpcCluster = cluster
+	}
+	return cfg
+}
+
+// NetworkFilter is the filter for TCP listeners.
+func NetworkFilter(o extauthz.Options) *envoyv1.NetworkFilter {
+	o = o.WithDefaults()
+	return &envoyv1.NetworkFilter{
+		Type:   "read",
+		Name:   o.FilterName,
+		Config: Config(o, o.FilterName),
+	}
+}
+
+// HTTPFilter is the filter for HTTP connection managers.
+func HTTPFilter(o extauthz.Options) envoyv1.HTTPFilter {
+	o = o.WithDefaults()
+	return envoyv1.HTTPFilter{
+		Type:   "decoder",
+		Name:   o.FilterName,
+		Config: Config(o, ""),
+	}
+}
+
+// Cluster is the cluster the filter sends its checks to, at the dikastes address addr, a tcp:// or unix:// URL.
+func Cluster(o extauthz.Options, addr string) *envoyv1.Cluster {
+	return &envoyv1.Cluster{
+		Name:             o.WithDefaults().ClusterName,
+		ConnectTimeoutMs: 1000,
+		Type:             envoyv1.ClusterTypeStatic,
+		LbType:           envoyv1.LbTypeRoundRobin,
+		Hosts:            []envoyv1.Host{{URL: addr}},
+		// The authz filter speaks gRPC.
+		Features: envoyv1.ClusterFeatureHTTP2,
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+	"github.com/projectcalico/pilot-webhook/pkg/extauthz"
+)
+
+func marshal(v interface{}) string {
+	b, err := json.Marshal(v)
+	Expect(err).To(BeNil())
+	return string(b)
+}
+
+func TestFilters(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(marshal(HTTPFilter(extauthz.Options{}))).To(MatchJSON(`{"type":"decoder","name":"envoy.ext_authz",` +
+		`"config":{"grpc_cluster":{"cluster_name":"calico.dikastes"}}}`))
+	Expect(marshal(NetworkFilter(extauthz.Options{}))).To(MatchJSON(`{"type":"read","name":"envoy.ext_authz",` +
+		`"config":{"stat_prefix":"envoy.ext_authz","grpc_cluster":{"cluster_name":"calico.dikastes"}}}`))
+
+	o := extauthz.Options{
+		GrpcService:      true,
+		FailureModeAllow: true,
+		InitialMetadata:  []config.HeaderValue{{Key: "k", Value: "v"}},
+	}
+	Expect(marshal(HTTPFilter(o))).To(MatchJSON(`{"type":"decoder","name":"envoy.ext_authz","config":{` +
+		`"grpc_service":{"envoy_grpc":{"cluster_name":"calico.dikastes"},"initial_metadata":[{"key":"k","value":"v"}]},` +
+		`"failure_mode_allow":true}}`))
+}
+
+func TestCluster(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(marshal(Cluster(extauthz.Options{ClusterName: "authz"}, "unix:///var/run/dikastes/dikastes.sock"))).To(
+		MatchJSON(`{"name":"authz","connect_timeout_ms":1000,"type":"static","lb_type":"round_robin",` +
+			`"hosts":[{"url":"unix:///var/run/dikastes/dikastes.sock"}],"features":"http2"}`))
+}
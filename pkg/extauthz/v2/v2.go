@@ -0,0 +1,195 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package v2 builds the ext_authz filter and dikastes cluster in the shapes of Envoy's v2 API, for EnvoyFilters and
+// v2 xDS.
+package v2
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+	"github.com/projectcalico/pilot-webhook/pkg/extauthz"
+)
+
+// Filter is a named network or HTTP filter.
+type Filter struct {
+	Name   string      `json:"name"`
+	Config interface{} `json:"config"`
+}
+
+// HTTPFilterConfig is the config of envoy.config.filter.http.ext_authz.v2.ExtAuthz.
+type HTTPFilterConfig struct {
+	GrpcService      *GrpcService `json:"grpc_service"`
+	FailureModeAllow bool         `json:"failure_mode_allow,omitempty"`
+}
+
+// NetworkFilterConfig is the config of envoy.config.filter.network.ext_authz.v2.ExtAuthz.
+type NetworkFilterConfig struct {
+	StatPrefix       string       `json:"stat_prefix"`
+	GrpcService      *GrpcService `json:"grpc_service"`
+	FailureModeAllow bool         `json:"failure_mode_allow,omitempty"`
+}
+
+// GrpcService is envoy.api.v2.core.GrpcService, through either Envoy's gRPC client and a cluster, or Google's and a
+// target URI.
+type GrpcService struct {
+	EnvoyGrpc       *EnvoyGrpc           `json:"envoy_grpc,omitempty"`
+	GoogleGrpc      *GoogleGrpc          `json:"google_grpc,omitempty"`
+	Timeout         string               `json:"timeout,omitempty"`
+	InitialMetadata []config.HeaderValue `json:"initial_metadata,omitempty"`
+}
+
+type EnvoyGrpc struct {
+	ClusterName string `json:"cluster_name"`
+}
+
+type GoogleGrpc struct {
+	TargetURI  string `json:"target_uri"`
+	StatPrefix string `json:"stat_prefix"`
+}
+
+// GrpcServiceFor returns the gRPC service the filter checks requests with.
+func GrpcServiceFor(o extauthz.Options) *GrpcService {
+	o = o.WithDefaults()
+	s := &GrpcService{InitialMetadata: o.InitialMetadata}
+	if o.TargetURI != "" {
+		s.GoogleGrpc = &GoogleGrpc{TargetURI: o.TargetURI, StatPrefix: o.FilterName}
+	} else {
+		s.EnvoyGrpc = &EnvoyGrpc{ClusterName: o.ClusterName}
+	}
+	if o.Timeout > 0 {
+		s.Timeout = Duration(o.Timeout)
+	}
+	return s
+}
+
+// HTTPFilter is the filter for HTTP connection managers.
+func HTTPFilter(o extauthz.Options) Filter {
+	o = o.WithDefaults()
+	return Filter{
+		Name:   o.FilterName,
+		Config: &HTTPFilterConfig{GrpcService: GrpcServiceFor(o), FailureModeAllow: o.FailureModeAllow},
+	}
+}
+
+// NetworkFilter is the filter for TCP listeners.
+func NetworkFilter(o extauthz.Options) Filter {
+	o = o.WithDefaults()
+	return Filter{
+		Name: o.FilterName,
+		Config: &NetworkFilterConfig{
+			StatPrefix:       o.FilterName,
+			GrpcService:      GrpcServiceFor(o),
+			FailureModeAllow: o.FailureModeAllow,
+		},
+	}
+}
+
+// Cluster is envoy.api.v2.Cluster, trimmed to what the dikastes cluster sets.
+type Cluster struct {
+	Name                 string                `json:"name"`
+	ConnectTimeout       string                `json:"connect_timeout"`
+	Type                 string                `json:"type"`
+	LbPolicy             string                `json:"lb_policy"`
+	HTTP2ProtocolOptions struct{}              `json:"http2_protocol_options"`
+	LoadAssignment       ClusterLoadAssignment `json:"load_assignment"`
+}
+
+type ClusterLoadAssignment struct {
+	ClusterName string              `json:"cluster_name"`
+	Endpoints   []LocalityEndpoints `json:"endpoints"`
+}
+
+type LocalityEndpoints struct {
+	LbEndpoints []LbEndpoint `json:"lb_endpoints"`
+}
+
+type LbEndpoint struct {
+	Endpoint Endpoint `json:"endpoint"`
+}
+
+type Endpoint struct {
+	Address Address `json:"address"`
+}
+
+// Address is either a socket address or a pipe.
+type Address struct {
+	SocketAddress *SocketAddress `json:"socket_address,omitempty"`
+	Pipe          *Pipe          `json:"pipe,omitempty"`
+}
+
+type SocketAddress struct {
+	Address   string `json:"address"`
+	PortValue int    `json:"port_value"`
+}
+
+type Pipe struct {
+	Path string `json:"path"`
+}
+
+// ParseAddress parses a dikastes address, a tcp://host:port or unix:///path URL.
+func ParseAddress(addr string) (Address, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return Address{}, err
+	}
+	switch u.Scheme {
+	case "unix":
+		if u.Path == "" {
+			return Address{}, fmt.Errorf("%q has no socket path", addr)
+		}
+		return Address{Pipe: &Pipe{Path: u.Path}}, nil
+	case "tcp":
+		host, p, err := net.SplitHostPort(u.Host)
+		if err != nil {
+			return Address{}, err
+		}
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return Address{}, fmt.Errorf("invalid port in %q", addr)
+		}
+		return Address{SocketAddress: &SocketAddress{Address: host, PortValue: port}}, nil
+	}
+	return Address{}, fmt.Errorf("%q is not a tcp:// or unix:// URL", addr)
+}
+
+// ClusterFor returns the cluster the filter sends its checks to, at the dikastes address addr, a tcp:// or unix://
+// URL.
+func ClusterFor(o extauthz.Options, addr string) (*Cluster, error) {
+	address, err := ParseAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+	name := o.WithDefaults().ClusterName
+	return &Cluster{
+		Name:           name,
+		ConnectTimeout: "1s",
+		Type:           "STATIC",
+		LbPolicy:       "ROUND_ROBIN",
+		LoadAssignment: ClusterLoadAssignment{
+			ClusterName: name,
+			Endpoints:   []LocalityEndpoints{{LbEndpoints: []LbEndpoint{{Endpoint: Endpoint{Address: address}}}}},
+		},
+	}, nil
+}
+
+// Duration formats a duration as a google.protobuf.Duration in JSON, e.g. "0.25s".
+func Duration(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v2
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+	"github.com/projectcalico/pilot-webhook/pkg/extauthz"
+)
+
+func marshal(v interface{}) string {
+	b, err := json.Marshal(v)
+	Expect(err).To(BeNil())
+	return string(b)
+}
+
+func TestFilters(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(marshal(HTTPFilter(extauthz.Options{}))).To(MatchJSON(`{"name":"envoy.ext_authz",` +
+		`"config":{"grpc_service":{"envoy_grpc":{"cluster_name":"calico.dikastes"}}}}`))
+
+	o := extauthz.Options{
+		TargetURI:        "unix:/var/run/dikastes/dikastes.sock",
+		FailureModeAllow: true,
+		InitialMetadata:  []config.HeaderValue{{Key: "k", Value: "v"}},
+		Timeout:          250 * time.Millisecond,
+	}
+	Expect(marshal(NetworkFilter(o))).To(MatchJSON(`{"name":"envoy.ext_authz","config":{` +
+		`"stat_prefix":"envoy.ext_authz",` +
+		`"grpc_service":{"google_grpc":{"target_uri":"unix:/var/run/dikastes/dikastes.sock",` +
+		`"stat_prefix":"envoy.ext_authz"},"timeout":"0.25s","initial_metadata":[{"key":"k","value":"v"}]},` +
+		`"failure_mode_allow":true}}`))
+}
+
+func TestClusterFor(t *testing.T) {
+	RegisterTestingT(t)
+
+	c, err := ClusterFor(extauthz.Options{}, "unix:///var/run/dikastes/dikastes.sock")
+	Expect(err).To(BeNil())
+	Expect(marshal(c)).To(MatchJSON(`{"name":"calico.dikastes","connect_timeout":"1s","type":"STATIC",` +
+		`"lb_policy":"ROUND_ROBIN","http2_protocol_options":{},"load_assignment":{"cluster_name":"calico.dikastes",` +
+		`"endpoints":[{"lb_endpoints":[{"endpoint":{"address":{"pipe":{"path":"/var/run/dikastes/dikastes.sock"}}}}]}]}}`))
+
+	c, err = ClusterFor(extauthz.Options{ClusterName: "authz"}, "tcp://10.0.0.9:9000")
+	Expect(err).To(BeNil())
+	Expect(c.LoadAssignment.Endpoints[0].LbEndpoints[0].Endpoint.Address).To(Equal(
+		Address{SocketAddress: &SocketAddress{Address: "10.0.0.9", PortValue: 9000}}))
+
+	for _, addr := range []string{"10.0.0.9:9000", "unix://", "tcp://10.0.0.9", "tcp://10.0.0.9:http", "udp://x:1"} {
+		_, err := ClusterFor(extauthz.Options{}, addr)
+		Expect(err).NotTo(BeNil(), addr)
+	}
+}
+
+func TestDuration(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(Duration(time.Second)).To(Equal("1s"))
+	Expect(Duration(1500 * time.Millisecond)).To(Equal("1.5s"))
+}
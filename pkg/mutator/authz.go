@@ -0,0 +1,284 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mutator
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+)
+
+// AuthzName is the name of the built-in Authz mutator.
+const AuthzName = "authz"
+
+// SkipReason is why a listener was not given the authz filter.
+type SkipReason string
+
+const (
+	// SkipOutbound is a listener for traffic leaving the workload.
+	SkipOutbound SkipReason = "outbound"
+	// SkipVirtual is the virtual listener that redirects to the real ones.
+	SkipVirtual SkipReason = "virtual"
+	// SkipExcludedPort is an inbound listener on a port excluded from enforcement.
+	SkipExcludedPort SkipReason = "excluded_port"
+	// SkipAlreadyInjected is a listener that already has the authz filter.
+	SkipAlreadyInjected SkipReason = "already_injected"
+)
+
+// ListenerResult is the outcome of giving one listener the authz filter.
+type ListenerResult struct {
+	// Raw is the listener, changed if Modified is set.
+	Raw  json.RawMessage
+	Name string
+	// Modified is set if the filter was added, and Authz if the listener has it either way.
+	Modified bool
+	Authz    bool
+	// Skip is why the filter was not added, if the listener was skipped.
+	Skip SkipReason
+	// Err is why an inbound listener could not be given the filter.  The listener is left as it was.
+	Err error
+}
+
+// ParallelListenersMin is the fewest listeners worth mutating in parallel.
+const ParallelListenersMin = 64
+
+// Authz is the built-in mutator that adds Calico's authz filter to a sidecar's inbound listeners, and the authz
+// cluster to its clusters if the request has a dikastes address.  The filter is shaped by the request's profile.  Its
+// fields let embedders change how listeners are classified and which are skipped; left unset, listeners are
+// classified by the names Pilot gives them, and only those on the profile's excluded ports are skipped.
+type Authz struct {
+	Passthrough
+	// Classify returns whether a listener is inbound, outbound or the virtual listener, and its protocol, for the
+	// request's node.  It is called with the listener's name and address only, and again with its filter names if
+	// that says it is inbound.
+	Classify func(req *Request, listener *v1.Listener) (Direction, Protocol)
+	// Skip returns why an inbound listener on port that would otherwise be given the filter should be left alone,
+	// or "" if it should not.
+	Skip func(req *Request, listener *v1.Listener, proto Protocol, port int) SkipReason
+	// Parallelism is how many goroutines mutate the listeners of responses with at least ParallelListenersMin.
+	Parallelism int
+}
+
+func (Authz) Name() string { return AuthzName }
+
+// Listeners adds the authz filter to the inbound listeners of an LDS response, and returns the listeners it changed,
+// as listener/<name>.  Nodes that are not sidecars, or that the request has it skip, are passed through.  The
+// request's OnListener, if set, is called with each listener's result, in listener order.  Listeners that cannot be
+// given the filter are left as they are; only a response that cannot be decoded is an error.  The work is done in
+// decode, classify, mutate and encode steps.
+func (a Authz) Listeners(req *Request, body []byte) ([]byte, []string, error) {
+	if !req.Node.IsSidecar() || req.SkipAuthz != "" {
+		return body, nil, nil
+	}
+	end := req.startStep("decode")
+	lds, err := DecodeXDS(body, "listeners")
+	end(err)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	end = req.startStep("classify")
+	results := make([]ListenerResult, len(lds.Items))
+	protos := make([]Protocol, len(lds.Items))
+	err = a.each(len(lds.Items), func(i int) (err error) {
+		results[i], protos[i], err = a.classifyRaw(req, lds.Items[i])
+		return err
+	})
+	end(err)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	end = req.startStep("mutate")
+	a.each(len(lds.Items), func(i int) error {
+		a.splice(req, &results[i], protos[i])
+		return nil
+	})
+	var changed []string
+	for i, res := range results {
+		if req.OnListener != nil {
+			req.OnListener(lds.Items[i], res)
+		}
+		if res.Modified {
+			lds.Items[i] = res.Raw
+			changed = append(changed, "listener/"+res.Name)
+		}
+	}
+	end(nil)
+
+	end = req.startStep("encode")
+	var buf bytes.Buffer
+	lds.EncodeTo(&buf)
+	end(nil)
+	return buf.Bytes(), changed, nil
+}
+
+// each calls f for 0 to n-1, in parallel for large responses, and returns the error of the lowest i that failed.
+func (a Authz) each(n int, f func(i int) error) error {
+	workers := a.Parallelism
+	if n < ParallelListenersMin {
+		workers = 1
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			if err := f(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	errs := make([]error, n)
+	next := int64(-1)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= n {
+					return
+				}
+				errs[i] = f(i)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Listener gives one raw listener of the request's node the authz filter, if it should have it.  It returns an error if
+// the listener cannot be decoded.
+func (a Authz) Listener(req *Request, raw json.RawMessage) (ListenerResult, error) {
+	res, proto, err := a.classifyRaw(req, raw)
+	if err != nil {
+		return res, err
+	}
+	a.splice(req, &res, proto)
+	return res, nil
+}
+
+// classifyRaw decides whether a raw listener should be given the authz filter, and with which protocol's filter.  The
+// listener is classified from its name and address alone, and its filter names are only decoded if it is inbound and
+// so may be mutated.  The result is skipped if the filter is not to be added.
+func (a Authz) classifyRaw(req *Request, raw json.RawMessage) (ListenerResult, Protocol, error) {
+	res := ListenerResult{Raw: raw}
+	header, err := DecodeListener(raw, false)
+	if err != nil {
+		return res, 0, err
+	}
+	res.Name = header.Name
+	if direction, _ := a.classify(req, header); direction != Inbound {
+		res.Skip = directionSkip(direction)
+		return res, 0, nil
+	}
+	l, err := DecodeListener(raw, true)
+	if err != nil {
+		return res, 0, err
+	}
+	direction, proto := a.classify(req, l)
+	res.Authz = HasAuthzFilter(l)
+	res.Skip = a.skip(req, l, direction, proto)
+	return res, proto, nil
+}
+
+// splice adds the filter to a classified listener that was not skipped.
+func (a Authz) splice(req *Request, res *ListenerResult, proto Protocol) {
+	if res.Skip != "" {
+		return
+	}
+	out, err := SpliceAuthzFilter(res.Raw, proto, SnippetsFor(req.Profile))
+	if err != nil {
+		res.Err = err
+		return
+	}
+	res.Raw, res.Modified, res.Authz = out, true, true
+}
+
+func (a Authz) classify(req *Request, listener *v1.Listener) (Direction, Protocol) {
+	if a.Classify != nil {
+		return a.Classify(req, listener)
+	}
+	proto, addr, virtual := ParseListenerName(listener.Name)
+	switch {
+	case virtual:
+		return Virtual, proto
+	case addr == req.Node.IP:
+		return Inbound, proto
+	}
+	return Outbound, proto
+}
+
+// skip returns why a classified listener should not be given the authz filter, if it should not.
+func (a Authz) skip(req *Request, listener *v1.Listener, direction Direction, proto Protocol) SkipReason {
+	if direction != Inbound {
+		return directionSkip(direction)
+	}
+	port, _ := ListenerPort(listener.Name, listener.Address)
+	switch {
+	case req.Profile.ExcludedPorts[port]:
+		// The port bypasses the sidecar.
+		return SkipExcludedPort
+	case HasAuthzFilter(listener):
+		return SkipAlreadyInjected
+	case a.Skip != nil:
+		return a.Skip(req, listener, proto, port)
+	}
+	return ""
+}
+
+func directionSkip(direction Direction) SkipReason {
+	if direction == Virtual {
+		return SkipVirtual
+	}
+	return SkipOutbound
+}
+
+// Clusters adds the authz cluster named by the request's profile, at its dikastes address, to a sidecar's CDS
+// response, in decode, mutate and encode steps.  Without a dikastes address the response is passed through.
+func (Authz) Clusters(req *Request, body []byte) ([]byte, []string, error) {
+	if req.DikastesAddress == "" || !req.Node.IsSidecar() {
+		return body, nil, nil
+	}
+	end := req.startStep("decode")
+	cds, err := DecodeXDS(body, "clusters")
+	end(err)
+	if err != nil {
+		return nil, nil, err
+	}
+	end = req.startStep("mutate")
+	var added bool
+	cds.Items, added, err = UpsertAuthzCluster(cds.Items, req.Profile.ClusterName, req.DikastesAddress)
+	end(err)
+	if err != nil {
+		return nil, nil, err
+	}
+	end = req.startStep("encode")
+	var buf bytes.Buffer
+	cds.EncodeTo(&buf)
+	end(nil)
+	if !added {
+		return buf.Bytes(), nil, nil
+	}
+	return buf.Bytes(), []string{"cluster/" + req.Profile.ClusterName}, nil
+}
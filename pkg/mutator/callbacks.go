@@ -0,0 +1,72 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mutator
+
+import "fmt"
+
+// Callbacks are called around the mutators, so that embedders can count, check or veto mutations.  Each is optional.
+// A callback vetoes the mutation by returning an error: Apply then returns a *VetoError, and the config should be sent
+// as Pilot generated it.
+type Callbacks struct {
+	// OnRequest is called with the config before any mutator runs.
+	OnRequest func(hook Hook, req *Request, body []byte) error
+	// OnResourceMutated is called after each mutator that changed resources, with its name and the resources, as
+	// <type>/<name>.
+	OnResourceMutated func(hook Hook, req *Request, mutator string, resources []string) error
+	// OnResponse is called with the mutated config and every resource changed, or with the error that stopped the
+	// mutators, in which case what it returns is ignored.
+	OnResponse func(hook Hook, req *Request, body []byte, changed []string, err error) error
+}
+
+// VetoError is a callback vetoing a mutation.
+type VetoError struct {
+	// Callback is OnRequest, OnResourceMutated or OnResponse.
+	Callback string
+	Err      error
+}
+
+func (e *VetoError) Error() string {
+	return fmt.Sprintf("vetoed by %s: %v", e.Callback, e.Err)
+}
+
+func (c Callbacks) onRequest(hook Hook, req *Request, body []byte) error {
+	if c.OnRequest == nil {
+		return nil
+	}
+	if err := c.OnRequest(hook, req, body); err != nil {
+		return &VetoError{Callback: "OnRequest", Err: err}
+	}
+	return nil
+}
+
+func (c Callbacks) onResourceMutated(hook Hook, req *Request, mutator string, resources []string) error {
+	if c.OnResourceMutated == nil || len(resources) == 0 {
+		return nil
+	}
+	if err := c.OnResourceMutated(hook, req, mutator, resources); err != nil {
+		return &VetoError{Callback: "OnResourceMutated", Err: err}
+	}
+	return nil
+}
+
+func (c Callbacks) onResponse(hook Hook, req *Request, body []byte, changed []string, err error) error {
+	if c.OnResponse == nil {
+		return err
+	}
+	if cbErr := c.OnResponse(hook, req, body, changed, err); cbErr != nil && err == nil {
+		return &VetoError{Callback: "OnResponse", Err: cbErr}
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mutator
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestCallbacks(t *testing.T) {
+	RegisterTestingT(t)
+
+	r := NewRegistry(appending{name: "a"}, appending{name: "b"})
+	var calls []string
+	cb := Callbacks{
+		OnRequest: func(hook Hook, req *Request, body []byte) error {
+			calls = append(calls, "request:"+string(body))
+			return nil
+		},
+		OnResourceMutated: func(hook Hook, req *Request, mutator string, resources []string) error {
+			calls = append(calls, "mutated:"+mutator+":"+resources[0])
+			return nil
+		},
+		OnResponse: func(hook Hook, req *Request, body []byte, changed []string, err error) error {
+			calls = append(calls, "response:"+string(body))
+			return nil
+		},
+	}
+	out, changed, err := r.ApplyWithCallbacks(cb, HookRoutes, &Request{}, []byte("x"))
+	Expect(err).To(BeNil())
+	Expect(string(out)).To(Equal("xab"))
+	Expect(changed).To(Equal([]string{"x/a", "x/b"}))
+	Expect(calls).To(Equal([]string{"request:x", "mutated:a:x/a", "mutated:b:x/b", "response:xab"}))
+}
+
+func TestCallbacksVeto(t *testing.T) {
+	RegisterTestingT(t)
+
+	r := NewRegistry(appending{name: "a"}, appending{name: "b"})
+	no := errors.New("no")
+	for name, cb := range map[string]Callbacks{
+		"OnRequest": {OnRequest: func(Hook, *Request, []byte) error { return no }},
+		"OnResourceMutated": {OnResourceMutated: func(hook Hook, req *Request, mutator string, resources []string) error {
+			if mutator == "b" {
+				return no
+			}
+			return nil
+		}},
+		"OnResponse": {OnResponse: func(Hook, *Request, []byte, []string, error) error { return no }},
+	} {
+		_, _, err := r.ApplyWithCallbacks(cb, HookRoutes, &Request{}, []byte("x"))
+		veto, ok := err.(*VetoError)
+		Expect(ok).To(BeTrue(), name)
+		Expect(veto.Callback).To(Equal(name))
+		Expect(veto.Err).To(Equal(no))
+	}
+
+	// OnResponse sees a mutator's error, and cannot turn it into a veto.
+	var seen error
+	r = NewRegistry(appending{name: "a", err: no})
+	cb := Callbacks{OnResponse: func(hook Hook, req *Request, body []byte, changed []string, err error) error {
+		seen = err
+		return errors.New("ignored")
+	}}
+	_, _, err := r.ApplyWithCallbacks(cb, HookRoutes, &Request{}, []byte("x"))
+	Expect(seen).To(Equal(err))
+	Expect(err.Error()).To(Equal("mutator a: no"))
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mutator
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+)
+
+// UpsertAuthzCluster adds the cluster the authz filter sends its checks to, replacing any existing one of the same
+// name, to raw clusters.  It returns the clusters and whether they were changed, and an error if they cannot be
+// decoded.
+func UpsertAuthzCluster(clusters []json.RawMessage, name, addr string) ([]json.RawMessage, bool, error) {
+	for i, raw := range clusters {
+		out, matched, err := ReplaceAuthzCluster(raw, name, addr)
+		if err != nil {
+			return clusters, false, err
+		}
+		if matched {
+			clusters[i] = out
+			return clusters, !bytes.Equal(out, raw), nil
+		}
+	}
+	return append(clusters, ClusterSnippet(name, addr)), true, nil
+}
+
+// ReplaceAuthzCluster returns a raw cluster, or the authz cluster in its place if it has the authz cluster's name but
+// differs from it.  matched is set if it has the authz cluster's name.
+func ReplaceAuthzCluster(raw json.RawMessage, name, addr string) (out json.RawMessage, matched bool, err error) {
+	var h struct {
+		Name string `json:"name"`
+	}
+	if err := codec.Unmarshal(raw, &h); err != nil {
+		return raw, false, err
+	}
+	if h.Name != name {
+		return raw, false, nil
+	}
+	var existing v1.Cluster
+	if err := codec.Unmarshal(raw, &existing); err != nil {
+		return raw, false, err
+	}
+	if reflect.DeepEqual(&existing, Cluster(name, addr)) {
+		return raw, true, nil
+	}
+	return ClusterSnippet(name, addr), true, nil
+}
+
+// Clusters adds the authz cluster name, at the dikastes address addr, to a CDS response.  It returns the response and
+// whether it was changed, or an error if it cannot be decoded.
+func Clusters(body []byte, name, addr string) ([]byte, bool, error) {
+	cds, err := DecodeXDS(body, "clusters")
+	if err != nil {
+		return nil, false, err
+	}
+	var added bool
+	if cds.Items, added, err = UpsertAuthzCluster(cds.Items, name, addr); err != nil {
+		return nil, false, err
+	}
+	var buf bytes.Buffer
+	cds.EncodeTo(&buf)
+	return buf.Bytes(), added, nil
+}
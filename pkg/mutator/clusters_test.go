@@ -0,0 +1,67 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mutator
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+)
+
+const testAddr = "tcp://10.96.0.20:9000"
+
+func decodeClusters(body []byte) v1.Clusters {
+	var cds struct {
+		Clusters v1.Clusters `json:"clusters"`
+	}
+	Expect(json.Unmarshal(body, &cds)).To(BeNil())
+	return cds.Clusters
+}
+
+func TestClusters(t *testing.T) {
+	RegisterTestingT(t)
+
+	out, added, err := Clusters([]byte(`{}`), config.AuthzClusterName, testAddr)
+	Expect(err).To(BeNil())
+	Expect(added).To(BeTrue())
+	clusters := decodeClusters(out)
+	Expect(clusters).To(HaveLen(1))
+	Expect(clusters[0]).To(Equal(Cluster(config.AuthzClusterName, testAddr)))
+
+	// Adding it again changes nothing.
+	again, added, err := Clusters(out, config.AuthzClusterName, testAddr)
+	Expect(err).To(BeNil())
+	Expect(added).To(BeFalse())
+	Expect(string(again)).To(Equal(string(out)))
+
+	// A stale cluster of the same name is replaced.
+	moved, added, err := Clusters(out, config.AuthzClusterName, "tcp://10.96.0.21:9000")
+	Expect(err).To(BeNil())
+	Expect(added).To(BeTrue())
+	clusters = decodeClusters(moved)
+	Expect(clusters).To(HaveLen(1))
+	Expect(clusters[0].Hosts[0].URL).To(Equal("tcp://10.96.0.21:9000"))
+}
+
+func TestClustersBadJSON(t *testing.T) {
+	RegisterTestingT(t)
+
+	_, _, err := Clusters([]byte(`{"clusters":[{"name":1}]}`), config.AuthzClusterName, testAddr)
+	Expect(err).NotTo(BeNil())
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mutator
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+	"github.com/projectcalico/pilot-webhook/pkg/extauthz"
+	extauthzv1 "github.com/projectcalico/pilot-webhook/pkg/extauthz/v1"
+)
+
+// The filter and cluster are built by pkg/extauthz/v1; these are the names the mutator has always had for them.
+type (
+	AuthzFilterConfig = extauthzv1.FilterConfig
+	GrpcClusterConfig = extauthzv1.GrpcClusterConfig
+	GrpcServiceConfig = extauthzv1.GrpcServiceConfig
+)
+
+// FilterConfig returns the authz filter's config pointing at the profile's authz cluster.
+func FilterConfig(profile config.Profile, statPrefix string) *AuthzFilterConfig {
+	return extauthzv1.Config(extauthz.OptionsFor(profile), statPrefix)
+}
+
+// NetworkFilter is the authz filter for TCP listeners.
+func NetworkFilter(profile config.Profile) *v1.NetworkFilter {
+	return extauthzv1.NetworkFilter(extauthz.OptionsFor(profile))
+}
+
+// HTTPFilter is the authz filter for HTTP connection managers.
+func HTTPFilter(profile config.Profile) v1.HTTPFilter {
+	return extauthzv1.HTTPFilter(extauthz.OptionsFor(profile))
+}
+
+// Cluster is the cluster the authz filter sends its checks to, at the dikastes address addr.
+func Cluster(name, addr string) *v1.Cluster {
+	return extauthzv1.Cluster(extauthz.Options{ClusterName: name}, addr)
+}
+
+// maxSnippets bounds the snippet caches.  Profiles carrying per workload metadata make one entry per workload, so
+// once full the cache is simply cleared rather than tracking recency.
+const maxSnippets = 4096
+
+// Snippets is the authz filter for a profile as JSON, ready to splice into listeners.
+type Snippets struct {
+	// Network is the filter for TCP listeners.
+	Network json.RawMessage
+	// HTTP is the filter for HTTP connection managers.
+	HTTP json.RawMessage
+}
+
+// snippetCache memoizes JSON snippets, so that each is marshaled once per config rather than once per listener.
+type snippetCache struct {
+	mu      sync.Mutex
+	entries map[string]interface{}
+}
+
+func newSnippetCache() *snippetCache {
+	return &snippetCache{entries: make(map[string]interface{})}
+}
+
+func (c *snippetCache) get(key string, build func() interface{}) interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v, ok := c.entries[key]; ok {
+		return v
+	}
+	if len(c.entries) >= maxSnippets {
+		c.entries = make(map[string]interface{})
+	}
+	v := build()
+	c.entries[key] = v
+	return v
+}
+
+var filterSnippets, clusterSnippets = newSnippetCache(), newSnippetCache()
+
+// SnippetsFor returns the authz filter snippets for a profile.
+func SnippetsFor(profile config.Profile) Snippets {
+	key := fmt.Sprintf("%s|%s|%t|%t|%v",
+		profile.FilterName, profile.ClusterName, profile.GrpcService, profile.FailOpen, profile.InitialMetadata)
+	return filterSnippets.get(key, func() interface{} {
+		// The filters are plain structs of strings and bools, so cannot fail to marshal.
+		network, _ := codec.Marshal(NetworkFilter(profile))
+		http, _ := codec.Marshal(HTTPFilter(profile))
+		return Snippets{Network: network, HTTP: http}
+	}).(Snippets)
+}
+
+// ClusterSnippet returns the authz cluster as JSON.
+func ClusterSnippet(name, addr string) json.RawMessage {
+	return clusterSnippets.get(name+"|"+addr, func() interface{} {
+		b, _ := codec.Marshal(Cluster(name, addr))
+		return json.RawMessage(b)
+	}).(json.RawMessage)
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mutator
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+)
+
+func TestSnippetsMatchStructs(t *testing.T) {
+	RegisterTestingT(t)
+
+	profile := config.ProfileFor(0, 0)
+	snippets := SnippetsFor(profile)
+
+	var network v1.NetworkFilter
+	Expect(json.Unmarshal(snippets.Network, &network)).To(BeNil())
+	Expect(network.Name).To(Equal(profile.FilterName))
+	Expect(network.Type).To(Equal("read"))
+	want, _ := json.Marshal(HTTPFilter(profile))
+	Expect(string(snippets.HTTP)).To(Equal(string(want)))
+
+	profile.FailOpen = true
+	Expect(string(SnippetsFor(profile).HTTP)).NotTo(Equal(string(snippets.HTTP)))
+}
+
+func TestFilterConfig(t *testing.T) {
+	RegisterTestingT(t)
+
+	profile := config.ProfileFor(0, 8)
+	profile.InitialMetadata = []config.HeaderValue{{Key: "k", Value: "v"}}
+	cfg := FilterConfig(profile, "p")
+	Expect(cfg.GrpcCluster).To(BeNil())
+	Expect(cfg.GrpcService.EnvoyGrpc.ClusterName).To(Equal(config.AuthzClusterName))
+	Expect(cfg.GrpcService.InitialMetadata).To(HaveLen(1))
+
+	cfg = FilterConfig(config.ProfileFor(0, 7), "p")
+	Expect(cfg.GrpcService).To(BeNil())
+	Expect(cfg.GrpcCluster.ClusterName).To(Equal(config.AuthzClusterName))
+}
+
+func TestSnippetCache(t *testing.T) {
+	RegisterTestingT(t)
+
+	c := newSnippetCache()
+	builds := 0
+	build := func() interface{} { builds++; return builds }
+	Expect(c.get("a", build)).To(Equal(1))
+	Expect(c.get("a", build)).To(Equal(1))
+	Expect(builds).To(Equal(1))
+
+	for i := 0; i <= maxSnippets; i++ {
+		c.get(string(rune(i)), func() interface{} { return i })
+	}
+	Expect(len(c.entries)).To(Equal(1))
+}
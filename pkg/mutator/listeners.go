@@ -0,0 +1,202 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mutator
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+)
+
+// ListenerNameSeparator separates the parts of the names Pilot gives listeners: <protocol>_<ip>_<port>.
+const ListenerNameSeparator = "_"
+
+// ParseListenerName returns the protocol and IP in a listener name as Pilot gives them, and whether it is the virtual
+// listener that redirects traffic to the others.  Names without an IP, which Pilot does not send, have an empty addr.
+func ParseListenerName(name string) (proto Protocol, addr string, virtual bool) {
+	if name == "virtual" {
+		return proto, "", true
+	}
+	c := strings.Split(name, ListenerNameSeparator)
+	if c[0] == "tcp" {
+		proto = TCP
+	}
+	if len(c) > 1 {
+		addr = c[1]
+	}
+	return proto, addr, false
+}
+
+// ListenerPort returns the port a listener is on, from its address or else its name.
+func ListenerPort(name, address string) (int, bool) {
+	if _, port, err := net.SplitHostPort(strings.TrimPrefix(address, "tcp://")); err == nil {
+		if p, err := strconv.Atoi(port); err == nil {
+			return p, true
+		}
+	}
+	c := strings.Split(name, ListenerNameSeparator)
+	p, err := strconv.Atoi(c[len(c)-1])
+	return p, err == nil
+}
+
+// listenerShape is the part of a listener needed to decide whether and how to mutate it.  Filter configs are only
+// decoded for the HTTP connection manager, and then only for the names of its HTTP filters.
+type listenerShape struct {
+	Name           string `json:"name"`
+	Address        string `json:"address"`
+	UseOriginalDst bool   `json:"use_original_dst"`
+	Filters        []struct {
+		Name   string          `json:"name"`
+		Config json.RawMessage `json:"config"`
+	} `json:"filters"`
+}
+
+type listenerHeader struct {
+	Name           string `json:"name"`
+	Address        string `json:"address"`
+	UseOriginalDst bool   `json:"use_original_dst"`
+}
+
+type filterNames struct {
+	Filters []struct {
+		Name string `json:"name"`
+	} `json:"filters"`
+}
+
+// DecodeListener decodes a raw listener as far as is needed to classify it: its name, address and whether it uses the
+// original destination, and if filters is set, the names of its filters and of its HTTP connection manager's filters.
+func DecodeListener(raw json.RawMessage, filters bool) (*v1.Listener, error) {
+	if !filters {
+		var h listenerHeader
+		if err := codec.Unmarshal(raw, &h); err != nil {
+			return nil, err
+		}
+		return &v1.Listener{Name: h.Name, Address: h.Address, UseOriginalDst: h.UseOriginalDst}, nil
+	}
+	var s listenerShape
+	if err := codec.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	l := &v1.Listener{Name: s.Name, Address: s.Address, UseOriginalDst: s.UseOriginalDst}
+	for _, f := range s.Filters {
+		filter := &v1.NetworkFilter{Name: f.Name}
+		if f.Name == v1.HTTPConnectionManager {
+			var names filterNames
+			if err := UnmarshalOptional(f.Config, &names); err != nil {
+				return nil, err
+			}
+			cfg := &v1.HTTPFilterConfig{}
+			for _, n := range names.Filters {
+				cfg.Filters = append(cfg.Filters, v1.HTTPFilter{Name: n.Name})
+			}
+			filter.Config = cfg
+		}
+		l.Filters = append(l.Filters, filter)
+	}
+	return l, nil
+}
+
+// HasAuthzFilter reports whether the listener already has the authz filter, either as a network filter or in its HTTP
+// connection manager.
+func HasAuthzFilter(listener *v1.Listener) bool {
+	for _, filter := range listener.Filters {
+		if filter.Name == config.AuthzFilterName {
+			return true
+		}
+		if cfg, ok := filter.Config.(*v1.HTTPFilterConfig); ok && filter.Name == v1.HTTPConnectionManager {
+			for _, f := range cfg.Filters {
+				if f.Name == config.AuthzFilterName {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// SpliceAuthzFilter inserts the authz filter into a listener's raw JSON: first in its filters for TCP, or first in its
+// HTTP connection manager's filters for HTTP.  It must be first so that a failed authorization closes the connection.
+func SpliceAuthzFilter(raw json.RawMessage, proto Protocol, snippets Snippets) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := codec.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	var filters []json.RawMessage
+	if err := UnmarshalOptional(fields["filters"], &filters); err != nil {
+		return nil, err
+	}
+	switch proto {
+	case TCP:
+		filters = append([]json.RawMessage{snippets.Network}, filters...)
+	case HTTP:
+		i, err := findHTTPConnectionManager(filters)
+		if err != nil {
+			return nil, err
+		}
+		if filters[i], err = prependHTTPFilter(filters[i], snippets.HTTP); err != nil {
+			return nil, err
+		}
+	}
+	fields["filters"] = RawArray(filters)
+	return RawObject(fields), nil
+}
+
+func findHTTPConnectionManager(filters []json.RawMessage) (int, error) {
+	for i, raw := range filters {
+		var f struct {
+			Name string `json:"name"`
+		}
+		if err := codec.Unmarshal(raw, &f); err != nil {
+			return 0, err
+		}
+		if f.Name == v1.HTTPConnectionManager {
+			return i, nil
+		}
+	}
+	return 0, ErrNoHTTPConnectionManager
+}
+
+// prependHTTPFilter inserts an HTTP filter at the front of a raw HTTP connection manager's filters.
+func prependHTTPFilter(raw, filter json.RawMessage) (json.RawMessage, error) {
+	var hcm, cfg map[string]json.RawMessage
+	var filters []json.RawMessage
+	if err := codec.Unmarshal(raw, &hcm); err != nil {
+		return nil, err
+	}
+	if err := UnmarshalOptional(hcm["config"], &cfg); err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, ErrNoHTTPConnectionManager
+	}
+	if err := UnmarshalOptional(cfg["filters"], &filters); err != nil {
+		return nil, err
+	}
+	cfg["filters"] = RawArray(append([]json.RawMessage{filter}, filters...))
+	hcm["config"] = RawObject(cfg)
+	return RawObject(hcm), nil
+}
+
+// Listeners adds the authz filter, shaped by profile, to the inbound listeners of an LDS response for node, as the
+// Authz mutator does unconfigured.  It returns the response and the listeners it changed, as listener/<name>, or an
+// error if the response cannot be decoded.
+func Listeners(body []byte, node config.Node, profile config.Profile) ([]byte, []string, error) {
+	return Authz{}.Listeners(&Request{Node: node, Profile: profile}, body)
+}
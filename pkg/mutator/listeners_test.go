@@ -0,0 +1,138 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mutator
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+	"github.com/projectcalico/pilot-webhook/pkg/testutil"
+)
+
+const testNode = "sidecar~10.0.0.1~web.default~default.svc.cluster.local"
+
+const testLDS = `{"listeners":[` +
+	`{"name":"virtual","address":"tcp://0.0.0.0:15001"},` +
+	`{"name":"http_0.0.0.0_80","address":"tcp://0.0.0.0:80"},` +
+	`{"name":"http_10.0.0.1_8080","address":"tcp://10.0.0.1:8080","custom":1,"filters":[` +
+	`{"type":"read","name":"http_connection_manager","config":{"filters":[{"type":"decoder","name":"router"}]}}]},` +
+	`{"name":"tcp_10.0.0.1_3306","address":"tcp://10.0.0.1:3306","filters":[` +
+	`{"type":"read","name":"tcp_proxy","config":{}}]},` +
+	`{"name":"tcp_10.0.0.1_5432","address":"tcp://10.0.0.1:5432","filters":[` +
+	`{"type":"read","name":"envoy.ext_authz","config":{}},{"type":"read","name":"tcp_proxy","config":{}}]}` +
+	`]}`
+
+func TestListeners(t *testing.T) {
+	RegisterTestingT(t)
+
+	out, changed, err := Listeners([]byte(testLDS), config.ParseNode(testNode), config.ProfileFor(0, 0))
+	Expect(err).To(BeNil())
+	Expect(changed).To(Equal([]string{"listener/http_10.0.0.1_8080", "listener/tcp_10.0.0.1_3306"}))
+
+	listeners, err := testutil.DecodeListeners(out)
+	Expect(err).To(BeNil())
+	Expect(listeners).To(HaveLen(5))
+	Expect(listeners[0].AuthzConfigs()).To(BeEmpty())
+	Expect(listeners[1].AuthzConfigs()).To(BeEmpty())
+	Expect(listeners[2].Filters[0].HTTPFilters()[0].Name).To(Equal(config.AuthzFilterName))
+	Expect(listeners[3].Filters[0].Name).To(Equal(config.AuthzFilterName))
+	Expect(listeners[4].AuthzConfigs()).To(HaveLen(1))
+
+	// Fields the v1 structs do not model are kept.
+	var raw struct {
+		Listeners []map[string]json.RawMessage `json:"listeners"`
+	}
+	Expect(json.Unmarshal(out, &raw)).To(BeNil())
+	Expect(string(raw.Listeners[2]["custom"])).To(Equal("1"))
+}
+
+func TestListenersExcludedPorts(t *testing.T) {
+	RegisterTestingT(t)
+
+	profile := config.ProfileFor(0, 0)
+	profile.ExcludedPorts = map[int]bool{3306: true}
+	_, changed, err := Listeners([]byte(testLDS), config.ParseNode(testNode), profile)
+	Expect(err).To(BeNil())
+	Expect(changed).To(Equal([]string{"listener/http_10.0.0.1_8080"}))
+}
+
+func TestListenersNotSidecar(t *testing.T) {
+	RegisterTestingT(t)
+
+	out, changed, err := Listeners([]byte(testLDS), config.ParseNode("router~10.0.0.1~r.default~d"),
+		config.ProfileFor(0, 0))
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeEmpty())
+	Expect(string(out)).To(Equal(testLDS))
+}
+
+func TestListenersNoHTTPConnectionManager(t *testing.T) {
+	RegisterTestingT(t)
+
+	lds := `{"listeners":[{"name":"http_10.0.0.1_80","address":"tcp://10.0.0.1:80","filters":[]}]}`
+	_, changed, err := Listeners([]byte(lds), config.ParseNode(testNode), config.ProfileFor(0, 0))
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeEmpty())
+
+	_, err = SpliceAuthzFilter(json.RawMessage(`{"filters":[]}`), HTTP, SnippetsFor(config.ProfileFor(0, 0)))
+	Expect(err).To(Equal(ErrNoHTTPConnectionManager))
+}
+
+func TestListenersBadJSON(t *testing.T) {
+	RegisterTestingT(t)
+
+	_, _, err := Listeners([]byte(`{"listeners":[{"name":1}]}`), config.ParseNode(testNode), config.ProfileFor(0, 0))
+	Expect(err).NotTo(BeNil())
+}
+
+func TestParseListenerName(t *testing.T) {
+	RegisterTestingT(t)
+
+	proto, addr, virtual := ParseListenerName("tcp_10.0.0.1_3306")
+	Expect(proto).To(Equal(TCP))
+	Expect(addr).To(Equal("10.0.0.1"))
+	Expect(virtual).To(BeFalse())
+	proto, addr, _ = ParseListenerName("http")
+	Expect(proto).To(Equal(HTTP))
+	Expect(addr).To(Equal(""))
+	_, _, virtual = ParseListenerName("virtual")
+	Expect(virtual).To(BeTrue())
+
+	port, ok := ListenerPort("http_10.0.0.1_80", "tcp://10.0.0.1:8080")
+	Expect(ok).To(BeTrue())
+	Expect(port).To(Equal(8080))
+	port, ok = ListenerPort("http_10.0.0.1_80", "")
+	Expect(ok).To(BeTrue())
+	Expect(port).To(Equal(80))
+	_, ok = ListenerPort("virtual", "")
+	Expect(ok).To(BeFalse())
+}
+
+func TestDecodeListener(t *testing.T) {
+	RegisterTestingT(t)
+
+	raw := json.RawMessage(`{"name":"virtual","address":"tcp://0.0.0.0:15001","use_original_dst":true,` +
+		`"filters":[{"name":"tcp_proxy","config":{}}]}`)
+	for _, filters := range []bool{false, true} {
+		l, err := DecodeListener(raw, filters)
+		Expect(err).To(BeNil())
+		Expect(l.Address).To(Equal("tcp://0.0.0.0:15001"))
+		Expect(l.UseOriginalDst).To(BeTrue())
+		Expect(l.Filters).To(HaveLen(map[bool]int{false: 0, true: 1}[filters]))
+	}
+}
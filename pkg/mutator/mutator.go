@@ -0,0 +1,67 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mutator adds Calico's authz filter and cluster to the xDS config Pilot sends Envoy sidecars.  Config is
+// handled as raw JSON: only the parts of resources needed to decide whether to mutate them are decoded, and what is
+// added is spliced in, so everything else is passed through byte for byte.  The authz mutations are the built-in
+// Mutator; others can be registered to run after them.
+package mutator
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// ErrNoHTTPConnectionManager is returned for an HTTP listener that cannot be given the authz filter because it has no
+// HTTP connection manager.
+var ErrNoHTTPConnectionManager = errors.New("HTTP listener has no HTTP connection manager")
+
+// Direction is which way traffic through a listener goes, relative to the sidecar's workload.
+type Direction int
+
+const (
+	Inbound Direction = iota
+	Outbound
+	Virtual
+)
+
+// Protocol is the protocol a listener proxies.
+type Protocol int
+
+const (
+	HTTP Protocol = iota
+	TCP
+)
+
+// Codec encodes and decodes the JSON the mutations read and write.  Codecs must produce the same bytes as
+// encoding/json, so that which one is used never shows in the config sent to Envoy.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// StdCodec is the encoding/json codec, which is used unless SetCodec is called.
+type StdCodec struct{}
+
+func (StdCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (StdCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+var codec Codec = StdCodec{}
+
+// SetCodec sets the codec used by the mutations.  It must not be called while config is being mutated.
+func SetCodec(c Codec) {
+	codec = c
+	// Drop the snippets the old codec marshaled, so that only the new one is used.
+	filterSnippets, clusterSnippets = newSnippetCache(), newSnippetCache()
+}
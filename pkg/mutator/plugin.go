@@ -0,0 +1,77 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mutator
+
+import (
+	"errors"
+	"fmt"
+	"plugin"
+)
+
+// PluginSymbol is the function a mutator plugin exports to make its mutator, of type NewMutatorFunc.
+const PluginSymbol = "NewMutator"
+
+// NewMutatorFunc makes a plugin's mutator.
+type NewMutatorFunc = func() (Mutator, error)
+
+// ErrPluginsUnsupported is returned for plugins given to a build that cannot load them.  See PluginsSupported.
+var ErrPluginsUnsupported = errors.New("plugins need a cgo enabled build on Linux or macOS")
+
+// LoadPlugin opens a Go plugin, built with -buildmode=plugin against the same version of this package, and returns
+// the mutator its NewMutator makes.
+func LoadPlugin(path string) (Mutator, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup(PluginSymbol)
+	if err != nil {
+		return nil, err
+	}
+	return pluginMutator(path, sym)
+}
+
+func pluginMutator(path string, sym plugin.Symbol) (Mutator, error) {
+	newMutator, ok := sym.(NewMutatorFunc)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s is a %T, not a func() (mutator.Mutator, error)", path, PluginSymbol, sym)
+	}
+	m, err := newMutator()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if m == nil || m.Name() == "" {
+		return nil, fmt.Errorf("%s: %s made a mutator with no name", path, PluginSymbol)
+	}
+	return m, nil
+}
+
+// LoadPlugins loads the mutator of each plugin in turn and registers it with r.  It fails with ErrPluginsUnsupported if
+// there are plugins and this build cannot load them.
+func LoadPlugins(r *Registry, paths ...string) error {
+	if len(paths) > 0 && !PluginsSupported {
+		return ErrPluginsUnsupported
+	}
+	for _, path := range paths {
+		m, err := LoadPlugin(path)
+		if err != nil {
+			return err
+		}
+		if err := r.Register(m); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mutator
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestPluginMutator(t *testing.T) {
+	RegisterTestingT(t)
+
+	m, err := pluginMutator("p.so", func() (Mutator, error) { return appending{name: "p"}, nil })
+	Expect(err).To(BeNil())
+	Expect(m.Name()).To(Equal("p"))
+
+	_, err = pluginMutator("p.so", func() Mutator { return appending{name: "p"} })
+	Expect(err.Error()).To(ContainSubstring("not a func() (mutator.Mutator, error)"))
+	_, err = pluginMutator("p.so", func() (Mutator, error) { return nil, errors.New("no config") })
+	Expect(err.Error()).To(Equal("p.so: no config"))
+	_, err = pluginMutator("p.so", func() (Mutator, error) { return nil, nil })
+	Expect(err.Error()).To(ContainSubstring("no name"))
+}
+
+func TestLoadPluginsMissing(t *testing.T) {
+	RegisterTestingT(t)
+
+	r := NewRegistry()
+	Expect(LoadPlugins(r, "/nonexistent/mutator.so")).NotTo(Succeed())
+	Expect(r.Mutators()).To(BeEmpty())
+}
+
+func TestLoadPluginsUnsupported(t *testing.T) {
+	RegisterTestingT(t)
+
+	if PluginsSupported {
+		t.Skip("this build can load plugins")
+	}
+	Expect(LoadPlugins(NewRegistry(), "/nonexistent/mutator.so")).To(Equal(ErrPluginsUnsupported))
+	Expect(LoadPlugins(NewRegistry())).To(Succeed())
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mutator
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// XDS is an xDS response held as raw JSON, with the resources under its key split out into Items, which may be
+// mutated in place before the response is encoded again.
+type XDS struct {
+	key    string
+	fields map[string]json.RawMessage
+	Items  []json.RawMessage
+}
+
+// DecodeXDS decodes an xDS response whose resources are under key, e.g. "listeners".
+func DecodeXDS(body []byte, key string) (*XDS, error) {
+	p := &XDS{key: key}
+	if err := codec.Unmarshal(body, &p.fields); err != nil {
+		return nil, err
+	}
+	if p.fields == nil {
+		p.fields = make(map[string]json.RawMessage)
+	}
+	if l, ok := p.fields[key]; ok {
+		if err := codec.Unmarshal(l, &p.Items); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// EncodeTo writes the response to buf, without first joining the resources into an array of their own.  The key is
+// written if it was in the request, or if resources were added.
+func (p *XDS) EncodeTo(buf *bytes.Buffer) {
+	if _, ok := p.fields[p.key]; !ok && len(p.Items) > 0 {
+		p.fields[p.key] = nil
+	}
+	buf.WriteByte('{')
+	for i, k := range sortedRawKeys(p.fields) {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeRawKey(buf, k)
+		if k == p.key {
+			WriteRawArray(buf, p.Items)
+		} else {
+			buf.Write(p.fields[k])
+		}
+	}
+	buf.WriteByte('}')
+}
+
+// UnmarshalOptional decodes raw into v, unless raw is missing.
+func UnmarshalOptional(raw json.RawMessage, v interface{}) error {
+	if raw == nil {
+		return nil
+	}
+	return codec.Unmarshal(raw, v)
+}
+
+// RawArray joins already encoded values into a JSON array.
+func RawArray(items []json.RawMessage) json.RawMessage {
+	size := 2
+	for _, item := range items {
+		size += len(item) + 1
+	}
+	var buf bytes.Buffer
+	buf.Grow(size)
+	WriteRawArray(&buf, items)
+	return buf.Bytes()
+}
+
+// WriteRawArray writes already encoded values to buf as a JSON array.
+func WriteRawArray(buf *bytes.Buffer, items []json.RawMessage) {
+	buf.WriteByte('[')
+	for i, item := range items {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(item)
+	}
+	buf.WriteByte(']')
+}
+
+// RawObject joins already encoded values into a JSON object with sorted keys, as encoding/json writes maps.
+func RawObject(fields map[string]json.RawMessage) json.RawMessage {
+	size := 2
+	for k, v := range fields {
+		size += len(k) + len(v) + 4
+	}
+	var buf bytes.Buffer
+	buf.Grow(size)
+	WriteRawObject(&buf, fields)
+	return buf.Bytes()
+}
+
+// WriteRawObject writes already encoded values to buf as a JSON object with sorted keys.
+func WriteRawObject(buf *bytes.Buffer, fields map[string]json.RawMessage) {
+	buf.WriteByte('{')
+	for i, k := range sortedRawKeys(fields) {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeRawKey(buf, k)
+		buf.Write(fields[k])
+	}
+	buf.WriteByte('}')
+}
+
+func sortedRawKeys(fields map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeRawKey(buf *bytes.Buffer, k string) {
+	key, _ := codec.Marshal(k)
+	buf.Write(key)
+	buf.WriteByte(':')
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mutator
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestXDSRoundTrip(t *testing.T) {
+	RegisterTestingT(t)
+
+	var buf bytes.Buffer
+	lds, err := DecodeXDS([]byte(`{"b":2, "listeners":[ {"name":"x"} ],"a":1}`), "listeners")
+	Expect(err).To(BeNil())
+	lds.EncodeTo(&buf)
+	Expect(buf.String()).To(Equal(`{"a":1,"b":2,"listeners":[{"name":"x"}]}`))
+
+	buf.Reset()
+	lds, err = DecodeXDS([]byte(`{}`), "listeners")
+	Expect(err).To(BeNil())
+	lds.EncodeTo(&buf)
+	Expect(buf.String()).To(Equal(`{}`))
+
+	_, err = DecodeXDS([]byte(`{"listeners":{}}`), "listeners")
+	Expect(err).NotTo(BeNil())
+}
+
+func TestRawObject(t *testing.T) {
+	RegisterTestingT(t)
+
+	obj := RawObject(map[string]json.RawMessage{"b": []byte(`[1]`), "a": []byte(`{}`)})
+	Expect(string(obj)).To(Equal(`{"a":{},"b":[1]}`))
+	Expect(string(RawArray(nil))).To(Equal(`[]`))
+}
@@ -0,0 +1,239 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mutator
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+)
+
+// Hook is one of the config endpoints Pilot calls the webhook for.
+type Hook string
+
+const (
+	HookListeners Hook = "listeners"
+	HookClusters  Hook = "clusters"
+	HookRoutes    Hook = "routes"
+	HookEndpoints Hook = "endpoints"
+)
+
+// Request is what a mutator is told about the hook request it is mutating config for.
+type Request struct {
+	// ServiceCluster and Node are the proxy the config is for.  They are empty for the endpoints hook, which Pilot
+	// calls per service rather than per proxy.
+	ServiceCluster string
+	Node           config.Node
+	// Service is the service the endpoints hook is called for.
+	Service string
+	// Profile is how the authz filter is configured for the node.
+	Profile config.Profile
+	// DikastesAddress is the address the authz cluster is added with, if it is added.
+	DikastesAddress string
+	// SkipAuthz, if set, is why the node's listeners are not to be given the authz filter, e.g. because its workload
+	// opted out.  The Authz mutator then passes them through.
+	SkipAuthz SkipReason
+	// OnListener, if set, is called by the Authz mutator with each listener it was given and what it did with it, in
+	// listener order, e.g. to count or audit them.
+	OnListener func(before json.RawMessage, res ListenerResult)
+	// StartStep, if set, is called as each step of a mutator's work starts, e.g. decode, classify, mutate or encode,
+	// and the func it returns when the step ends, with the step's error, so callers can trace and time them.
+	StartStep func(name string) (end func(err error))
+}
+
+// startStep starts a step of a mutator's work, if the caller traces them.
+func (r *Request) startStep(name string) func(err error) {
+	if r.StartStep == nil {
+		return func(error) {}
+	}
+	return r.StartStep(name)
+}
+
+// Mutator changes the config Pilot sends proxies.  Each method is passed the response for its hook, as raw JSON, and
+// returns it changed, or as it is, and the resources it changed, as <type>/<name>.  Mutators are run in turn, each on
+// the output of the last, so must pass through what they do not change.  Embed Passthrough for the hooks a mutator
+// does not change.
+type Mutator interface {
+	// Name identifies the mutator in the registry and in logs.
+	Name() string
+	Listeners(req *Request, body []byte) ([]byte, []string, error)
+	Clusters(req *Request, body []byte) ([]byte, []string, error)
+	Routes(req *Request, body []byte) ([]byte, []string, error)
+	Endpoints(req *Request, body []byte) ([]byte, []string, error)
+}
+
+// Passthrough implements every hook of Mutator by changing nothing.
+type Passthrough struct{}
+
+func (Passthrough) Listeners(req *Request, body []byte) ([]byte, []string, error) {
+	return body, nil, nil
+}
+func (Passthrough) Clusters(req *Request, body []byte) ([]byte, []string, error) {
+	return body, nil, nil
+}
+func (Passthrough) Routes(req *Request, body []byte) ([]byte, []string, error) { return body, nil, nil }
+func (Passthrough) Endpoints(req *Request, body []byte) ([]byte, []string, error) {
+	return body, nil, nil
+}
+
+// Registry is an ordered set of mutators, each registered under a unique name.
+type Registry struct {
+	mu       sync.RWMutex
+	mutators []Mutator
+}
+
+// NewRegistry returns a registry of the mutators, in order.  It panics if two have the same name.
+func NewRegistry(mutators ...Mutator) *Registry {
+	r := &Registry{}
+	for _, m := range mutators {
+		if err := r.Register(m); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+// Default is the registry the webhook runs.  It starts with the Authz mutator, and others run after it in the order
+// they are registered.
+var Default = NewRegistry(Authz{})
+
+// Register adds a mutator to the Default registry.
+func Register(m Mutator) error {
+	return Default.Register(m)
+}
+
+// Register adds a mutator, to run after those already registered.  It returns an error if one of the same name is
+// registered.
+func (r *Registry) Register(m Mutator) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.mutators {
+		if existing.Name() == m.Name() {
+			return fmt.Errorf("mutator %q is already registered", m.Name())
+		}
+	}
+	r.mutators = append(r.mutators, m)
+	return nil
+}
+
+// Replace puts m in place of the registered mutator of the same name, so that it runs in the same order, e.g. to
+// configure the Authz mutator.  It returns an error if none of that name is registered.
+func (r *Registry) Replace(m Mutator) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, existing := range r.mutators {
+		if existing.Name() == m.Name() {
+			r.mutators[i] = m
+			return nil
+		}
+	}
+	return fmt.Errorf("mutator %q is not registered", m.Name())
+}
+
+// Unregister removes the named mutator, and reports whether it was registered.
+func (r *Registry) Unregister(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, m := range r.mutators {
+		if m.Name() == name {
+			r.mutators = append(r.mutators[:i:i], r.mutators[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Mutators returns the registered mutators, in the order they run.
+func (r *Registry) Mutators() []Mutator {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]Mutator(nil), r.mutators...)
+}
+
+// Error is a mutator failing.  Body and Changed are the config as the mutators before it left it, so that callers can
+// fall back to that rather than to the config as Pilot generated it.
+type Error struct {
+	Mutator string
+	Err     error
+	Body    []byte
+	Changed []string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("mutator %s: %v", e.Mutator, e.Err)
+}
+
+// Apply runs the hook of each registered mutator in turn, except those named in skip, and returns the config and the
+// resources changed.  It stops at the first error, which is an *Error naming the mutator, or a *VetoError.
+func (r *Registry) Apply(hook Hook, req *Request, body []byte, skip ...string) ([]byte, []string, error) {
+	return r.ApplyWithCallbacks(Callbacks{}, hook, req, body, skip...)
+}
+
+// ApplyWithCallbacks is Apply, calling the callbacks around the mutators.
+func (r *Registry) ApplyWithCallbacks(cb Callbacks, hook Hook, req *Request, body []byte,
+	skip ...string) ([]byte, []string, error) {
+	if err := cb.onRequest(hook, req, body); err != nil {
+		return nil, nil, err
+	}
+	out, changed, err := r.apply(cb, hook, req, body, skip)
+	if err = cb.onResponse(hook, req, out, changed, err); err != nil {
+		return nil, nil, err
+	}
+	return out, changed, nil
+}
+
+func (r *Registry) apply(cb Callbacks, hook Hook, req *Request, body []byte, skip []string) ([]byte, []string, error) {
+	var changed []string
+	for _, m := range r.Mutators() {
+		if contains(skip, m.Name()) {
+			continue
+		}
+		var out []byte
+		var c []string
+		var err error
+		switch hook {
+		case HookListeners:
+			out, c, err = m.Listeners(req, body)
+		case HookClusters:
+			out, c, err = m.Clusters(req, body)
+		case HookRoutes:
+			out, c, err = m.Routes(req, body)
+		case HookEndpoints:
+			out, c, err = m.Endpoints(req, body)
+		default:
+			return nil, nil, fmt.Errorf("unknown hook %q", hook)
+		}
+		if err != nil {
+			return nil, nil, &Error{Mutator: m.Name(), Err: err, Body: body, Changed: changed}
+		}
+		body = out
+		if err := cb.onResourceMutated(hook, req, m.Name(), c); err != nil {
+			return nil, nil, err
+		}
+		changed = append(changed, c...)
+	}
+	return body, changed, nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
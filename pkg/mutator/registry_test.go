@@ -0,0 +1,159 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mutator
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+)
+
+// appending appends its suffix to every hook's config, so that the order mutators run in shows.
+type appending struct {
+	name string
+	err  error
+}
+
+func (a appending) Name() string { return a.name }
+
+func (a appending) mutate(body []byte) ([]byte, []string, error) {
+	if a.err != nil {
+		return nil, nil, a.err
+	}
+	return append(body, a.name...), []string{"x/" + a.name}, nil
+}
+
+func (a appending) Listeners(req *Request, body []byte) ([]byte, []string, error) {
+	return a.mutate(body)
+}
+func (a appending) Clusters(req *Request, body []byte) ([]byte, []string, error) {
+	return a.mutate(body)
+}
+func (a appending) Routes(req *Request, body []byte) ([]byte, []string, error) { return a.mutate(body) }
+func (a appending) Endpoints(req *Request, body []byte) ([]byte, []string, error) {
+	return a.mutate(body)
+}
+
+func TestRegistry(t *testing.T) {
+	RegisterTestingT(t)
+
+	r := NewRegistry(appending{name: "a"})
+	Expect(r.Register(appending{name: "b"})).To(Succeed())
+	Expect(r.Register(appending{name: "a"})).NotTo(Succeed())
+
+	for _, hook := range []Hook{HookListeners, HookClusters, HookRoutes, HookEndpoints} {
+		out, changed, err := r.Apply(hook, &Request{}, []byte("-"))
+		Expect(err).To(BeNil())
+		Expect(string(out)).To(Equal("-ab"))
+		Expect(changed).To(Equal([]string{"x/a", "x/b"}))
+	}
+	out, _, err := r.Apply(HookRoutes, &Request{}, []byte("-"), "a")
+	Expect(err).To(BeNil())
+	Expect(string(out)).To(Equal("-b"))
+	_, _, err = r.Apply("bogus", &Request{}, nil)
+	Expect(err).NotTo(BeNil())
+
+	Expect(r.Unregister("a")).To(BeTrue())
+	Expect(r.Unregister("a")).To(BeFalse())
+	Expect(r.Mutators()).To(HaveLen(1))
+
+	Expect(r.Replace(appending{name: "c"})).NotTo(Succeed())
+	Expect(r.Register(appending{name: "c", err: errors.New("broken")})).To(Succeed())
+	_, _, err = r.Apply(HookListeners, &Request{}, []byte("-"))
+	Expect(err.Error()).To(ContainSubstring("mutator c: broken"))
+	// The error carries the config as the mutators before the failed one left it.
+	merr, ok := err.(*Error)
+	Expect(ok).To(BeTrue())
+	Expect(merr.Mutator).To(Equal("c"))
+	Expect(string(merr.Body)).To(Equal("-b"))
+	Expect(merr.Changed).To(Equal([]string{"x/b"}))
+
+	// Replacing keeps the mutator's place.
+	Expect(r.Register(appending{name: "d"})).To(Succeed())
+	Expect(r.Replace(appending{name: "c"})).To(Succeed())
+	out, _, err = r.Apply(HookListeners, &Request{}, []byte("-"))
+	Expect(err).To(BeNil())
+	Expect(string(out)).To(Equal("-bcd"))
+}
+
+func TestAuthzMutator(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(Default.Mutators()[0].Name()).To(Equal(AuthzName))
+
+	req := &Request{Node: config.ParseNode(testNode), Profile: config.ProfileFor(0, 0)}
+	_, changed, err := Authz{}.Listeners(req, []byte(testLDS))
+	Expect(err).To(BeNil())
+	Expect(changed).To(HaveLen(2))
+
+	// Without a dikastes address, CDS is passed through.
+	out, changed, err := Authz{}.Clusters(req, []byte(`{"clusters":[]}`))
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeEmpty())
+	Expect(string(out)).To(Equal(`{"clusters":[]}`))
+
+	req.DikastesAddress = testAddr
+	_, changed, err = Authz{}.Clusters(req, []byte(`{"clusters":[]}`))
+	Expect(err).To(BeNil())
+	Expect(changed).To(Equal([]string{"cluster/" + config.AuthzClusterName}))
+
+	out, changed, err = Authz{}.Routes(req, []byte(`{}`))
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeEmpty())
+	Expect(string(out)).To(Equal(`{}`))
+
+	// Nodes the request skips are passed through.
+	req.SkipAuthz = SkipExcludedPort
+	out, changed, err = Authz{}.Listeners(req, []byte(testLDS))
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeEmpty())
+	Expect(string(out)).To(Equal(testLDS))
+}
+
+func TestAuthzMutatorConfigured(t *testing.T) {
+	RegisterTestingT(t)
+
+	var results []ListenerResult
+	req := &Request{Node: config.ParseNode(testNode), Profile: config.ProfileFor(0, 0)}
+	req.OnListener = func(_ json.RawMessage, res ListenerResult) { results = append(results, res) }
+	a := Authz{
+		Skip: func(_ *Request, _ *v1.Listener, _ Protocol, port int) SkipReason {
+			if port == 3306 {
+				return SkipExcludedPort
+			}
+			return ""
+		},
+	}
+	_, changed, err := a.Listeners(req, []byte(testLDS))
+	Expect(err).To(BeNil())
+	Expect(changed).To(Equal([]string{"listener/http_10.0.0.1_8080"}))
+	Expect(results).To(HaveLen(5))
+	Expect(results[3].Skip).To(Equal(SkipExcludedPort))
+
+	// Every listener classified outbound is skipped.
+	a.Classify = func(*Request, *v1.Listener) (Direction, Protocol) { return Outbound, TCP }
+	results = nil
+	_, changed, err = a.Listeners(req, []byte(testLDS))
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeEmpty())
+	for _, res := range results {
+		Expect(res.Skip).To(Equal(SkipOutbound))
+	}
+}
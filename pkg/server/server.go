@@ -0,0 +1,164 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package server serves Pilot's webhook hooks with the mutators registered in pkg/mutator, so that other components
+// and tests can run them in process, on their own HTTP servers.  It has none of the webhook binary's optional
+// features, such as dikastes discovery, caching or metrics, and depends only on net/http.
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+// Options configures the hooks.
+type Options struct {
+	// DikastesAddress is the address the authz cluster is added with, e.g. unix:///var/run/dikastes/dikastes.sock.
+	// If it is empty, CDS is passed through, and sidecars must get the cluster some other way.
+	DikastesAddress string
+	// Profile returns the profile to configure a sidecar's authz filter with.  If it is nil, every sidecar gets the
+	// profile of an unknown Istio version.
+	Profile func(node config.Node) config.Profile
+	// Registry holds the mutators to run.  If it is nil, those in mutator.Default are run.
+	Registry *mutator.Registry
+	// Callbacks are called around the mutators of each request.  If one vetoes a mutation, the config is sent as
+	// Pilot generated it.
+	Callbacks mutator.Callbacks
+}
+
+func (o Options) profile(node config.Node) config.Profile {
+	if o.Profile == nil {
+		return config.ProfileFor(0, 0)
+	}
+	return o.Profile(node)
+}
+
+func (o Options) registry() *mutator.Registry {
+	if o.Registry == nil {
+		return mutator.Default
+	}
+	return o.Registry
+}
+
+// New returns a handler serving the hooks on the paths Pilot calls them on, for other programs to mount on their own
+// servers, e.g. with mux.Handle("/v1/", server.New(opts)).  It uses only net/http, and the state in opts.
+func New(opts Options) http.Handler {
+	return handler{opts}
+}
+
+type handler struct {
+	opts Options
+}
+
+// hookParams are the path parameters of a hook request.
+type hookParams struct {
+	serviceCluster, serviceNode, serviceName string
+}
+
+func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hook, params, ok := parseHookPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	h.opts.serve(w, r, hook, params)
+}
+
+// parseHookPath returns the hook and parameters of a hook request's path.
+func parseHookPath(path string) (mutator.Hook, hookParams, bool) {
+	c := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for _, s := range c {
+		if s == "" {
+			return "", hookParams{}, false
+		}
+	}
+	switch {
+	case len(c) == 4 && c[0] == "v1" && c[1] == "listeners":
+		return mutator.HookListeners, hookParams{serviceCluster: c[2], serviceNode: c[3]}, true
+	case len(c) == 4 && c[0] == "v1" && c[1] == "clusters":
+		return mutator.HookClusters, hookParams{serviceCluster: c[2], serviceNode: c[3]}, true
+	case len(c) == 5 && c[0] == "v1" && c[1] == "routes":
+		return mutator.HookRoutes, hookParams{serviceCluster: c[3], serviceNode: c[4]}, true
+	case len(c) == 3 && c[0] == "v1" && c[1] == "registration":
+		return mutator.HookEndpoints, hookParams{serviceName: c[2]}, true
+	}
+	return "", hookParams{}, false
+}
+
+// serve runs the registered mutators for a hook request.
+func (o Options) serve(w http.ResponseWriter, r *http.Request, hook mutator.Hook, params hookParams) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.WithField("err", err).Warn("Failed to read request")
+		http.Error(w, "failed to read request", http.StatusInternalServerError)
+		return
+	}
+	out, changed, err := o.registry().ApplyWithCallbacks(o.Callbacks, hook, o.request(params), body)
+	if veto, ok := err.(*mutator.VetoError); ok {
+		log.WithFields(log.Fields{"hook": hook, "err": veto}).Info("Mutation vetoed; sending config unchanged")
+		out, changed, err = body, nil, nil
+	}
+	writeMutation(w, r, hook, body, out, changed, err)
+}
+
+// request describes a hook request to the mutators.
+func (o Options) request(params hookParams) *mutator.Request {
+	r := &mutator.Request{
+		ServiceCluster:  params.serviceCluster,
+		Service:         params.serviceName,
+		DikastesAddress: o.DikastesAddress,
+	}
+	if params.serviceNode != "" {
+		r.Node = config.ParseNode(params.serviceNode)
+		r.Profile = o.profile(r.Node)
+	}
+	return r
+}
+
+// writeMutation writes a hook's mutated config, or for a dry run the request body with the changes in a header.
+func writeMutation(w http.ResponseWriter, r *http.Request, hook mutator.Hook, body, out []byte, changed []string,
+	err error) {
+	if err != nil {
+		log.WithFields(log.Fields{"hook": hook, "err": err}).Warn("Failed to mutate config")
+		http.Error(w, "could not mutate request JSON", http.StatusBadRequest)
+		return
+	}
+	if isDryRun(r) {
+		summary := "none"
+		if len(changed) > 0 {
+			summary = strings.Join(changed, ",")
+		}
+		w.Header().Set(config.DryRunChangesHeader, summary)
+		out = body
+	}
+	w.Write(out)
+}
+
+func isDryRun(r *http.Request) bool {
+	dryRun, _ := strconv.ParseBool(r.Header.Get(config.DryRunHeader))
+	return dryRun
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+	"github.com/projectcalico/pilot-webhook/pkg/testutil"
+)
+
+// TestGolden checks that the embeddable hooks answer the webhook's golden cases as the webhook does.
+func TestGolden(t *testing.T) {
+	RegisterTestingT(t)
+
+	handler := New(Options{DikastesAddress: testutil.DikastesAddress})
+	cases, err := testutil.LoadGoldenCases("../../testdata/golden")
+	Expect(err).To(BeNil())
+	for _, c := range cases {
+		c := c
+		t.Run(c.Hook+"/"+c.Name, func(t *testing.T) {
+			RegisterTestingT(t)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("POST", c.Path(), bytes.NewReader(c.Request)))
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(MatchJSON(c.Golden))
+		})
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	RegisterTestingT(t)
+
+	handler := New(Options{DikastesAddress: testutil.DikastesAddress})
+	body := `{"clusters":[]}`
+	req := httptest.NewRequest("POST", testutil.HookPath("clusters", "sidecar"), strings.NewReader(body))
+	req.Header.Set(config.DryRunHeader, "true")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	Expect(rec.Code).To(Equal(http.StatusOK))
+	Expect(rec.Header().Get(config.DryRunChangesHeader)).To(Equal("cluster/" + config.AuthzClusterName))
+	Expect(rec.Body.String()).To(Equal(body))
+}
+
+func TestProfile(t *testing.T) {
+	RegisterTestingT(t)
+
+	var got config.Node
+	handler := New(Options{Profile: func(node config.Node) config.Profile {
+		got = node
+		p := config.ProfileFor(0, 8)
+		p.ClusterName = "custom"
+		return p
+	}})
+	body := `{"listeners":[{"name":"tcp_` + testutil.NodeIP + `_80","address":"tcp://` + testutil.NodeIP + `:80"}]}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", testutil.HookPath("listeners", "sidecar"),
+		strings.NewReader(body)))
+	Expect(rec.Code).To(Equal(http.StatusOK))
+	Expect(got.IP).To(Equal(testutil.NodeIP))
+	Expect(rec.Body.String()).To(ContainSubstring(`"grpc_service":{"envoy_grpc":{"cluster_name":"custom"}}`))
+
+	// Without a dikastes address, CDS is passed through.
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", testutil.HookPath("clusters", "sidecar"),
+		strings.NewReader(`{"clusters":[]}`)))
+	Expect(rec.Body.String()).To(Equal(`{"clusters":[]}`))
+}
+
+// stamping adds a field to route configs.
+type stamping struct {
+	mutator.Passthrough
+}
+
+func (stamping) Name() string { return "stamping" }
+
+func (stamping) Routes(req *mutator.Request, body []byte) ([]byte, []string, error) {
+	return []byte(`{"stamped":"` + req.Node.IP + `"}`), []string{"route/stamped"}, nil
+}
+
+func TestRegistry(t *testing.T) {
+	RegisterTestingT(t)
+
+	handler := New(Options{Registry: mutator.NewRegistry(mutator.Authz{}, stamping{})})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", testutil.HookPath("routes", "sidecar"),
+		strings.NewReader(`{}`)))
+	Expect(rec.Code).To(Equal(http.StatusOK))
+	Expect(rec.Body.String()).To(Equal(`{"stamped":"` + testutil.NodeIP + `"}`))
+}
+
+func TestCallbacksVeto(t *testing.T) {
+	RegisterTestingT(t)
+
+	var mutated []string
+	handler := New(Options{
+		DikastesAddress: testutil.DikastesAddress,
+		Callbacks: mutator.Callbacks{
+			OnResourceMutated: func(hook mutator.Hook, req *mutator.Request, name string, resources []string) error {
+				mutated = append(mutated, resources...)
+				return errors.New("not today")
+			},
+		},
+	})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", testutil.HookPath("clusters", "sidecar"),
+		strings.NewReader(`{"clusters":[]}`)))
+	Expect(rec.Code).To(Equal(http.StatusOK))
+	Expect(rec.Body.String()).To(Equal(`{"clusters":[]}`))
+	Expect(mutated).To(Equal([]string{"cluster/" + config.AuthzClusterName}))
+}
+
+func TestRouting(t *testing.T) {
+	RegisterTestingT(t)
+
+	// Mounted under a prefix on another program's mux.
+	mux := http.NewServeMux()
+	mux.Handle("/pilot/", http.StripPrefix("/pilot", New(Options{DikastesAddress: testutil.DikastesAddress})))
+	for path, code := range map[string]int{
+		"/pilot" + testutil.HookPath("clusters", "sidecar"):                         http.StatusOK,
+		"/pilot/v1/routes/80/" + testutil.ServiceNode("sidecar", testutil.NodeIP):   http.StatusNotFound,
+		"/pilot/v1/registration/a.default.svc.cluster.local":                        http.StatusOK,
+		"/pilot/v1/listeners/cluster/":                                              http.StatusNotFound,
+		"/pilot/v2/listeners/cluster/" + testutil.ServiceNode("x", testutil.NodeIP): http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("POST", path, strings.NewReader(`{}`)))
+		Expect(rec.Code).To(Equal(code), path)
+	}
+
+	rec := httptest.NewRecorder()
+	New(Options{}).ServeHTTP(rec, httptest.NewRequest("GET", testutil.HookPath("clusters", "sidecar"), nil))
+	Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+}
+
+func TestBadRequest(t *testing.T) {
+	RegisterTestingT(t)
+
+	rec := httptest.NewRecorder()
+	New(Options{}).ServeHTTP(rec, httptest.NewRequest("POST", testutil.HookPath("listeners", "sidecar"),
+		strings.NewReader(`{"listeners":`)))
+	Expect(rec.Code).To(Equal(http.StatusBadRequest))
+}
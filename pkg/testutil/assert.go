@@ -0,0 +1,125 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// The names the webhook gives the authz filter and the cluster it sends its checks to, unless configured otherwise.
+const (
+	AuthzFilterName  = "envoy.ext_authz"
+	AuthzClusterName = "calico.dikastes"
+)
+
+// Filter is a network or HTTP filter, decoded only as far as the assertions need.
+type Filter struct {
+	Name   string          `json:"name"`
+	Config json.RawMessage `json:"config"`
+}
+
+// HTTPFilters returns the filters of an http_connection_manager.
+func (f Filter) HTTPFilters() []Filter {
+	var hcm struct {
+		Filters []Filter `json:"filters"`
+	}
+	json.Unmarshal(f.Config, &hcm)
+	return hcm.Filters
+}
+
+// Listener is a listener, decoded only as far as the assertions need.
+type Listener struct {
+	Name    string   `json:"name"`
+	Address string   `json:"address"`
+	Filters []Filter `json:"filters"`
+}
+
+// AuthzConfigs returns the config of each authz filter on the listener, whether a network filter or an HTTP filter.
+func (l Listener) AuthzConfigs() []json.RawMessage {
+	var configs []json.RawMessage
+	for _, f := range l.Filters {
+		if f.Name == AuthzFilterName {
+			configs = append(configs, f.Config)
+		}
+		if f.Name == "http_connection_manager" {
+			for _, h := range f.HTTPFilters() {
+				if h.Name == AuthzFilterName {
+					configs = append(configs, h.Config)
+				}
+			}
+		}
+	}
+	return configs
+}
+
+// Inbound reports whether the listener is for traffic to the sidecar on ip.
+func (l Listener) Inbound(ip string) bool {
+	return strings.HasPrefix(l.Address, "tcp://"+ip+":")
+}
+
+// DecodeListeners decodes the listeners of an LDS response.
+func DecodeListeners(body []byte) ([]Listener, error) {
+	var lds struct {
+		Listeners []Listener `json:"listeners"`
+	}
+	if err := json.Unmarshal(body, &lds); err != nil {
+		return nil, err
+	}
+	return lds.Listeners, nil
+}
+
+// CheckMutatedLDS checks an LDS response mutated for the sidecar on ip: each inbound listener has exactly one authz
+// filter, and no other listener has one.  It returns the number of inbound listeners.
+func CheckMutatedLDS(body []byte, ip string) (int, error) {
+	listeners, err := DecodeListeners(body)
+	if err != nil {
+		return 0, err
+	}
+	inbound := 0
+	for _, l := range listeners {
+		n := len(l.AuthzConfigs())
+		if !l.Inbound(ip) {
+			if n > 0 {
+				return inbound, fmt.Errorf("outbound listener %s has the authz filter", l.Name)
+			}
+			continue
+		}
+		if n != 1 {
+			return inbound, fmt.Errorf("inbound listener %s has %d authz filters", l.Name, n)
+		}
+		inbound++
+	}
+	return inbound, nil
+}
+
+// CheckAuthzCluster checks that a CDS response has the authz cluster.
+func CheckAuthzCluster(body []byte) error {
+	var cds struct {
+		Clusters []struct {
+			Name string `json:"name"`
+		} `json:"clusters"`
+	}
+	if err := json.Unmarshal(body, &cds); err != nil {
+		return err
+	}
+	for _, c := range cds.Clusters {
+		if c.Name == AuthzClusterName {
+			return nil
+		}
+	}
+	return fmt.Errorf("no %s cluster", AuthzClusterName)
+}
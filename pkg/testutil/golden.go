@@ -0,0 +1,83 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// GoldenSuffix ends the names of golden response files.
+const GoldenSuffix = ".golden.json"
+
+// DikastesAddress is the dikastes address the golden responses' authz clusters are made with.
+const DikastesAddress = "tcp://10.96.0.20:9000"
+
+// GoldenCase is a request in a golden fixture directory and the response expected for it.
+type GoldenCase struct {
+	// Hook is the hook the case is for, the name of the directory it is in.
+	Hook string
+	// Name is the case's name, which for registration cases is the service.
+	Name string
+	// RequestFile holds the request body, Request.
+	RequestFile string
+	Request     []byte
+	// GoldenFile holds the expected response, Golden, which is nil if there is none yet.
+	GoldenFile string
+	Golden     []byte
+}
+
+// Path is where the case's request is sent.  Hooks are called for a sidecar on NodeIP.
+func (c GoldenCase) Path() string {
+	if c.Hook == "registration" {
+		return "/v1/registration/" + c.Name
+	}
+	return HookPath(c.Hook, "sidecar")
+}
+
+// LoadGoldenCases reads the cases in dir, which has a directory per hook, named as in the hook's path, of <case>.json
+// requests and the <case>.golden.json responses expected for them.
+func LoadGoldenCases(dir string) ([]GoldenCase, error) {
+	requests, err := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var cases []GoldenCase
+	for _, req := range requests {
+		if strings.HasSuffix(req, GoldenSuffix) {
+			continue
+		}
+		c := GoldenCase{
+			Hook:        filepath.Base(filepath.Dir(req)),
+			Name:        strings.TrimSuffix(filepath.Base(req), ".json"),
+			RequestFile: req,
+			GoldenFile:  strings.TrimSuffix(req, ".json") + GoldenSuffix,
+		}
+		if c.Request, err = ioutil.ReadFile(req); err != nil {
+			return nil, err
+		}
+		if c.Golden, err = ioutil.ReadFile(c.GoldenFile); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		cases = append(cases, c)
+	}
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("no golden cases in %s", dir)
+	}
+	return cases, nil
+}
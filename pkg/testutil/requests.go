@@ -0,0 +1,82 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package testutil has the request builders, fixture loaders and assertions the webhook's own tests use, so that
+// other repos can test against the webhook's behavior the same way.
+package testutil
+
+import (
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/emicklei/go-restful"
+)
+
+// The node and names the request builders use.
+const (
+	NodeIP         = "3.4.5.6"
+	ServiceCluster = "testcluster"
+	RouteConfig    = "testrds"
+	ServiceName    = "testeds"
+)
+
+// ServiceNode returns the service node ID Pilot passes for a proxy of the given type, e.g. "sidecar", on ip.
+func ServiceNode(nodeType, ip string) string {
+	return fmt.Sprintf("%s~%s~testpod.testns~testns.svc.cluster.local", nodeType, ip)
+}
+
+// HookPath returns the path Pilot calls a hook on for a proxy of the given type on NodeIP.  The registration hook is
+// called for ServiceName.
+func HookPath(hook, nodeType string) string {
+	sn := url.PathEscape(ServiceNode(nodeType, NodeIP))
+	switch hook {
+	case "routes":
+		return fmt.Sprintf("/v1/routes/%s/%s/%s", RouteConfig, ServiceCluster, sn)
+	case "registration":
+		return "/v1/registration/" + ServiceName
+	}
+	return fmt.Sprintf("/v1/%s/%s/%s", hook, ServiceCluster, sn)
+}
+
+// newRequest returns a request for a hook handler, with the path parameters go-restful would have set.
+func newRequest(hook, nodeType string, body io.Reader) *restful.Request {
+	req := restful.NewRequest(httptest.NewRequest("POST", "http://unix"+HookPath(hook, nodeType), body))
+	if hook != "registration" {
+		req.PathParameters()["serviceCluster"] = ServiceCluster
+		req.PathParameters()["serviceNode"] = ServiceNode(nodeType, NodeIP)
+	}
+	return req
+}
+
+// NewLDSRequest returns a listeners hook request for a proxy of the given type on NodeIP.
+func NewLDSRequest(nodeType string, body io.Reader) *restful.Request {
+	return newRequest("listeners", nodeType, body)
+}
+
+// NewCDSRequest returns a clusters hook request for a proxy of the given type on NodeIP.
+func NewCDSRequest(nodeType string, body io.Reader) *restful.Request {
+	return newRequest("clusters", nodeType, body)
+}
+
+// NewRDSRequest returns a routes hook request for RouteConfig, for a proxy of the given type on NodeIP.
+func NewRDSRequest(nodeType string, body io.Reader) *restful.Request {
+	return newRequest("routes", nodeType, body)
+}
+
+// NewEDSRequest returns a registration hook request for ServiceName.
+func NewEDSRequest(body io.Reader) *restful.Request {
+	return newRequest("registration", "", body)
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestHookPath(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(HookPath("listeners", "sidecar")).To(Equal(
+		"/v1/listeners/testcluster/sidecar~3.4.5.6~testpod.testns~testns.svc.cluster.local"))
+	Expect(HookPath("routes", "router")).To(HavePrefix("/v1/routes/testrds/testcluster/router~"))
+	Expect(HookPath("registration", "")).To(Equal("/v1/registration/testeds"))
+
+	req := NewCDSRequest("sidecar", strings.NewReader("{}"))
+	Expect(req.PathParameter("serviceNode")).To(Equal(ServiceNode("sidecar", NodeIP)))
+	Expect(req.Request.URL.Path).To(Equal(HookPath("clusters", "sidecar")))
+}
+
+func TestLoadGoldenCases(t *testing.T) {
+	RegisterTestingT(t)
+
+	cases, err := LoadGoldenCases("../../testdata/golden")
+	Expect(err).To(BeNil())
+	hooks := map[string]bool{}
+	for _, c := range cases {
+		hooks[c.Hook] = true
+		Expect(c.Request).NotTo(BeEmpty(), c.RequestFile)
+		Expect(c.Golden).NotTo(BeNil(), c.GoldenFile)
+	}
+	Expect(hooks).To(HaveLen(4))
+
+	_, err = LoadGoldenCases("testdata/nonexistent")
+	Expect(err).NotTo(BeNil())
+}
+
+const mutatedLDS = `{"listeners":[
+  {"name":"http_3.4.5.6_80","address":"tcp://3.4.5.6:80","filters":[{"name":"http_connection_manager","config":{
+    "filters":[{"name":"envoy.ext_authz","config":{}},{"name":"router","config":{}}]}}]},
+  {"name":"tcp_3.4.5.6_3306","address":"tcp://3.4.5.6:3306","filters":[
+    {"name":"envoy.ext_authz","config":{}},{"name":"tcp_proxy","config":{}}]},
+  {"name":"http_0.0.0.0_80","address":"tcp://0.0.0.0:80","filters":[{"name":"http_connection_manager","config":{
+    "filters":[{"name":"router","config":{}}]}}]}
+]}`
+
+func TestCheckMutatedLDS(t *testing.T) {
+	RegisterTestingT(t)
+
+	inbound, err := CheckMutatedLDS([]byte(mutatedLDS), NodeIP)
+	Expect(err).To(BeNil())
+	Expect(inbound).To(Equal(2))
+
+	// From another node's point of view, the filters are on outbound listeners.
+	_, err = CheckMutatedLDS([]byte(mutatedLDS), "10.0.0.1")
+	Expect(err).NotTo(BeNil())
+
+	unmutated := strings.Replace(mutatedLDS, AuthzFilterName, "other", -1)
+	_, err = CheckMutatedLDS([]byte(unmutated), NodeIP)
+	Expect(err).NotTo(BeNil())
+
+	_, err = CheckMutatedLDS([]byte(`{"listeners":`), NodeIP)
+	Expect(err).NotTo(BeNil())
+}
+
+func TestCheckAuthzCluster(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(CheckAuthzCluster([]byte(`{"clusters":[{"name":"a"},{"name":"calico.dikastes"}]}`))).To(Succeed())
+	Expect(CheckAuthzCluster([]byte(`{"clusters":[{"name":"a"}]}`))).NotTo(Succeed())
+}
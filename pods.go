@@ -0,0 +1,128 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// InjectAnnotation set to "false" on a pod stops the authz filter being added to its listeners.
+const InjectAnnotation = "authz.projectcalico.org/inject"
+
+// Istio's own annotations, which are honoured too.
+const (
+	// IstioInjectAnnotation set to "false" on a pod keeps it out of the mesh.
+	IstioInjectAnnotation = "sidecar.istio.io/inject"
+	// IstioExcludeInboundPortsAnnotation lists ports whose inbound traffic is not redirected to the sidecar.
+	IstioExcludeInboundPortsAnnotation = "traffic.sidecar.istio.io/excludeInboundPorts"
+)
+
+const podIPIndex = "podIP"
+
+var errPodsNotSynced = errors.New("pods not yet synced")
+
+// pods finds the pod behind a service node's IP.  It is nil unless --watch-pods is set.
+var pods *podIndex
+
+// podIndex looks pods up by IP in an informer's cache.
+type podIndex struct {
+	indexer cache.Indexer
+	synced  cache.InformerSynced
+}
+
+func newPodIndex(informer cache.SharedIndexInformer) (*podIndex, error) {
+	if err := informer.AddIndexers(cache.Indexers{podIPIndex: indexPodIP}); err != nil {
+		return nil, err
+	}
+	return &podIndex{indexer: informer.GetIndexer(), synced: informer.HasSynced}, nil
+}
+
+// indexPodIP indexes pods by IP.  Host networked pods share their node's IP, so are left out.
+func indexPodIP(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok || pod.Spec.HostNetwork || pod.Status.PodIP == "" {
+		return nil, nil
+	}
+	return []string{pod.Status.PodIP}, nil
+}
+
+// byIP returns the pod with the given IP, or nil if there is none.  Finished pods may linger with an IP that has been
+// reused, so a pod that is still running is preferred.
+func (p *podIndex) byIP(ip string) (*v1.Pod, error) {
+	objs, err := p.indexer.ByIndex(podIPIndex, ip)
+	if err != nil {
+		return nil, err
+	}
+	var found *v1.Pod
+	for _, obj := range objs {
+		pod := obj.(*v1.Pod)
+		if pod.Status.Phase != v1.PodSucceeded && pod.Status.Phase != v1.PodFailed {
+			return pod, nil
+		}
+		found = pod
+	}
+	return found, nil
+}
+
+// list returns every pod in the cache.
+func (p *podIndex) list() []*v1.Pod {
+	var list []*v1.Pod
+	for _, obj := range p.indexer.List() {
+		list = append(list, obj.(*v1.Pod))
+	}
+	return list
+}
+
+// check is a readiness check that fails until the pod cache has synced.
+func (p *podIndex) check() error {
+	if !p.synced() {
+		return errPodsNotSynced
+	}
+	return nil
+}
+
+// podOptedOut reports whether the pod is annotated not to be injected.
+func podOptedOut(pod *v1.Pod) bool {
+	inject, err := strconv.ParseBool(pod.Annotations[InjectAnnotation])
+	return err == nil && !inject
+}
+
+// podIstioOptedOut reports whether the pod is annotated to be left out of the mesh.
+func podIstioOptedOut(pod *v1.Pod) bool {
+	inject, err := strconv.ParseBool(pod.Annotations[IstioInjectAnnotation])
+	return err == nil && !inject
+}
+
+// podExcludedInboundPorts returns the ports whose inbound traffic bypasses the pod's sidecar, so that listeners on
+// them never see a request.
+func podExcludedInboundPorts(pod *v1.Pod) map[int]bool {
+	var ports map[int]bool
+	for _, s := range strings.Split(pod.Annotations[IstioExcludeInboundPortsAnnotation], ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			continue
+		}
+		if ports == nil {
+			ports = make(map[int]bool)
+		}
+		ports[port] = true
+	}
+	return ports
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func testPod(name, ip string, phase v1.PodPhase, annotations map[string]string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "testns", Annotations: annotations},
+		Status:     v1.PodStatus{PodIP: ip, Phase: phase},
+	}
+}
+
+func newTestPodIndex(objs ...*v1.Pod) *podIndex {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{podIPIndex: indexPodIP})
+	for _, o := range objs {
+		indexer.Add(o)
+	}
+	return &podIndex{indexer: indexer, synced: func() bool { return true }}
+}
+
+func TestPodByIP(t *testing.T) {
+	RegisterTestingT(t)
+
+	hostNet := testPod("hostnet", "3.4.5.6", v1.PodRunning, nil)
+	hostNet.Spec.HostNetwork = true
+	p := newTestPodIndex(
+		testPod("done", "3.4.5.6", v1.PodSucceeded, nil),
+		testPod("running", "3.4.5.6", v1.PodRunning, nil),
+		testPod("old", "3.4.5.7", v1.PodFailed, nil),
+		hostNet,
+	)
+
+	pod, err := p.byIP("3.4.5.6")
+	Expect(err).To(BeNil())
+	Expect(pod.Name).To(Equal("running"))
+	pod, _ = p.byIP("3.4.5.7")
+	Expect(pod.Name).To(Equal("old"))
+	pod, _ = p.byIP("3.4.5.8")
+	Expect(pod).To(BeNil())
+}
+
+func TestPodOptOut(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { pods = nil }()
+	pods = newTestPodIndex(testPod("testpod", NODE_IP, v1.PodRunning, map[string]string{InjectAnnotation: "false"}))
+	Expect(skipNode(SERVICE_CLUSTER, serviceNode("sidecar", NODE_IP), "sidecar", NODE_IP)).To(Equal(SkipPodOptOut))
+	Expect(skipNode(SERVICE_CLUSTER, serviceNode("sidecar", "9.9.9.9"), "sidecar", "9.9.9.9")).To(Equal(skipReason("")))
+
+	pods = newTestPodIndex(testPod("testpod", NODE_IP, v1.PodRunning, map[string]string{InjectAnnotation: "true"}))
+	Expect(skipNode(SERVICE_CLUSTER, serviceNode("sidecar", NODE_IP), "sidecar", NODE_IP)).To(Equal(skipReason("")))
+}
+
+func TestIstioOptOut(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func(old *namespaceSelector) { pods, namespaces = nil, old }(namespaces)
+	pods = newTestPodIndex(testPod("testpod", NODE_IP, v1.PodRunning, map[string]string{IstioInjectAnnotation: "false"}))
+	// The pod is out of the mesh, so it is not counted as skipped for any other reason.
+	namespaces = newTestNamespaceSelector("calico-authz=enabled")
+	Expect(skipNode(SERVICE_CLUSTER, serviceNode("sidecar", NODE_IP), "sidecar", NODE_IP)).To(Equal(SkipIstioOptOut))
+}
+
+func TestExcludedInboundPorts(t *testing.T) {
+	RegisterTestingT(t)
+
+	pod := testPod("testpod", NODE_IP, v1.PodRunning, map[string]string{IstioExcludeInboundPortsAnnotation: "9090, 8081,x"})
+	Expect(podExcludedInboundPorts(pod)).To(Equal(map[int]bool{9090: true, 8081: true}))
+	Expect(podExcludedInboundPorts(testPod("other", "9.9.9.9", v1.PodRunning, nil))).To(BeNil())
+}
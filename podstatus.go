@@ -0,0 +1,177 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Pod annotations recording what the webhook last did with each workload's listeners.
+const (
+	// InjectionStatusAnnotation is "injected" or "skipped".
+	InjectionStatusAnnotation = "authz.projectcalico.org/injection-status"
+	// InjectionReasonAnnotation is why the workload was skipped, as in pilot_webhook_mutations_skipped_total.
+	InjectionReasonAnnotation = "authz.projectcalico.org/injection-reason"
+	// LastPushAnnotation is when the workload's listeners were last served, in RFC 3339 format.
+	LastPushAnnotation = "authz.projectcalico.org/last-push"
+)
+
+const (
+	StatusInjected = "injected"
+	StatusSkipped  = "skipped"
+)
+
+// SkipNoInboundListeners is the reason recorded for a workload none of whose listeners were given the filter, other
+// than those counted individually in mutations_skipped_total.
+const SkipNoInboundListeners skipReason = "no_inbound_listeners"
+
+// podStatusRefresh is how often an unchanged status is written again to refresh the last push time.
+const podStatusRefresh = 5 * time.Minute
+
+const podStatusQueueSize = 1000
+
+// podStatus writes each workload's injection status to its pod's annotations.  It is nil unless --annotate-pods is
+// set.
+var podStatus *podStatusReporter
+
+// podStatusReporter patches pods in the background, and only when their status changes or is due a refresh, so that
+// the hooks never wait on the API server and pods are not patched on every push.
+type podStatusReporter struct {
+	mu      sync.Mutex
+	written map[string]podStatusUpdate
+	queue   chan podStatusUpdate
+	patch   func(namespace, name string, data []byte) error
+	now     func() time.Time
+}
+
+type podStatusUpdate struct {
+	namespace string
+	name      string
+	status    string
+	reason    skipReason
+	at        time.Time
+}
+
+func newPodStatusReporter(client kubernetes.Interface) *podStatusReporter {
+	return &podStatusReporter{
+		written: make(map[string]podStatusUpdate),
+		queue:   make(chan podStatusUpdate, podStatusQueueSize),
+		patch: func(namespace, name string, data []byte) error {
+			_, err := client.CoreV1().Pods(namespace).Patch(name, types.MergePatchType, data)
+			return err
+		},
+		now: time.Now,
+	}
+}
+
+// report queues the status of the pod identified by serviceNode.  reason is "" for injected pods.
+func (r *podStatusReporter) report(serviceNode, status string, reason skipReason) {
+	name, namespace, ok := podFromServiceNode(serviceNode)
+	if !ok {
+		return
+	}
+	u := podStatusUpdate{namespace: namespace, name: name, status: status, reason: reason, at: r.now()}
+	key := namespace + "/" + name
+	r.mu.Lock()
+	last, ok := r.written[key]
+	if ok && last.status == status && last.reason == reason && u.at.Sub(last.at) < podStatusRefresh {
+		r.mu.Unlock()
+		return
+	}
+	r.written[key] = u
+	r.mu.Unlock()
+	select {
+	case r.queue <- u:
+	default:
+		r.forget(key)
+		log.WithField("pod", key).Debug("Pod status queue full; dropping update")
+	}
+}
+
+func (r *podStatusReporter) forget(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.written, key)
+}
+
+// prune drops statuses old enough to be written again anyway, so that deleted pods are not remembered forever.
+func (r *podStatusReporter) prune() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, u := range r.written {
+		if r.now().Sub(u.at) >= podStatusRefresh {
+			delete(r.written, key)
+		}
+	}
+}
+
+// podStatusPatch is the merge patch setting the annotations for an update.  A null reason removes the annotation.
+func podStatusPatch(u podStatusUpdate) ([]byte, error) {
+	var reason *string
+	if u.reason != "" {
+		s := string(u.reason)
+		reason = &s
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				InjectionStatusAnnotation: u.status,
+				InjectionReasonAnnotation: reason,
+				LastPushAnnotation:        u.at.UTC().Format(time.RFC3339),
+			},
+		},
+	})
+}
+
+// write patches one pod.  On failure the status is forgotten so that the next push tries again.
+func (r *podStatusReporter) write(u podStatusUpdate) {
+	key := u.namespace + "/" + u.name
+	data, err := podStatusPatch(u)
+	if err == nil {
+		err = r.patch(u.namespace, u.name, data)
+	}
+	if err != nil {
+		r.forget(key)
+		errorLog.Warn(log.Fields{"pod": key, "err": err}, "failed to annotate pod with injection status")
+	}
+}
+
+// run writes queued updates.  It never returns.
+func (r *podStatusReporter) run() {
+	prune := time.NewTicker(podStatusRefresh)
+	for {
+		select {
+		case u := <-r.queue:
+			r.write(u)
+		case <-prune.C:
+			r.prune()
+		}
+	}
+}
+
+// listenersStatus returns the status to report for a workload after its listeners have been mutated, given whether
+// any of them has the authz filter.
+func listenersStatus(authz bool) (string, skipReason) {
+	if authz {
+		return StatusInjected, ""
+	}
+	return StatusSkipped, SkipNoInboundListeners
+}
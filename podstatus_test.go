@@ -0,0 +1,107 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+)
+
+func TestPodStatusPatch(t *testing.T) {
+	RegisterTestingT(t)
+
+	at := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	data, err := podStatusPatch(podStatusUpdate{status: StatusSkipped, reason: SkipPodOptOut, at: at})
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(Equal(`{"metadata":{"annotations":{"authz.projectcalico.org/injection-reason":"pod_opt_out",` +
+		`"authz.projectcalico.org/injection-status":"skipped","authz.projectcalico.org/last-push":"2018-06-01T12:00:00Z"}}}`))
+
+	data, err = podStatusPatch(podStatusUpdate{status: StatusInjected, at: at})
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(ContainSubstring(`"authz.projectcalico.org/injection-reason":null`))
+}
+
+func TestPodStatusReporter(t *testing.T) {
+	RegisterTestingT(t)
+
+	now := time.Unix(1000, 0)
+	patched := map[string]int{}
+	var fail error
+	r := newPodStatusReporter(nil)
+	r.now = func() time.Time { return now }
+	r.patch = func(namespace, name string, data []byte) error {
+		patched[namespace+"/"+name]++
+		return fail
+	}
+	drain := func() {
+		for len(r.queue) > 0 {
+			r.write(<-r.queue)
+		}
+	}
+
+	sn := serviceNode("sidecar", NODE_IP)
+	r.report(sn, StatusInjected, "")
+	r.report(sn, StatusInjected, "")
+	drain()
+	Expect(patched["testns/testpod"]).To(Equal(1))
+
+	// A change is written straight away, and an unchanged status once it is due a refresh.
+	r.report(sn, StatusSkipped, SkipPodOptOut)
+	drain()
+	Expect(patched["testns/testpod"]).To(Equal(2))
+	now = now.Add(podStatusRefresh)
+	r.report(sn, StatusSkipped, SkipPodOptOut)
+	drain()
+	Expect(patched["testns/testpod"]).To(Equal(3))
+
+	// A failed write is retried on the next push.
+	fail = errors.New("forbidden")
+	r.report(sn, StatusInjected, "")
+	drain()
+	fail = nil
+	r.report(sn, StatusInjected, "")
+	drain()
+	Expect(patched["testns/testpod"]).To(Equal(5))
+
+	now = now.Add(podStatusRefresh)
+	r.prune()
+	Expect(r.written).To(BeEmpty())
+}
+
+func TestListenersReportPodStatus(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { podStatus = nil }()
+	podStatus = newPodStatusReporter(nil)
+
+	req := newLDSRequest("sidecar", strings.NewReader(`{"listeners": [{"name": "tcp_`+NODE_IP+`_76", "filters": []}]}`))
+	listeners(req, restful.NewResponse(httptest.NewRecorder()))
+	Expect(podStatus.queue).To(HaveLen(1))
+	u := <-podStatus.queue
+	Expect(u.status).To(Equal(StatusInjected))
+	Expect(u.name).To(Equal("testpod"))
+
+	req = newLDSRequest("sidecar", strings.NewReader(`{"listeners": []}`))
+	listeners(req, restful.NewResponse(httptest.NewRecorder()))
+	u = <-podStatus.queue
+	Expect(u.status).To(Equal(StatusSkipped))
+	Expect(u.reason).To(Equal(SkipNoInboundListeners))
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const profileSuffix = ".pprof"
+
+// profileCheckInterval is how often the profiler checks whether a threshold has been exceeded.
+const profileCheckInterval = 10 * time.Second
+
+var profilesCaptured = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "profiles_captured_total",
+	Help:      "Profiles written to --profile-dir, by kind.",
+}, []string{"kind"})
+
+func init() {
+	prometheus.MustRegister(profilesCaptured)
+}
+
+// profiler writes profiles when the webhook is slow or big.  It is nil unless --profile-dir is set.
+var profiler *selfProfiler
+
+// selfProfiler writes a CPU profile after a hook request is slower than --slow-request-threshold, and a heap profile
+// when the heap in use exceeds heapBytes, to dir.  Each kind is written at most once per interval, and at most
+// maxProfiles are kept by deleting the oldest, so that a struggling webhook cannot fill the node's disk.
+type selfProfiler struct {
+	dir         string
+	maxProfiles int
+	interval    time.Duration
+	cpuDuration time.Duration
+	heapBytes   uint64
+	now         func() time.Time
+	heapInUse   func() uint64
+
+	// slow is set when a slow request is seen, and cleared when the profiler next checks.
+	slow int32
+
+	mu       sync.Mutex
+	profiles []string
+	last     map[string]time.Time
+}
+
+func newSelfProfiler(dir string, maxProfiles int, interval, cpuDuration time.Duration,
+	heapBytes uint64) (*selfProfiler, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	// Pick up profiles from previous runs so they are rotated too.  Names sort by capture time.
+	profiles, err := filepath.Glob(filepath.Join(dir, "*"+profileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(profiles)
+	p := &selfProfiler{
+		dir:         dir,
+		maxProfiles: maxProfiles,
+		interval:    interval,
+		cpuDuration: cpuDuration,
+		heapBytes:   heapBytes,
+		now:         time.Now,
+		heapInUse:   heapInUse,
+		profiles:    profiles,
+		last:        make(map[string]time.Time),
+	}
+	p.rotate()
+	return p, nil
+}
+
+func heapInUse() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapInuse
+}
+
+// requestSlow notes that a hook request was slow, so that the next check profiles the CPU.
+func (p *selfProfiler) requestSlow() {
+	atomic.StoreInt32(&p.slow, 1)
+}
+
+// check writes any profiles that are due.  A CPU profile covers the cpuDuration after the check, on the basis that a
+// webhook slow enough to trip the threshold usually stays slow while the load that caused it lasts.
+func (p *selfProfiler) check() {
+	if atomic.SwapInt32(&p.slow, 0) == 1 && p.due("cpu") {
+		p.write("cpu", func(f *os.File) error {
+			if err := pprof.StartCPUProfile(f); err != nil {
+				// Only one CPU profile can run at a time in a process.
+				return err
+			}
+			time.Sleep(p.cpuDuration)
+			pprof.StopCPUProfile()
+			return nil
+		})
+	}
+	if p.heapBytes > 0 && p.heapInUse() > p.heapBytes && p.due("heap") {
+		p.write("heap", func(f *os.File) error {
+			return pprof.Lookup("heap").WriteTo(f, 0)
+		})
+	}
+}
+
+// run periodically checks whether to profile.  It never returns.
+func (p *selfProfiler) run() {
+	for range time.Tick(profileCheckInterval) {
+		p.check()
+	}
+}
+
+// due reports whether a profile of the given kind may be written now, and if so counts it as written.
+func (p *selfProfiler) due(kind string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	if last, ok := p.last[kind]; ok && now.Sub(last) < p.interval {
+		return false
+	}
+	p.last[kind] = now
+	return true
+}
+
+func (p *selfProfiler) write(kind string, profile func(*os.File) error) {
+	path := filepath.Join(p.dir, p.now().UTC().Format("20060102T150405.000000000")+"-"+kind+profileSuffix)
+	f, err := os.Create(path)
+	if err != nil {
+		errorLog.Error(log.Fields{"err": err}, "failed to write profile")
+		return
+	}
+	err = profile(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		errorLog.Error(log.Fields{"kind": kind, "err": err}, "failed to write profile")
+		return
+	}
+	profilesCaptured.WithLabelValues(kind).Inc()
+	log.WithField("path", path).Info("Wrote profile.")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.profiles = append(p.profiles, path)
+	p.rotate()
+}
+
+func (p *selfProfiler) rotate() {
+	for len(p.profiles) > p.maxProfiles {
+		os.Remove(p.profiles[0])
+		p.profiles = p.profiles[1:]
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func newTestProfiler(dir string, max int) (*selfProfiler, *time.Time) {
+	p, err := newSelfProfiler(dir, max, time.Minute, 10*time.Millisecond, 1<<20)
+	Expect(err).To(BeNil())
+	now := time.Unix(1000, 0)
+	p.now = func() time.Time { return now }
+	p.heapInUse = func() uint64 { return 0 }
+	return p, &now
+}
+
+func TestProfilerCPUAfterSlowRequest(t *testing.T) {
+	RegisterTestingT(t)
+
+	dir, err := ioutil.TempDir("", "profiles")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	p, now := newTestProfiler(dir, 10)
+
+	p.check()
+	Expect(captureFiles(dir, profileSuffix)).To(BeEmpty())
+
+	p.requestSlow()
+	p.check()
+	files := captureFiles(dir, profileSuffix)
+	Expect(files).To(HaveLen(1))
+	Expect(files[0]).To(HaveSuffix("-cpu" + profileSuffix))
+
+	// At most one per interval.
+	p.requestSlow()
+	p.check()
+	Expect(captureFiles(dir, profileSuffix)).To(HaveLen(1))
+	*now = now.Add(time.Minute)
+	p.check()
+	Expect(captureFiles(dir, profileSuffix)).To(HaveLen(1))
+	p.requestSlow()
+	p.check()
+	Expect(captureFiles(dir, profileSuffix)).To(HaveLen(2))
+}
+
+func TestProfilerHeapThreshold(t *testing.T) {
+	RegisterTestingT(t)
+
+	dir, err := ioutil.TempDir("", "profiles")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	p, now := newTestProfiler(dir, 2)
+
+	p.heapInUse = func() uint64 { return 1 << 20 }
+	p.check()
+	Expect(captureFiles(dir, profileSuffix)).To(BeEmpty())
+
+	p.heapInUse = func() uint64 { return 1<<20 + 1 }
+	for i := 0; i < 3; i++ {
+		p.check()
+		*now = now.Add(time.Minute)
+	}
+	// Only the newest are kept.
+	files := captureFiles(dir, profileSuffix)
+	Expect(files).To(HaveLen(2))
+	Expect(filepath.Base(files[0])).To(HavePrefix("19700101T001740"))
+	Expect(files[1]).To(HaveSuffix("-heap" + profileSuffix))
+
+	// Profiles from before a restart count towards the limit.
+	p, _ = newTestProfiler(dir, 1)
+	Expect(captureFiles(dir, profileSuffix)).To(HaveLen(1))
+}
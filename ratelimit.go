@@ -0,0 +1,95 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var rateLimitedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "rate_limited_requests_total",
+	Help:      "Hook requests refused by --rate-limit, by hook.",
+}, []string{"hook"})
+
+func init() {
+	prometheus.MustRegister(rateLimitedRequests)
+}
+
+// hookRateLimit limits the rate each hook is called at.  It is nil unless --rate-limit is set.
+var hookRateLimit *rateLimiter
+
+// rateLimiter is a token bucket per hook: each holds up to burst tokens, refilled at rate a second, and a request takes
+// one.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), now: time.Now, buckets: make(map[string]*tokenBucket)}
+}
+
+// allow reports whether a request to the hook may go ahead, taking a token if so.
+func (l *rateLimiter) allow(hook string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	b, ok := l.buckets[hook]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[hook] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimited is a route filter that refuses requests over the hook's --rate-limit with a 429, so that a burst of
+// pushes is shed rather than queued up behind the mutations.
+func rateLimited(hook string) restful.FilterFunction {
+	return func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		if hookRateLimit == nil || hookRateLimit.allow(hook) {
+			chain.ProcessFilter(req, resp)
+			return
+		}
+		rateLimitedRequests.WithLabelValues(hook).Inc()
+		errorLog.Warn(log.Fields{"hook": hook, "path": req.Request.URL.Path}, "refused request over --rate-limit")
+		resp.AddHeader("Retry-After", "1")
+		resp.WriteErrorString(http.StatusTooManyRequests, "too many requests")
+	}
+}
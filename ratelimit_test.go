@@ -0,0 +1,65 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRateLimiter(t *testing.T) {
+	RegisterTestingT(t)
+
+	now := time.Unix(0, 0)
+	l := newRateLimiter(2, 2)
+	l.now = func() time.Time { return now }
+	Expect(l.allow("listeners")).To(BeTrue())
+	Expect(l.allow("listeners")).To(BeTrue())
+	Expect(l.allow("listeners")).To(BeFalse())
+	// Each hook has its own bucket.
+	Expect(l.allow("clusters")).To(BeTrue())
+
+	now = now.Add(500 * time.Millisecond)
+	Expect(l.allow("listeners")).To(BeTrue())
+	Expect(l.allow("listeners")).To(BeFalse())
+
+	// The bucket never holds more than the burst.
+	now = now.Add(time.Hour)
+	Expect(l.allow("listeners")).To(BeTrue())
+	Expect(l.allow("listeners")).To(BeTrue())
+	Expect(l.allow("listeners")).To(BeFalse())
+}
+
+func TestRateLimited(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { hookRateLimit = nil }()
+	hookRateLimit = newRateLimiter(1, 1)
+	hookRateLimit.now = func() time.Time { return time.Unix(0, 0) }
+	before := testutil.ToFloat64(rateLimitedRequests.WithLabelValues("routes"))
+
+	hooks := newHooks()
+	rec := serveHook(hooks, hookRequest("routes", "sidecar", strings.NewReader("RDS")))
+	Expect(rec.Code).To(Equal(http.StatusOK))
+	rec = serveHook(hooks, hookRequest("routes", "sidecar", strings.NewReader("RDS")))
+	Expect(rec.Code).To(Equal(http.StatusTooManyRequests))
+	Expect(rec.Header().Get("Retry-After")).To(Equal("1"))
+	Expect(testutil.ToFloat64(rateLimitedRequests.WithLabelValues("routes"))).To(Equal(before + 1))
+}
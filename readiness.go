@@ -0,0 +1,71 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/emicklei/go-restful"
+)
+
+// readiness holds the checks consulted by /readyz.  Optional features register a check when they are enabled.
+var readiness = newReadinessChecks()
+
+type readinessChecks struct {
+	mu     sync.Mutex
+	checks map[string]func() error
+}
+
+// readinessReport is the body of a /readyz response.
+type readinessReport struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+func newReadinessChecks() *readinessChecks {
+	return &readinessChecks{checks: make(map[string]func() error)}
+}
+
+func (r *readinessChecks) register(name string, check func() error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[name] = check
+}
+
+func (r *readinessChecks) report() readinessReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep := readinessReport{Ready: true, Checks: make(map[string]string)}
+	for name, check := range r.checks {
+		if err := check(); err != nil {
+			rep.Ready = false
+			rep.Checks[name] = err.Error()
+		} else {
+			rep.Checks[name] = "ok"
+		}
+	}
+	return rep
+}
+
+// readyz reports whether the webhook is ready to serve, with the result of each check.
+func readyz(req *restful.Request, resp *restful.Response) {
+	rep := readiness.report()
+	status := http.StatusOK
+	if !rep.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	resp.WriteHeaderAndEntity(status, rep)
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+)
+
+func getReadyz() (int, readinessReport) {
+	rec := httptest.NewRecorder()
+	readyz(restful.NewRequest(httptest.NewRequest("GET", "/readyz", nil)), restful.NewResponse(rec))
+	var rep readinessReport
+	err := json.Unmarshal(rec.Body.Bytes(), &rep)
+	Expect(err).To(BeNil())
+	return rec.Code, rep
+}
+
+func TestReadyz(t *testing.T) {
+	RegisterTestingT(t)
+
+	readiness = newReadinessChecks()
+	defer func() { readiness = newReadinessChecks() }()
+
+	code, rep := getReadyz()
+	Expect(code).To(Equal(http.StatusOK))
+	Expect(rep.Ready).To(BeTrue())
+
+	var checkErr error
+	readiness.register("test", func() error { return checkErr })
+	code, rep = getReadyz()
+	Expect(code).To(Equal(http.StatusOK))
+	Expect(rep.Checks).To(Equal(map[string]string{"test": "ok"}))
+
+	checkErr = errors.New("not yet")
+	code, rep = getReadyz()
+	Expect(code).To(Equal(http.StatusServiceUnavailable))
+	Expect(rep.Ready).To(BeFalse())
+	Expect(rep.Checks).To(Equal(map[string]string{"test": "not yet"}))
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// sensitiveKeyParts mark JSON keys whose values are secrets, e.g. private_key, client_secret or access_token.  Keys
+// ending in _file are paths to secrets rather than the secrets themselves and are kept.
+var sensitiveKeyParts = []string{"private_key", "password", "secret", "token", "authorization", "cookie"}
+
+// pemPrivateKey matches PEM encoded private keys wherever they appear.
+var pemPrivateKey = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[^-]*-----END [A-Z ]*PRIVATE KEY-----`)
+
+// sensitiveJSONField matches a sensitive key and string value in text that could not be parsed as JSON.
+var sensitiveJSONField = regexp.MustCompile(
+	`(?i)("[^"]*(?:private_key|password|secret|token|authorization|cookie)[^"]*"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+
+func isSensitiveKey(key string) bool {
+	k := strings.ToLower(key)
+	if strings.HasSuffix(k, "_file") {
+		return false
+	}
+	for _, part := range sensitiveKeyParts {
+		if strings.Contains(k, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactBody returns body with secrets replaced, for writing to logs, captures and debug endpoints.  Bodies that are
+// not JSON, such as the ones that fail to parse, are redacted by pattern instead.  body is returned as is when there
+// is nothing to redact.
+func redactBody(body []byte) []byte {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		out := pemPrivateKey.ReplaceAll(body, []byte(redacted))
+		return sensitiveJSONField.ReplaceAll(out, []byte(`$1"`+redacted+`"`))
+	}
+	if !redactValue(v) {
+		return body
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return []byte(redacted)
+	}
+	return out
+}
+
+// redactValue replaces secrets in a generic JSON value in place and returns whether anything was replaced.  Header
+// lists such as request_headers_to_add are {"key": ..., "value": ...} pairs, so the value is redacted if the key is
+// sensitive.
+func redactValue(v interface{}) bool {
+	changed := false
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if key, ok := t["key"].(string); ok && isSensitiveKey(key) {
+			if _, ok := t["value"]; ok {
+				t["value"] = redacted
+				changed = true
+			}
+		}
+		for k, val := range t {
+			if isSensitiveKey(k) {
+				t[k] = redacted
+				changed = true
+				continue
+			}
+			if s, ok := val.(string); ok {
+				if r := pemPrivateKey.ReplaceAllString(s, redacted); r != s {
+					t[k] = r
+					changed = true
+				}
+				continue
+			}
+			if redactValue(val) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for i, val := range t {
+			if s, ok := val.(string); ok {
+				if r := pemPrivateKey.ReplaceAllString(s, redacted); r != s {
+					t[i] = r
+					changed = true
+				}
+				continue
+			}
+			if redactValue(val) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
@@ -0,0 +1,264 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/emicklei/go-restful"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxReplayDiffs is how many differences are listed for each capture whose response differs.
+const maxReplayDiffs = 10
+
+// capture is an exchange written by --capture-dir.  Its bodies may also be gzipped, with a .gz suffix, as the
+// benchmark fixtures are.
+type capture struct {
+	name     string
+	meta     captureMeta
+	request  []byte
+	response []byte
+}
+
+// loadCaptures reads the captures in dir in the order they were made.  Captures without a response, such as the
+// benchmark fixtures, have a nil response.
+func loadCaptures(dir string) ([]capture, error) {
+	metas, err := filepath.Glob(filepath.Join(dir, "*"+captureMetaSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(metas)
+	var captures []capture
+	for _, m := range metas {
+		base := strings.TrimSuffix(m, captureMetaSuffix)
+		c := capture{name: filepath.Base(base)}
+		b, err := ioutil.ReadFile(m)
+		if err == nil {
+			err = json.Unmarshal(b, &c.meta)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", m, err)
+		}
+		if c.request, err = readCaptureFile(base + captureRequestSuffix); err != nil {
+			return nil, err
+		}
+		c.response, err = readCaptureFile(base + captureResponseSuffix)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		captures = append(captures, c)
+	}
+	return captures, nil
+}
+
+// readCaptureFile reads a capture body, or its gzipped form if there is no plain one.
+func readCaptureFile(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if !os.IsNotExist(err) {
+		return b, err
+	}
+	f, err := os.Open(path + ".gz")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(zr)
+}
+
+// replayer sends captured requests to a webhook, either one serving on a socket or the hooks in process.
+type replayer struct {
+	client *http.Client
+	local  http.Handler
+}
+
+// newReplayer returns a replayer for the webhook serving on socket, which may instead be a tcp://<host>:<port>
+// address, or for the hooks in process if it is empty.
+func newReplayer(socket string) *replayer {
+	if socket == "" {
+		container := restful.NewContainer()
+		container.Add(newWebhook())
+		return &replayer{local: container}
+	}
+	network, addr := "unix", socket
+	if strings.HasPrefix(socket, "tcp://") {
+		network, addr = "tcp", strings.TrimPrefix(socket, "tcp://")
+	}
+	return &replayer{client: &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}}
+}
+
+// send replays a captured request, returning the status and body of the response.
+func (r *replayer) send(c capture) (int, []byte, error) {
+	method := c.meta.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	if r.local != nil {
+		rec := httptest.NewRecorder()
+		r.local.ServeHTTP(rec, httptest.NewRequest(method, c.meta.Path, bytes.NewReader(c.request)))
+		return rec.Code, rec.Body.Bytes(), nil
+	}
+	req, err := http.NewRequest(method, "http://unix"+c.meta.Path, bytes.NewReader(c.request))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", restful.MIME_JSON)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, body, err
+}
+
+// replayResult is the outcome of replaying one capture.
+type replayResult struct {
+	status int
+	body   []byte
+	// diffs lists how the response differs from the recorded one, if it was recorded.
+	diffs []string
+}
+
+// replay sends a capture and compares the response with the recorded one.  Both are redacted, since captures are, and
+// compared as JSON, so that a change in field order is not a difference.
+func (r *replayer) replay(c capture) (replayResult, error) {
+	status, body, err := r.send(c)
+	if err != nil {
+		return replayResult{}, err
+	}
+	res := replayResult{status: status, body: redactBody(body)}
+	if c.meta.Status != 0 && status != c.meta.Status {
+		res.diffs = append(res.diffs, fmt.Sprintf("status: %d -> %d", c.meta.Status, status))
+	}
+	if c.response != nil {
+		res.diffs = append(res.diffs, diffJSONBodies(c.response, res.body)...)
+	}
+	return res, nil
+}
+
+// diffJSONBodies lists the JSON Patch operations that turn the recorded body into the replayed one, as the audit log
+// does, comparing them as text if either is not JSON.
+func diffJSONBodies(recorded, replayed []byte) []string {
+	var a, b interface{}
+	if json.Unmarshal(recorded, &a) != nil || json.Unmarshal(replayed, &b) != nil {
+		if bytes.Equal(bytes.TrimSpace(recorded), bytes.TrimSpace(replayed)) {
+			return nil
+		}
+		return []string{"body: not JSON, and differs"}
+	}
+	var diffs []string
+	for i, op := range diffJSON("", a, b) {
+		if i == maxReplayDiffs {
+			diffs = append(diffs, "...")
+			break
+		}
+		d := op.Op + " " + op.Path
+		if op.Op != "remove" {
+			d += " " + summarizeJSON(op.Value)
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs
+}
+
+// summarizeJSON formats a value for a diff line, shortening big ones.
+func summarizeJSON(v interface{}) string {
+	b, _ := json.Marshal(v)
+	if len(b) > 60 {
+		return string(b[:57]) + "..."
+	}
+	return string(b)
+}
+
+// runReplay replays every capture in dir, writing a line per capture to out, and the replayed exchanges as captures
+// to outDir if it is set, so that they can be the baseline for the next version.  It returns how many responses
+// differed from those recorded.
+func runReplay(r *replayer, dir, outDir string, out io.Writer) (int, error) {
+	captures, err := loadCaptures(dir)
+	if err != nil {
+		return 0, err
+	}
+	var rec *payloadCapturer
+	if outDir != "" {
+		if rec, err = newPayloadCapturer(outDir, 1, len(captures)); err != nil {
+			return 0, err
+		}
+	}
+	differed := 0
+	for _, c := range captures {
+		res, err := r.replay(c)
+		if err != nil {
+			return differed, fmt.Errorf("%s: %v", c.name, err)
+		}
+		switch {
+		case c.response == nil:
+			fmt.Fprintf(out, "%s: %d, nothing recorded to compare\n", c.name, res.status)
+		case len(res.diffs) == 0:
+			fmt.Fprintf(out, "%s: same\n", c.name)
+		default:
+			differed++
+			fmt.Fprintf(out, "%s: differs\n", c.name)
+			for _, d := range res.diffs {
+				fmt.Fprintf(out, "    %s\n", d)
+			}
+		}
+		if rec != nil {
+			meta := c.meta
+			meta.Status = res.status
+			rec.write(meta, hookName(meta.Path), c.request, res.body)
+		}
+	}
+	fmt.Fprintf(out, "%d replayed, %d differed\n", len(captures), differed)
+	return differed, nil
+}
+
+// replay runs the replay command, exiting with status 1 if any response differed.
+func replay(arguments map[string]interface{}) {
+	if addr, ok := arguments["--dikastes-address"].(string); ok {
+		dikastes = staticResolver(addr)
+	}
+	socket, _ := arguments["--replay-socket"].(string)
+	outDir, _ := arguments["--replay-out"].(string)
+	differed, err := runReplay(newReplayer(socket), arguments["<dir>"].(string), outDir, os.Stdout)
+	if err != nil {
+		log.WithField("err", err).Fatal("Unable to replay captures.")
+	}
+	if differed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pkg/testutil"
+)
+
+// writeReplayCaptures captures one LDS exchange served in process to a new directory, with the response changed by
+// edit.
+func writeReplayCaptures(t *testing.T, edit func([]byte) []byte) string {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newPayloadCapturer(dir, 1, 10)
+	Expect(err).To(BeNil())
+	path := testutil.HookPath("listeners", "sidecar")
+	lds := capture{meta: captureMeta{Method: "POST", Path: path}, request: []byte(ldsWithUnknownFields)}
+	status, body, err := newReplayer("").send(lds)
+	Expect(err).To(BeNil())
+	c.write(captureMeta{Time: time.Unix(1000, 0), Method: "POST", Path: path, Status: status}, "listeners",
+		lds.request, edit(redactBody(body)))
+	return dir
+}
+
+func TestReplaySame(t *testing.T) {
+	RegisterTestingT(t)
+
+	dir := writeReplayCaptures(t, func(b []byte) []byte { return b })
+	defer os.RemoveAll(dir)
+	var out bytes.Buffer
+	differed, err := runReplay(newReplayer(""), dir, "", &out)
+	Expect(err).To(BeNil())
+	Expect(differed).To(Equal(0))
+	Expect(out.String()).To(ContainSubstring("-listeners: same\n"))
+}
+
+func TestReplayDiffers(t *testing.T) {
+	RegisterTestingT(t)
+
+	dir := writeReplayCaptures(t, func(b []byte) []byte {
+		return bytes.Replace(b, []byte(AuthZFilterName), []byte("other"), 1)
+	})
+	defer os.RemoveAll(dir)
+	outDir, err := ioutil.TempDir("", "replay-out")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(outDir)
+
+	var out bytes.Buffer
+	differed, err := runReplay(newReplayer(""), dir, outDir, &out)
+	Expect(err).To(BeNil())
+	Expect(differed).To(Equal(1))
+	Expect(out.String()).To(ContainSubstring("-listeners: differs\n"))
+	Expect(out.String()).To(ContainSubstring(`replace /listeners/`))
+
+	// The replayed exchanges are the baseline for the next run.
+	out.Reset()
+	differed, err = runReplay(newReplayer(""), outDir, "", &out)
+	Expect(err).To(BeNil())
+	Expect(differed).To(Equal(0))
+}
+
+func TestReplayOverSocket(t *testing.T) {
+	RegisterTestingT(t)
+
+	dir := writeReplayCaptures(t, func(b []byte) []byte { return b })
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "webhook.sock")
+	lis, err := net.Listen("unix", socket)
+	Expect(err).To(BeNil())
+	container := newHooks()
+	server := &http.Server{Handler: container}
+	go server.Serve(lis)
+	defer server.Close()
+
+	var out bytes.Buffer
+	differed, err := runReplay(newReplayer(socket), dir, "", &out)
+	Expect(err).To(BeNil())
+	Expect(differed).To(Equal(0))
+}
+
+func TestDiffJSONBodies(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(diffJSONBodies([]byte(`{"a":1,"b":[1,2]}`), []byte(`{"b":[1,2],"a":1}`))).To(BeEmpty())
+	Expect(diffJSONBodies([]byte(`{"a":1}`), []byte(`{"a":2,"b":"x"}`))).To(Equal([]string{
+		`replace /a 2`, `add /b "x"`,
+	}))
+	Expect(diffJSONBodies([]byte(`not json`), []byte(`not json`))).To(BeEmpty())
+	Expect(diffJSONBodies([]byte(`not json`), []byte(`{}`))).To(HaveLen(1))
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var responseCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "response_cache_requests_total",
+	Help:      "Hook requests looked up in the response cache, by hook and result.",
+}, []string{"hook", "result"})
+
+func init() {
+	prometheus.MustRegister(responseCacheRequests)
+}
+
+// responseCache holds mutated responses so that a body Pilot pushes again, to the same node or, for CDS, to every
+// node sharing a dikastes, is only mutated once.  It is nil unless --response-cache-size is set.
+var responseCache *mutationCache
+
+// cachedMutation is a mutated response and what the handler would have recorded while mutating it.
+type cachedMutation struct {
+	key  string
+	at   time.Time
+	body []byte
+	// changed is the number of listeners given the filter or clusters added.
+	changed int
+	// listeners and authz are the listener count and whether any listener has the filter, for LDS.
+	listeners int
+	authz     bool
+
+	// node is the sidecar an LDS response was mutated for, and nodeEl its place in the node's own LRU.
+	node   string
+	nodeEl *list.Element
+}
+
+// mutationCache is an LRU of mutated responses whose entries expire after ttl, if set.  Entries are keyed by config
+// version, and the cache is cleared once the version changes, so a change to the webhook's config invalidates them
+// all.  LDS entries are also bounded per node, so that a node whose listeners churn cannot evict everyone else's.
+type mutationCache struct {
+	size    int
+	perNode int
+	ttl     time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	version uint64
+	order   *list.List
+	entries map[string]*list.Element
+	nodes   map[string]*list.List
+}
+
+func newMutationCache(size, perNode int, ttl time.Duration) *mutationCache {
+	return &mutationCache{
+		size:    size,
+		perNode: perNode,
+		ttl:     ttl,
+		now:     time.Now,
+		version: currentConfigVersion(),
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		nodes:   make(map[string]*list.List),
+	}
+}
+
+// mutationCacheKey identifies a request by hook, config version, the class of node, i.e. everything other than the
+// body and config the mutation depends on, and a hash of the body.  The version must be read before mutating, so that
+// a response mutated across a change is never cached as if it were current.
+func mutationCacheKey(hook, class string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf("%s|%d|%s|%s", hook, currentConfigVersion(), class, hex.EncodeToString(sum[:]))
+}
+
+// listenersNodeClass is the class of an LDS request: the node's IP, which listeners are classified by, its pod, which
+// the metadata classifier and --cni-compat classify them by, and its profile.  Other state the profile does not
+// capture, such as the DNS service's ports, bumps the config version.
+func listenersNodeClass(ip string, profile hookProfile) string {
+	sniffing := protocolSniffing != nil && protocolSniffing.inboundEnabled()
+	return fmt.Sprintf("%s|%s|%t|%+v", ip, podVersion(ip), sniffing, profile)
+}
+
+// podVersion identifies the version of the pod with the given IP, so that a change to it, such as to its ports or
+// annotations, is a new class rather than a change to every class, as bumping the config version would be.
+func podVersion(ip string) string {
+	if pods == nil {
+		return ""
+	}
+	pod, err := pods.byIP(ip)
+	if err != nil || pod == nil {
+		return ""
+	}
+	return pod.Namespace + "/" + pod.Name + "/" + string(pod.UID) + "/" + pod.ResourceVersion
+}
+
+// mutatorsNodeClass returns the class of a request given the class the authz mutator's changes depend on.  What the
+// other registered mutators depend on is unknown, so with any registered the class is the whole service cluster and
+// node.
+func mutatorsNodeClass(req *restful.Request, class string) string {
+	if onlyAuthz() {
+		return class
+	}
+	return class + "|" + req.PathParameter("serviceCluster") + "|" + req.PathParameter("serviceNode")
+}
+
+func (c *mutationCache) get(hook, key string) (cachedMutation, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if ok && c.ttl > 0 && c.now().Sub(el.Value.(*cachedMutation).at) >= c.ttl {
+		c.remove(el)
+		ok = false
+	}
+	if !ok {
+		responseCacheRequests.WithLabelValues(hook, "miss").Inc()
+		return cachedMutation{}, false
+	}
+	responseCacheRequests.WithLabelValues(hook, "hit").Inc()
+	m := el.Value.(*cachedMutation)
+	c.order.MoveToFront(el)
+	if m.nodeEl != nil {
+		c.nodes[m.node].MoveToFront(m.nodeEl)
+	}
+	return *m, true
+}
+
+// store caches a mutation for node, which is empty for responses shared between nodes.  The body is copied, since
+// responses are encoded into pooled buffers.
+func (c *mutationCache) store(key, node string, m cachedMutation) {
+	m.key = key
+	m.at = c.now()
+	m.body = append([]byte(nil), m.body...)
+	m.node = node
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if v := currentConfigVersion(); v != c.version {
+		// Entries for older versions can never be hit again.
+		c.version = v
+		c.order.Init()
+		c.entries = make(map[string]*list.Element)
+		c.nodes = make(map[string]*list.List)
+	}
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.order.PushFront(&m)
+	if node != "" && c.perNode > 0 {
+		l := c.nodes[node]
+		if l == nil {
+			l = list.New()
+			c.nodes[node] = l
+		}
+		m.nodeEl = l.PushFront(c.entries[key])
+		if l.Len() > c.perNode {
+			c.remove(l.Back().Value.(*list.Element))
+		}
+	}
+	if c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *mutationCache) remove(el *list.Element) {
+	m := el.Value.(*cachedMutation)
+	c.order.Remove(el)
+	delete(c.entries, m.key)
+	if m.nodeEl != nil {
+		l := c.nodes[m.node]
+		l.Remove(m.nodeEl)
+		if l.Len() == 0 {
+			delete(c.nodes, m.node)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestMutationCacheExpiry(t *testing.T) {
+	RegisterTestingT(t)
+
+	now := time.Unix(1000, 0)
+	c := newMutationCache(2, 0, time.Minute)
+	c.now = func() time.Time { return now }
+
+	body := []byte("mutated")
+	c.store("a", "", cachedMutation{body: body, changed: 1})
+	body[0] = 'X'
+	m, ok := c.get("listeners", "a")
+	Expect(ok).To(BeTrue())
+	Expect(string(m.body)).To(Equal("mutated"))
+	Expect(m.changed).To(Equal(1))
+
+	now = now.Add(time.Minute)
+	_, ok = c.get("listeners", "a")
+	Expect(ok).To(BeFalse())
+	Expect(c.order.Len()).To(Equal(0))
+}
+
+func TestMutationCacheEviction(t *testing.T) {
+	RegisterTestingT(t)
+
+	c := newMutationCache(2, 0, time.Minute)
+	c.store("a", "", cachedMutation{})
+	c.store("b", "", cachedMutation{})
+	_, ok := c.get("listeners", "a")
+	Expect(ok).To(BeTrue())
+	c.store("c", "", cachedMutation{})
+
+	_, ok = c.get("listeners", "b")
+	Expect(ok).To(BeFalse())
+	_, ok = c.get("listeners", "a")
+	Expect(ok).To(BeTrue())
+	_, ok = c.get("listeners", "c")
+	Expect(ok).To(BeTrue())
+}
+
+func TestMutationCachePerNode(t *testing.T) {
+	RegisterTestingT(t)
+
+	c := newMutationCache(10, 2, 0)
+	c.store("a1", "a", cachedMutation{})
+	c.store("a2", "a", cachedMutation{})
+	c.store("b1", "b", cachedMutation{})
+	c.store("shared", "", cachedMutation{})
+	_, ok := c.get("listeners", "a1")
+	Expect(ok).To(BeTrue())
+	c.store("a3", "a", cachedMutation{})
+
+	// Only node a's least recently used entry is evicted, and with no TTL nothing expires.
+	_, ok = c.get("listeners", "a2")
+	Expect(ok).To(BeFalse())
+	for _, key := range []string{"a1", "a3", "b1", "shared"} {
+		_, ok = c.get("listeners", key)
+		Expect(ok).To(BeTrue(), key)
+	}
+	Expect(c.nodes["a"].Len()).To(Equal(2))
+
+	c.store("a3", "a", cachedMutation{changed: 1})
+	m, _ := c.get("listeners", "a3")
+	Expect(m.changed).To(Equal(1))
+	Expect(c.nodes["a"].Len()).To(Equal(2))
+	Expect(c.order.Len()).To(Equal(4))
+}
+
+func TestMutationCacheConfigVersion(t *testing.T) {
+	RegisterTestingT(t)
+
+	c := newMutationCache(10, 0, 0)
+	a := mutationCacheKey("clusters", "class", []byte("{}"))
+	c.store(a, "", cachedMutation{})
+	bumpConfigVersion("test")
+
+	b := mutationCacheKey("clusters", "class", []byte("{}"))
+	Expect(b).NotTo(Equal(a))
+	_, ok := c.get("clusters", b)
+	Expect(ok).To(BeFalse())
+	c.store(b, "", cachedMutation{})
+	Expect(c.order.Len()).To(Equal(1))
+	_, ok = c.get("clusters", a)
+	Expect(ok).To(BeFalse())
+}
+
+func TestDNSServiceChangeBumpsConfigVersion(t *testing.T) {
+	RegisterTestingT(t)
+
+	s := &dnsPortSet{key: "kube-system/kube-dns"}
+	v := currentConfigVersion()
+	s.changed(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "kube-system"}})
+	Expect(currentConfigVersion()).To(Equal(v))
+	s.changed(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "kube-dns", Namespace: "kube-system"}})
+	Expect(currentConfigVersion()).To(Equal(v + 1))
+	s.changed(cache.DeletedFinalStateUnknown{Key: "kube-system/kube-dns"})
+	Expect(currentConfigVersion()).To(Equal(v + 2))
+}
+
+func TestMutationCacheKey(t *testing.T) {
+	RegisterTestingT(t)
+
+	profile := profileFor(istioVersion{})
+	a := mutationCacheKey("listeners", listenersNodeClass("1.2.3.4", profile), []byte("{}"))
+	Expect(mutationCacheKey("listeners", listenersNodeClass("1.2.3.4", profile), []byte("{}"))).To(Equal(a))
+	Expect(mutationCacheKey("listeners", listenersNodeClass("1.2.3.5", profile), []byte("{}"))).NotTo(Equal(a))
+	Expect(mutationCacheKey("listeners", listenersNodeClass("1.2.3.4", profile), []byte("[]"))).NotTo(Equal(a))
+	profile.FailOpen = true
+	Expect(mutationCacheKey("listeners", listenersNodeClass("1.2.3.4", profile), []byte("{}"))).NotTo(Equal(a))
+}
+
+func TestListenersNodeClassPod(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { pods = nil }()
+	profile := profileFor(istioVersion{})
+	pod := testPod("testpod", "1.2.3.4", corev1.PodRunning, nil)
+	pod.ResourceVersion = "1"
+	pods = newTestPodIndex(pod)
+	a := listenersNodeClass("1.2.3.4", profile)
+	Expect(listenersNodeClass("1.2.3.4", profile)).To(Equal(a))
+
+	// An update to the pod, e.g. adding a container port, is a new class.
+	updated := pod.DeepCopy()
+	updated.ResourceVersion = "2"
+	pods = newTestPodIndex(updated)
+	Expect(listenersNodeClass("1.2.3.4", profile)).NotTo(Equal(a))
+}
+
+func TestListenersCached(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { responseCache = nil }()
+	responseCache = newMutationCache(10, 0, time.Minute)
+
+	serve := func() (string, *hookStats) {
+		req := newLDSRequest("sidecar", strings.NewReader(ldsWithUnknownFields))
+		recorder := httptest.NewRecorder()
+		listeners(req, restful.NewResponse(recorder))
+		return recorder.Body.String(), statsFor(req)
+	}
+	first, stats := serve()
+	Expect(stats.Injected).To(Equal(2))
+	Expect(responseCache.order.Len()).To(Equal(1))
+
+	second, stats := serve()
+	Expect(second).To(Equal(first))
+	Expect(stats.Injected).To(Equal(2))
+	Expect(stats.Listeners).To(Equal(3))
+}
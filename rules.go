@@ -0,0 +1,324 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+// RulesMutatorName is the name the --rules mutator is registered under.
+const RulesMutatorName = "rules"
+
+// Rules are applied to listeners, or to their network or HTTP filters, as EnvoyFilter config patches are.
+const (
+	ApplyToListener      = "LISTENER"
+	ApplyToNetworkFilter = "NETWORK_FILTER"
+	ApplyToHTTPFilter    = "HTTP_FILTER"
+)
+
+// Rule patch operations.
+const (
+	RuleMerge        = "MERGE"
+	RuleInsertBefore = "INSERT_BEFORE"
+	RuleInsertAfter  = "INSERT_AFTER"
+	RuleRemove       = "REMOVE"
+)
+
+// rule patches the LDS responses of the nodes and listeners it matches, in the manner of an EnvoyFilter config patch.
+type rule struct {
+	Name    string    `json:"name"`
+	ApplyTo string    `json:"applyTo"`
+	Match   ruleMatch `json:"match"`
+	Patch   rulePatch `json:"patch"`
+
+	value interface{}
+}
+
+// ruleMatch selects what a rule patches.  A rule applies where every field that is set matches; unset fields match
+// anything.  Nodes are selected as meshes select them, and also by type.
+type ruleMatch struct {
+	nodeSelector
+	// NodeType is the node's type, e.g. sidecar or router.
+	NodeType string `json:"nodeType,omitempty"`
+	// Listener is a glob pattern matched against the listener name.
+	Listener string `json:"listener,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	// Filter is the name of the network or HTTP filter a filter rule patches, or inserts before or after.  Without
+	// it, a merge or removal applies to every filter, and an insertion is made before the first or after the last.
+	Filter string `json:"filter,omitempty"`
+}
+
+type rulePatch struct {
+	Operation string `json:"operation"`
+	// Value is the JSON merge patch (RFC 7386) to merge, or the filter to insert.
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ruleMutator applies the --rules, in order, as a registered mutator.
+type ruleMutator struct {
+	mutator.Passthrough
+	rules []rule
+}
+
+func loadRules(file string) (*ruleMutator, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseRules(b)
+}
+
+func parseRules(b []byte) (*ruleMutator, error) {
+	var rules []rule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		r := &rules[i]
+		switch r.ApplyTo {
+		case ApplyToListener:
+			if r.Patch.Operation != RuleMerge && r.Patch.Operation != RuleRemove {
+				return nil, fmt.Errorf("rule %q: listeners can only be merged or removed", r.Name)
+			}
+			if r.Match.Filter != "" {
+				return nil, fmt.Errorf("rule %q: a listener rule cannot match a filter", r.Name)
+			}
+		case ApplyToNetworkFilter, ApplyToHTTPFilter:
+			switch r.Patch.Operation {
+			case RuleMerge, RuleInsertBefore, RuleInsertAfter, RuleRemove:
+			default:
+				return nil, fmt.Errorf("rule %q: unknown operation %q", r.Name, r.Patch.Operation)
+			}
+		default:
+			return nil, fmt.Errorf("rule %q: applyTo must be %s, %s or %s", r.Name, ApplyToListener,
+				ApplyToNetworkFilter, ApplyToHTTPFilter)
+		}
+		if err := r.Match.validate(); err != nil {
+			return nil, fmt.Errorf("rule %q: %v", r.Name, err)
+		}
+		if _, err := path.Match(r.Match.Listener, ""); err != nil {
+			return nil, fmt.Errorf("rule %q: invalid listener pattern %q", r.Name, r.Match.Listener)
+		}
+		if r.Patch.Operation == RuleRemove {
+			continue
+		}
+		// Numbers are decoded as listeners are, so that a merge can tell whether it changes them.
+		d := json.NewDecoder(bytes.NewReader(r.Patch.Value))
+		d.UseNumber()
+		if err := d.Decode(&r.value); err != nil {
+			return nil, fmt.Errorf("rule %q: invalid value: %v", r.Name, err)
+		}
+		if _, ok := r.value.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("rule %q: value must be an object", r.Name)
+		}
+	}
+	return &ruleMutator{rules: rules}, nil
+}
+
+func (m *ruleMutator) Name() string { return RulesMutatorName }
+
+// matchesNode reports whether a rule applies to a request's node.
+func (r *rule) matchesNode(req *mutator.Request) bool {
+	if r.Match.NodeType != "" && r.Match.NodeType != req.Node.Type {
+		return false
+	}
+	return r.Match.matches(req.ServiceCluster, req.Node.Domain)
+}
+
+// matchesListener reports whether a rule applies to a listener.
+func (r *rule) matchesListener(listener map[string]interface{}) bool {
+	name, _ := listener["name"].(string)
+	if r.Match.Listener != "" {
+		if ok, _ := path.Match(r.Match.Listener, name); !ok {
+			return false
+		}
+	}
+	if r.Match.Port != 0 {
+		address, _ := listener["address"].(string)
+		if port, ok := mutator.ListenerPort(name, address); !ok || port != r.Match.Port {
+			return false
+		}
+	}
+	return true
+}
+
+// Listeners applies the rules to each listener of the node that they match.  Only listeners a rule changes are
+// re-encoded.
+func (m *ruleMutator) Listeners(req *mutator.Request, body []byte) ([]byte, []string, error) {
+	var rules []*rule
+	for i := range m.rules {
+		if m.rules[i].matchesNode(req) {
+			rules = append(rules, &m.rules[i])
+		}
+	}
+	if len(rules) == 0 {
+		return body, nil, nil
+	}
+	x, err := mutator.DecodeXDS(body, "listeners")
+	if err != nil {
+		return nil, nil, err
+	}
+	var changed []string
+	items := x.Items[:0]
+	for _, item := range x.Items {
+		d := json.NewDecoder(bytes.NewReader(item))
+		d.UseNumber()
+		var listener map[string]interface{}
+		if err := d.Decode(&listener); err != nil {
+			return nil, nil, err
+		}
+		name, _ := listener["name"].(string)
+		patched, removed := false, false
+		for _, r := range rules {
+			if !r.matchesListener(listener) {
+				continue
+			}
+			var ok bool
+			if listener, ok, err = r.apply(listener); err != nil {
+				return nil, nil, fmt.Errorf("rule %q on listener %q: %v", r.Name, name, err)
+			}
+			patched = patched || ok
+			if listener == nil {
+				removed = true
+				break
+			}
+		}
+		if patched {
+			changed = append(changed, "listener/"+name)
+		}
+		if removed {
+			continue
+		}
+		if patched {
+			if item, err = json.Marshal(listener); err != nil {
+				return nil, nil, err
+			}
+		}
+		items = append(items, item)
+	}
+	if len(changed) == 0 {
+		return body, nil, nil
+	}
+	x.Items = items
+	var buf bytes.Buffer
+	x.EncodeTo(&buf)
+	return buf.Bytes(), changed, nil
+}
+
+// apply patches a listener, returning it, or nil if the rule removes it, and whether it changed.  A merge that leaves
+// the listener as it was is not a change.
+func (r *rule) apply(listener map[string]interface{}) (map[string]interface{}, bool, error) {
+	switch r.ApplyTo {
+	case ApplyToListener:
+		if r.Patch.Operation == RuleRemove {
+			return nil, true, nil
+		}
+		if !mergeChanges(listener, r.value) {
+			return listener, false, nil
+		}
+		return mergePatch(listener, r.value).(map[string]interface{}), true, nil
+	case ApplyToNetworkFilter:
+		filters, ok := r.patchFilters(listener["filters"])
+		if ok {
+			listener["filters"] = filters
+		}
+		return listener, ok, nil
+	}
+	hcm := findFilter(listener["filters"], v1.HTTPConnectionManager)
+	if hcm == nil {
+		// Only HTTP listeners have HTTP filters.
+		return listener, false, nil
+	}
+	cfg, ok := hcm["config"].(map[string]interface{})
+	if !ok {
+		return nil, false, mutator.ErrNoHTTPConnectionManager
+	}
+	filters, ok := r.patchFilters(cfg["filters"])
+	if ok {
+		cfg["filters"] = filters
+	}
+	return listener, ok, nil
+}
+
+// patchFilters applies a filter rule to a list of filters, returning the list and whether it changed.
+func (r *rule) patchFilters(list interface{}) ([]interface{}, bool) {
+	filters, _ := list.([]interface{})
+	var out []interface{}
+	changed := false
+	for i, f := range filters {
+		filter, _ := f.(map[string]interface{})
+		name, _ := filter["name"].(string)
+		match := r.Match.Filter == "" || name == r.Match.Filter
+		switch r.Patch.Operation {
+		case RuleInsertBefore:
+			if r.Match.Filter == "" && i == 0 || r.Match.Filter != "" && match {
+				out = append(out, r.newFilter())
+				changed = true
+			}
+			out = append(out, f)
+		case RuleInsertAfter:
+			out = append(out, f)
+			if r.Match.Filter == "" && i == len(filters)-1 || r.Match.Filter != "" && match {
+				out = append(out, r.newFilter())
+				changed = true
+			}
+		case RuleMerge:
+			if match && mergeChanges(filter, r.value) {
+				f = mergePatch(filter, r.value)
+				changed = true
+			}
+			out = append(out, f)
+		case RuleRemove:
+			if match {
+				changed = true
+				continue
+			}
+			out = append(out, f)
+		}
+	}
+	if len(filters) == 0 && r.Match.Filter == "" &&
+		(r.Patch.Operation == RuleInsertBefore || r.Patch.Operation == RuleInsertAfter) {
+		out = append(out, r.newFilter())
+		changed = true
+	}
+	if out == nil {
+		out = []interface{}{}
+	}
+	return out, changed
+}
+
+// newFilter returns a copy of the filter a rule inserts, so that listeners do not share it.
+func (r *rule) newFilter() interface{} {
+	return mergePatch(nil, r.value)
+}
+
+// findFilter returns the named filter in a list of filters, or nil.
+func findFilter(list interface{}, name string) map[string]interface{} {
+	filters, _ := list.([]interface{})
+	for _, f := range filters {
+		if filter, ok := f.(map[string]interface{}); ok && filter["name"] == name {
+			return filter
+		}
+	}
+	return nil
+}
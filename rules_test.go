@@ -0,0 +1,146 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+const testRulesLDS = `{"listeners":[
+  {"name":"http_10.0.0.1_80","address":"tcp://10.0.0.1:80","filters":[
+    {"type":"read","name":"http_connection_manager","config":{"filters":[
+      {"type":"decoder","name":"cors","config":{}},
+      {"type":"decoder","name":"router","config":{}}]}}]},
+  {"name":"tcp_10.0.0.1_3306","address":"tcp://10.0.0.1:3306","filters":[
+    {"type":"read","name":"tcp_proxy","config":{"stat_prefix":"tcp"}}]}]}`
+
+func applyRules(rules string, node string) ([]byte, []string, error) {
+	m, err := parseRules([]byte(rules))
+	Expect(err).To(BeNil())
+	req := &mutator.Request{ServiceCluster: SERVICE_CLUSTER, Node: config.ParseNode(node)}
+	return m.Listeners(req, []byte(testRulesLDS))
+}
+
+func TestParseRulesInvalid(t *testing.T) {
+	RegisterTestingT(t)
+
+	for _, body := range []string{
+		`[{"name": "bad", "applyTo": "CLUSTER", "patch": {"operation": "MERGE", "value": {}}}]`,
+		`[{"name": "bad", "applyTo": "LISTENER", "patch": {"operation": "INSERT_BEFORE", "value": {}}}]`,
+		`[{"name": "bad", "applyTo": "LISTENER", "match": {"filter": "x"}, "patch": {"operation": "REMOVE"}}]`,
+		`[{"name": "bad", "applyTo": "HTTP_FILTER", "patch": {"operation": "REPLACE", "value": {}}}]`,
+		`[{"name": "bad", "applyTo": "HTTP_FILTER", "patch": {"operation": "MERGE", "value": [1]}}]`,
+		`[{"name": "bad", "applyTo": "LISTENER", "match": {"listener": "["}, "patch": {"operation": "REMOVE"}}]`,
+		`not JSON`,
+	} {
+		_, err := parseRules([]byte(body))
+		Expect(err).NotTo(BeNil(), body)
+	}
+}
+
+func TestRulesHTTPFilters(t *testing.T) {
+	RegisterTestingT(t)
+
+	out, changed, err := applyRules(`[
+	  {"name": "lua", "applyTo": "HTTP_FILTER", "match": {"port": 80, "filter": "router"},
+	   "patch": {"operation": "INSERT_BEFORE", "value": {"type": "decoder", "name": "lua", "config": {}}}},
+	  {"name": "no-cors", "applyTo": "HTTP_FILTER", "match": {"filter": "cors"}, "patch": {"operation": "REMOVE"}}
+	]`, serviceNode("sidecar", NODE_IP))
+	Expect(err).To(BeNil())
+	Expect(changed).To(Equal([]string{"listener/http_10.0.0.1_80"}))
+	Expect(out).To(MatchJSON(`{"listeners":[
+	  {"name":"http_10.0.0.1_80","address":"tcp://10.0.0.1:80","filters":[
+	    {"type":"read","name":"http_connection_manager","config":{"filters":[
+	      {"type":"decoder","name":"lua","config":{}},
+	      {"type":"decoder","name":"router","config":{}}]}}]},
+	  {"name":"tcp_10.0.0.1_3306","address":"tcp://10.0.0.1:3306","filters":[
+	    {"type":"read","name":"tcp_proxy","config":{"stat_prefix":"tcp"}}]}]}`))
+}
+
+func TestRulesNetworkFilters(t *testing.T) {
+	RegisterTestingT(t)
+
+	out, changed, err := applyRules(`[
+	  {"name": "prefix", "applyTo": "NETWORK_FILTER", "match": {"listener": "tcp_*", "filter": "tcp_proxy"},
+	   "patch": {"operation": "MERGE", "value": {"config": {"stat_prefix": "mysql"}}}},
+	  {"name": "first", "applyTo": "NETWORK_FILTER", "match": {"listener": "tcp_*"},
+	   "patch": {"operation": "INSERT_BEFORE", "value": {"type": "read", "name": "mysql_proxy", "config": {}}}}
+	]`, serviceNode("sidecar", NODE_IP))
+	Expect(err).To(BeNil())
+	Expect(changed).To(Equal([]string{"listener/tcp_10.0.0.1_3306"}))
+	Expect(out).To(ContainSubstring(
+		`"filters":[{"config":{},"name":"mysql_proxy","type":"read"},` +
+			`{"config":{"stat_prefix":"mysql"},"name":"tcp_proxy","type":"read"}]`))
+}
+
+func TestRulesListeners(t *testing.T) {
+	RegisterTestingT(t)
+
+	rules := `[
+	  {"name": "drop-mysql", "applyTo": "LISTENER", "match": {"nodeType": "sidecar", "port": 3306},
+	   "patch": {"operation": "REMOVE"}},
+	  {"name": "buffer", "applyTo": "LISTENER", "match": {"serviceClusters": ["` + SERVICE_CLUSTER + `"]},
+	   "patch": {"operation": "MERGE", "value": {"per_connection_buffer_limit_bytes": 1024}}}
+	]`
+	out, changed, err := applyRules(rules, serviceNode("sidecar", NODE_IP))
+	Expect(err).To(BeNil())
+	Expect(changed).To(Equal([]string{"listener/http_10.0.0.1_80", "listener/tcp_10.0.0.1_3306"}))
+	Expect(out).To(ContainSubstring(`"per_connection_buffer_limit_bytes":1024`))
+	Expect(out).NotTo(ContainSubstring(`tcp_10.0.0.1_3306`))
+
+	// Routers only match the rule without a node type.
+	out, changed, err = applyRules(rules, serviceNode("router", NODE_IP))
+	Expect(err).To(BeNil())
+	Expect(changed).To(HaveLen(2))
+	Expect(out).To(ContainSubstring(`tcp_10.0.0.1_3306`))
+
+	// Nodes no rule matches get the response as it was.
+	out, changed, err = applyRules(`[{"name": "x", "applyTo": "LISTENER", "match": {"domain": "west.local"},
+	  "patch": {"operation": "REMOVE"}}]`, serviceNode("sidecar", NODE_IP))
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeEmpty())
+	Expect(string(out)).To(Equal(testRulesLDS))
+}
+
+func TestRulesNoOpMerge(t *testing.T) {
+	RegisterTestingT(t)
+
+	// Merging what a listener or filter already has changes nothing.
+	out, changed, err := applyRules(`[
+	  {"name": "same", "applyTo": "LISTENER", "match": {"port": 80}, "patch": {"operation": "MERGE",
+	   "value": {"address": "tcp://10.0.0.1:80"}}},
+	  {"name": "same-prefix", "applyTo": "NETWORK_FILTER", "match": {"filter": "tcp_proxy"},
+	   "patch": {"operation": "MERGE", "value": {"config": {"stat_prefix": "tcp", "unset": null}}}}
+	]`, serviceNode("sidecar", NODE_IP))
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeEmpty())
+	Expect(string(out)).To(Equal(testRulesLDS))
+}
+
+func TestRulesMatchEveryField(t *testing.T) {
+	RegisterTestingT(t)
+
+	// The service cluster matches but the domain does not.
+	_, changed, err := applyRules(`[{"name": "x", "applyTo": "LISTENER",
+	  "match": {"serviceClusters": ["`+SERVICE_CLUSTER+`"], "domain": "west.local"}, "patch": {"operation": "REMOVE"}}]`,
+		serviceNode("sidecar", NODE_IP))
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeEmpty())
+}
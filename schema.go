@@ -0,0 +1,219 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// outputSchemas checks mutated responses against the xDS schemas before they are sent.  It is nil unless
+// --validate-output is set.
+var outputSchemas map[string]*jsonSchema
+
+// jsonSchema is the subset of JSON Schema the response schemas use.  Unknown fields are allowed everywhere, as Pilot
+// sends fields the webhook does not know about and it must pass them through.
+type jsonSchema struct {
+	Type       string                 `json:"type"`
+	Required   []string               `json:"required"`
+	Properties map[string]*jsonSchema `json:"properties"`
+	Items      *jsonSchema            `json:"items"`
+	Enum       []interface{}          `json:"enum"`
+	MinLength  int                    `json:"minLength"`
+}
+
+// The schemas describe the v1 xDS responses as far as the webhook's changes could break them: the fields Envoy
+// requires of listeners, filters and clusters, and their types.
+const (
+	httpFilterSchema = `{
+  "type": "object", "required": ["name", "config"],
+  "properties": {
+    "type": {"type": "string", "enum": ["decoder", "encoder", "both"]},
+    "name": {"type": "string", "minLength": 1},
+    "config": {"type": "object"}
+  }
+}`
+
+	// networkFilterSchema covers the HTTP filters of connection managers too, which other filters do not have.
+	networkFilterSchema = `{
+  "type": "object", "required": ["name", "config"],
+  "properties": {
+    "type": {"type": "string", "enum": ["read", "write", "both"]},
+    "name": {"type": "string", "minLength": 1},
+    "config": {"type": "object", "properties": {
+      "filters": {"type": "array", "items": ` + httpFilterSchema + `}
+    }}
+  }
+}`
+
+	ldsSchema = `{
+  "type": "object", "required": ["listeners"],
+  "properties": {"listeners": {"type": "array", "items": {
+    "type": "object", "required": ["name", "address", "filters"],
+    "properties": {
+      "name": {"type": "string", "minLength": 1},
+      "address": {"type": "string", "minLength": 1},
+      "bind_to_port": {"type": "boolean"},
+      "use_original_dst": {"type": "boolean"},
+      "filters": {"type": "array", "items": ` + networkFilterSchema + `}
+    }
+  }}}
+}`
+
+	cdsSchema = `{
+  "type": "object", "required": ["clusters"],
+  "properties": {"clusters": {"type": "array", "items": {
+    "type": "object", "required": ["name", "type", "connect_timeout_ms", "lb_type"],
+    "properties": {
+      "name": {"type": "string", "minLength": 1},
+      "type": {"type": "string", "enum": ["static", "strict_dns", "logical_dns", "sds", "original_dst"]},
+      "connect_timeout_ms": {"type": "number"},
+      "lb_type": {"type": "string"},
+      "features": {"type": "string"},
+      "hosts": {"type": "array", "items": {"type": "object", "required": ["url"], "properties": {
+        "url": {"type": "string", "minLength": 1}
+      }}}
+    }
+  }}}
+}`
+
+	rdsSchema = `{
+  "type": "object",
+  "properties": {"virtual_hosts": {"type": "array", "items": {
+    "type": "object", "required": ["name", "domains", "routes"],
+    "properties": {
+      "name": {"type": "string", "minLength": 1},
+      "domains": {"type": "array", "items": {"type": "string"}},
+      "routes": {"type": "array", "items": {"type": "object"}}
+    }
+  }}}
+}`
+)
+
+// loadOutputSchemas parses the response schemas of the hooks that mutate their config.
+func loadOutputSchemas() (map[string]*jsonSchema, error) {
+	schemas := make(map[string]*jsonSchema)
+	for hook, s := range map[string]string{"listeners": ldsSchema, "clusters": cdsSchema, "routes": rdsSchema} {
+		var schema jsonSchema
+		if err := json.Unmarshal([]byte(s), &schema); err != nil {
+			return nil, fmt.Errorf("%s schema: %v", hook, err)
+		}
+		schemas[hook] = &schema
+	}
+	return schemas, nil
+}
+
+// validateOutput checks a hook's response against its schema, if it has one.
+func validateOutput(schemas map[string]*jsonSchema, hook string, body []byte) error {
+	schema, ok := schemas[hook]
+	if !ok {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return err
+	}
+	return schema.validate("$", v)
+}
+
+// checkOutput validates a mutated response for --validate-output, reporting it if it does not match its schema, in
+// which case the request should be passed through unmutated.
+func checkOutput(hook, serviceNode string, body []byte) bool {
+	if outputSchemas == nil {
+		return true
+	}
+	if err := validateOutput(outputSchemas, hook, body); err != nil {
+		reportError(hook, ErrorClassSchema, log.Fields{"serviceNode": serviceNode, "err": err},
+			"mutated response does not match the xDS schema")
+		return false
+	}
+	return true
+}
+
+// validate returns an error naming the first place v does not match the schema.
+func (s *jsonSchema) validate(path string, v interface{}) error {
+	if s.Type != "" && jsonType(v) != s.Type && !(s.Type == "number" && jsonType(v) == "integer") {
+		return fmt.Errorf("%s: expected %s, found %s", path, s.Type, jsonType(v))
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			found = found || e == v
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", path, v, s.Enum)
+		}
+	}
+	switch v := v.(type) {
+	case string:
+		if len(v) < s.MinLength {
+			return fmt.Errorf("%s: shorter than %d", path, s.MinLength)
+		}
+	case map[string]interface{}:
+		for _, k := range s.Required {
+			if _, ok := v[k]; !ok {
+				return fmt.Errorf("%s: missing %q", path, k)
+			}
+		}
+		// Sorted, so that the same error is reported every time.
+		keys := make([]string, 0, len(s.Properties))
+		for k := range s.Properties {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if field, ok := v[k]; ok && field != nil {
+				if err := s.Properties[k].validate(path+"."+k, field); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if s.Items == nil {
+			return nil
+		}
+		for i, item := range v {
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return strings.ToLower(fmt.Sprintf("%T", v))
+}
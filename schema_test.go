@@ -0,0 +1,98 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pilottest"
+)
+
+func TestOutputSchemas(t *testing.T) {
+	RegisterTestingT(t)
+
+	schemas, err := loadOutputSchemas()
+	Expect(err).To(BeNil())
+	for _, tc := range []struct {
+		hook, body, err string
+	}{
+		{"listeners", `{"listeners":[]}`, ""},
+		{"listeners", `{}`, `$: missing "listeners"`},
+		{"listeners", `{"listeners":[{"name":"a","address":"tcp://1.2.3.4:80","filters":[]}]}`, ""},
+		{"listeners", `{"listeners":[{"name":"a","filters":[]}]}`, `$.listeners[0]: missing "address"`},
+		{"listeners", `{"listeners":[{"name":"a","address":"x","filters":[{"name":"f"}]}]}`,
+			`$.listeners[0].filters[0]: missing "config"`},
+		{"listeners", `{"listeners":[{"name":"a","address":"x","filters":[{"name":"f","config":{"filters":[` +
+			`{"type":"read","name":"g","config":{}}]}}]}]}`,
+			`$.listeners[0].filters[0].config.filters[0].type: read is not one of [decoder encoder both]`},
+		{"clusters", `{"clusters":[{"name":"c","type":"static","connect_timeout_ms":1000,"lb_type":"round_robin"}]}`, ""},
+		{"clusters", `{"clusters":[{"name":"c","type":"static","connect_timeout_ms":"1s","lb_type":"round_robin"}]}`,
+			`$.clusters[0].connect_timeout_ms: expected number, found string`},
+		{"clusters", `{"clusters":[{"name":"","type":"static","connect_timeout_ms":1,"lb_type":"round_robin"}]}`,
+			`$.clusters[0].name: shorter than 1`},
+		{"routes", `{"virtual_hosts":[{"name":"v","domains":["*"],"routes":[]}]}`, ""},
+		{"endpoints", `not even JSON`, ""},
+	} {
+		err := validateOutput(schemas, tc.hook, []byte(tc.body))
+		if tc.err == "" {
+			Expect(err).To(BeNil(), tc.body)
+		} else {
+			Expect(err).NotTo(BeNil(), tc.body)
+			Expect(err.Error()).To(Equal(tc.err))
+		}
+	}
+}
+
+func TestMutatedSidecarMatchesSchemas(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { dikastes = nil }()
+	dikastes = staticResolver("tcp://10.96.0.20:9000")
+	schemas, err := loadOutputSchemas()
+	Expect(err).To(BeNil())
+	s := pilottest.NewSidecar("10.2.0.1", pilottest.NewMesh(20))
+	lds := callHook("listeners", listeners, SERVICE_CLUSTER, s.Node(), bytes.NewReader(s.LDS()))
+	Expect(validateOutput(schemas, "listeners", lds.Body.Bytes())).To(Succeed())
+	cds := callHook("clusters", clusters, SERVICE_CLUSTER, s.Node(), bytes.NewReader(s.CDS()))
+	Expect(validateOutput(schemas, "clusters", cds.Body.Bytes())).To(Succeed())
+	Expect(validateOutput(schemas, "routes", s.RDS("8000"))).To(Succeed())
+}
+
+func TestValidateOutputPassesThrough(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { outputSchemas = nil }()
+	var err error
+	outputSchemas, err = loadOutputSchemas()
+	Expect(err).To(BeNil())
+
+	// An inbound listener without an address is still given the filter, but Envoy would reject it.
+	body := `{"listeners":[{"name":"tcp_` + NODE_IP + `_3306","filters":[` +
+		`{"type":"read","name":"tcp_proxy","config":{"stat_prefix":"tcp"}}]}]}`
+	req := newLDSRequest("sidecar", strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	listeners(req, restful.NewResponse(recorder))
+	Expect(recorder.Body.String()).To(Equal(body))
+
+	s := pilottest.NewSidecar(NODE_IP, pilottest.NewMesh(4))
+	recorder = callHook("listeners", listeners, SERVICE_CLUSTER, s.Node(), bytes.NewReader(s.LDS()))
+	Expect(recorder.Body.String()).To(ContainSubstring(AuthZFilterName))
+}
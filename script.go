@@ -0,0 +1,242 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+// ScriptsMutatorName is the name the --scripts mutator is registered under.
+const ScriptsMutatorName = "scripts"
+
+// script is a one-off customization: a JSON merge patch (RFC 7386) applied to each resource of a hook's response that
+// an expression matches.  See expr.go for the expression language.
+type script struct {
+	Name string `json:"name"`
+	// Hook is listeners, clusters, routes or endpoints.
+	Hook mutator.Hook `json:"hook"`
+	// Match is an expression over resource, node, hook and cluster that must be true for the resource to be patched.
+	// An empty match patches every resource.
+	Match string          `json:"match,omitempty"`
+	Patch json.RawMessage `json:"patch"`
+
+	match expr
+	patch interface{}
+}
+
+// scriptResources are the key each hook's response has its resources under, and their type in reported changes.
+var scriptResources = map[mutator.Hook]struct{ key, kind string }{
+	mutator.HookListeners: {"listeners", "listener"},
+	mutator.HookClusters:  {"clusters", "cluster"},
+	mutator.HookRoutes:    {"virtual_hosts", "virtual_host"},
+	mutator.HookEndpoints: {"hosts", "endpoint"},
+}
+
+// scriptMutator runs the --scripts, in order, as a registered mutator.
+type scriptMutator struct {
+	scripts []script
+}
+
+func loadScripts(file string) (*scriptMutator, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseScripts(b)
+}
+
+func parseScripts(b []byte) (*scriptMutator, error) {
+	var scripts []script
+	if err := json.Unmarshal(b, &scripts); err != nil {
+		return nil, err
+	}
+	for i := range scripts {
+		s := &scripts[i]
+		if _, ok := scriptResources[s.Hook]; !ok {
+			return nil, fmt.Errorf("script %q: unknown hook %q", s.Name, s.Hook)
+		}
+		if s.Match != "" {
+			var err error
+			if s.match, err = compileExpr(s.Match); err != nil {
+				return nil, fmt.Errorf("script %q: invalid match: %v", s.Name, err)
+			}
+		}
+		if err := json.Unmarshal(s.Patch, &s.patch); err != nil {
+			return nil, fmt.Errorf("script %q: invalid patch: %v", s.Name, err)
+		}
+		if _, ok := s.patch.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("script %q: patch must be an object", s.Name)
+		}
+	}
+	return &scriptMutator{scripts: scripts}, nil
+}
+
+func (m *scriptMutator) Name() string { return ScriptsMutatorName }
+
+func (m *scriptMutator) Listeners(req *mutator.Request, body []byte) ([]byte, []string, error) {
+	return m.run(mutator.HookListeners, req, body)
+}
+
+func (m *scriptMutator) Clusters(req *mutator.Request, body []byte) ([]byte, []string, error) {
+	return m.run(mutator.HookClusters, req, body)
+}
+
+func (m *scriptMutator) Routes(req *mutator.Request, body []byte) ([]byte, []string, error) {
+	return m.run(mutator.HookRoutes, req, body)
+}
+
+func (m *scriptMutator) Endpoints(req *mutator.Request, body []byte) ([]byte, []string, error) {
+	return m.run(mutator.HookEndpoints, req, body)
+}
+
+// run applies the hook's scripts to each resource in the response.  Only resources a script patches are re-encoded.
+// A match that fails to evaluate, e.g. on a field the resource does not have, counts as no match and is logged as a
+// warning, since a script whose match is not guarded with has() may be silently doing nothing.
+func (m *scriptMutator) run(hook mutator.Hook, req *mutator.Request, body []byte) ([]byte, []string, error) {
+	var scripts []*script
+	for i := range m.scripts {
+		if m.scripts[i].Hook == hook {
+			scripts = append(scripts, &m.scripts[i])
+		}
+	}
+	if len(scripts) == 0 {
+		return body, nil, nil
+	}
+	res := scriptResources[hook]
+	x, err := mutator.DecodeXDS(body, res.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	env := exprEnv{
+		"hook":    string(hook),
+		"cluster": req.ServiceCluster,
+		"node": map[string]interface{}{
+			"type":   req.Node.Type,
+			"ip":     req.Node.IP,
+			"id":     req.Node.ID,
+			"domain": req.Node.Domain,
+		},
+	}
+	var changed []string
+	for i, item := range x.Items {
+		d := json.NewDecoder(bytes.NewReader(item))
+		d.UseNumber()
+		var resource interface{}
+		if err := d.Decode(&resource); err != nil {
+			return nil, nil, err
+		}
+		env["resource"] = resource
+		patched := false
+		for _, s := range scripts {
+			if s.match != nil {
+				ok, err := s.match.evalBool(env)
+				if err != nil {
+					log.WithFields(log.Fields{
+						"script":   s.Name,
+						"resource": scriptResourceName(resource, i),
+						"err":      err,
+					}).Warn("Script match failed.")
+				}
+				if !ok {
+					continue
+				}
+			}
+			resource = mergePatch(resource, s.patch)
+			env["resource"] = resource
+			patched = true
+		}
+		if !patched {
+			continue
+		}
+		if x.Items[i], err = json.Marshal(resource); err != nil {
+			return nil, nil, err
+		}
+		changed = append(changed, res.kind+"/"+scriptResourceName(resource, i))
+	}
+	if len(changed) == 0 {
+		return body, nil, nil
+	}
+	var buf bytes.Buffer
+	x.EncodeTo(&buf)
+	return buf.Bytes(), changed, nil
+}
+
+// scriptResourceName names the i'th resource of a response in reported changes: by its name, an endpoint by its
+// address, or anything else by its index.
+func scriptResourceName(resource interface{}, i int) string {
+	obj, _ := resource.(map[string]interface{})
+	if name, ok := obj["name"].(string); ok {
+		return name
+	}
+	if addr, ok := obj["ip_address"].(string); ok {
+		return addr
+	}
+	return strconv.Itoa(i)
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386) to a decoded JSON document: objects are merged recursively, null
+// removes a field and anything else, including a list, replaces it.
+func mergePatch(doc, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		obj = make(map[string]interface{}, len(p))
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(obj, k)
+			continue
+		}
+		obj[k] = mergePatch(obj[k], v)
+	}
+	return obj
+}
+
+// mergeChanges reports whether applying a JSON merge patch to a decoded JSON document would change it.
+func mergeChanges(doc, patch interface{}) bool {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return !reflect.DeepEqual(doc, patch)
+	}
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return true
+	}
+	for k, v := range p {
+		old, set := obj[k]
+		if v == nil {
+			if set {
+				return true
+			}
+			continue
+		}
+		if !set || mergeChanges(old, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+const testScripts = `[
+  {"name": "idle-timeout", "hook": "clusters",
+   "match": "resource.name.startsWith('outbound|') && node.type == 'sidecar'",
+   "patch": {"idle_timeout_ms": 5000, "circuit_breakers": null}},
+  {"name": "all-routes", "hook": "routes", "patch": {"require_ssl": "all"}}
+]`
+
+func TestParseScriptsInvalid(t *testing.T) {
+	RegisterTestingT(t)
+
+	for _, body := range []string{
+		`[{"name": "bad", "hook": "secrets", "patch": {}}]`,
+		`[{"name": "bad", "hook": "clusters", "match": "resource.name ==", "patch": {}}]`,
+		`[{"name": "bad", "hook": "clusters", "patch": [1]}]`,
+		`not JSON`,
+	} {
+		_, err := parseScripts([]byte(body))
+		Expect(err).NotTo(BeNil(), body)
+	}
+}
+
+func TestScriptMutator(t *testing.T) {
+	RegisterTestingT(t)
+
+	m, err := parseScripts([]byte(testScripts))
+	Expect(err).To(BeNil())
+	req := &mutator.Request{Node: config.ParseNode(serviceNode("sidecar", NODE_IP))}
+
+	body := []byte(`{"clusters":[` +
+		`{"name":"outbound|80||a.default.svc.cluster.local","connect_timeout_ms":1000,"circuit_breakers":{"default":{}}},` +
+		`{"name":"inbound|80||a.default.svc.cluster.local","connect_timeout_ms":1000}]}`)
+	out, changed, err := m.Clusters(req, body)
+	Expect(err).To(BeNil())
+	Expect(changed).To(Equal([]string{"cluster/outbound|80||a.default.svc.cluster.local"}))
+	Expect(out).To(MatchJSON(`{"clusters":[` +
+		`{"name":"outbound|80||a.default.svc.cluster.local","connect_timeout_ms":1000,"idle_timeout_ms":5000},` +
+		`{"name":"inbound|80||a.default.svc.cluster.local","connect_timeout_ms":1000}]}`))
+
+	// Routers are not sidecars, so the match is false.
+	out, changed, err = m.Clusters(&mutator.Request{Node: config.ParseNode(serviceNode("router", NODE_IP))}, body)
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeEmpty())
+	Expect(out).To(Equal(body))
+
+	out, changed, err = m.Routes(req, []byte(`{"virtual_hosts":[{"name":"a","domains":["*"]}],"validate_clusters":true}`))
+	Expect(err).To(BeNil())
+	Expect(changed).To(Equal([]string{"virtual_host/a"}))
+	Expect(out).To(MatchJSON(
+		`{"virtual_hosts":[{"name":"a","domains":["*"],"require_ssl":"all"}],"validate_clusters":true}`))
+
+	// Resources without a name or address are reported by their index.
+	out, changed, err = m.Routes(req, []byte(`{"virtual_hosts":[{"domains":["a"]},{"domains":["b"]}]}`))
+	Expect(err).To(BeNil())
+	Expect(changed).To(Equal([]string{"virtual_host/0", "virtual_host/1"}))
+
+	// Hooks without scripts pass through untouched.
+	out, changed, err = m.Listeners(req, []byte(`not JSON`))
+	Expect(err).To(BeNil())
+	Expect(changed).To(BeEmpty())
+	Expect(string(out)).To(Equal(`not JSON`))
+}
+
+func TestMergePatch(t *testing.T) {
+	RegisterTestingT(t)
+
+	doc := map[string]interface{}{"a": "b", "c": map[string]interface{}{"d": "e", "f": "g"}, "l": []interface{}{1.0}}
+	patch := map[string]interface{}{
+		"a": "z", "c": map[string]interface{}{"f": nil}, "l": []interface{}{2.0}, "n": map[string]interface{}{"x": 1.0},
+	}
+	Expect(mergePatch(doc, patch)).To(Equal(map[string]interface{}{
+		"a": "z", "c": map[string]interface{}{"d": "e"}, "l": []interface{}{2.0}, "n": map[string]interface{}{"x": 1.0},
+	}))
+}
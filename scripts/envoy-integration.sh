@@ -0,0 +1,23 @@
+#!/bin/bash
+
+set -ex
+set -o errexit
+set -o nounset
+set -o pipefail
+
+# Run the Envoy tests against the istio/proxy image for an Istio version: validating the mutated config, then running
+# it in a real Envoy with a fake authz server.
+
+image="istio/proxy:0.8.0"
+
+while [[ $# -gt 0 ]]; do
+    case "$1" in
+        -image) image="$2"; shift ;;
+        *) ;;
+    esac
+    shift
+done
+
+go test -tags envoy -run Envoy -v -args \
+    -envoy-command "docker run --rm -v {dir}:{dir} ${image} /usr/local/bin/envoy --mode validate -c {config}" \
+    -envoy-run-command "docker run --rm --network host -v {dir}:{dir} ${image} /usr/local/bin/envoy -c {config}"
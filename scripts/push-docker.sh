@@ -21,8 +21,9 @@ while [[ $# -gt 0 ]]; do
     shift
 done
 
-# Collect artifacts for pushing
-CGO_ENABLED=0 GOOS=linux go build
+# Collect artifacts for pushing.  The image is built from scratch, so the binary is static, and cannot load
+# --mutator-plugins.
+CGO_ENABLED=0 GOOS=linux go build -ldflags "-X main.gitCommit=${git_commit}"
 
 # Build and push images
 
@@ -0,0 +1,211 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/emicklei/go-restful"
+)
+
+const selfTestCluster = "selftest"
+const selfTestNode = "sidecar~127.0.0.1~selftest.pilot-webhook~pilot-webhook.svc.cluster.local"
+
+// selfTestLDS has one listener of each kind the LDS hook distinguishes.
+const selfTestLDS = `{"listeners": [
+  {"name": "http_127.0.0.1_8080", "address": "tcp://127.0.0.1:8080", "bind_to_port": false, "filters": [
+    {"type": "read", "name": "http_connection_manager", "config": {
+      "codec_type": "auto", "stat_prefix": "http",
+      "filters": [{"type": "decoder", "name": "router", "config": {}}]}}]},
+  {"name": "tcp_127.0.0.1_3306", "address": "tcp://127.0.0.1:3306", "bind_to_port": false, "filters": [
+    {"type": "read", "name": "tcp_proxy", "config": {
+      "stat_prefix": "tcp", "route_config": {"routes": [{"cluster": "in.3306"}]}}}]},
+  {"name": "http_10.0.0.1_80", "address": "tcp://10.0.0.1:80", "bind_to_port": false, "filters": [
+    {"type": "read", "name": "http_connection_manager", "config": {
+      "codec_type": "auto", "stat_prefix": "http",
+      "filters": [{"type": "decoder", "name": "router", "config": {}}]}}]},
+  {"name": "virtual", "address": "tcp://0.0.0.0:15001", "bind_to_port": true, "use_original_dst": true, "filters": []}
+]}`
+
+const selfTestCDS = `{"clusters": [
+  {"name": "in.8080", "connect_timeout_ms": 1000, "type": "static", "lb_type": "round_robin",
+   "hosts": [{"url": "tcp://127.0.0.1:8080"}]}
+]}`
+
+// lastSelfTest holds the error from the most recent self-test for the readiness check, which does not rerun it
+// so that frequent probes do not skew the hook metrics.
+var lastSelfTest struct {
+	sync.Mutex
+	err error
+}
+
+// selfTestCheck is the result of one self-test case.
+type selfTestCheck struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// selfTestReport is the body of a /selftest response.
+type selfTestReport struct {
+	Passed bool            `json:"passed"`
+	Checks []selfTestCheck `json:"checks"`
+}
+
+// err summarizes the failed checks, or returns nil if all passed.
+func (r selfTestReport) err() error {
+	var failed []string
+	for _, c := range r.Checks {
+		if !c.Passed {
+			failed = append(failed, c.Name+": "+c.Error)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(failed, "; "))
+}
+
+// runSelfTest runs the canned fixtures through the hook handlers and checks the results, so that a webhook that is
+// up but mangling config is not reported as ready.
+func runSelfTest() selfTestReport {
+	rep := selfTestReport{Passed: true}
+	add := func(name string, err error) {
+		c := selfTestCheck{Name: name, Passed: err == nil}
+		if err != nil {
+			c.Error = err.Error()
+			rep.Passed = false
+		}
+		rep.Checks = append(rep.Checks, c)
+	}
+
+	lds, err := runSelfTestHook("listeners", listeners, selfTestLDS)
+	if err != nil {
+		add("lds", err)
+	} else {
+		ls, _ := lds["listeners"].([]interface{})
+		if len(ls) != 4 {
+			add("lds", fmt.Errorf("expected 4 listeners, got %d", len(ls)))
+		} else {
+			add("lds/inbound-http", expectHTTPAuthz(ls[0]))
+			add("lds/inbound-tcp", expectTCPAuthz(ls[1]))
+			add("lds/outbound", expectNoAuthz(ls[2]))
+			add("lds/virtual", expectNoAuthz(ls[3]))
+		}
+	}
+
+	cds, err := runSelfTestHook("clusters", clusters, selfTestCDS)
+	if err == nil {
+		var want map[string]interface{}
+		json.Unmarshal([]byte(selfTestCDS), &want)
+		// The authz cluster may be added, but nothing else may change.
+		var got []interface{}
+		cs, _ := cds["clusters"].([]interface{})
+		for _, c := range cs {
+			if filterName(c) != AuthZClusterName {
+				got = append(got, c)
+			}
+		}
+		if !reflect.DeepEqual(got, want["clusters"]) {
+			err = errors.New("clusters were modified")
+		}
+	}
+	add("cds", err)
+
+	lastSelfTest.Lock()
+	lastSelfTest.err = rep.err()
+	lastSelfTest.Unlock()
+	return rep
+}
+
+// selfTestReady is a readiness check reporting the result of the last self-test.
+func selfTestReady() error {
+	lastSelfTest.Lock()
+	defer lastSelfTest.Unlock()
+	return lastSelfTest.err
+}
+
+// runSelfTestHook calls a hook handler with a canned body and decodes its response.
+func runSelfTestHook(hook string, handler restful.RouteFunction, body string) (map[string]interface{}, error) {
+	recorder := callHook(hook, handler, selfTestCluster, selfTestNode, strings.NewReader(body))
+	if recorder.Code != http.StatusOK {
+		return nil, fmt.Errorf("status %d: %s", recorder.Code, strings.TrimSpace(recorder.Body.String()))
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &out); err != nil {
+		return nil, fmt.Errorf("invalid response JSON: %v", err)
+	}
+	return out, nil
+}
+
+func filterName(f interface{}) string {
+	m, _ := f.(map[string]interface{})
+	name, _ := m["name"].(string)
+	return name
+}
+
+func networkFilters(listener interface{}) []interface{} {
+	m, _ := listener.(map[string]interface{})
+	fs, _ := m["filters"].([]interface{})
+	return fs
+}
+
+func expectHTTPAuthz(listener interface{}) error {
+	fs := networkFilters(listener)
+	if len(fs) == 0 || filterName(fs[0]) != "http_connection_manager" {
+		return errors.New("HTTP connection manager is missing")
+	}
+	cfg, _ := fs[0].(map[string]interface{})["config"].(map[string]interface{})
+	hfs, _ := cfg["filters"].([]interface{})
+	if len(hfs) == 0 || filterName(hfs[0]) != AuthZFilterName {
+		return errors.New("authz filter is not the first HTTP filter")
+	}
+	if len(hfs) != 2 {
+		return fmt.Errorf("expected 2 HTTP filters, got %d", len(hfs))
+	}
+	return nil
+}
+
+func expectTCPAuthz(listener interface{}) error {
+	fs := networkFilters(listener)
+	if len(fs) != 2 || filterName(fs[0]) != AuthZFilterName {
+		return errors.New("authz filter is not the first of 2 network filters")
+	}
+	return nil
+}
+
+func expectNoAuthz(listener interface{}) error {
+	b, _ := json.Marshal(listener)
+	if strings.Contains(string(b), AuthZFilterName) {
+		return errors.New("authz filter was added")
+	}
+	return nil
+}
+
+// selfTest runs the self-test on demand.  The result also updates readiness.
+func selfTest(req *restful.Request, resp *restful.Response) {
+	rep := runSelfTest()
+	status := http.StatusOK
+	if !rep.Passed {
+		status = http.StatusServiceUnavailable
+	}
+	resp.WriteHeaderAndEntity(status, rep)
+}
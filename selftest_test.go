@@ -0,0 +1,63 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestSelfTestPasses(t *testing.T) {
+	RegisterTestingT(t)
+
+	rep := runSelfTest()
+	Expect(rep.err()).To(BeNil())
+	Expect(rep.Passed).To(BeTrue())
+	Expect(rep.Checks).To(HaveLen(5))
+	Expect(selfTestReady()).To(BeNil())
+}
+
+func TestSelfTestExpectations(t *testing.T) {
+	RegisterTestingT(t)
+
+	var lds map[string]interface{}
+	Expect(json.Unmarshal([]byte(selfTestLDS), &lds)).To(Succeed())
+	ls := lds["listeners"].([]interface{})
+
+	// The unmutated fixtures must fail the checks for the mutated ones.
+	Expect(expectHTTPAuthz(ls[0])).NotTo(BeNil())
+	Expect(expectTCPAuthz(ls[1])).NotTo(BeNil())
+	Expect(expectNoAuthz(ls[2])).To(BeNil())
+}
+
+func TestSelfTestReportError(t *testing.T) {
+	RegisterTestingT(t)
+
+	rep := selfTestReport{Checks: []selfTestCheck{
+		{Name: "a", Passed: true},
+		{Name: "b", Error: "broken"},
+	}}
+	Expect(rep.err().Error()).To(Equal("b: broken"))
+}
+
+func TestSelfTestWithDiscovery(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { dikastes = nil }()
+	dikastes = staticResolver("tcp://10.96.0.20:9000")
+	Expect(runSelfTest().err()).To(BeNil())
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"k8s.io/api/core/v1"
+)
+
+// spiffeServiceAccount matches the SPIFFE ids Istio gives workloads, so that rules can be copied from mesh policy.
+var spiffeServiceAccount = regexp.MustCompile(`^spiffe://[^/]+/ns/([^/]+)/sa/([^/]+)$`)
+
+// excludedServiceAccounts stops injection for pods running as the matching service accounts, e.g. the Istio control
+// plane.  It is nil unless --exclude-service-accounts is set.
+var excludedServiceAccounts *serviceAccountMatcher
+
+// serviceAccountMatcher matches service accounts against namespace/name glob patterns.
+type serviceAccountMatcher struct {
+	patterns []string
+}
+
+// newServiceAccountMatcher parses a comma separated list of namespace/name globs or SPIFFE ids.
+func newServiceAccountMatcher(list string) (*serviceAccountMatcher, error) {
+	m := &serviceAccountMatcher{}
+	for _, p := range strings.Split(list, ",") {
+		if s := spiffeServiceAccount.FindStringSubmatch(p); s != nil {
+			p = s[1] + "/" + s[2]
+		}
+		if strings.Count(p, "/") != 1 {
+			return nil, fmt.Errorf("%q is not namespace/name", p)
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q", p)
+		}
+		m.patterns = append(m.patterns, p)
+	}
+	return m, nil
+}
+
+// matches reports whether the pod's service account matches any of the patterns.
+func (m *serviceAccountMatcher) matches(pod *v1.Pod) bool {
+	sa := pod.Spec.ServiceAccountName
+	if sa == "" {
+		sa = "default"
+	}
+	for _, p := range m.patterns {
+		if ok, _ := path.Match(p, pod.Namespace+"/"+sa); ok {
+			return true
+		}
+	}
+	return false
+}
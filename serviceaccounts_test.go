@@ -0,0 +1,72 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/api/core/v1"
+)
+
+func TestServiceAccountMatcher(t *testing.T) {
+	RegisterTestingT(t)
+
+	m, err := newServiceAccountMatcher("istio-system/*,spiffe://cluster.local/ns/testns/sa/admin")
+	Expect(err).To(BeNil())
+	Expect(m.patterns).To(Equal([]string{"istio-system/*", "testns/admin"}))
+
+	pod := testPod("testpod", NODE_IP, v1.PodRunning, nil)
+	Expect(m.matches(pod)).To(BeFalse())
+	pod.Spec.ServiceAccountName = "admin"
+	Expect(m.matches(pod)).To(BeTrue())
+	pod.Namespace = "istio-system"
+	pod.Spec.ServiceAccountName = ""
+	Expect(m.matches(pod)).To(BeTrue())
+
+	for _, bad := range []string{"admin", "a/b/c", "ns/["} {
+		_, err := newServiceAccountMatcher(bad)
+		Expect(err).NotTo(BeNil(), bad)
+	}
+}
+
+func TestSkipServiceAccount(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() {
+		pods = nil
+		excludedServiceAccounts = nil
+	}()
+	pod := testPod("testpod", NODE_IP, v1.PodRunning, nil)
+	pod.Spec.ServiceAccountName = "pilot"
+	pods = newTestPodIndex(pod)
+	excludedServiceAccounts, _ = newServiceAccountMatcher("testns/pilot")
+	Expect(skipNode(SERVICE_CLUSTER, serviceNode("sidecar", NODE_IP), "sidecar", NODE_IP)).To(Equal(SkipServiceAccount))
+
+	excludedServiceAccounts, _ = newServiceAccountMatcher("istio-system/*")
+	Expect(skipNode(SERVICE_CLUSTER, serviceNode("sidecar", NODE_IP), "sidecar", NODE_IP)).To(Equal(skipReason("")))
+}
+
+func TestPodPatchServiceAccount(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { excludedServiceAccounts = nil }()
+	excludedServiceAccounts, _ = newServiceAccountMatcher("istio-system/*")
+	pod := &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "istio-proxy"}}}}
+	pod.Namespace = "istio-system"
+	Expect(podPatch(pod)).To(BeEmpty())
+	pod.Namespace = "testns"
+	Expect(podPatch(pod)).NotTo(BeEmpty())
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"strings"
+
+	"github.com/emicklei/go-restful"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Results of comparing a response with the shadow webhook's.
+const (
+	ShadowSame    = "same"
+	ShadowDiffers = "differs"
+	ShadowError   = "error"
+	ShadowDropped = "dropped"
+)
+
+// maxShadowInflight bounds the comparisons in progress, so that a slow shadow webhook cannot pile up goroutines.
+const maxShadowInflight = 64
+
+// shadow sends a copy of hook requests to a second webhook and compares its responses with this one's, so that a new
+// version of the webhook can be checked against real traffic before it takes over.  It is nil unless --shadow-socket
+// is set.
+var shadow *shadowComparer
+
+var shadowComparisons = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "shadow_comparisons_total",
+	Help:      "Number of hook responses compared with the shadow webhook's, by hook and result.",
+}, []string{"hook", "result"})
+
+func init() {
+	prometheus.MustRegister(shadowComparisons)
+}
+
+type shadowComparer struct {
+	replayer *replayer
+	rate     float64
+	sample   func() float64
+	inflight chan struct{}
+}
+
+func newShadowComparer(socket string, rate float64) *shadowComparer {
+	return &shadowComparer{
+		replayer: newReplayer(socket),
+		rate:     rate,
+		sample:   rand.Float64,
+		inflight: make(chan struct{}, maxShadowInflight),
+	}
+}
+
+// compare sends the request to the shadow webhook and compares its response with ours, as replay does.
+func (s *shadowComparer) compare(c capture) string {
+	hook := hookName(c.meta.Path)
+	fields := log.Fields{"hook": hook, "path": c.meta.Path}
+	res, err := s.replayer.replay(c)
+	switch {
+	case err != nil:
+		fields["err"] = err
+		errorLog.Warn(fields, "Unable to send request to shadow webhook")
+		shadowComparisons.WithLabelValues(hook, ShadowError).Inc()
+		return ShadowError
+	case len(res.diffs) > 0:
+		fields["diffs"] = strings.Join(res.diffs, "; ")
+		errorLog.Warn(fields, "Shadow webhook response differs")
+		shadowComparisons.WithLabelValues(hook, ShadowDiffers).Inc()
+		return ShadowDiffers
+	}
+	shadowComparisons.WithLabelValues(hook, ShadowSame).Inc()
+	return ShadowSame
+}
+
+// shadowCompared is a filter that compares a sample of responses with the shadow webhook's after they
+// have been sent, so that the shadow adds no latency.  Comparisons past maxShadowInflight are dropped.
+func shadowCompared(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	if shadow == nil || shadow.sample() >= shadow.rate {
+		chain.ProcessFilter(req, resp)
+		return
+	}
+	body, err := ioutil.ReadAll(limitBody(req.Request.Body))
+	req.Request.Body = replayBody(body, err)
+	cw := &captureWriter{ResponseWriter: resp.ResponseWriter, max: math.MaxInt32}
+	resp.ResponseWriter = cw
+
+	chain.ProcessFilter(req, resp)
+
+	if err != nil {
+		return
+	}
+	c := capture{
+		meta:     captureMeta{Method: req.Request.Method, Path: req.Request.URL.Path, Status: resp.StatusCode()},
+		request:  body,
+		response: redactBody(cw.buf.Bytes()),
+	}
+	s := shadow
+	select {
+	case s.inflight <- struct{}{}:
+	default:
+		shadowComparisons.WithLabelValues(hookName(c.meta.Path), ShadowDropped).Inc()
+		return
+	}
+	go func() {
+		defer func() { <-s.inflight }()
+		s.compare(c)
+	}()
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestShadow returns a comparer for a shadow webhook served in process by handler.
+func newTestShadow(handler http.Handler) *shadowComparer {
+	s := newShadowComparer("", 1)
+	s.replayer = &replayer{local: handler}
+	return s
+}
+
+func ldsCapture(t *testing.T) capture {
+	req := hookRequest("listeners", "sidecar", strings.NewReader(ldsWithUnknownFields))
+	rec := serveHook(newHooks(), req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	return capture{
+		meta:     captureMeta{Method: "POST", Path: req.URL.Path, Status: rec.Code},
+		request:  []byte(ldsWithUnknownFields),
+		response: rec.Body.Bytes(),
+	}
+}
+
+func TestShadowCompare(t *testing.T) {
+	RegisterTestingT(t)
+
+	c := ldsCapture(t)
+	Expect(newTestShadow(newHooks()).compare(c)).To(Equal(ShadowSame))
+
+	differs := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"listeners":[]}`))
+	})
+	before := testutil.ToFloat64(shadowComparisons.WithLabelValues("listeners", ShadowDiffers))
+	Expect(newTestShadow(differs).compare(c)).To(Equal(ShadowDiffers))
+	Expect(testutil.ToFloat64(shadowComparisons.WithLabelValues("listeners", ShadowDiffers))).To(Equal(before + 1))
+
+	fails := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "oops", http.StatusInternalServerError)
+	})
+	Expect(newTestShadow(fails).compare(c)).To(Equal(ShadowDiffers))
+
+	Expect(newShadowComparer("/nonexistent/webhook.sock", 1).compare(c)).To(Equal(ShadowError))
+}
+
+func TestShadowCompared(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { shadow = nil }()
+	received := make(chan string, 1)
+	shadow = newTestShadow(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.URL.Path
+		w.Write([]byte(`{"listeners":[]}`))
+	}))
+	before := testutil.ToFloat64(shadowComparisons.WithLabelValues("listeners", ShadowDiffers))
+
+	c := ldsCapture(t)
+	// The primary response is unaffected by the shadow's.
+	container := newHooks()
+	rec := serveHook(container, httptest.NewRequest("POST", c.meta.Path, strings.NewReader(ldsWithUnknownFields)))
+	Expect(rec.Body.String()).To(MatchJSON(c.response))
+
+	Expect(<-received).To(Equal(c.meta.Path))
+	Eventually(func() float64 {
+		return testutil.ToFloat64(shadowComparisons.WithLabelValues("listeners", ShadowDiffers))
+	}, time.Second).Should(Equal(before + 1))
+
+	// Comparisons past the limit are dropped rather than queued.
+	for i := 0; i < maxShadowInflight; i++ {
+		shadow.inflight <- struct{}{}
+	}
+	before = testutil.ToFloat64(shadowComparisons.WithLabelValues("listeners", ShadowDropped))
+	rec = serveHook(container, httptest.NewRequest("POST", c.meta.Path, strings.NewReader(ldsWithUnknownFields)))
+	Expect(rec.Code).To(Equal(http.StatusOK))
+	Expect(testutil.ToFloat64(shadowComparisons.WithLabelValues("listeners", ShadowDropped))).To(Equal(before + 1))
+}
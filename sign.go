@@ -0,0 +1,130 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	log "github.com/sirupsen/logrus"
+)
+
+// signingKeySecretKey is the key of the --signing-key-secret Secret holding the key.
+const signingKeySecretKey = "key"
+
+// minSigningKeyLength is the shortest key accepted, the length of the SHA-256 output.
+const minSigningKeyLength = 32
+
+// signer signs hook responses.  It is nil unless --signing-key-file or --signing-key-secret is set.
+var signer *responseSigner
+
+// responseSigner signs successful hook responses with an HMAC-SHA256 of their body, so that whoever receives the
+// config from Pilot can check that it is what the webhook sent.
+type responseSigner struct {
+	mu  sync.RWMutex
+	key []byte
+	// source returns the current key.
+	source func() ([]byte, error)
+	name   string
+}
+
+func newResponseSigner(name string, source func() ([]byte, error)) *responseSigner {
+	return &responseSigner{name: name, source: source}
+}
+
+// load replaces the key with that of the source, keeping the old key if the source cannot be read or holds too
+// short a key.
+func (s *responseSigner) load() error {
+	b, err := s.source()
+	if err != nil {
+		return err
+	}
+	key := bytes.TrimSpace(b)
+	if len(key) < minSigningKeyLength {
+		return fmt.Errorf("signing key is shorter than %d bytes", minSigningKeyLength)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.key != nil && !hmac.Equal(s.key, key) {
+		log.WithField("source", s.name).Info("Reloaded response signing key.")
+	}
+	s.key = key
+	return nil
+}
+
+// run polls the source for a new key.  It never returns.
+func (s *responseSigner) run() {
+	for {
+		time.Sleep(tokenReloadInterval)
+		if err := s.load(); err != nil {
+			log.WithFields(log.Fields{"source": s.name, "err": err}).Warn("Unable to reload response signing key.")
+		}
+	}
+}
+
+// sign returns the signature header value for a body.
+func (s *responseSigner) sign(body []byte) string {
+	s.mu.RLock()
+	mac := hmac.New(sha256.New, s.key)
+	s.mu.RUnlock()
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// signingWriter holds back the status as well as the body, since the signature header has to be set before either
+// is sent.
+type signingWriter struct {
+	http.ResponseWriter
+	buf    *bytes.Buffer
+	status int
+}
+
+func (w *signingWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *signingWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
+// signed is a filter that signs successful hook responses.  It runs inside gzip and outside canonicalization, so that
+// the signature is of the JSON Pilot passes on.
+func signed(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	if signer == nil {
+		chain.ProcessFilter(req, resp)
+		return
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	orig := resp.ResponseWriter
+	w := &signingWriter{ResponseWriter: orig, buf: buf, status: http.StatusOK}
+	resp.ResponseWriter = w
+
+	chain.ProcessFilter(req, resp)
+
+	resp.ResponseWriter = orig
+	if w.status == http.StatusOK {
+		orig.Header().Set(SignatureHeader, signer.sign(buf.Bytes()))
+	}
+	orig.WriteHeader(w.status)
+	orig.Write(buf.Bytes())
+}
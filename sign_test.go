@@ -0,0 +1,99 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+const testSigningKey = "0123456789abcdef0123456789abcdef"
+
+// expectSignature checks the signature header of a response against its body.
+func expectSignature(header string, body []byte) {
+	mac := hmac.New(sha256.New, []byte(testSigningKey))
+	mac.Write(body)
+	Expect(header).To(Equal("sha256=" + hex.EncodeToString(mac.Sum(nil))))
+}
+
+func TestResponseSignerLoad(t *testing.T) {
+	RegisterTestingT(t)
+
+	key := testSigningKey + "\n"
+	s := newResponseSigner("test", func() ([]byte, error) { return []byte(key), nil })
+	Expect(s.load()).To(Succeed())
+	expectSignature(s.sign([]byte("body")), []byte("body"))
+
+	// A bad update keeps the old key.
+	key = "short"
+	Expect(s.load()).NotTo(Succeed())
+	expectSignature(s.sign([]byte("body")), []byte("body"))
+	s.source = func() ([]byte, error) { return nil, errors.New("unavailable") }
+	Expect(s.load()).NotTo(Succeed())
+	expectSignature(s.sign([]byte("body")), []byte("body"))
+}
+
+// signedRequest sends an LDS request through the hooks with the given headers.
+func signedRequest(body string, header http.Header) *httptest.ResponseRecorder {
+	req := hookRequest("listeners", "sidecar", strings.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return serveHook(newHooks(), req)
+}
+
+func TestSigned(t *testing.T) {
+	RegisterTestingT(t)
+
+	rec := signedRequest(ldsWithUnknownFields, nil)
+	Expect(rec.Header().Get(SignatureHeader)).To(Equal(""), "not signed unless enabled")
+
+	signer = newResponseSigner("test", func() ([]byte, error) { return []byte(testSigningKey), nil })
+	defer func() { signer, canonicalJSON = nil, false }()
+	Expect(signer.load()).To(Succeed())
+
+	rec = signedRequest(ldsWithUnknownFields, nil)
+	Expect(rec.Code).To(Equal(http.StatusOK))
+	expectSignature(rec.Header().Get(SignatureHeader), rec.Body.Bytes())
+
+	// The signature is of the canonical form that is sent.
+	canonicalJSON = true
+	rec = signedRequest(ldsWithUnknownFields, nil)
+	expectSignature(rec.Header().Get(SignatureHeader), rec.Body.Bytes())
+	canonicalJSON = false
+
+	// And of the JSON, not its compressed form.
+	rec = signedRequest(ldsWithUnknownFields, http.Header{"Accept-Encoding": {"gzip"}})
+	Expect(rec.Header().Get("Content-Encoding")).To(Equal("gzip"))
+	zr, err := gzip.NewReader(rec.Body)
+	Expect(err).To(BeNil())
+	body, err := ioutil.ReadAll(zr)
+	Expect(err).To(BeNil())
+	expectSignature(rec.Header().Get(SignatureHeader), body)
+
+	rec = signedRequest("not JSON", nil)
+	Expect(rec.Code).NotTo(Equal(http.StatusOK))
+	Expect(rec.Header().Get(SignatureHeader)).To(Equal(""), "errors are not signed")
+}
@@ -0,0 +1,206 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+)
+
+// simulatePollInterval is how often simulate checks whether the webhook's caches are ready.
+const simulatePollInterval = 100 * time.Millisecond
+
+// simulation is what the hooks would do for one workload.
+type simulation struct {
+	Pod  string
+	IP   string
+	Skip skipReason
+	// Listeners and Clusters are the resources the hooks would change, as reported for dry runs.
+	Listeners []string
+	Clusters  []string
+	Err       error
+}
+
+// simulateCluster is the service cluster Istio's injector gives a pod's sidecar: its app label, or istio-proxy.
+func simulateCluster(pod *v1.Pod) string {
+	if app := pod.Labels["app"]; app != "" {
+		return app
+	}
+	return "istio-proxy"
+}
+
+// simulateNode is the service node of a pod's sidecar.
+func simulateNode(pod *v1.Pod) string {
+	return strings.Join([]string{"sidecar", pod.Status.PodIP, pod.Name + "." + pod.Namespace,
+		pod.Namespace + ".svc.cluster.local"}, serviceNodeSeparator)
+}
+
+// simulateXDS returns an LDS and a CDS request with an inbound listener and cluster for each port of the pod's
+// workload containers.  Pilot decides between HTTP and TCP listeners on the protocol of the service port, which the
+// pod does not have, so ports are taken to be HTTP if their name says so, as Istio requires service port names to.
+func simulateXDS(pod *v1.Pod) (lds, cds []byte) {
+	type object map[string]interface{}
+	listeners, clusters := []object{}, []object{}
+	ip := pod.Status.PodIP
+	for _, c := range pod.Spec.Containers {
+		if c.Name == sidecarContainerName {
+			continue
+		}
+		for _, p := range c.Ports {
+			if p.Protocol != "" && p.Protocol != v1.ProtocolTCP {
+				continue
+			}
+			cluster := fmt.Sprintf("in.%d", p.ContainerPort)
+			listener := object{
+				"address":      fmt.Sprintf("tcp://%s:%d", ip, p.ContainerPort),
+				"bind_to_port": false,
+			}
+			name := strings.ToLower(p.Name)
+			if strings.HasPrefix(name, "http") || strings.HasPrefix(name, "grpc") {
+				listener["name"] = fmt.Sprintf("http_%s_%d", ip, p.ContainerPort)
+				listener["filters"] = []object{{"type": "read", "name": "http_connection_manager", "config": object{
+					"codec_type": "auto", "stat_prefix": "http",
+					"filters": []object{{"type": "decoder", "name": "router", "config": object{}}}}}}
+			} else {
+				listener["name"] = fmt.Sprintf("tcp_%s_%d", ip, p.ContainerPort)
+				listener["filters"] = []object{{"type": "read", "name": "tcp_proxy", "config": object{
+					"stat_prefix": "tcp", "route_config": object{"routes": []object{{"cluster": cluster}}}}}}
+			}
+			listeners = append(listeners, listener)
+			clusters = append(clusters, object{"name": cluster, "connect_timeout_ms": 1000, "type": "static",
+				"lb_type": "round_robin", "hosts": []object{{"url": fmt.Sprintf("tcp://127.0.0.1:%d", p.ContainerPort)}}})
+		}
+	}
+	lds, _ = json.Marshal(object{"listeners": listeners})
+	cds, _ = json.Marshal(object{"clusters": clusters})
+	return lds, cds
+}
+
+// dryRunHook calls a hook handler in process as a dry run, and returns the resources it would change.
+func dryRunHook(hook string, handler restful.RouteFunction, cluster, node string, body []byte) ([]string, error) {
+	req := newHookRequest(hook, cluster, node, bytes.NewReader(body))
+	req.Request.Header.Set(DryRunHeader, "true")
+	recorder := httptest.NewRecorder()
+	handler(req, restful.NewResponse(recorder))
+	if recorder.Code != http.StatusOK {
+		return nil, fmt.Errorf("%s: status %d: %s", hook, recorder.Code, strings.TrimSpace(recorder.Body.String()))
+	}
+	changes := recorder.Header().Get(DryRunChangesHeader)
+	if changes == "" || changes == "none" {
+		return nil, nil
+	}
+	return strings.Split(changes, ","), nil
+}
+
+// simulatePod works out what the hooks would do for a pod's sidecar, without sending anything to it.
+func simulatePod(pod *v1.Pod) simulation {
+	sim := simulation{Pod: pod.Namespace + "/" + pod.Name, IP: pod.Status.PodIP}
+	cluster, node := simulateCluster(pod), simulateNode(pod)
+	if sim.Skip = skipNode(cluster, node, "sidecar", sim.IP); sim.Skip != "" {
+		return sim
+	}
+	lds, cds := simulateXDS(pod)
+	if sim.Listeners, sim.Err = dryRunHook("listeners", listeners, cluster, node, lds); sim.Err != nil {
+		return sim
+	}
+	sim.Clusters, sim.Err = dryRunHook("clusters", clusters, cluster, node, cds)
+	return sim
+}
+
+// simulatedPod reports whether a pod is one that Pilot would call the hooks for: it runs a sidecar and has an IP of its
+// own.
+func simulatedPod(pod *v1.Pod) bool {
+	if pod.Spec.HostNetwork || pod.Status.PodIP == "" ||
+		pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return false
+	}
+	for _, c := range pod.Spec.Containers {
+		if c.Name == sidecarContainerName {
+			return true
+		}
+	}
+	return false
+}
+
+// runSimulate simulates the hooks for each sidecar in the pods, in the namespace if it is set, and writes a report to
+// out.
+func runSimulate(pods []*v1.Pod, namespace string, out io.Writer) error {
+	var sims []simulation
+	for _, pod := range pods {
+		if simulatedPod(pod) && (namespace == "" || pod.Namespace == namespace) {
+			sims = append(sims, simulatePod(pod))
+		}
+	}
+	sort.Slice(sims, func(i, j int) bool { return sims[i].Pod < sims[j].Pod })
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprint(w, "WORKLOAD\tIP\tRESULT\tLISTENERS\tCLUSTERS\n")
+	for _, s := range sims {
+		switch {
+		case s.Skip != "":
+			fmt.Fprintf(w, "%s\t%s\tskipped: %s\t-\t-\n", s.Pod, s.IP, s.Skip)
+		case s.Err != nil:
+			fmt.Fprintf(w, "%s\t%s\terror: %v\t-\t-\n", s.Pod, s.IP, s.Err)
+		default:
+			fmt.Fprintf(w, "%s\t%s\tinjected\t%s\t%s\n", s.Pod, s.IP, simulateList(s.Listeners),
+				simulateList(s.Clusters))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(out, "\n%d workloads\n", len(sims))
+	return err
+}
+
+func simulateList(changed []string) string {
+	if len(changed) == 0 {
+		return "-"
+	}
+	return strings.Join(changed, ",")
+}
+
+// simulate runs the simulate command, once the features main has set up are ready.
+func simulate(arguments map[string]interface{}) {
+	timeout, err := time.ParseDuration(arguments["--simulate-timeout"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --simulate-timeout.")
+	}
+	for deadline := time.Now().Add(timeout); ; time.Sleep(simulatePollInterval) {
+		rep := readiness.report()
+		if rep.Ready {
+			break
+		}
+		if time.Now().After(deadline) {
+			log.WithField("checks", rep.Checks).Fatal("Timed out waiting for the cluster state to sync.")
+		}
+	}
+	namespace, _ := arguments["--simulate-namespace"].(string)
+	if err := runSimulate(pods.list(), namespace, os.Stdout); err != nil {
+		log.WithField("err", err).Fatal("Unable to write simulation report.")
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/api/core/v1"
+)
+
+func simulatedTestPod(name, ip string, annotations map[string]string, ports ...v1.ContainerPort) *v1.Pod {
+	pod := testPod(name, ip, v1.PodRunning, annotations)
+	pod.Spec.Containers = []v1.Container{{Name: "app", Ports: ports}, {Name: sidecarContainerName}}
+	return pod
+}
+
+func TestSimulate(t *testing.T) {
+	RegisterTestingT(t)
+
+	noSidecar := testPod("plain", "10.0.0.4", v1.PodRunning, nil)
+	noSidecar.Spec.Containers = []v1.Container{{Name: "app"}}
+	list := []*v1.Pod{
+		simulatedTestPod("web", "10.0.0.1", nil,
+			v1.ContainerPort{Name: "http-web", ContainerPort: 8080}, v1.ContainerPort{Name: "db", ContainerPort: 3306}),
+		simulatedTestPod("opted-out", "10.0.0.2", map[string]string{InjectAnnotation: "false"},
+			v1.ContainerPort{Name: "http", ContainerPort: 80}),
+		simulatedTestPod("dns", "10.0.0.3", nil, v1.ContainerPort{Name: "dns", ContainerPort: 53, Protocol: v1.ProtocolUDP}),
+		noSidecar,
+	}
+	defer func() { pods, dikastes = nil, nil }()
+	pods = newTestPodIndex(list...)
+	dikastes = staticResolver("tcp://10.96.0.20:9000")
+
+	var out bytes.Buffer
+	Expect(runSimulate(pods.list(), "", &out)).To(Succeed())
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	Expect(lines).To(HaveLen(6))
+	Expect(strings.Fields(lines[0])).To(Equal([]string{"WORKLOAD", "IP", "RESULT", "LISTENERS", "CLUSTERS"}))
+	Expect(strings.Fields(lines[1])).To(Equal([]string{"testns/dns", "10.0.0.3", "injected", "-",
+		"cluster/" + AuthZClusterName}))
+	Expect(strings.Fields(lines[2])).To(Equal([]string{"testns/opted-out", "10.0.0.2", "skipped:",
+		string(SkipPodOptOut), "-", "-"}))
+	Expect(strings.Fields(lines[3])).To(Equal([]string{"testns/web", "10.0.0.1", "injected",
+		"listener/http_10.0.0.1_8080,listener/tcp_10.0.0.1_3306", "cluster/" + AuthZClusterName}))
+	Expect(lines[5]).To(Equal("3 workloads"))
+
+	out.Reset()
+	Expect(runSimulate(pods.list(), "other", &out)).To(Succeed())
+	Expect(out.String()).To(HaveSuffix("\n0 workloads\n"))
+}
+
+func TestSimulateNode(t *testing.T) {
+	RegisterTestingT(t)
+
+	pod := testPod("web-1", "10.0.0.1", v1.PodRunning, nil)
+	Expect(simulateCluster(pod)).To(Equal("istio-proxy"))
+	pod.Labels = map[string]string{"app": "web"}
+	Expect(simulateCluster(pod)).To(Equal("web"))
+	name, namespace, ok := podFromServiceNode(simulateNode(pod))
+	Expect(ok).To(BeTrue())
+	Expect(name).To(Equal("web-1"))
+	Expect(namespace).To(Equal("testns"))
+}
@@ -0,0 +1,338 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Results of publishing a mutated response to an output sink.
+const (
+	SinkPublished = "published"
+	SinkFailed    = "failed"
+	SinkDropped   = "dropped"
+)
+
+// sinkQueueLength bounds the records waiting for each sink, so that a slow or unreachable sink drops records rather
+// than holding memory or slowing responses.
+const sinkQueueLength = 256
+
+// sinkTimeout bounds each write to a network sink.
+const sinkTimeout = 5 * time.Second
+
+// outputSinks publishes each mutated response, for audit, offline analysis or drift detection.  It is nil unless
+// --output-sinks is set.
+var outputSinks *sinkPublisher
+
+var sinkRecords = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "output_sink_records_total",
+	Help:      "Number of mutated responses sent to each output sink, by sink and result.",
+}, []string{"sink", "result"})
+
+func init() {
+	prometheus.MustRegister(sinkRecords)
+}
+
+// sinkRecord is what is published for each mutated response.
+type sinkRecord struct {
+	Time           time.Time       `json:"time"`
+	Hook           string          `json:"hook"`
+	ServiceCluster string          `json:"serviceCluster,omitempty"`
+	ServiceNode    string          `json:"serviceNode,omitempty"`
+	Config         json.RawMessage `json:"config"`
+}
+
+// outputSink is somewhere records are published.  Publish is only called from one goroutine at a time.
+type outputSink interface {
+	publish(record []byte) error
+}
+
+// sinkQueue feeds one sink from its own goroutine.
+type sinkQueue struct {
+	name  string
+	sink  outputSink
+	queue chan []byte
+}
+
+func (q *sinkQueue) run() {
+	for record := range q.queue {
+		if err := q.sink.publish(record); err != nil {
+			log.WithFields(log.Fields{"sink": q.name, "err": err}).Warn("Failed to publish to output sink")
+			sinkRecords.WithLabelValues(q.name, SinkFailed).Inc()
+			continue
+		}
+		sinkRecords.WithLabelValues(q.name, SinkPublished).Inc()
+	}
+}
+
+// sinkPublisher redacts and encodes records off the response path and hands them to each sink's queue.
+type sinkPublisher struct {
+	in     chan sinkRecord
+	queues []*sinkQueue
+}
+
+// newSinkPublisher returns a publisher for the sinks in --output-sinks, a comma separated list of URLs.
+func newSinkPublisher(urls string) (*sinkPublisher, error) {
+	p := &sinkPublisher{in: make(chan sinkRecord, sinkQueueLength)}
+	for _, s := range strings.Split(urls, ",") {
+		u, err := url.Parse(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		sink, err := newOutputSink(u)
+		if err != nil {
+			return nil, fmt.Errorf("sink %s: %v", sinkName(u), err)
+		}
+		p.queues = append(p.queues, &sinkQueue{name: sinkName(u), sink: sink, queue: make(chan []byte, sinkQueueLength)})
+	}
+	return p, nil
+}
+
+// sinkName names a sink in logs and metrics, without any credentials in its URL.
+func sinkName(u *url.URL) string {
+	return u.Scheme + "://" + u.Host + u.Path
+}
+
+func newOutputSink(u *url.URL) (outputSink, error) {
+	switch u.Scheme {
+	case "file":
+		return newFileSink(u.Path)
+	case "nats":
+		return newNATSSink(u)
+	case "kafka-rest":
+		return newKafkaRESTSink(u)
+	}
+	return nil, fmt.Errorf("unsupported scheme %q; use file, nats or kafka-rest", u.Scheme)
+}
+
+func (p *sinkPublisher) run() {
+	for _, q := range p.queues {
+		go q.run()
+	}
+	for rec := range p.in {
+		rec.Config = redactBody(rec.Config)
+		b, err := json.Marshal(rec)
+		if err != nil {
+			log.WithFields(log.Fields{"hook": rec.Hook, "err": err}).Warn("Unable to encode output sink record")
+			for _, q := range p.queues {
+				sinkRecords.WithLabelValues(q.name, SinkFailed).Inc()
+			}
+			continue
+		}
+		for _, q := range p.queues {
+			select {
+			case q.queue <- b:
+			default:
+				sinkRecords.WithLabelValues(q.name, SinkDropped).Inc()
+			}
+		}
+	}
+}
+
+// publish queues a record without blocking, dropping it if the publisher is behind.
+func (p *sinkPublisher) publish(rec sinkRecord) {
+	select {
+	case p.in <- rec:
+	default:
+		for _, q := range p.queues {
+			sinkRecords.WithLabelValues(q.name, SinkDropped).Inc()
+		}
+	}
+}
+
+// publishedToSinks is a filter that publishes each successful response to the output sinks.  Dry runs are not
+// published, since their responses are the requests.
+func publishedToSinks(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	if outputSinks == nil || isDryRun(req) {
+		chain.ProcessFilter(req, resp)
+		return
+	}
+	cw := &captureWriter{ResponseWriter: resp.ResponseWriter, max: math.MaxInt32}
+	resp.ResponseWriter = cw
+
+	chain.ProcessFilter(req, resp)
+
+	if resp.StatusCode() != http.StatusOK {
+		return
+	}
+	outputSinks.publish(sinkRecord{
+		Time:           time.Now(),
+		Hook:           hookName(req.Request.URL.Path),
+		ServiceCluster: req.PathParameter("serviceCluster"),
+		ServiceNode:    req.PathParameter("serviceNode"),
+		Config:         cw.buf.Bytes(),
+	})
+}
+
+// fileSink appends records to a file as JSON lines.
+type fileSink struct {
+	f *os.File
+}
+
+func newFileSink(path string) (*fileSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{f: f}, nil
+}
+
+func (s *fileSink) publish(record []byte) error {
+	_, err := s.f.Write(append(record, '\n'))
+	return err
+}
+
+// natsSink publishes records to a NATS subject, nats://[user:password@]host:port/subject, with the core NATS text
+// protocol.  It reconnects on the next record after a failure.
+type natsSink struct {
+	addr    string
+	subject string
+	connect []byte
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newNATSSink(u *url.URL) (*natsSink, error) {
+	subject := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || subject == "" || strings.ContainsAny(subject, " \t/") {
+		return nil, fmt.Errorf("NATS sinks are nats://host:port/subject")
+	}
+	opts := map[string]interface{}{"verbose": false, "pedantic": false, "name": syslogAppName}
+	if u.User != nil {
+		opts["user"] = u.User.Username()
+		opts["pass"], _ = u.User.Password()
+	}
+	connect, _ := json.Marshal(opts)
+	return &natsSink{addr: u.Host, subject: subject, connect: []byte("CONNECT " + string(connect) + "\r\n")}, nil
+}
+
+func (s *natsSink) dial() (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", s.addr, sinkTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetWriteDeadline(time.Now().Add(sinkTimeout))
+	if _, err := conn.Write(s.connect); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go s.serve(conn)
+	return conn, nil
+}
+
+// serve reads what the server sends on a connection, answering its pings so that it keeps the connection open.
+func (s *natsSink) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			s.mu.Lock()
+			if s.conn == conn {
+				s.conn = nil
+			}
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		switch {
+		case strings.HasPrefix(line, "PING"):
+			s.mu.Lock()
+			conn.SetWriteDeadline(time.Now().Add(sinkTimeout))
+			conn.Write([]byte("PONG\r\n"))
+			s.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			log.WithFields(log.Fields{"sink": "nats://" + s.addr, "err": strings.TrimSpace(line)}).Warn(
+				"NATS server reported an error")
+		}
+	}
+}
+
+func (s *natsSink) publish(record []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "PUB %s %d\r\n", s.subject, len(record))
+	msg.Write(record)
+	msg.WriteString("\r\n")
+	s.conn.SetWriteDeadline(time.Now().Add(sinkTimeout))
+	if _, err := s.conn.Write(msg.Bytes()); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// kafkaRESTSink produces records to a Kafka topic through a Kafka REST proxy, kafka-rest://host:port/topic, keyed by
+// service node so that each proxy's configs stay in order on one partition.
+type kafkaRESTSink struct {
+	url    string
+	client *http.Client
+}
+
+func newKafkaRESTSink(u *url.URL) (*kafkaRESTSink, error) {
+	topic := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || topic == "" {
+		return nil, fmt.Errorf("Kafka REST sinks are kafka-rest://host:port/topic")
+	}
+	target := url.URL{Scheme: "http", User: u.User, Host: u.Host, Path: "/topics/" + topic}
+	return &kafkaRESTSink{url: target.String(), client: &http.Client{Timeout: sinkTimeout}}, nil
+}
+
+func (s *kafkaRESTSink) publish(record []byte) error {
+	var key struct {
+		ServiceNode string `json:"serviceNode"`
+	}
+	json.Unmarshal(record, &key)
+	body, err := json.Marshal(map[string]interface{}{
+		"records": []map[string]interface{}{{"key": key.ServiceNode, "value": json.RawMessage(record)}},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/vnd.kafka.json.v2+json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Kafka REST proxy returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPublishedToSinks(t *testing.T) {
+	RegisterTestingT(t)
+
+	dir, err := ioutil.TempDir("", "sinks")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "configs.jsonl")
+	outputSinks, err = newSinkPublisher("file://" + file)
+	Expect(err).To(BeNil())
+	defer func() { outputSinks = nil }()
+	go outputSinks.run()
+
+	container := newHooks()
+	serveHook(container, hookRequest("routes", "sidecar", strings.NewReader(`{"virtual_hosts":[]}`)))
+	// Dry runs are not published.
+	req := hookRequest("routes", "sidecar", strings.NewReader(`{"virtual_hosts":[{"name":"dry"}]}`))
+	req.Header.Set(DryRunHeader, "true")
+	serveHook(container, req)
+
+	read := func() string {
+		b, _ := ioutil.ReadFile(file)
+		return string(b)
+	}
+	Eventually(read).Should(HaveSuffix("\n"))
+	var rec sinkRecord
+	Expect(json.Unmarshal([]byte(read()), &rec)).To(Succeed())
+	Expect(rec.Hook).To(Equal("routes"))
+	Expect(rec.ServiceCluster).To(Equal(SERVICE_CLUSTER))
+	Expect(rec.ServiceNode).To(Equal(serviceNode("sidecar", NODE_IP)))
+	Expect(string(rec.Config)).To(MatchJSON(`{"virtual_hosts":[]}`))
+	Consistently(read).ShouldNot(ContainSubstring("dry"))
+}
+
+func TestSinkPublisherInvalid(t *testing.T) {
+	RegisterTestingT(t)
+
+	for _, urls := range []string{"ftp://host/x", "nats://host:4222", "kafka-rest://host:8082", "file:///no/such/dir/x"} {
+		_, err := newSinkPublisher(urls)
+		Expect(err).NotTo(BeNil(), urls)
+	}
+}
+
+// blockedSink blocks publishing until it is released.
+type blockedSink chan struct{}
+
+func (s blockedSink) publish([]byte) error {
+	<-s
+	return nil
+}
+
+func TestSinkDrops(t *testing.T) {
+	RegisterTestingT(t)
+
+	sink := make(blockedSink)
+	defer close(sink)
+	q := &sinkQueue{name: "blocked", sink: sink, queue: make(chan []byte, 1)}
+	p := &sinkPublisher{in: make(chan sinkRecord, 1), queues: []*sinkQueue{q}}
+	go p.run()
+
+	dropped := func() float64 { return testutil.ToFloat64(sinkRecords.WithLabelValues("blocked", SinkDropped)) }
+	before := dropped()
+	// One record is being published, one waits in the queue, and the rest are dropped.
+	for i := 0; i < 10; i++ {
+		p.publish(sinkRecord{Hook: "routes", Config: json.RawMessage(`{}`)})
+	}
+	Eventually(dropped).Should(BeNumerically(">=", before+7))
+}
+
+func TestNATSSink(t *testing.T) {
+	RegisterTestingT(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer lis.Close()
+	lines := make(chan string, 10)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("PING\r\n"))
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- strings.TrimSpace(line)
+		}
+	}()
+
+	u, _ := url.Parse("nats://webhook:secret@" + lis.Addr().String() + "/pilot.configs")
+	sink, err := newNATSSink(u)
+	Expect(err).To(BeNil())
+	Expect(sink.publish([]byte(`{"hook":"routes"}`))).To(Succeed())
+	var got []string
+	for i := 0; i < 4; i++ {
+		select {
+		case line := <-lines:
+			got = append(got, line)
+		case <-time.After(time.Second):
+			t.Fatalf("only got %q", got)
+		}
+	}
+	// The server's ping may be answered before or after the message.
+	Expect(got).To(ConsistOf(
+		`CONNECT {"name":"pilot-webhook","pass":"secret","pedantic":false,"user":"webhook","verbose":false}`,
+		"PUB pilot.configs 17",
+		`{"hook":"routes"}`,
+		"PONG",
+	))
+}
+
+func TestKafkaRESTSink(t *testing.T) {
+	RegisterTestingT(t)
+
+	var path, contentType string
+	var body map[string][]map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse("kafka-rest://" + strings.TrimPrefix(server.URL, "http://") + "/pilot-configs")
+	sink, err := newKafkaRESTSink(u)
+	Expect(err).To(BeNil())
+	Expect(sink.publish([]byte(`{"serviceNode":"sidecar~1.2.3.4~a~b","hook":"listeners"}`))).To(Succeed())
+	Expect(path).To(Equal("/topics/pilot-configs"))
+	Expect(contentType).To(Equal("application/vnd.kafka.json.v2+json"))
+	Expect(body["records"]).To(HaveLen(1))
+	Expect(body["records"][0]["key"]).To(Equal("sidecar~1.2.3.4~a~b"))
+	Expect(body["records"][0]["value"]).To(HaveKeyWithValue("hook", "listeners"))
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such topic", http.StatusNotFound)
+	})
+	Expect(sink.publish([]byte(`{}`))).NotTo(Succeed())
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+// skipReason is why the authz filter was not added, so that gaps in enforcement can be measured.  The authz mutator
+// reports why it skipped a listener, and the hooks why they skipped a whole node.
+type skipReason = mutator.SkipReason
+
+const (
+	// SkipNonSidecar is an LDS request for a node that is not a sidecar, e.g. an ingress.  It is counted once per
+	// request, since the listeners are passed through without being parsed.
+	SkipNonSidecar skipReason = "non_sidecar"
+	// SkipMalformedNode is an LDS request for a sidecar whose service node ID has no IP, so its inbound listeners
+	// cannot be told apart.  It is counted once per request.
+	SkipMalformedNode skipReason = "malformed_node"
+	// SkipOutbound is a listener for traffic leaving the pod.
+	SkipOutbound skipReason = mutator.SkipOutbound
+	// SkipVirtual is the virtual listener that redirects to the real ones.
+	SkipVirtual skipReason = mutator.SkipVirtual
+	// SkipExcludedPort is an inbound listener on a port excluded from enforcement.
+	SkipExcludedPort skipReason = mutator.SkipExcludedPort
+	// SkipAlreadyInjected is a listener that already has the authz filter.
+	SkipAlreadyInjected skipReason = mutator.SkipAlreadyInjected
+	// SkipNotCalico is an LDS request for a node whose IP is not a Calico workload endpoint, e.g. a host networked
+	// pod.  Like SkipNonSidecar it is counted once per request.
+	SkipNotCalico skipReason = "not_calico"
+	// SkipPodOptOut is an LDS request for a pod annotated not to be injected.  It is counted once per request.
+	SkipPodOptOut skipReason = "pod_opt_out"
+	// SkipNamespaceNotSelected is an LDS request for a pod in a namespace that does not match --namespace-selector.
+	// It is counted once per request.
+	SkipNamespaceNotSelected skipReason = "namespace_not_selected"
+	// SkipNoL7Policy is an LDS request for a workload that no Calico policy with application layer rules applies to.
+	// It is counted once per request.
+	SkipNoL7Policy skipReason = "no_l7_policy"
+	// SkipMeshDisabled is an LDS request for a node in a mesh configured not to be injected.  It is counted once per
+	// request.
+	SkipMeshDisabled skipReason = "mesh_disabled"
+	// SkipServiceAccount is an LDS request for a pod running as an excluded service account.  It is counted once per
+	// request.
+	SkipServiceAccount skipReason = "service_account_excluded"
+	// SkipIstioOptOut is an LDS request for a pod annotated to be left out of the Istio mesh.  It is checked before
+	// anything else about the pod, and counted once per request.
+	SkipIstioOptOut skipReason = "istio_opt_out"
+)
+
+var mutationsSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "mutations_skipped_total",
+	Help:      "Listeners not given the authz filter, by reason.",
+}, []string{"reason"})
+
+func init() {
+	prometheus.MustRegister(mutationsSkipped)
+}
+
+// skipNode returns why a node's listeners should all be left alone, or "" if they should be mutated.
+func skipNode(serviceCluster, serviceNode, nodeType, ip string) skipReason {
+	if nodeType != "sidecar" {
+		return SkipNonSidecar
+	}
+	if net.ParseIP(ip) == nil {
+		return SkipMalformedNode
+	}
+	var pod *corev1.Pod
+	if pods != nil {
+		var err error
+		pod, err = pods.byIP(ip)
+		if err != nil {
+			// Fall back to injecting, which is what the pod gets by default.
+			reportError("listeners", ErrorClassLookup, log.Fields{"ip": ip, "err": err}, "failed to look up pod")
+		} else if pod != nil && podIstioOptedOut(pod) {
+			return SkipIstioOptOut
+		}
+	}
+	if mesh := meshFor(serviceCluster, serviceNode); mesh != nil {
+		if reason := mesh.skip(serviceNode); reason != "" {
+			return reason
+		}
+	}
+	if namespaces != nil {
+		_, namespace, ok := podFromServiceNode(serviceNode)
+		if !ok || !namespaces.enabled(namespace) {
+			return SkipNamespaceNotSelected
+		}
+	}
+	if calicoEndpoints != nil {
+		ep, ok := calicoEndpoints.endpoint(ip)
+		if !ok {
+			// Dikastes is only wired into Calico networked pods, so the filter would fail every request.
+			return SkipNotCalico
+		}
+		if l7Policies != nil && !l7Policies.selects(ep) {
+			return SkipNoL7Policy
+		}
+	}
+	if pod != nil && podOptedOut(pod) {
+		return SkipPodOptOut
+	} else if pod != nil && excludedServiceAccounts != nil && excludedServiceAccounts.matches(pod) {
+		return SkipServiceAccount
+	}
+	return ""
+}
+
+func countSkip(reason skipReason) {
+	mutationsSkipped.WithLabelValues(string(reason)).Inc()
+}
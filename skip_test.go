@@ -0,0 +1,96 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+func skipped(reason skipReason) float64 {
+	return testutil.ToFloat64(mutationsSkipped.WithLabelValues(string(reason)))
+}
+
+func TestSkipCounted(t *testing.T) {
+	RegisterTestingT(t)
+
+	outbound, virtual := skipped(SkipOutbound), skipped(SkipVirtual)
+	updateListener(&v1.Listener{Name: "http_10.65.8.9_443"}, "1.2.3.4")
+	updateListener(&v1.Listener{Name: "virtual"}, "1.2.3.4")
+	Expect(skipped(SkipOutbound)).To(Equal(outbound + 1))
+	Expect(skipped(SkipVirtual)).To(Equal(virtual + 1))
+}
+
+func TestAlreadyInjectedTCP(t *testing.T) {
+	RegisterTestingT(t)
+
+	before := skipped(SkipAlreadyInjected)
+	l := v1.Listener{
+		Name:    "tcp_1.2.3.4_76",
+		Filters: []*v1.NetworkFilter{{Name: v1.TCPProxyFilter}},
+	}
+	updateListener(&l, "1.2.3.4")
+	updateListener(&l, "1.2.3.4")
+	Expect(l.Filters).To(HaveLen(2))
+	Expect(skipped(SkipAlreadyInjected)).To(Equal(before + 1))
+}
+
+func TestAlreadyInjectedHTTP(t *testing.T) {
+	RegisterTestingT(t)
+
+	cfg := &v1.HTTPFilterConfig{Filters: []v1.HTTPFilter{{Name: "router"}}}
+	l := v1.Listener{
+		Name:    "http_1.2.3.4_80",
+		Filters: []*v1.NetworkFilter{{Name: v1.HTTPConnectionManager, Config: cfg}},
+	}
+	Expect(mutator.HasAuthzFilter(&l)).To(BeFalse())
+	updateListener(&l, "1.2.3.4")
+	Expect(mutator.HasAuthzFilter(&l)).To(BeTrue())
+	updateListener(&l, "1.2.3.4")
+	Expect(l.Filters[0].Config.(*v1.HTTPFilterConfig).Filters).To(HaveLen(2))
+}
+
+func TestNonSidecarSkipCounted(t *testing.T) {
+	RegisterTestingT(t)
+
+	before := skipped(SkipNonSidecar)
+	req := newLDSRequest("ingress", strings.NewReader(`{"listeners": []}`))
+	listeners(req, restful.NewResponse(httptest.NewRecorder()))
+	Expect(skipped(SkipNonSidecar)).To(Equal(before + 1))
+}
+
+func TestExcludedInboundPortSkipped(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { pods = nil }()
+	pods = newTestPodIndex(testPod("testpod", NODE_IP, "Running", map[string]string{IstioExcludeInboundPortsAnnotation: "9090"}))
+	excluded := skipped(SkipExcludedPort)
+	metrics := &v1.Listener{Name: "tcp_" + NODE_IP + "_9090"}
+	updateListener(metrics, NODE_IP)
+	Expect(metrics.Filters).To(BeEmpty())
+	Expect(skipped(SkipExcludedPort)).To(Equal(excluded + 1))
+
+	app := &v1.Listener{Name: "tcp_" + NODE_IP + "_8080"}
+	updateListener(app, NODE_IP)
+	Expect(app.Filters).To(HaveLen(1))
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	sliAvailability = "availability"
+	sliLatency      = "latency"
+)
+
+// sloWindows are the windows burn rates are published for, covering the usual 1h/5m and 6h/30m alert pairs.
+var sloWindows = map[string]time.Duration{
+	"5m":  5 * time.Minute,
+	"30m": 30 * time.Minute,
+	"1h":  time.Hour,
+	"6h":  6 * time.Hour,
+}
+
+// sloBucketCount is enough one minute buckets for the longest window.
+const sloBucketCount = 6 * 60
+
+var sliRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "sli_requests_total",
+	Help:      "Hook requests counted towards the SLIs, by hook.",
+}, []string{"hook"})
+
+var sliErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "sli_errors_total",
+	Help:      "Hook requests that failed with a server error, by hook.",
+}, []string{"hook"})
+
+var sliSlow = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "sli_slow_requests_total",
+	Help:      "Hook requests slower than the latency objective, by hook.",
+}, []string{"hook"})
+
+var sloObjective = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "slo_objective_ratio",
+	Help:      "Target ratio of good requests, by SLI.",
+}, []string{"sli"})
+
+var sloBurnRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "slo_burn_rate",
+	Help:      "Rate at which the error budget is being spent over the window, by hook and SLI.  1 spends it exactly.",
+}, []string{"hook", "sli", "window"})
+
+func init() {
+	prometheus.MustRegister(sliRequests, sliErrors, sliSlow, sloObjective, sloBurnRate)
+}
+
+// slo tracks requests against the service level objectives.  It is set up from the --slo-* flags.
+var slo = newSLOTracker(0.999, 250*time.Millisecond, 0.99)
+
+// sloBucket counts the requests to a hook in one minute.
+type sloBucket struct {
+	minute int64
+	total  int
+	errors int
+	slow   int
+}
+
+// sloTracker keeps per minute request counts for each hook over the longest window, so that burn rates can be
+// published directly rather than needing recording rules.
+type sloTracker struct {
+	availability     float64
+	latency          time.Duration
+	latencyObjective float64
+	now              func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*[sloBucketCount]sloBucket
+}
+
+func newSLOTracker(availability float64, latency time.Duration, latencyObjective float64) *sloTracker {
+	sloObjective.WithLabelValues(sliAvailability).Set(availability)
+	sloObjective.WithLabelValues(sliLatency).Set(latencyObjective)
+	return &sloTracker{
+		availability:     availability,
+		latency:          latency,
+		latencyObjective: latencyObjective,
+		now:              time.Now,
+		buckets:          make(map[string]*[sloBucketCount]sloBucket),
+	}
+}
+
+// observe counts one hook request.  Only server errors spend the availability budget; a 4xx means Pilot sent
+// something the webhook could not use.
+func (s *sloTracker) observe(hook string, status int, d time.Duration) {
+	failed := status >= http.StatusInternalServerError
+	slow := d > s.latency
+	sliRequests.WithLabelValues(hook).Inc()
+	if failed {
+		sliErrors.WithLabelValues(hook).Inc()
+	}
+	if slow {
+		sliSlow.WithLabelValues(hook).Inc()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ring, ok := s.buckets[hook]
+	if !ok {
+		ring = &[sloBucketCount]sloBucket{}
+		s.buckets[hook] = ring
+	}
+	minute := s.now().Unix() / 60
+	b := &ring[minute%sloBucketCount]
+	if b.minute != minute {
+		*b = sloBucket{minute: minute}
+	}
+	b.total++
+	if failed {
+		b.errors++
+	}
+	if slow {
+		b.slow++
+	}
+}
+
+// burnRate returns the burn rate of the named SLI for a hook over the window: the ratio of bad requests divided by the
+// ratio the objective allows.
+func (s *sloTracker) burnRate(hook, sli string, window time.Duration) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ring, ok := s.buckets[hook]
+	if !ok {
+		return 0
+	}
+	now := s.now().Unix() / 60
+	oldest := now - int64(window/time.Minute)
+	var total, bad int
+	for _, b := range ring {
+		if b.minute <= oldest || b.minute > now {
+			continue
+		}
+		total += b.total
+		if sli == sliAvailability {
+			bad += b.errors
+		} else {
+			bad += b.slow
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	objective := s.availability
+	if sli == sliLatency {
+		objective = s.latencyObjective
+	}
+	if objective >= 1 {
+		// There is no budget to burn.
+		return 0
+	}
+	return float64(bad) / float64(total) / (1 - objective)
+}
+
+// update publishes the burn rates of every hook seen.
+func (s *sloTracker) update() {
+	s.mu.Lock()
+	hooks := make([]string, 0, len(s.buckets))
+	for hook := range s.buckets {
+		hooks = append(hooks, hook)
+	}
+	s.mu.Unlock()
+	for _, hook := range hooks {
+		for name, window := range sloWindows {
+			for _, sli := range []string{sliAvailability, sliLatency} {
+				sloBurnRate.WithLabelValues(hook, sli, name).Set(s.burnRate(hook, sli, window))
+			}
+		}
+	}
+}
+
+// run periodically publishes the burn rates.  It never returns.
+func (s *sloTracker) run() {
+	for range time.Tick(15 * time.Second) {
+		s.update()
+	}
+}
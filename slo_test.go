@@ -0,0 +1,51 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestBurnRate(t *testing.T) {
+	RegisterTestingT(t)
+
+	now := time.Unix(1500000000, 0)
+	s := newSLOTracker(0.9, 100*time.Millisecond, 0.5)
+	s.now = func() time.Time { return now }
+
+	for i := 0; i < 8; i++ {
+		s.observe("listeners", 200, time.Millisecond)
+	}
+	s.observe("listeners", 500, time.Millisecond)
+	s.observe("listeners", 400, time.Second)
+
+	// 1 in 10 failed against a 10% budget; 1 in 10 slow against a 50% budget.
+	Expect(s.burnRate("listeners", sliAvailability, time.Hour)).To(BeNumerically("~", 1.0, 1e-9))
+	Expect(s.burnRate("listeners", sliLatency, time.Hour)).To(BeNumerically("~", 0.2, 1e-9))
+	Expect(s.burnRate("clusters", sliAvailability, time.Hour)).To(Equal(0.0))
+
+	// Old requests drop out of the shorter windows.
+	now = now.Add(10 * time.Minute)
+	Expect(s.burnRate("listeners", sliAvailability, 5*time.Minute)).To(Equal(0.0))
+	Expect(s.burnRate("listeners", sliAvailability, time.Hour)).To(BeNumerically("~", 1.0, 1e-9))
+
+	// Buckets are reused once the ring wraps.
+	now = now.Add(6 * time.Hour)
+	s.observe("listeners", 200, time.Millisecond)
+	Expect(s.burnRate("listeners", sliAvailability, 6*time.Hour)).To(Equal(0.0))
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const meshConfigPollInterval = 30 * time.Second
+
+// meshConfigKey is the key of the Istio mesh config ConfigMap holding the mesh config YAML.
+const meshConfigKey = "mesh"
+
+var errMeshConfigNotLoaded = errors.New("Istio mesh config not yet loaded")
+
+// protocolSniffing says whether Pilot sniffs the protocol of inbound connections.  It is nil unless
+// --protocol-sniffing is "on" or "mesh".
+var protocolSniffing *sniffingConfig
+
+// sniffingConfig mirrors Pilot's protocol sniffing setting.  With sniffing on, Pilot no longer names inbound listeners
+// after their protocol, so the webhook classifies them by the connection manager Pilot gave them instead.
+type sniffingConfig struct {
+	mu      sync.RWMutex
+	inbound bool
+	loaded  bool
+}
+
+// meshSettings is the part of the Istio mesh config the webhook mirrors.
+type meshSettings struct {
+	EnableProtocolSniffingForInbound bool `json:"enableProtocolSniffingForInbound"`
+}
+
+// newSniffingConfig returns the config for a --protocol-sniffing mode, which is nil for "off".  The "mesh" mode
+// starts unloaded, to be kept up to date by run.
+func newSniffingConfig(mode string) (*sniffingConfig, error) {
+	switch mode {
+	case "off":
+		return nil, nil
+	case "on":
+		return &sniffingConfig{inbound: true, loaded: true}, nil
+	case "mesh":
+		return &sniffingConfig{}, nil
+	}
+	return nil, fmt.Errorf("unknown protocol sniffing mode %q", mode)
+}
+
+func parseMeshSettings(mesh string) (meshSettings, error) {
+	var settings meshSettings
+	err := yaml.NewYAMLOrJSONDecoder(strings.NewReader(mesh), 4096).Decode(&settings)
+	return settings, err
+}
+
+// inboundEnabled reports whether inbound listeners should be classified by their filters rather than their names.
+func (s *sniffingConfig) inboundEnabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.inbound
+}
+
+// apply updates the setting from the mesh config ConfigMap.
+func (s *sniffingConfig) apply(cm *corev1.ConfigMap) error {
+	settings, err := parseMeshSettings(cm.Data[meshConfigKey])
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.loaded || s.inbound != settings.EnableProtocolSniffingForInbound {
+		log.WithField("inbound", settings.EnableProtocolSniffingForInbound).Info("Loaded Istio protocol sniffing setting.")
+		// Names are parsed differently with sniffing, and the entries for the old setting will not be used again.
+		listenerNames.invalidate()
+		bumpConfigVersion("protocol-sniffing")
+	}
+	s.inbound = settings.EnableProtocolSniffingForInbound
+	s.loaded = true
+	return nil
+}
+
+// run polls the mesh config ConfigMap for changes.  It never returns.
+func (s *sniffingConfig) run(configMaps typedcorev1.ConfigMapInterface, name string) {
+	for {
+		cm, err := configMaps.Get(name, metav1.GetOptions{})
+		if err == nil {
+			err = s.apply(cm)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{"configmap": name, "err": err}).Warn("Unable to load Istio mesh config.")
+		}
+		time.Sleep(meshConfigPollInterval)
+	}
+}
+
+// check is a readiness check that fails until the mesh config has been loaded, so that listeners are not
+// misclassified meanwhile.
+func (s *sniffingConfig) check() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.loaded {
+		return errMeshConfigNotLoaded
+	}
+	return nil
+}
+
+// listenerProtocol classifies a listener by whether Pilot gave it an HTTP connection manager.
+func listenerProtocol(listener *v1.Listener) Protocol {
+	for _, filter := range listener.Filters {
+		if filter.Name == v1.HTTPConnectionManager {
+			return HTTP
+		}
+	}
+	return TCP
+}
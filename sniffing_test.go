@@ -0,0 +1,72 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestSniffingConfigFromMesh(t *testing.T) {
+	RegisterTestingT(t)
+
+	s, err := newSniffingConfig("mesh")
+	Expect(err).To(BeNil())
+	Expect(s.check()).To(Equal(errMeshConfigNotLoaded))
+
+	cm := &corev1.ConfigMap{Data: map[string]string{
+		meshConfigKey: "mixerCheckServer: istio-policy.istio-system:15004\nenableProtocolSniffingForInbound: true\n",
+	}}
+	Expect(s.apply(cm)).To(BeNil())
+	Expect(s.check()).To(BeNil())
+	Expect(s.inboundEnabled()).To(BeTrue())
+
+	cm.Data[meshConfigKey] = "mixerCheckServer: istio-policy.istio-system:15004\n"
+	Expect(s.apply(cm)).To(BeNil())
+	Expect(s.inboundEnabled()).To(BeFalse())
+
+	_, err = newSniffingConfig("sometimes")
+	Expect(err).NotTo(BeNil())
+}
+
+func TestClassifySniffedListeners(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { protocolSniffing = nil }()
+	protocolSniffing, _ = newSniffingConfig("on")
+
+	http := &v1.Listener{
+		Name:    "1.2.3.4_8080",
+		Filters: []*v1.NetworkFilter{{Name: v1.HTTPConnectionManager}},
+	}
+	direction, proto := classifyListener(http, "1.2.3.4")
+	Expect(direction).To(Equal(INBOUND))
+	Expect(proto).To(Equal(HTTP))
+
+	// A listener Pilot named for HTTP is still classified by what it has.
+	tcp := &v1.Listener{
+		Name:    "http_1.2.3.4_3306",
+		Filters: []*v1.NetworkFilter{{Name: v1.TCPProxyFilter}},
+	}
+	direction, proto = classifyListener(tcp, "1.2.3.4")
+	Expect(direction).To(Equal(INBOUND))
+	Expect(proto).To(Equal(TCP))
+
+	direction, _ = classifyListener(&v1.Listener{Name: "10.65.8.9_443"}, "1.2.3.4")
+	Expect(direction).To(Equal(OUTBOUND))
+}
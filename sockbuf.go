@@ -0,0 +1,66 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// socketBuffers is implemented by connections whose kernel buffers can be sized, e.g. *net.UnixConn.
+type socketBuffers interface {
+	SetReadBuffer(bytes int) error
+	SetWriteBuffer(bytes int) error
+}
+
+// sizedListener sets the kernel buffer sizes of the connections it accepts.  Larger buffers let Pilot write a big
+// body, and the webhook read it, in fewer system calls.
+type sizedListener struct {
+	net.Listener
+	readBuffer  int
+	writeBuffer int
+}
+
+// withSocketBuffers wraps a listener to size the buffers of its connections.  Sizes of 0 leave the OS default.
+func withSocketBuffers(lis net.Listener, readBuffer, writeBuffer int) net.Listener {
+	if readBuffer <= 0 && writeBuffer <= 0 {
+		return lis
+	}
+	return &sizedListener{Listener: lis, readBuffer: readBuffer, writeBuffer: writeBuffer}
+}
+
+func (l *sizedListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return c, err
+	}
+	b, ok := c.(socketBuffers)
+	if !ok {
+		return c, nil
+	}
+	// The kernel clamps sizes to its limits rather than failing, so errors here mean the connection is already gone.
+	if l.readBuffer > 0 {
+		if err := b.SetReadBuffer(l.readBuffer); err != nil {
+			log.WithField("err", err).Debug("Unable to set socket read buffer")
+		}
+	}
+	if l.writeBuffer > 0 {
+		if err := b.SetWriteBuffer(l.writeBuffer); err != nil {
+			log.WithField("err", err).Debug("Unable to set socket write buffer")
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+type bufferedConn struct {
+	net.Conn
+	read, write int
+}
+
+func (c *bufferedConn) SetReadBuffer(n int) error {
+	c.read = n
+	return nil
+}
+
+func (c *bufferedConn) SetWriteBuffer(n int) error {
+	c.write = n
+	return nil
+}
+
+type connListener struct {
+	net.Listener
+	conn net.Conn
+}
+
+func (l connListener) Accept() (net.Conn, error) {
+	return l.conn, nil
+}
+
+func TestSocketBuffers(t *testing.T) {
+	RegisterTestingT(t)
+
+	lis := connListener{conn: &bufferedConn{}}
+	Expect(withSocketBuffers(lis, 0, 0)).To(Equal(lis))
+
+	c, err := withSocketBuffers(lis, 1<<20, 0).Accept()
+	Expect(err).To(BeNil())
+	Expect(c.(*bufferedConn).read).To(Equal(1 << 20))
+	Expect(c.(*bufferedConn).write).To(Equal(0))
+
+	// Connections without buffers to size are accepted as they are.
+	_, err = withSocketBuffers(connListener{conn: &net.TCPConn{}}, 1<<20, 1<<20).Accept()
+	Expect(err).To(BeNil())
+}
+
+func TestSocketBuffersUnix(t *testing.T) {
+	RegisterTestingT(t)
+
+	dir, err := ioutil.TempDir("", "sockbuf")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "webhook.sock")
+	lis, err := net.Listen("unix", path)
+	Expect(err).To(BeNil())
+	lis = withSocketBuffers(lis, 1<<20, 1<<20)
+	defer lis.Close()
+
+	go func() {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := lis.Accept()
+	Expect(err).To(BeNil())
+	c.Close()
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var spiffeRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "spiffe_rejections_total",
+	Help:      "Hook callers over mTLS rejected for their SPIFFE ID, by reason.",
+}, []string{"reason"})
+
+func init() {
+	prometheus.MustRegister(spiffeRejections)
+}
+
+// spiffeVerifier checks the SPIFFE ID in the URI SAN of hook callers' certificates, after the certificates themselves
+// have been verified against the client CA.
+type spiffeVerifier struct {
+	trustDomain string
+	// ids are the SPIFFE IDs allowed, with their trust domain lowercased, or nil for any in the trust domain.
+	// Service accounts are allowed as the IDs Istio gives them, spiffe://<trust domain>/ns/<namespace>/sa/<name>.
+	ids map[string]bool
+}
+
+// newSPIFFEVerifier returns a verifier for --spiffe-trust-domain and --spiffe-ids, a comma separated list of SPIFFE
+// IDs or <namespace>/<service account> pairs.  A service account is only allowed in its own namespace, since any
+// namespace could have one of the same name.
+func newSPIFFEVerifier(trustDomain, ids string) (*spiffeVerifier, error) {
+	if trustDomain == "" || strings.ContainsAny(trustDomain, "/:") {
+		return nil, fmt.Errorf("invalid trust domain %q", trustDomain)
+	}
+	v := &spiffeVerifier{trustDomain: strings.ToLower(trustDomain)}
+	for _, id := range strings.Split(ids, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if v.ids == nil {
+			v.ids = map[string]bool{}
+		}
+		if !strings.HasPrefix(id, "spiffe://") {
+			c := strings.Split(id, "/")
+			if len(c) != 2 || c[0] == "" || c[1] == "" {
+				return nil, fmt.Errorf("%q is neither a SPIFFE ID nor a <namespace>/<service account> pair", id)
+			}
+			v.ids[spiffeID(v.trustDomain, "/ns/"+c[0]+"/sa/"+c[1])] = true
+			continue
+		}
+		u, err := url.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		td, path, err := parseSPIFFEID(u)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", id, err)
+		}
+		if td != v.trustDomain {
+			return nil, fmt.Errorf("%s is not in trust domain %s", id, v.trustDomain)
+		}
+		v.ids[spiffeID(td, path)] = true
+	}
+	return v, nil
+}
+
+// spiffeID returns the SPIFFE ID with a trust domain and path, in the form the allowed IDs are kept in.
+func spiffeID(trustDomain, path string) string {
+	return "spiffe://" + trustDomain + path
+}
+
+// parseSPIFFEID returns the trust domain and path of a SPIFFE ID.
+func parseSPIFFEID(u *url.URL) (string, string, error) {
+	switch {
+	case u.Scheme != "spiffe":
+		return "", "", errors.New("not a spiffe URI")
+	case u.Host == "" || u.User != nil || u.Port() != "":
+		return "", "", errors.New("invalid trust domain")
+	case u.RawQuery != "" || u.Fragment != "":
+		return "", "", errors.New("SPIFFE IDs have no query or fragment")
+	}
+	return strings.ToLower(u.Host), u.Path, nil
+}
+
+// verify checks the SPIFFE ID of a verified client certificate.
+func (v *spiffeVerifier) verify(cert *x509.Certificate) error {
+	var spiffe []*url.URL
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			spiffe = append(spiffe, u)
+		}
+	}
+	if len(spiffe) != 1 {
+		// The SPIFFE X.509-SVID spec allows exactly one.
+		return v.reject("no_id", cert, fmt.Errorf("certificate has %d SPIFFE IDs", len(spiffe)))
+	}
+	td, path, err := parseSPIFFEID(spiffe[0])
+	if err != nil {
+		return v.reject("invalid_id", cert, err)
+	}
+	if td != v.trustDomain {
+		return v.reject("trust_domain", cert, fmt.Errorf("%s is not in trust domain %s", spiffe[0], v.trustDomain))
+	}
+	if v.ids != nil && !v.ids[spiffeID(td, path)] {
+		return v.reject("not_allowed", cert, fmt.Errorf("%s is not allowed", spiffe[0]))
+	}
+	return nil
+}
+
+func (v *spiffeVerifier) reject(reason string, cert *x509.Certificate, err error) error {
+	spiffeRejections.WithLabelValues(reason).Inc()
+	log.WithFields(log.Fields{"subject": cert.Subject.String(), "err": err}).Warn("Rejected hook caller's SPIFFE ID.")
+	return err
+}
+
+// verifyPeerCertificate is a tls.Config.VerifyPeerCertificate that checks the leaf of the chain the client
+// certificate was verified with.
+func (v *spiffeVerifier) verifyPeerCertificate(_ [][]byte, chains [][]*x509.Certificate) error {
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return errors.New("client certificate was not verified")
+	}
+	return v.verify(chains[0][0])
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testCert is a certificate and its key, for mTLS tests.
+type testCert struct {
+	cert *x509.Certificate
+	der  []byte
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert returns a certificate with the given URI SANs, signed by parent, or self-signed CA if parent is nil.
+func newTestCert(name string, parent *testCert, uris ...string) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	for _, u := range uris {
+		parsed, err := url.Parse(u)
+		Expect(err).To(BeNil())
+		template.URIs = append(template.URIs, parsed)
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	Expect(err).To(BeNil())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).To(BeNil())
+	return &testCert{cert: cert, der: der, key: key}
+}
+
+func TestNewSPIFFEVerifier(t *testing.T) {
+	RegisterTestingT(t)
+
+	v, err := newSPIFFEVerifier("Cluster.Local", "")
+	Expect(err).To(BeNil())
+	Expect(v.trustDomain).To(Equal("cluster.local"))
+	Expect(v.ids).To(BeNil())
+
+	v, err = newSPIFFEVerifier("cluster.local",
+		"spiffe://Cluster.Local/ns/istio-system/sa/pilot, istio-system/istio-pilot-service-account")
+	Expect(err).To(BeNil())
+	Expect(v.ids).To(Equal(map[string]bool{
+		"spiffe://cluster.local/ns/istio-system/sa/pilot":                       true,
+		"spiffe://cluster.local/ns/istio-system/sa/istio-pilot-service-account": true,
+	}))
+
+	for _, bad := range [][2]string{
+		{"", ""},
+		{"spiffe://cluster.local", ""},
+		{"cluster.local", "spiffe://other.domain/ns/istio-system/sa/pilot"},
+		{"cluster.local", "spiffe://cluster.local/ns/x?y=z"},
+		{"cluster.local", "ns/istio-system/sa/pilot"},
+		{"cluster.local", "istio-pilot-service-account"},
+		{"cluster.local", "/istio-pilot-service-account"},
+	} {
+		_, err = newSPIFFEVerifier(bad[0], bad[1])
+		Expect(err).NotTo(BeNil(), "%v", bad)
+	}
+}
+
+func TestSPIFFEVerify(t *testing.T) {
+	RegisterTestingT(t)
+
+	ca := newTestCert("ca", nil)
+	pilot := newTestCert("pilot", ca, "spiffe://cluster.local/ns/istio-system/sa/istio-pilot-service-account")
+	other := newTestCert("other", ca, "spiffe://cluster.local/ns/default/sa/default")
+	foreign := newTestCert("foreign", ca, "spiffe://other.domain/ns/istio-system/sa/istio-pilot-service-account")
+	none := newTestCert("none", ca)
+	two := newTestCert("two", ca, "spiffe://cluster.local/ns/a/sa/a", "spiffe://cluster.local/ns/b/sa/b")
+
+	any, err := newSPIFFEVerifier("cluster.local", "")
+	Expect(err).To(BeNil())
+	Expect(any.verify(pilot.cert)).To(Succeed())
+	Expect(any.verify(other.cert)).To(Succeed())
+
+	before := testutil.ToFloat64(spiffeRejections.WithLabelValues("trust_domain"))
+	Expect(any.verify(foreign.cert)).NotTo(Succeed())
+	Expect(testutil.ToFloat64(spiffeRejections.WithLabelValues("trust_domain"))).To(Equal(before + 1))
+	Expect(any.verify(none.cert)).NotTo(Succeed())
+	Expect(any.verify(two.cert)).NotTo(Succeed())
+
+	bySA, err := newSPIFFEVerifier("cluster.local", "istio-system/istio-pilot-service-account")
+	Expect(err).To(BeNil())
+	Expect(bySA.verify(pilot.cert)).To(Succeed())
+	// The same service account name in another namespace is not allowed.
+	impostor := newTestCert("impostor", ca, "spiffe://cluster.local/ns/default/sa/istio-pilot-service-account")
+	Expect(bySA.verify(impostor.cert)).NotTo(Succeed())
+	before = testutil.ToFloat64(spiffeRejections.WithLabelValues("not_allowed"))
+	Expect(bySA.verify(other.cert)).NotTo(Succeed())
+	Expect(testutil.ToFloat64(spiffeRejections.WithLabelValues("not_allowed"))).To(Equal(before + 1))
+
+	byID, err := newSPIFFEVerifier("cluster.local", "spiffe://CLUSTER.local/ns/default/sa/default")
+	Expect(err).To(BeNil())
+	Expect(byID.verify(other.cert)).To(Succeed())
+	Expect(byID.verify(pilot.cert)).NotTo(Succeed())
+
+	Expect(any.verifyPeerCertificate(nil, nil)).NotTo(Succeed())
+	Expect(any.verifyPeerCertificate(nil, [][]*x509.Certificate{{pilot.cert, ca.cert}})).To(Succeed())
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/emicklei/go-restful"
+)
+
+const statsAttribute = "calico.hookStats"
+
+// hookStats records what a hook handler did with a request, so that the filters around it can report on it.
+type hookStats struct {
+	// Listeners is the number of listeners in an LDS request.
+	Listeners int
+	// Injected is the number of authz filters inserted.
+	Injected int
+	// ClustersAdded is the number of clusters added to a CDS response.
+	ClustersAdded int
+	// EndpointsRemoved is the number of hosts removed from an EDS response.
+	EndpointsRemoved int
+	// Steps are the timings of the handler's steps, in order.
+	Steps []stepTiming
+}
+
+// stepTiming is how long one step of a hook handler took.
+type stepTiming struct {
+	Name     string
+	Duration time.Duration
+}
+
+// statsFor returns the stats for the request, creating them on first use.
+func statsFor(req *restful.Request) *hookStats {
+	if s, ok := req.Attribute(statsAttribute).(*hookStats); ok {
+		return s
+	}
+	s := &hookStats{}
+	req.SetAttribute(statsAttribute, s)
+	return s
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// streamArrays is set by --stream-arrays, to mutate LDS and CDS resources one at a time as they are read, rather than
+// reading the whole request first.
+var streamArrays bool
+
+// streamWrites is set by --stream-writes, to write streamed responses as they are mutated rather than once the whole
+// request has been read.
+var streamWrites bool
+
+var errTrailingData = errors.New("unexpected data after top-level value")
+
+// streamXDS copies an xDS response from r to w, passing each resource in the array under key through item as it is
+// decoded, and appending the resources extra returns once the array has been read.  Only one resource is held in
+// memory at a time.  Unlike mutator.XDS.EncodeTo, fields are written in the order they were read.  It returns an
+// error if r is not a JSON object, or if item does.
+func streamXDS(r io.Reader, w io.Writer, key string,
+	item func(json.RawMessage) (json.RawMessage, error), extra func() []json.RawMessage) error {
+	dec := json.NewDecoder(r)
+	bw := bufio.NewWriter(w)
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	// A null response is treated as an empty one, as decodeRawXDS does.
+	if tok != nil && tok != json.Delim('{') {
+		return fmt.Errorf("expected an object, found %v", tok)
+	}
+	isNull := tok == nil
+	bw.WriteByte('{')
+	fields, seen := 0, false
+	for !isNull && dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		k := tok.(string)
+		writeStreamKey(bw, k, fields)
+		fields++
+		if k != key {
+			var v json.RawMessage
+			if err := dec.Decode(&v); err != nil {
+				return err
+			}
+			bw.Write(v)
+			continue
+		}
+		seen = true
+		if err := streamArray(dec, bw, item, extra); err != nil {
+			return err
+		}
+	}
+	if !isNull {
+		// The closing brace.
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+	}
+	if !seen {
+		if items := extra(); len(items) > 0 {
+			writeStreamKey(bw, key, fields)
+			bw.WriteByte('[')
+			writeStreamItems(bw, items, 0)
+			bw.WriteByte(']')
+		}
+	}
+	bw.WriteByte('}')
+	if _, err := dec.Token(); err != io.EOF {
+		if err == nil {
+			err = errTrailingData
+		}
+		return err
+	}
+	return bw.Flush()
+}
+
+// earlyWriter writes a streamed response straight to the client, which receives it in chunks as it is mutated.  It
+// notes whether anything has been written, after which a failure can no longer be reported with an error status.
+type earlyWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (e *earlyWriter) Write(p []byte) (int, error) {
+	e.started = e.started || len(p) > 0
+	return e.w.Write(p)
+}
+
+// streamTarget returns where a streamed response should be written: the response itself for --stream-writes, or buf
+// to be written once the request has been read.  The earlyWriter is nil unless writing early.
+func streamTarget(resp io.Writer, buf io.Writer) (io.Writer, *earlyWriter) {
+	if !streamWrites {
+		return buf, nil
+	}
+	early := &earlyWriter{w: resp}
+	return early, early
+}
+
+// streamArray copies the array the decoder is at, passing each element through item and appending extra.  A null
+// array is written as an empty one.
+func streamArray(dec *json.Decoder, bw *bufio.Writer,
+	item func(json.RawMessage) (json.RawMessage, error), extra func() []json.RawMessage) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != nil && tok != json.Delim('[') {
+		return fmt.Errorf("expected an array, found %v", tok)
+	}
+	bw.WriteByte('[')
+	n := 0
+	for tok != nil && dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		out, err := item(raw)
+		if err != nil {
+			return err
+		}
+		n = writeStreamItems(bw, []json.RawMessage{out}, n)
+	}
+	if tok != nil {
+		// The closing bracket.
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+	}
+	writeStreamItems(bw, extra(), n)
+	bw.WriteByte(']')
+	return nil
+}
+
+// writeStreamKey writes an object key after the n fields already written.
+func writeStreamKey(bw *bufio.Writer, k string, n int) {
+	if n > 0 {
+		bw.WriteByte(',')
+	}
+	name, _ := json.Marshal(k)
+	bw.Write(name)
+	bw.WriteByte(':')
+}
+
+// writeStreamItems writes array elements after the n already written, and returns how many have been written.
+func writeStreamItems(bw *bufio.Writer, items []json.RawMessage, n int) int {
+	for _, item := range items {
+		if n > 0 {
+			bw.WriteByte(',')
+		}
+		bw.Write(item)
+		n++
+	}
+	return n
+}
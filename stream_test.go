@@ -0,0 +1,179 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+func TestStreamXDS(t *testing.T) {
+	RegisterTestingT(t)
+
+	upper := func(raw json.RawMessage) (json.RawMessage, error) {
+		return bytes.ToUpper(raw), nil
+	}
+	for _, tc := range []struct {
+		in, extra, out string
+	}{
+		{`{"b":2, "items":[ "x", "y" ],"a":{"c":1}}`, ``, `{"b":2,"items":["X","Y"],"a":{"c":1}}`},
+		{`{"items":[]}`, `"z"`, `{"items":["z"]}`},
+		{`{"items":["x"]}`, `"z"`, `{"items":["X","z"]}`},
+		{`{"items":null}`, `"z"`, `{"items":["z"]}`},
+		{`{"a":1}`, `"z"`, `{"a":1,"items":["z"]}`},
+		{`{}`, `"z"`, `{"items":["z"]}`},
+		{`{}`, ``, `{}`},
+		{`null`, ``, `{}`},
+		{" {\"a\":1}\n", ``, `{"a":1}`},
+	} {
+		extra := func() []json.RawMessage {
+			if tc.extra == "" {
+				return nil
+			}
+			return []json.RawMessage{json.RawMessage(tc.extra)}
+		}
+		var out bytes.Buffer
+		Expect(streamXDS(strings.NewReader(tc.in), &out, "items", upper, extra)).To(BeNil(), tc.in)
+		Expect(out.String()).To(Equal(tc.out), tc.in)
+	}
+}
+
+func TestStreamXDSErrors(t *testing.T) {
+	RegisterTestingT(t)
+
+	same := func(raw json.RawMessage) (json.RawMessage, error) { return raw, nil }
+	none := func() []json.RawMessage { return nil }
+	for _, in := range []string{``, `[]`, `1`, `{"items":{}}`, `{"items":[1,}`, `{"items":[]`, `{"a":1} {}`, `{"a":}`} {
+		var out bytes.Buffer
+		Expect(streamXDS(strings.NewReader(in), &out, "items", same, none)).NotTo(BeNil(), in)
+	}
+
+	failed := func(raw json.RawMessage) (json.RawMessage, error) { return nil, errNoHTTPConnectionManager }
+	var out bytes.Buffer
+	err := streamXDS(strings.NewReader(`{"items":[1]}`), &out, "items", failed, none)
+	Expect(err).To(Equal(errNoHTTPConnectionManager))
+}
+
+func TestStreamedListenersMatchBuffered(t *testing.T) {
+	RegisterTestingT(t)
+
+	for _, body := range [][]byte{benchLDS(8), []byte(ldsWithUnknownFields)} {
+		serve := func() (*restful.Request, *httptest.ResponseRecorder) {
+			req := newLDSRequest("sidecar", bytes.NewReader(body))
+			recorder := httptest.NewRecorder()
+			listeners(req, restful.NewResponse(recorder))
+			return req, recorder
+		}
+		bufferedReq, buffered := serve()
+		streamArrays = true
+		streamedReq, streamed := serve()
+		streamArrays = false
+
+		Expect(streamed.Code).To(Equal(http.StatusOK))
+		Expect(streamed.Body.String()).To(MatchJSON(buffered.Body.String()))
+		Expect(statsFor(streamedReq).Listeners).To(Equal(statsFor(bufferedReq).Listeners))
+		Expect(statsFor(streamedReq).Injected).To(Equal(statsFor(bufferedReq).Injected))
+	}
+}
+
+func TestStreamedListenersBadRequest(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { streamArrays = false }()
+	streamArrays = true
+	for _, body := range []string{`{"listeners":[{"name":1}]}`, `{"listeners":[`} {
+		req := newLDSRequest("sidecar", strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		listeners(req, restful.NewResponse(recorder))
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest), body)
+	}
+
+	defer func() { maxPayloadBytes = 0 }()
+	maxPayloadBytes = 64
+	req := newLDSRequest("sidecar", bytes.NewReader(benchLDS(8)))
+	recorder := httptest.NewRecorder()
+	listeners(req, restful.NewResponse(recorder))
+	Expect(recorder.Code).To(Equal(http.StatusRequestEntityTooLarge))
+}
+
+func TestStreamedClusters(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { dikastes, streamArrays = nil, false }()
+	dikastes = staticResolver("tcp://10.96.0.20:9000")
+	streamArrays = true
+
+	serve := func(body string) (*restful.Request, *httptest.ResponseRecorder) {
+		req := newCDSRequest("sidecar", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		clusters(req, restful.NewResponse(rec))
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		return req, rec
+	}
+	authz := string(mutator.ClusterSnippet(AuthZClusterName, "tcp://10.96.0.20:9000"))
+
+	req, rec := serve(`{"clusters":[{"name":"in.80"}]}`)
+	Expect(rec.Body.String()).To(Equal(`{"clusters":[{"name":"in.80"},` + authz + `]}`))
+	Expect(statsFor(req).ClustersAdded).To(Equal(1))
+
+	req, rec = serve(`{"version":"1"}`)
+	Expect(rec.Body.String()).To(Equal(`{"version":"1","clusters":[` + authz + `]}`))
+	Expect(statsFor(req).ClustersAdded).To(Equal(1))
+
+	// An up to date authz cluster is left as it is, and a stale one replaced.
+	req, rec = serve(`{"clusters":[` + authz + `,{"name":"in.80"}]}`)
+	Expect(rec.Body.String()).To(Equal(`{"clusters":[` + authz + `,{"name":"in.80"}]}`))
+	Expect(statsFor(req).ClustersAdded).To(Equal(0))
+	req, rec = serve(`{"clusters":[{"name":"` + AuthZClusterName + `"}]}`)
+	Expect(rec.Body.String()).To(Equal(`{"clusters":[` + authz + `]}`))
+	Expect(statsFor(req).ClustersAdded).To(Equal(1))
+}
+
+func TestStreamWrites(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { streamArrays, streamWrites = false, false }()
+	serve := func(body []byte) *httptest.ResponseRecorder {
+		req := newLDSRequest("sidecar", bytes.NewReader(body))
+		recorder := httptest.NewRecorder()
+		listeners(req, restful.NewResponse(recorder))
+		return recorder
+	}
+	body := benchLDS(64)
+	streamArrays = true
+	buffered := serve(body)
+	streamWrites = true
+	early := serve(body)
+	Expect(early.Code).To(Equal(http.StatusOK))
+	Expect(early.Body.String()).To(Equal(buffered.Body.String()))
+
+	// A request that is malformed before anything is written is still rejected.
+	Expect(serve([]byte(`{"listeners":[{"name":1}]}`)).Code).To(Equal(http.StatusBadRequest))
+
+	// Once the response has started it can only be cut short.
+	truncated := serve(body[:len(body)-2])
+	Expect(truncated.Code).To(Equal(http.StatusOK))
+	Expect(truncated.Body.Len()).To(BeNumerically(">", 0))
+	Expect(json.Valid(truncated.Body.Bytes())).To(BeFalse())
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var hookRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "requests_total",
+	Help:      "Hook requests handled, by hook, service cluster and HTTP status code.",
+}, []string{"hook", "service_cluster", "code"})
+
+func init() {
+	prometheus.MustRegister(hookRequests)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.ReadCloser
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += n
+	return n, err
+}
+
+// summarized returns a route filter that logs one summary line for each call of the named hook, so that successful
+// mutations can be verified from the logs.
+func summarized(hook string) restful.FilterFunction {
+	return func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		start := time.Now()
+		in := &countingReader{ReadCloser: req.Request.Body}
+		req.Request.Body = in
+		out := &captureWriter{ResponseWriter: resp.ResponseWriter}
+		resp.ResponseWriter = out
+
+		chain.ProcessFilter(req, resp)
+
+		duration := time.Since(start)
+		cluster := serviceClusterLabels.label(req.PathParameter("serviceCluster"))
+		hookRequests.WithLabelValues(hook, cluster, strconv.Itoa(resp.StatusCode())).Inc()
+		observePayloadSizes(hook, cluster, req.PathParameter("serviceNode"), in.n, out.n)
+		observeLatency(req, hook, cluster, duration)
+		slo.observe(hook, resp.StatusCode(), duration)
+
+		stats := statsFor(req)
+		fields := log.Fields{
+			"hook":           hook,
+			"serviceCluster": req.PathParameter("serviceCluster"),
+			"serviceNode":    req.PathParameter("serviceNode"),
+			"status":         resp.StatusCode(),
+			"bytesIn":        in.n,
+			"bytesOut":       out.n,
+			"duration":       duration,
+		}
+		if hook == "listeners" {
+			fields["listeners"] = stats.Listeners
+			fields["filtersInjected"] = stats.Injected
+		}
+		if hook == "clusters" {
+			fields["clustersAdded"] = stats.ClustersAdded
+		}
+		if hook == "endpoints" {
+			fields["endpointsRemoved"] = stats.EndpointsRemoved
+		}
+		if isDryRun(req) {
+			fields["dryRun"] = true
+		}
+		log.WithFields(fields).Info("Handled hook request.")
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestCountingReader(t *testing.T) {
+	RegisterTestingT(t)
+
+	r := &countingReader{ReadCloser: ioutil.NopCloser(strings.NewReader("twelve bytes"))}
+	b, err := ioutil.ReadAll(r)
+	Expect(err).To(BeNil())
+	Expect(b).To(HaveLen(12))
+	Expect(r.n).To(Equal(12))
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const syslogAppName = "pilot-webhook"
+const syslogLocalSocket = "/dev/log"
+
+// syslogFacilityDaemon is the "system daemons" facility code from RFC 5424.
+const syslogFacilityDaemon = 3
+
+// RFC 5424 caps TIMESTAMP precision at microseconds.
+const syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+var syslogSeverity = map[log.Level]int{
+	log.PanicLevel: 1,
+	log.FatalLevel: 2,
+	log.ErrorLevel: 3,
+	log.WarnLevel:  4,
+	log.InfoLevel:  6,
+	log.DebugLevel: 7,
+}
+
+// syslogHook is a logrus hook that sends each entry as an RFC 5424 message to a local or remote syslog daemon.
+type syslogHook struct {
+	network  string
+	addr     string
+	hostname string
+	pid      int
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// newSyslogHook creates a hook for the given target, which is "local" for the local syslog socket, or a URL of the form
+// udp://host:port, tcp://host:port or unix:///path.
+func newSyslogHook(target string) (*syslogHook, error) {
+	h := &syslogHook{pid: os.Getpid()}
+	if target == "local" {
+		h.network, h.addr = "unixgram", syslogLocalSocket
+	} else {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		switch u.Scheme {
+		case "udp", "tcp":
+			h.network, h.addr = u.Scheme, u.Host
+		case "unix":
+			h.network, h.addr = "unixgram", u.Path
+		default:
+			return nil, fmt.Errorf("unsupported syslog scheme %q", u.Scheme)
+		}
+	}
+	h.hostname, _ = os.Hostname()
+	if h.hostname == "" {
+		h.hostname = "-"
+	}
+	if err := h.connect(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *syslogHook) connect() error {
+	conn, err := net.Dial(h.network, h.addr)
+	if err != nil {
+		return err
+	}
+	h.conn = conn
+	return nil
+}
+
+func (h *syslogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *syslogHook) Fire(entry *log.Entry) error {
+	msg := h.format(entry)
+	if h.network == "tcp" {
+		// RFC 6587 octet counting, since messages may contain newlines.
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conn == nil {
+		if err := h.connect(); err != nil {
+			return err
+		}
+	}
+	_, err := h.conn.Write(msg)
+	if err != nil {
+		// The daemon may have restarted; reconnect and retry once.
+		h.conn.Close()
+		h.conn = nil
+		if err = h.connect(); err != nil {
+			return err
+		}
+		_, err = h.conn.Write(msg)
+	}
+	return err
+}
+
+// format renders the entry as an RFC 5424 message.  Fields are appended to the message as key=value pairs rather than
+// structured data, which would need a registered enterprise number.
+func (h *syslogHook) format(entry *log.Entry) []byte {
+	var b bytes.Buffer
+	pri := syslogFacilityDaemon*8 + syslogSeverity[entry.Level]
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d - - %s",
+		pri, entry.Time.UTC().Format(syslogTimeFormat), h.hostname, syslogAppName, h.pid, entry.Message)
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%q", k, fmt.Sprint(entry.Data[k]))
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestSyslogFormat(t *testing.T) {
+	RegisterTestingT(t)
+
+	h := &syslogHook{hostname: "node1", pid: 42}
+	entry := &log.Entry{
+		Time:    time.Date(2018, 3, 1, 12, 30, 0, 123456789, time.UTC),
+		Level:   log.WarnLevel,
+		Message: "Suppressed repeated error logs",
+		Data:    log.Fields{"suppressed": 3, "error": "failed to read"},
+	}
+	Expect(string(h.format(entry))).To(Equal(
+		`<28>1 2018-03-01T12:30:00.123456Z node1 pilot-webhook 42 - - Suppressed repeated error logs ` +
+			`error="failed to read" suppressed="3"`))
+}
+
+func TestSyslogUDP(t *testing.T) {
+	RegisterTestingT(t)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer conn.Close()
+
+	h, err := newSyslogHook("udp://" + conn.LocalAddr().String())
+	Expect(err).To(BeNil())
+	err = h.Fire(&log.Entry{Time: time.Now(), Level: log.ErrorLevel, Message: "boom"})
+	Expect(err).To(BeNil())
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	Expect(err).To(BeNil())
+	Expect(string(buf[:n])).To(HavePrefix("<27>1 "))
+	Expect(string(buf[:n])).To(HaveSuffix(" - - boom"))
+}
+
+func TestSyslogBadTarget(t *testing.T) {
+	RegisterTestingT(t)
+
+	_, err := newSyslogHook("http://example.com")
+	Expect(err).ToNot(BeNil())
+}
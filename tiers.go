@@ -0,0 +1,83 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// WorkloadTiersMetadata is the gRPC metadata carrying the tiers of the policies that apply to the workload on authz
+// checks, in evaluation order and separated by commas.
+const WorkloadTiersMetadata = "x-calico-workload-tiers"
+
+// workloadTiers resolves the policy tiers that apply to each workload.  It is nil unless --propagate-tiers is set.
+var workloadTiers *tierResolver
+
+// tierResolver finds the tiers of the policies in an index that apply to a workload, and puts them in the order given
+// by --tier-order.  Neither libcalico-go nor Typha say how tiers are ordered, so it has to be configured.
+type tierResolver struct {
+	policies *policyIndex
+	rank     map[string]int
+}
+
+// newTierResolver returns a resolver over the given index, which must hold all policies, ordering tiers as in the
+// comma separated list.
+func newTierResolver(policies *policyIndex, order string) *tierResolver {
+	r := &tierResolver{policies: policies, rank: make(map[string]int)}
+	for _, tier := range strings.Split(order, ",") {
+		if tier = strings.TrimSpace(tier); tier != "" {
+			if _, ok := r.rank[tier]; !ok {
+				r.rank[tier] = len(r.rank)
+			}
+		}
+	}
+	return r
+}
+
+// sort orders tiers as listed, followed by any that are not listed in name order.
+func (r *tierResolver) sort(tiers []string) {
+	sort.Slice(tiers, func(i, j int) bool {
+		ri, iok := r.rank[tiers[i]]
+		rj, jok := r.rank[tiers[j]]
+		if iok != jok {
+			return iok
+		}
+		if iok {
+			return ri < rj
+		}
+		return tiers[i] < tiers[j]
+	})
+}
+
+// tiersFor returns the ordered tiers of the policies that apply to the Calico workload endpoint with the given IP.
+func (r *tierResolver) tiersFor(ip string) ([]string, bool) {
+	ep, ok := calicoEndpoints.endpoint(ip)
+	if !ok {
+		return nil, false
+	}
+	tiers := r.policies.tiers(ep)
+	r.sort(tiers)
+	return tiers, true
+}
+
+// tierMetadata returns the metadata telling dikastes which tiers apply to the workload with the given IP.
+func tierMetadata(ip string) []headerValue {
+	tiers, ok := workloadTiers.tiersFor(ip)
+	if !ok {
+		return nil
+	}
+	return []headerValue{{Key: WorkloadTiersMetadata, Value: strings.Join(tiers, ",")}}
+}
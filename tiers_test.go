@@ -0,0 +1,64 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/projectcalico/libcalico-go/lib/backend/api"
+	"github.com/projectcalico/libcalico-go/lib/backend/model"
+)
+
+func TestTierOrder(t *testing.T) {
+	RegisterTestingT(t)
+
+	r := newTierResolver(nil, "security, platform,security")
+	tiers := []string{"default", "platform", "apps", "security"}
+	r.sort(tiers)
+	Expect(tiers).To(Equal([]string{"security", "platform", "apps", "default"}))
+}
+
+func TestTierMetadata(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { calicoEndpoints, workloadTiers = nil, nil }()
+	calicoEndpoints = newEndpointIndex()
+	policies := newPolicyIndex()
+	policies.all = true
+	workloadTiers = newTierResolver(policies, "security")
+
+	f := newTyphaFeed("typha:5473", nil, calicoEndpoints, policies)
+	f.reset()
+	tierPolicy := func(tier, name, selector string) api.Update {
+		return api.Update{
+			KVPair:     model.KVPair{Key: model.PolicyKey{Tier: tier, Name: name}, Value: &model.Policy{Selector: selector}},
+			UpdateType: api.UpdateTypeKVNew,
+		}
+	}
+	f.OnUpdates([]api.Update{
+		typhaEndpointUpdate("web", "10.0.0.1/32", map[string]string{"app": "web"}),
+		tierPolicy("default", "web", "app == 'web'"),
+		tierPolicy("security", "security.quarantine", "all()"),
+		tierPolicy("platform", "platform.db", "app == 'db'"),
+	})
+	f.OnStatusUpdated(api.InSync)
+
+	Expect(tierMetadata("10.0.0.1")).To(Equal([]headerValue{{Key: WorkloadTiersMetadata, Value: "security,default"}}))
+	Expect(tierMetadata("10.0.0.2")).To(BeNil())
+	// Policies without application layer rules are held only for their tiers.
+	ep, _ := calicoEndpoints.endpoint("10.0.0.1")
+	Expect(policies.selects(ep)).To(BeFalse())
+}
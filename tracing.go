@@ -0,0 +1,126 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/projectcalico/pilot-webhook"
+
+// tracer is a no-op until setupTracing installs an exporting provider.
+var tracer = otel.Tracer(tracerName)
+
+// setupTracing exports spans over OTLP/gRPC to the given collector endpoint.  The returned function flushes any
+// buffered spans and shuts the exporter down.
+func setupTracing(endpoint string) (func(), error) {
+	ctx := context.Background()
+	exp, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(endpoint), otlptracegrpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(sdkresource.NewSchemaless(
+			attribute.String("service.name", "pilot-webhook"),
+			attribute.String("service.version", version),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	tracer = tp.Tracer(tracerName)
+	return func() {
+		if err := tp.Shutdown(ctx); err != nil {
+			log.WithField("err", err).Warn("Failed to flush traces.")
+		}
+	}, nil
+}
+
+// traced returns a route filter that wraps the hook in a server span named after it, continuing any trace context
+// Pilot propagated in the request headers.  The span is carried in the request context for handlers to add steps.
+func traced(name string) restful.FilterFunction {
+	return func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		ctx := otel.GetTextMapPropagator().Extract(req.Request.Context(), propagation.HeaderCarrier(req.Request.Header))
+		ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+		span.SetAttributes(
+			attribute.String("pilot.service_cluster", req.PathParameter("serviceCluster")),
+			attribute.String("pilot.service_node", req.PathParameter("serviceNode")),
+		)
+		req.Request = req.Request.WithContext(ctx)
+
+		chain.ProcessFilter(req, resp)
+
+		code := resp.StatusCode()
+		span.SetAttributes(attribute.Int("http.status_code", code))
+		if code >= http.StatusBadRequest {
+			span.SetStatus(codes.Error, http.StatusText(code))
+		}
+	}
+}
+
+// endWithError records err on a step span and ends it.
+func endWithError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	span.End()
+}
+
+// step is a span for one step of a hook handler that also records the step's duration in the request stats, so slow
+// requests can be broken down without a tracing backend.
+type step struct {
+	trace.Span
+	stats *hookStats
+	name  string
+	start time.Time
+}
+
+// startStep starts a step of a hook handler as a child span of the hook's span.
+func startStep(ctx context.Context, stats *hookStats, name string) step {
+	_, span := tracer.Start(ctx, name)
+	return step{Span: span, stats: stats, name: name, start: time.Now()}
+}
+
+func (s step) End(options ...trace.SpanEndOption) {
+	s.stats.Steps = append(s.stats.Steps, stepTiming{Name: s.name, Duration: time.Since(s.start)})
+	s.Span.End(options...)
+}
+
+// stepStarter returns a mutator.Request StartStep that traces and times the mutators' steps as steps of the hook.
+func stepStarter(ctx context.Context, stats *hookStats) func(name string) func(err error) {
+	return func(name string) func(err error) {
+		s := startStep(ctx, stats, name)
+		return func(err error) {
+			if err != nil {
+				endWithError(s, err)
+				return
+			}
+			s.End()
+		}
+	}
+}
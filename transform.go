@@ -0,0 +1,97 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/emicklei/go-restful"
+	log "github.com/sirupsen/logrus"
+)
+
+// transformCluster is the service cluster transform passes to the hooks, which only matters for --meshes.
+const transformCluster = "transform"
+
+// transformHooks are the hooks transform can run.
+var transformHooks = map[string]restful.RouteFunction{
+	"listeners": listeners,
+	"clusters":  clusters,
+	"routes":    routes,
+}
+
+// staticResolver points every node at the same dikastes, for transform's --dikastes-address.
+type staticResolver string
+
+func (s staticResolver) address(string) (string, error) { return string(s), nil }
+func (s staticResolver) check() error                   { return nil }
+
+// newHookRequest returns a hook request as Pilot would send it over the socket.
+func newHookRequest(hook, cluster, node string, body io.Reader) *restful.Request {
+	path := fmt.Sprintf("http://unix/v1/%s/%s/%s", hook, url.PathEscape(cluster), url.PathEscape(node))
+	req := restful.NewRequest(httptest.NewRequest("POST", path, body))
+	req.PathParameters()["serviceCluster"] = cluster
+	req.PathParameters()["serviceNode"] = node
+	if hook == "routes" {
+		req.PathParameters()["routeConfigName"] = transformCluster
+	}
+	return req
+}
+
+// callHook calls a hook handler in process, as Pilot would over the socket.
+func callHook(hook string, handler restful.RouteFunction, cluster, node string, body io.Reader) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler(newHookRequest(hook, cluster, node, body), restful.NewResponse(recorder))
+	return recorder
+}
+
+// runTransform mutates the xDS response read from in as the hook would for the node, and writes the result to out,
+// so that config can be checked without a Pilot.  Features that need the cluster, such as pod lookups, are off.
+func runTransform(hook, node string, in io.Reader, out io.Writer) error {
+	handler, ok := transformHooks[hook]
+	if !ok {
+		return fmt.Errorf("unknown hook %q: expected listeners, clusters or routes", hook)
+	}
+	recorder := callHook(hook, handler, transformCluster, node, in)
+	if recorder.Code != http.StatusOK {
+		return fmt.Errorf("status %d: %s", recorder.Code, strings.TrimSpace(recorder.Body.String()))
+	}
+	_, err := out.Write(recorder.Body.Bytes())
+	return err
+}
+
+// transform runs the transform command.
+func transform(arguments map[string]interface{}) {
+	if addr, ok := arguments["--dikastes-address"].(string); ok {
+		dikastes = staticResolver(addr)
+	}
+	in := os.Stdin
+	if file, ok := arguments["<file>"].(string); ok {
+		f, err := os.Open(file)
+		if err != nil {
+			log.WithFields(log.Fields{"file": file, "err": err}).Fatal("Unable to read xDS response.")
+		}
+		defer f.Close()
+		in = f
+	}
+	if err := runTransform(arguments["<hook>"].(string), arguments["<node>"].(string), in, os.Stdout); err != nil {
+		log.WithField("err", err).Fatal("Unable to transform xDS response.")
+	}
+}
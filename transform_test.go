@@ -0,0 +1,65 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestTransformListeners(t *testing.T) {
+	RegisterTestingT(t)
+
+	var out bytes.Buffer
+	Expect(runTransform("listeners", serviceNode("sidecar", NODE_IP), strings.NewReader(ldsWithUnknownFields),
+		&out)).To(BeNil())
+	var lds struct {
+		Listeners []json.RawMessage `json:"listeners"`
+	}
+	Expect(json.Unmarshal(out.Bytes(), &lds)).To(BeNil())
+	Expect(lds.Listeners).To(HaveLen(3))
+	Expect(out.String()).To(ContainSubstring(AuthZFilterName))
+}
+
+func TestTransformClusters(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { dikastes = nil }()
+	var out bytes.Buffer
+	Expect(runTransform("clusters", serviceNode("sidecar", NODE_IP), strings.NewReader(`{"clusters":[]}`),
+		&out)).To(BeNil())
+	Expect(out.String()).To(Equal(`{"clusters":[]}`))
+
+	dikastes = staticResolver("tcp://10.96.0.20:9000")
+	out.Reset()
+	Expect(runTransform("clusters", serviceNode("sidecar", NODE_IP), strings.NewReader(`{"clusters":[]}`),
+		&out)).To(BeNil())
+	Expect(out.String()).To(ContainSubstring(AuthZClusterName))
+}
+
+func TestTransformErrors(t *testing.T) {
+	RegisterTestingT(t)
+
+	var out bytes.Buffer
+	Expect(runTransform("endpoints", serviceNode("sidecar", NODE_IP), strings.NewReader(`{}`), &out)).NotTo(BeNil())
+	err := runTransform("listeners", serviceNode("sidecar", NODE_IP), strings.NewReader(`{"listeners":[`), &out)
+	Expect(err).NotTo(BeNil())
+	Expect(err.Error()).To(ContainSubstring("400"))
+	Expect(out.Len()).To(Equal(0))
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/projectcalico/libcalico-go/lib/backend/api"
+	"github.com/projectcalico/libcalico-go/lib/backend/model"
+	"github.com/projectcalico/typha/pkg/syncclient"
+	log "github.com/sirupsen/logrus"
+)
+
+// typhaFeed keeps the endpoint and policy indexes up to date from Typha rather than by watching the datastore, so
+// that many webhooks share Typha's one watch.  Typha sends a full snapshot on every connection, which is built up in
+// fresh indexes and only swapped in once in sync, so a reconnect never empties the live ones.
+type typhaFeed struct {
+	addr    string
+	options *syncclient.Options
+
+	// endpoints and policies are the live indexes; either may be nil if the feature using it is off.
+	endpoints *endpointIndex
+	policies  *policyIndex
+
+	// Until the snapshot is complete, updates go to the staging indexes.
+	inSync           bool
+	stagingEndpoints *endpointIndex
+	stagingPolicies  *policyIndex
+}
+
+func newTyphaFeed(addr string, options *syncclient.Options, endpoints *endpointIndex, policies *policyIndex) *typhaFeed {
+	return &typhaFeed{addr: addr, options: options, endpoints: endpoints, policies: policies}
+}
+
+// reset prepares for a new connection's snapshot.
+func (f *typhaFeed) reset() {
+	f.inSync = false
+	f.stagingEndpoints = newEndpointIndex()
+	f.stagingPolicies = newPolicyIndex()
+	f.stagingPolicies.all = f.policies != nil && f.policies.all
+}
+
+// OnStatusUpdated swaps the snapshot in once Typha reports it is complete.
+func (f *typhaFeed) OnStatusUpdated(status api.SyncStatus) {
+	if status != api.InSync || f.inSync {
+		return
+	}
+	if f.endpoints != nil {
+		f.endpoints.adopt(f.stagingEndpoints)
+	}
+	if f.policies != nil {
+		f.policies.adopt(f.stagingPolicies)
+	}
+	f.inSync = true
+	calicoHealth.connected("typha")
+	log.WithField("typha", f.addr).Info("Synced Calico resources from Typha.")
+}
+
+// OnUpdates applies the workload endpoint and policy updates, ignoring everything else Typha sends.
+func (f *typhaFeed) OnUpdates(updates []api.Update) {
+	endpoints, policies := f.endpoints, f.policies
+	if !f.inSync {
+		endpoints, policies = f.stagingEndpoints, f.stagingPolicies
+	}
+	for _, u := range updates {
+		switch key := u.Key.(type) {
+		case model.WorkloadEndpointKey:
+			if f.endpoints == nil {
+				continue
+			}
+			// Kubernetes workload IDs are namespace/pod, which makes this the key endpointIndex expects.
+			k := key.WorkloadID + "/" + key.EndpointID
+			wep, ok := u.Value.(*model.WorkloadEndpoint)
+			if !ok || wep == nil {
+				endpoints.deleteKey(k)
+				continue
+			}
+			endpoints.updateKey(k, modelEndpointIPs(wep), wep.Labels)
+		case model.PolicyKey:
+			if f.policies == nil {
+				continue
+			}
+			// Typha's policy selectors already include the namespace of namespaced policies.
+			policy, ok := u.Value.(*model.Policy)
+			if !ok || policy == nil {
+				policies.remove(key.Name)
+				continue
+			}
+			policies.setSelector(key.Name, key.Tier, "", policy.Selector, modelHasL7Rules(policy))
+		}
+	}
+}
+
+func modelEndpointIPs(wep *model.WorkloadEndpoint) []string {
+	var ips []string
+	for _, n := range wep.IPv4Nets {
+		ips = append(ips, n.IP.String())
+	}
+	for _, n := range wep.IPv6Nets {
+		ips = append(ips, n.IP.String())
+	}
+	return ips
+}
+
+// modelHasL7Rules is hasL7Rules for the policies Typha sends.
+func modelHasL7Rules(policy *model.Policy) bool {
+	if len(policy.Types) > 0 {
+		found := false
+		for _, t := range policy.Types {
+			found = found || t == "ingress"
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, r := range policy.InboundRules {
+		if r.HTTPMatch != nil || r.OriginalSrcServiceAccountNames != nil || r.OriginalSrcServiceAccountSelector != "" ||
+			r.OriginalDstServiceAccountNames != nil || r.OriginalDstServiceAccountSelector != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// run connects to Typha, reconnecting whenever the connection drops.  It never returns.
+func (f *typhaFeed) run() {
+	hostname, _ := os.Hostname()
+	for {
+		f.reset()
+		client := syncclient.New(f.addr, version, hostname, "calico-pilot-webhook", f, f.options)
+		if err := client.Start(context.Background()); err != nil {
+			log.WithFields(log.Fields{"typha": f.addr, "err": err}).Warn("Unable to connect to Typha; retrying.")
+		} else {
+			client.Finished.Wait()
+			log.WithField("typha", f.addr).Warn("Typha connection closed; reconnecting.")
+		}
+		calicoHealth.disconnected("typha")
+		time.Sleep(calicoResyncDelay)
+	}
+}
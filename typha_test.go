@@ -0,0 +1,88 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/projectcalico/libcalico-go/lib/backend/api"
+	"github.com/projectcalico/libcalico-go/lib/backend/model"
+	cnet "github.com/projectcalico/libcalico-go/lib/net"
+)
+
+func typhaEndpointUpdate(pod, cidr string, labels map[string]string) api.Update {
+	key := model.WorkloadEndpointKey{Hostname: "node1", OrchestratorID: "k8s", WorkloadID: "testns/" + pod, EndpointID: "eth0"}
+	if cidr == "" {
+		return api.Update{KVPair: model.KVPair{Key: key}, UpdateType: api.UpdateTypeKVDeleted}
+	}
+	_, n, _ := cnet.ParseCIDR(cidr)
+	return api.Update{
+		KVPair:     model.KVPair{Key: key, Value: &model.WorkloadEndpoint{IPv4Nets: []cnet.IPNet{*n}, Labels: labels}},
+		UpdateType: api.UpdateTypeKVNew,
+	}
+}
+
+func typhaPolicyUpdate(name, selector string, rules ...model.Rule) api.Update {
+	return api.Update{
+		KVPair:     model.KVPair{Key: model.PolicyKey{Tier: "default", Name: name}, Value: &model.Policy{Selector: selector, InboundRules: rules}},
+		UpdateType: api.UpdateTypeKVNew,
+	}
+}
+
+func TestTyphaFeedSnapshot(t *testing.T) {
+	RegisterTestingT(t)
+
+	endpoints, policies := newEndpointIndex(), newPolicyIndex()
+	endpoints.replace(nil)
+	endpoints.updateKey("testns/old/eth0", []string{"10.0.0.9"}, nil)
+	f := newTyphaFeed("typha:5473", nil, endpoints, policies)
+	f.reset()
+
+	f.OnUpdates([]api.Update{
+		typhaEndpointUpdate("web", "10.0.0.1/32", map[string]string{"app": "web"}),
+		typhaPolicyUpdate("testns/knp.default.web", "app == 'web'", model.Rule{HTTPMatch: &model.HTTPMatch{Methods: []string{"GET"}}}),
+		typhaPolicyUpdate("l4-only", "all()", model.Rule{Action: "allow"}),
+	})
+	// The live indexes keep the old state until the snapshot is complete.
+	Expect(endpoints.managed("10.0.0.9")).To(BeTrue())
+	Expect(endpoints.managed("10.0.0.1")).To(BeFalse())
+	Expect(policies.check()).To(Equal(errPoliciesNotSynced))
+
+	f.OnStatusUpdated(api.InSync)
+	Expect(endpoints.managed("10.0.0.9")).To(BeFalse())
+	ep, ok := endpoints.endpoint("10.0.0.1")
+	Expect(ok).To(BeTrue())
+	Expect(ep.Namespace).To(Equal("testns"))
+	Expect(policies.check()).To(BeNil())
+	Expect(policies.selects(ep)).To(BeTrue())
+	Expect(policies.policies).To(HaveLen(1))
+
+	// After the snapshot, updates go straight to the live indexes.
+	f.OnUpdates([]api.Update{typhaEndpointUpdate("web", "", nil)})
+	Expect(endpoints.managed("10.0.0.1")).To(BeFalse())
+}
+
+func TestModelHasL7Rules(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(modelHasL7Rules(&model.Policy{InboundRules: []model.Rule{{OriginalSrcServiceAccountSelector: "app == 'a'"}}})).To(BeTrue())
+	Expect(modelHasL7Rules(&model.Policy{
+		Types:         []string{"egress"},
+		OutboundRules: []model.Rule{{HTTPMatch: &model.HTTPMatch{}}},
+		InboundRules:  []model.Rule{{HTTPMatch: &model.HTTPMatch{}}},
+	})).To(BeFalse())
+	Expect(modelHasL7Rules(&model.Policy{InboundRules: []model.Rule{{Action: "allow"}}})).To(BeFalse())
+}
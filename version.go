@@ -0,0 +1,84 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/emicklei/go-restful"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gitCommit is set at build time with -ldflags "-X main.gitCommit=<commit>".
+var gitCommit = "unknown"
+
+var buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "build_info",
+	Help:      "Always 1; labelled with the version and commit the webhook was built from.",
+}, []string{"version", "git_commit", "go_version"})
+
+func init() {
+	prometheus.MustRegister(buildInfo)
+	buildInfo.WithLabelValues(version, gitCommit, runtime.Version()).Set(1)
+}
+
+var featuresLock sync.Mutex
+var features []string
+
+// enableFeature records that an optional feature is turned on, for reporting by /version.
+func enableFeature(name string) {
+	featuresLock.Lock()
+	defer featuresLock.Unlock()
+	features = append(features, name)
+	sort.Strings(features)
+}
+
+// featureEnabled reports whether an optional feature has been turned on.
+func featureEnabled(name string) bool {
+	featuresLock.Lock()
+	defer featuresLock.Unlock()
+	for _, f := range features {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+type versionInfo struct {
+	Version   string   `json:"version"`
+	GitCommit string   `json:"gitCommit"`
+	GoVersion string   `json:"goVersion"`
+	Features  []string `json:"features"`
+}
+
+func currentVersion() versionInfo {
+	featuresLock.Lock()
+	defer featuresLock.Unlock()
+	return versionInfo{
+		Version:   version,
+		GitCommit: gitCommit,
+		GoVersion: runtime.Version(),
+		Features:  append([]string{}, features...),
+	}
+}
+
+// versionHandler reports the build and the optional features that are enabled.
+func versionHandler(req *restful.Request, resp *restful.Response) {
+	resp.WriteEntity(currentVersion())
+}
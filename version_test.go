@@ -0,0 +1,47 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestVersion(t *testing.T) {
+	RegisterTestingT(t)
+
+	enableFeature("b-feature")
+	enableFeature("a-feature")
+	defer func() { features = nil }()
+
+	rec := httptest.NewRecorder()
+	versionHandler(restful.NewRequest(httptest.NewRequest("GET", "/version", nil)), restful.NewResponse(rec))
+	var v versionInfo
+	err := json.Unmarshal(rec.Body.Bytes(), &v)
+	Expect(err).To(BeNil())
+	Expect(v).To(Equal(versionInfo{
+		Version:   version,
+		GitCommit: "unknown",
+		GoVersion: runtime.Version(),
+		Features:  []string{"a-feature", "b-feature"},
+	}))
+	Expect(testutil.ToFloat64(buildInfo.WithLabelValues(version, gitCommit, runtime.Version()))).To(Equal(1.0))
+}
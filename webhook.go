@@ -15,38 +15,281 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/docopt/docopt-go"
 	"github.com/emicklei/go-restful"
+	"github.com/projectcalico/typha/pkg/syncclient"
 	log "github.com/sirupsen/logrus"
 	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
 )
 
 const usage = `Istio Pilot Webhook
 
 Usage:
+  webhook admission --tls-cert=<file> --tls-key=<file> [options]
+  webhook generate-envoyfilter [options]
+  webhook transform <hook> <node> [<file>] [options]
+  webhook diff <hook> <node> [<file>] [options]
+  webhook lint [<file>] [options]
+  webhook replay <dir> [options]
+  webhook export-fixtures <dir> [options]
+  webhook loadtest <path> [options]
+  webhook check <path> [options]
+  webhook simulate [options]
   webhook <path> [options]
 
 Options:
-  <path>                 Absolute path to webhook listen socket
-  --debug                Log at Debug level.`
+  <path>                                Absolute path to webhook listen socket
+  admission                             Serve a Kubernetes admission webhook that adds the dikastes socket to pods,
+                                        instead of the Pilot hooks.
+  --tls-cert=<file>                     Certificate for the admission webhook.
+  --tls-key=<file>                      Private key for the admission webhook.
+  --admission-address=<host:port>       Address for the admission webhook [default: :8443].
+  generate-envoyfilter                  Print an Istio EnvoyFilter that adds the authz filter like the Pilot hooks do,
+                                        for Istio versions without the hooks.
+  --envoyfilter-name=<name>             Name of the generated EnvoyFilter [default: calico-authz].
+  --envoyfilter-namespace=<ns>          Namespace of the generated EnvoyFilter [default: istio-system].
+  --envoyfilter-labels=<k=v,...>        Only apply the generated EnvoyFilter to workloads with these labels.
+  transform                             Mutate the xDS response in <file>, or stdin, as the <hook> hook (listeners,
+                                        clusters or routes) would for the <node> ID, and print it.
+  diff                                  Print what transform would change in the xDS response.  Exits 1 if it would
+                                        change anything.
+  --diff-format=<format>                Format of diff: unified, or patch for a JSON Patch [default: unified].
+  lint                                  Report filters and fields in the LDS response in <file>, or stdin, that
+                                        --envoy-version deprecates.  Exits 1 if there are any.
+  --envoy-version=<version>             Envoy version to lint for [default: 1.7].
+  --lint-node=<node>                    Lint the LDS response as the listeners hook would mutate it for this node ID.
+  replay                                Resend the requests captured in <dir> by --capture-dir and compare the
+                                        responses with those recorded.  Exits 1 if any differ.
+  --replay-socket=<path>                For replay, send the requests to the webhook serving on this socket, or a
+                                        tcp://<host>:<port> address, rather than to the hooks in process.
+  --replay-out=<dir>                    For replay, write the replayed exchanges to this directory as captures.
+  loadtest                              Send synthetic LDS and CDS requests to the webhook serving on <path>, and
+                                        report their latency.  Exits 1 if any request fails.
+  --loadtest-hooks=<hooks>              Comma separated hooks to call in turn [default: listeners,clusters].
+  --loadtest-services=<n>               Services in the synthetic mesh, which sets the size of each request
+                                        [default: 100].
+  --loadtest-sidecars=<n>               Distinct sidecars to make requests for [default: 100].
+  --loadtest-concurrency=<n>            Requests in flight at once [default: 8].
+  --loadtest-requests=<n>               Total requests to send [default: 1000].
+  export-fixtures                       Write the newest requests captured in <dir> by --capture-dir as golden test
+                                        cases, redacted and rewritten for the golden tests' node.
+  --fixtures-out=<dir>                  Golden test directory to write cases to [default: testdata/golden].
+  --fixtures-max=<n>                    Export at most the newest n captures; 0 for all [default: 20].
+  check                                 Check that the webhook can listen on <path> and that dikastes is serving on
+                                        --dikastes-socket, and --dikastes-address if set, printing how to fix any
+                                        problems.  Exits 1 if any check fails.
+  simulate                              Report what the hooks would do for each sidecar in the cluster, set up with the
+                                        given options as when serving, without serving or changing anything.
+  --simulate-namespace=<ns>             Only simulate the sidecars in this namespace.
+  --simulate-timeout=<duration>         How long to wait for the cluster state to sync [default: 1m].
+  --dikastes-address=<url>              For transform, diff and replay in process, the dikastes address to add the
+                                        authz cluster with, e.g. tcp://10.96.0.20:9000.  For check, an address to
+                                        connect to.
+  --sync-envoyfilter                    Keep the generated EnvoyFilter applied to the cluster while serving.
+  --leader-elect                        Only run controller style tasks, such as --sync-envoyfilter, on the replica
+                                        holding a leader lease.  Every replica serves the hooks.
+  --leader-elect-lock=<ns/name>         ConfigMap used as the leader lease
+                                        [default: kube-system/calico-pilot-webhook].
+  --debug                               Log at Debug level.
+  --syslog=<target>                     Also send logs to syslog: "local", or udp://, tcp:// or unix:// address.
+  --error-log-burst=<n>                 Number of times each error may be logged per interval [default: 10].
+  --error-log-interval=<duration>       Interval over which error logs are rate limited [default: 1m].
+  --otlp-endpoint=<host:port>           Export traces over OTLP/gRPC to this collector.
+  --audit-log=<path>                    Record the changes made to each request as JSON lines to this file, or - for stdout.
+  --admin-address=<host:port>           Serve admin and debug endpoints over TCP on this address.
+  --debug-history=<n>                   Keep the last n requests and responses for the debug endpoint [default: 0].
+  --debug-history-body-limit=<bytes>    Truncate bodies kept in the request history to this size [default: 4096].
+  --node-cache-size=<n>                 Keep the last LDS and CDS responses served to up to n nodes [default: 0].
+  --listener-parallelism=<n>            Mutate the listeners of LDS requests with many listeners on up to n
+                                        goroutines [default: 1].
+  --stream-arrays                       Mutate LDS and CDS resources one at a time as they are read.
+  --stream-writes                       With --stream-arrays, write responses as they are mutated.
+  --canonical-json                      Write hook responses compact and with sorted keys, so that the same config is
+                                        always the same bytes.
+  --auth-token-file=<path>              Only serve hook callers presenting one of the bearer tokens in this file,
+                                        one per line.  The file is reloaded while serving.
+  --auth-token-secret=<ns/name>         As --auth-token-file, with the tokens in the "token" key of this Secret.
+  --signing-key-file=<path>             Sign hook responses with an HMAC-SHA256 of their body, with the key in this
+                                        file, in the X-Calico-Signature header.  The file is reloaded while serving.
+  --signing-key-secret=<ns/name>        As --signing-key-file, with the key in the "key" key of this Secret.
+  --hook-address=<host:port>            Also serve the hooks over mTLS on this address.
+  --hook-tls-cert=<file>                Certificate for --hook-address.
+  --hook-tls-key=<file>                 Private key for --hook-address.
+  --hook-client-ca=<file>               CA that must have signed the certificates of callers of --hook-address.
+  --spiffe-trust-domain=<domain>        Only accept callers of --hook-address with a SPIFFE ID in this trust domain.
+  --spiffe-ids=<ids>                    Comma separated SPIFFE IDs, or <namespace>/<service account> pairs in
+                                        Istio's form of ID, allowed to call --hook-address; all in the trust domain
+                                        if not set.
+  --deny-unexpected                     Answer hook requests for unknown paths, with unexpected methods, or for
+                                        malformed service nodes with a 403, and audit them, rather than handling
+                                        them as best the webhook can.
+  --middleware=<stages>                 Comma separated stages each hook request goes through, in order, outermost
+                                        first, or "all" for every stage in the default order: token-auth,
+                                        deny-unexpected, chaos, gzip, signing, history, capture, sinks, shadow,
+                                        canonical, metrics, tracing, node-local, node-allowlist, correlation,
+                                        node-cache, workers.  Leaving out the stage of an enabled feature is an
+                                        error [default: all].
+  --mutator-plugins=<paths>             Comma separated Go plugin files, each exporting a NewMutator func, whose
+                                        mutators run after the authz mutator.  Needs a cgo enabled build.
+  --scripts=<path>                      JSON file of scripts, each patching the resources of a hook's responses
+                                        that its match expression selects.
+  --rules=<path>                        JSON file of EnvoyFilter style rules, each merging, inserting or removing
+                                        the listeners or filters it matches in LDS responses.
+  --validate-output                     Check mutated LDS and CDS responses against the xDS schemas, and pass the
+                                        request through instead if they do not match.
+  --json-codec=<name>                   JSON codec for mutating config: std, or jsoniter when built with
+                                        -tags jsoniter [default: std].
+  --mutation-workers=<n>                Mutate at most n LDS and CDS requests at once; 0 for no limit [default: 0].
+  --rate-limit=<n>                      Serve each hook at most n requests a second, refusing the rest with a 429; 0
+                                        for no limit [default: 0].
+  --rate-limit-burst=<n>                Requests each hook may serve in a burst over --rate-limit [default: 10].
+  --response-cache-size=<n>             Cache up to n mutated LDS and CDS responses, so that a body pushed again is
+                                        not mutated again [default: 0].
+  --response-cache-ttl=<duration>       Expire cached responses after this long; 0 to keep them until the
+                                        webhook's config changes [default: 1m].
+  --response-cache-per-node=<n>         Cache up to n LDS responses per node; 0 for no limit [default: 4].
+  --capture-dir=<dir>                   Write a sample of raw request and response bodies to this directory.
+  --capture-rate=<fraction>             Fraction of requests to capture [default: 0.01].
+  --capture-max=<n>                     Number of captures to keep before deleting the oldest [default: 100].
+  --output-sinks=<urls>                 Comma separated file://, nats:// or kafka-rest:// URLs to also publish
+                                        each mutated response to, asynchronously.
+  --shadow-socket=<path>                Send a copy of hook requests to the webhook serving on this socket, and
+                                        compare its responses with this one's.
+  --shadow-rate=<fraction>              Fraction of requests to send to the shadow webhook [default: 1].
+  --chaos-latency=<duration>            Chaos mode: delay hook responses by this long [default: 0s].
+  --chaos-latency-rate=<fraction>       Chaos mode: fraction of responses to delay [default: 1].
+  --chaos-error-rate=<fraction>         Chaos mode: fraction of requests to fail with a 503 [default: 0].
+  --chaos-truncate-rate=<fraction>      Chaos mode: fraction of responses to cut short [default: 0].
+  --profile-dir=<dir>                   Write CPU profiles after slow requests, and heap profiles when the heap is
+                                        large, to this directory.
+  --profile-heap-bytes=<bytes>          Write a heap profile when the heap in use exceeds this; 0 disables
+                                        [default: 0].
+  --profile-cpu-duration=<duration>     Length of CPU profiles [default: 10s].
+  --profile-interval=<duration>         Write each kind of profile at most once per interval [default: 10m].
+  --profile-max=<n>                     Number of profiles to keep before deleting the oldest [default: 20].
+  --dikastes-socket=<path>              Dikastes socket to probe [default: /var/run/dikastes/dikastes.sock].
+  --dikastes-probe-interval=<duration>  Probe the dikastes socket at this interval; 0 disables probing [default: 0s].
+  --dikastes-grpc-health                Probe dikastes with a gRPC health check rather than just connecting.
+  --large-payload-bytes=<bytes>         Warn when a request or response body exceeds this size; 0 disables
+                                        [default: 10485760].
+  --max-payload-bytes=<bytes>           Reject request bodies larger than this with 413 rather than read them into
+                                        memory; 0 disables [default: 0].
+  --body-size-hint=<bytes>              Allocate this much up front for request bodies sent without a Content-Length
+                                        [default: 0].
+  --read-buffer-bytes=<bytes>           Size the socket receive buffer of hook connections; 0 leaves the OS default
+                                        [default: 0].
+  --write-buffer-bytes=<bytes>          Size the socket send buffer of hook connections; 0 leaves the OS default
+                                        [default: 0].
+  --slow-request-threshold=<duration>   Log a timing breakdown of hook requests slower than this; 0 disables
+                                        [default: 1s].
+  --slo-availability=<ratio>            Objective for the ratio of hook requests without a server error
+                                        [default: 0.999].
+  --slo-latency=<duration>              Hook requests slower than this count against the latency objective
+                                        [default: 250ms].
+  --slo-latency-objective=<ratio>       Objective for the ratio of hook requests within --slo-latency [default: 0.99].
+  --metrics-service-clusters=<list>     Comma separated service clusters to label metrics with; others are labelled
+                                        "other".  By default the first --metrics-service-cluster-limit seen are used.
+  --metrics-service-cluster-limit=<n>   Number of service clusters to label metrics with [default: 20].
+  --correlation-window=<duration>       Warn when a node is sent the authz filter but does not fetch clusters within
+                                        this window; 0 disables [default: 0s].
+  --kube-events                         Emit Kubernetes Events against workloads whose config could not be mutated.
+  --annotate-pods                       Record whether each workload was injected, why not, and when its listeners
+                                        were last served in annotations on its pod.
+  --calico-endpoints-only               Only mutate config for nodes whose IP is a Calico workload endpoint.
+  --require-l7-policy                   Only inject for workloads that a Calico policy with HTTP or service account
+                                        rules applies to.  Implies --calico-endpoints-only.
+  --eds-allow-networksets=<selector>    Only keep EDS hosts in a GlobalNetworkSet matching this Calico selector.
+  --eds-deny-networksets=<selector>     Remove EDS hosts in a GlobalNetworkSet matching this Calico selector.
+  --propagate-labels                    Send dikastes the workload's namespace and labels with each check.  Only for
+                                        sidecars from Istio 0.8, and needs --watch-pods or --calico-endpoints-only.
+  --propagate-tiers                     Send dikastes the tiers of the Calico policies that apply to the workload with
+                                        each check.  Only for sidecars from Istio 0.8.  Implies
+                                        --calico-endpoints-only.
+  --tier-order=<list>                   Comma separated tiers in evaluation order for --propagate-tiers; others
+                                        follow in name order.
+  --app-layer-policy                    Add the route rules of the Calico ApplicationLayerPolicies that select a
+                                        sidecar's workload to the routes they match in RDS.  Needs --watch-pods.
+  --typha-address=<host:port>           Get the workload endpoints and policies for --calico-endpoints-only,
+                                        --require-l7-policy and --propagate-tiers from Typha instead of the datastore.
+  --typha-ca-file=<file>                CA certificate to verify Typha with.
+  --typha-cert-file=<file>              Client certificate to present to Typha.
+  --typha-key-file=<file>               Private key for --typha-cert-file.
+  --typha-cn=<name>                     Common name Typha's certificate must have.
+  --calico-config=<path>                Calico client config file; the environment is used if unset.
+  --watch-pods                          Watch pods so that they can opt out of injection with the
+                                        authz.projectcalico.org/inject: "false" annotation, and so that Istio's
+                                        sidecar.istio.io/inject and excludeInboundPorts annotations are honoured.
+  --exclude-service-accounts=<list>     Comma separated namespace/name globs or SPIFFE ids of service accounts whose
+                                        pods are not injected, e.g. istio-system/*.  Needs --watch-pods
+                                        unless serving admission.
+  --namespace-selector=<selector>       Only inject into pods in namespaces matching this label selector, e.g.
+                                        calico-authz=enabled.
+  --dikastes-discovery=<mode>           Add the authz cluster to CDS, finding dikastes through its "service", or the
+                                        "daemonset" that runs it on each host (which needs --watch-pods).
+  --dikastes-service=<ns/name>          Service for dikastes discovery [default: kube-system/dikastes].
+  --dikastes-daemonset=<ns/name>        DaemonSet for dikastes discovery [default: kube-system/calico-node].
+  --dikastes-per-namespace              Add an authz cluster per namespace to CDS, for a dikastes per namespace, and
+                                        point each workload's filter at its namespace's.
+  --dikastes-cluster-template=<name>    Authz cluster name for --dikastes-per-namespace
+                                        [default: calico.dikastes.{namespace}].
+  --dikastes-socket-template=<path>     Dikastes socket for --dikastes-per-namespace
+                                        [default: /var/run/dikastes/{namespace}/dikastes.sock].
+  --exclude-dns-ports                   Never add the authz filter to TCP listeners on the cluster DNS service's ports.
+  --dns-service=<ns/name>               Cluster DNS service for --exclude-dns-ports [default: kube-system/kube-dns].
+  --istio-version=<version>             Istio version to shape config for where a sidecar's is not known from its
+                                        image, or the URL of Pilot's /version endpoint to probe for it.
+  --felix-sync-gating                   Only give workloads a fail closed authz filter once Felix is ready on their
+                                        node, and a fail open one until then.  Needs --watch-pods.
+  --felix-selector=<selector>           Labels of the calico-node pods for --felix-sync-gating
+                                        [default: k8s-app=calico-node].
+  --node-local=<action>                 When running on each node, "flag" or "reject" requests for service nodes whose
+                                        pod is on another node.  Needs --watch-pods.
+  --node-name=<name>                    This node, for --node-local; $NODE_NAME is used if unset.
+  --allowed-node-ids=<globs>            Comma separated globs of the service node IDs, e.g. *.istio-system, to
+                                        mutate config for.
+  --allowed-node-ips=<cidrs>            Comma separated IPs and CIDRs of the service nodes to mutate config for.
+  --unlisted-nodes=<action>             "pass" through unmodified, or "reject", requests for service nodes not
+                                        allowed by --allowed-node-ids or --allowed-node-ips [default: pass].
+  --protocol-sniffing=<mode>            Classify inbound listeners by their connection manager rather than their name,
+                                        as Pilot's protocol sniffing needs: "off", "on", or "mesh" to follow the
+                                        Istio mesh config [default: off].
+  --mesh-config=<ns/name>               Istio mesh config ConfigMap for --protocol-sniffing=mesh
+                                        [default: istio-system/istio].
+  --cni-compat=<mode>                   Classify wildcard listeners on a pod's container ports as inbound, for pods
+                                        whose traffic is redirected by Istio CNI: "off", "on", or "auto" for pods
+                                        without an istio-init container.  Needs --watch-pods [default: off].
+  --listener-classifier=<name>          How inbound listeners are told apart: "name" by the names Pilot gives them,
+                                        "address" by their addresses, or "metadata" by the ports the workload's pod
+                                        declares, which needs --watch-pods [default: name].
+  --meshes=<path>                       JSON file of per cluster or mesh settings, such as the dikastes address.
+  --kubeconfig=<path>                   Kubeconfig for Kubernetes access; the in-cluster config is used if unset.`
 
 const version = "0.1"
 
-const serviceNodeSeparator = "~"
-const listenerNameSeparator = "_"
-const AuthZFilterName = "envoy.ext_authz"
-const AuthZClusterName = "calico.dikastes"
+const serviceNodeSeparator = config.ServiceNodeSeparator
+const listenerNameSeparator = mutator.ListenerNameSeparator
+const AuthZFilterName = config.AuthzFilterName
+const DryRunHeader = config.DryRunHeader
+const DryRunChangesHeader = config.DryRunChangesHeader
+const SignatureHeader = config.SignatureHeader
+const AuthZClusterName = config.AuthzClusterName
 const DikastesSocketDir = "/var/run/dikastes"
 
+var errNoHTTPConnectionManager = mutator.ErrNoHTTPConnectionManager
+
 type ldsResponse struct {
 	Listeners v1.Listeners `json:"listeners"`
 }
@@ -55,33 +298,26 @@ type cdsResponse struct {
 	Clusters v1.Clusters `json:"clusters"`
 }
 
-type Direction int
+// sdsHost is the part of an EDS host the webhook looks at.  Hosts are otherwise passed through untouched.
+type sdsHost struct {
+	IPAddress string `json:"ip_address"`
+}
+
+// The direction and protocol of listeners, as the mutations classify them.
+type Direction = mutator.Direction
+type Protocol = mutator.Protocol
 
 const (
-	INBOUND Direction = iota
-	OUTBOUND
-	VIRTUAL
+	INBOUND  = mutator.Inbound
+	OUTBOUND = mutator.Outbound
+	VIRTUAL  = mutator.Virtual
 )
 
-type Protocol int
-
 const (
-	HTTP Protocol = iota
-	TCP
+	HTTP = mutator.HTTP
+	TCP  = mutator.TCP
 )
 
-type AuthzFilterConfig struct {
-	StatPrefix  string             `json:"stat_prefix,omitempty"`
-	GrpcCluster *GrpcClusterConfig `json:"grpc_cluster,omitempty"`
-}
-
-type GrpcClusterConfig struct {
-	ClusterName string `json:"cluster_name"`
-	// TODO: (spikecurtis) include Duration once we move to v2 API.
-}
-
-func (*AuthzFilterConfig) IsNetworkFilterConfig() {}
-
 func main() {
 	arguments, err := docopt.Parse(usage, nil, true, version, false)
 	if err != nil {
@@ -91,37 +327,781 @@ func main() {
 	if arguments["--debug"].(bool) {
 		log.SetLevel(log.DebugLevel)
 	}
+	if arguments["generate-envoyfilter"].(bool) {
+		if err := writeEnvoyFilter(os.Stdout, envoyFilterFromArgs(arguments)); err != nil {
+			log.WithField("err", err).Fatal("Unable to write EnvoyFilter.")
+		}
+		return
+	}
+	if target, ok := arguments["--syslog"].(string); ok {
+		hook, err := newSyslogHook(target)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"target": target,
+				"err":    err,
+			}).Fatal("Unable to connect to syslog.")
+		}
+		log.AddHook(hook)
+		enableFeature("syslog")
+	}
+	burst, err := strconv.Atoi(arguments["--error-log-burst"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --error-log-burst.")
+	}
+	interval, err := time.ParseDuration(arguments["--error-log-interval"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --error-log-interval.")
+	}
+	errorLog = newSampledLogger(burst, interval)
+	go errorLog.run()
+	if endpoint, ok := arguments["--otlp-endpoint"].(string); ok {
+		shutdown, err := setupTracing(endpoint)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"endpoint": endpoint,
+				"err":      err,
+			}).Fatal("Unable to set up tracing.")
+		}
+		defer shutdown()
+		enableFeature("tracing")
+	}
+	if path, ok := arguments["--audit-log"].(string); ok {
+		auditLog, err = openAuditLog(path)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"path": path,
+				"err":  err,
+			}).Fatal("Unable to open audit log.")
+		}
+		enableFeature("audit-log")
+	}
+	if arguments["--deny-unexpected"].(bool) {
+		denyUnexpected = true
+		enableFeature("deny-unexpected")
+	}
+	historySize, err := strconv.Atoi(arguments["--debug-history"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --debug-history.")
+	}
+	if historySize > 0 {
+		bodyLimit, err := strconv.Atoi(arguments["--debug-history-body-limit"].(string))
+		if err != nil {
+			log.WithField("err", err).Fatal("Invalid --debug-history-body-limit.")
+		}
+		history = newExchangeRing(historySize, bodyLimit)
+		enableFeature("debug-history")
+	}
+	cacheSize, err := strconv.Atoi(arguments["--node-cache-size"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --node-cache-size.")
+	}
+	if cacheSize > 0 {
+		servedConfigs = newNodeCache(cacheSize)
+		enableFeature("node-cache")
+	}
+	hookAuthz.Parallelism, err = strconv.Atoi(arguments["--listener-parallelism"].(string))
+	if err != nil || hookAuthz.Parallelism < 1 {
+		log.WithField("err", err).Fatal("Invalid --listener-parallelism.")
+	}
+	if hookAuthz.Parallelism > 1 {
+		enableFeature("listener-parallelism")
+	}
+	name := arguments["--json-codec"].(string)
+	c, ok := jsonCodecs[name]
+	if !ok {
+		log.WithFields(log.Fields{"codec": name, "available": jsonCodecNames()}).Fatal("Unknown --json-codec.")
+	}
+	setCodec(c)
+	if name != "std" {
+		enableFeature("json-codec")
+	}
+	if arguments["--stream-arrays"].(bool) {
+		streamArrays = true
+		enableFeature("stream-arrays")
+	}
+	if arguments["--validate-output"].(bool) {
+		outputSchemas, err = loadOutputSchemas()
+		if err != nil {
+			log.WithField("err", err).Fatal("Unable to load xDS schemas.")
+		}
+		enableFeature("validate-output")
+	}
+	if pipeline, err = parsePipeline(arguments["--middleware"].(string)); err != nil {
+		log.WithField("err", err).Fatal("Invalid --middleware.")
+	}
+	if arguments["--middleware"].(string) != "all" {
+		enableFeature("middleware")
+	}
+	log.WithField("stages", middlewareNames(pipeline)).Debug("Hook pipeline")
+	if paths, ok := arguments["--mutator-plugins"].(string); ok {
+		if err := mutator.LoadPlugins(mutator.Default, strings.Split(paths, ",")...); err != nil {
+			log.WithField("err", err).Fatal("Invalid --mutator-plugins.")
+		}
+	}
+	if file, ok := arguments["--scripts"].(string); ok {
+		scripts, err := loadScripts(file)
+		if err == nil {
+			err = mutator.Register(scripts)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"file": file,
+				"err":  err,
+			}).Fatal("Unable to load --scripts.")
+		}
+		enableFeature("scripts")
+	}
+	if file, ok := arguments["--rules"].(string); ok {
+		rules, err := loadRules(file)
+		if err == nil {
+			err = mutator.Register(rules)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"file": file,
+				"err":  err,
+			}).Fatal("Unable to load --rules.")
+		}
+		enableFeature("rules")
+	}
+	if names := extraMutators(); len(names) > 0 {
+		log.WithField("mutators", names).Info("Running registered mutators after the authz mutator.")
+		enableFeature("mutators")
+	}
+	if arguments["--canonical-json"].(bool) {
+		canonicalJSON = true
+		enableFeature("canonical-json")
+	}
+	if arguments["--stream-writes"].(bool) {
+		if !streamArrays {
+			log.Fatal("--stream-writes needs --stream-arrays.")
+		}
+		if canonicalJSON {
+			log.Fatal("--stream-writes cannot be used with --canonical-json, which holds responses back.")
+		}
+		streamWrites = true
+		enableFeature("stream-writes")
+	}
+	workers, err := strconv.Atoi(arguments["--mutation-workers"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --mutation-workers.")
+	}
+	if workers > 0 {
+		mutationWorkers = newWorkerPool(workers)
+		enableFeature("mutation-workers")
+	}
+	limit, err := strconv.ParseFloat(arguments["--rate-limit"].(string), 64)
+	if err != nil || limit < 0 {
+		log.WithField("err", err).Fatal("Invalid --rate-limit.")
+	}
+	if limit > 0 {
+		burst, err := strconv.Atoi(arguments["--rate-limit-burst"].(string))
+		if err != nil || burst < 1 {
+			log.WithField("err", err).Fatal("Invalid --rate-limit-burst.")
+		}
+		hookRateLimit = newRateLimiter(limit, burst)
+		enableFeature("rate-limit")
+	}
+	responseCacheSize, err := strconv.Atoi(arguments["--response-cache-size"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --response-cache-size.")
+	}
+	if responseCacheSize > 0 {
+		ttl, err := time.ParseDuration(arguments["--response-cache-ttl"].(string))
+		if err != nil {
+			log.WithField("err", err).Fatal("Invalid --response-cache-ttl.")
+		}
+		perNode, err := strconv.Atoi(arguments["--response-cache-per-node"].(string))
+		if err != nil || perNode < 0 {
+			log.WithField("err", err).Fatal("Invalid --response-cache-per-node.")
+		}
+		responseCache = newMutationCache(responseCacheSize, perNode, ttl)
+		enableFeature("response-cache")
+	}
+	if dir, ok := arguments["--capture-dir"].(string); ok {
+		rate, err := strconv.ParseFloat(arguments["--capture-rate"].(string), 64)
+		if err != nil {
+			log.WithField("err", err).Fatal("Invalid --capture-rate.")
+		}
+		max, err := strconv.Atoi(arguments["--capture-max"].(string))
+		if err != nil {
+			log.WithField("err", err).Fatal("Invalid --capture-max.")
+		}
+		capturer, err = newPayloadCapturer(dir, rate, max)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"dir": dir,
+				"err": err,
+			}).Fatal("Unable to set up payload capture.")
+		}
+		enableFeature("capture")
+	}
+	if urls, ok := arguments["--output-sinks"].(string); ok {
+		outputSinks, err = newSinkPublisher(urls)
+		if err != nil {
+			log.WithField("err", err).Fatal("Invalid --output-sinks.")
+		}
+		go outputSinks.run()
+		enableFeature("output-sinks")
+	}
+	if socket, ok := arguments["--shadow-socket"].(string); ok {
+		rate, err := strconv.ParseFloat(arguments["--shadow-rate"].(string), 64)
+		if err != nil {
+			log.WithField("err", err).Fatal("Invalid --shadow-rate.")
+		}
+		shadow = newShadowComparer(socket, rate)
+		enableFeature("shadow")
+	}
+	chaosLatency, err := time.ParseDuration(arguments["--chaos-latency"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --chaos-latency.")
+	}
+	chaosLatencyRate, err := strconv.ParseFloat(arguments["--chaos-latency-rate"].(string), 64)
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --chaos-latency-rate.")
+	}
+	chaosErrorRate, err := strconv.ParseFloat(arguments["--chaos-error-rate"].(string), 64)
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --chaos-error-rate.")
+	}
+	chaosTruncateRate, err := strconv.ParseFloat(arguments["--chaos-truncate-rate"].(string), 64)
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --chaos-truncate-rate.")
+	}
+	chaos, err = newChaosConfig(chaosLatency, chaosLatencyRate, chaosErrorRate, chaosTruncateRate)
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid chaos mode options.")
+	}
+	if chaos != nil {
+		log.Warn("Chaos mode is on: hook responses will be deliberately delayed, failed or truncated.")
+		enableFeature("chaos")
+	}
+	if dir, ok := arguments["--profile-dir"].(string); ok {
+		heapBytes, err := strconv.ParseUint(arguments["--profile-heap-bytes"].(string), 10, 64)
+		if err != nil {
+			log.WithField("err", err).Fatal("Invalid --profile-heap-bytes.")
+		}
+		cpuDuration, err := time.ParseDuration(arguments["--profile-cpu-duration"].(string))
+		if err != nil {
+			log.WithField("err", err).Fatal("Invalid --profile-cpu-duration.")
+		}
+		interval, err := time.ParseDuration(arguments["--profile-interval"].(string))
+		if err != nil {
+			log.WithField("err", err).Fatal("Invalid --profile-interval.")
+		}
+		max, err := strconv.Atoi(arguments["--profile-max"].(string))
+		if err != nil {
+			log.WithField("err", err).Fatal("Invalid --profile-max.")
+		}
+		profiler, err = newSelfProfiler(dir, max, interval, cpuDuration, heapBytes)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"dir": dir,
+				"err": err,
+			}).Fatal("Unable to set up profiling.")
+		}
+		go profiler.run()
+		enableFeature("profiling")
+	}
+	largePayloadBytes, err = strconv.Atoi(arguments["--large-payload-bytes"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --large-payload-bytes.")
+	}
+	maxPayloadBytes, err = strconv.Atoi(arguments["--max-payload-bytes"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --max-payload-bytes.")
+	}
+	if maxPayloadBytes > 0 {
+		enableFeature("max-payload-bytes")
+	}
+	bodySizeHint, err = strconv.Atoi(arguments["--body-size-hint"].(string))
+	if err != nil || bodySizeHint < 0 || bodySizeHint > maxPooledBuffer {
+		log.WithField("err", err).Fatal("Invalid --body-size-hint.")
+	}
+	readBuffer, err := strconv.Atoi(arguments["--read-buffer-bytes"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --read-buffer-bytes.")
+	}
+	writeBuffer, err := strconv.Atoi(arguments["--write-buffer-bytes"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --write-buffer-bytes.")
+	}
+	if readBuffer > 0 || writeBuffer > 0 {
+		enableFeature("socket-buffers")
+	}
+	slowRequestThreshold, err = time.ParseDuration(arguments["--slow-request-threshold"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --slow-request-threshold.")
+	}
+	availability, err := strconv.ParseFloat(arguments["--slo-availability"].(string), 64)
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --slo-availability.")
+	}
+	sloLatency, err := time.ParseDuration(arguments["--slo-latency"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --slo-latency.")
+	}
+	latencyObjective, err := strconv.ParseFloat(arguments["--slo-latency-objective"].(string), 64)
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --slo-latency-objective.")
+	}
+	slo = newSLOTracker(availability, sloLatency, latencyObjective)
+	go slo.run()
+	clusterLimit, err := strconv.Atoi(arguments["--metrics-service-cluster-limit"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --metrics-service-cluster-limit.")
+	}
+	var allowedClusters []string
+	if list, ok := arguments["--metrics-service-clusters"].(string); ok {
+		allowedClusters = strings.Split(list, ",")
+	}
+	serviceClusterLabels = newClusterLabeler(allowedClusters, clusterLimit)
+	window, err := time.ParseDuration(arguments["--correlation-window"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --correlation-window.")
+	}
+	if window > 0 {
+		correlator = newHookCorrelator(window)
+		go correlator.run()
+		enableFeature("correlation")
+	}
+	if file, ok := arguments["--meshes"].(string); ok {
+		meshes, err = loadMeshes(file)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"file": file,
+				"err":  err,
+			}).Fatal("Unable to load --meshes.")
+		}
+		enableFeature("meshes")
+	}
+	if arguments["transform"].(bool) {
+		transform(arguments)
+		return
+	}
+	if arguments["diff"].(bool) {
+		diff(arguments)
+		return
+	}
+	if arguments["lint"].(bool) {
+		lint(arguments)
+		return
+	}
+	if arguments["loadtest"].(bool) {
+		loadtest(arguments)
+		return
+	}
+	if arguments["export-fixtures"].(bool) {
+		exportFixtures(arguments)
+		return
+	}
+	if arguments["replay"].(bool) {
+		replay(arguments)
+		return
+	}
+	if arguments["check"].(bool) {
+		check(arguments)
+		return
+	}
+	// Simulations only read the cluster, so leave out the features that write to it.
+	simulating := arguments["simulate"].(bool)
+	kubeconfig, _ := arguments["--kubeconfig"].(string)
+	kube := &kubeClients{kubeconfig: kubeconfig}
+	if file, ok := arguments["--auth-token-file"].(string); ok {
+		hookAuth = newTokenAuth(file, fileSource(file))
+	} else if secret, ok := arguments["--auth-token-secret"].(string); ok {
+		s := strings.SplitN(secret, "/", 2)
+		if len(s) != 2 {
+			log.Fatal("Invalid --auth-token-secret.")
+		}
+		hookAuth = newTokenAuth(secret, secretSource(kube.Client().CoreV1().Secrets(s[0]), s[1], tokenSecretKey))
+	}
+	if hookAuth != nil {
+		if err := hookAuth.load(); err != nil {
+			log.WithFields(log.Fields{"source": hookAuth.name, "err": err}).Fatal("Unable to load hook auth tokens.")
+		}
+		go hookAuth.run()
+		enableFeature("token-auth")
+	}
+	if file, ok := arguments["--signing-key-file"].(string); ok {
+		signer = newResponseSigner(file, fileSource(file))
+	} else if secret, ok := arguments["--signing-key-secret"].(string); ok {
+		s := strings.SplitN(secret, "/", 2)
+		if len(s) != 2 {
+			log.Fatal("Invalid --signing-key-secret.")
+		}
+		signer = newResponseSigner(secret, secretSource(kube.Client().CoreV1().Secrets(s[0]), s[1], signingKeySecretKey))
+	}
+	if signer != nil {
+		if err := signer.load(); err != nil {
+			log.WithFields(log.Fields{"source": signer.name, "err": err}).Fatal("Unable to load response signing key.")
+		}
+		go signer.run()
+		enableFeature("signing")
+	}
+	if arguments["--kube-events"].(bool) && !simulating {
+		events = newEventRecorder(kube.Client())
+		enableFeature("kube-events")
+	}
+	if arguments["--watch-pods"].(bool) || simulating {
+		pods, err = newPodIndex(kube.Informers().Core().V1().Pods().Informer())
+		if err != nil {
+			log.WithField("err", err).Fatal("Unable to index pods.")
+		}
+		readiness.register("pods", pods.check)
+		enableFeature("watch-pods")
+	}
+	if action, ok := arguments["--node-local"].(string); ok {
+		if pods == nil {
+			log.Fatal("--node-local needs --watch-pods.")
+		}
+		if action != "flag" && action != "reject" {
+			log.WithField("action", action).Fatal("Invalid --node-local.")
+		}
+		node, ok := arguments["--node-name"].(string)
+		if !ok {
+			node = os.Getenv("NODE_NAME")
+		}
+		if node == "" {
+			log.Fatal("--node-local needs --node-name or $NODE_NAME.")
+		}
+		nodeLocal = newNodeLocalValidator(node, action == "reject")
+		enableFeature("node-local")
+	}
+	if list, ok := arguments["--exclude-service-accounts"].(string); ok {
+		if pods == nil && !arguments["admission"].(bool) {
+			log.Fatal("--exclude-service-accounts needs --watch-pods.")
+		}
+		excludedServiceAccounts, err = newServiceAccountMatcher(list)
+		if err != nil {
+			log.WithField("err", err).Fatal("Invalid --exclude-service-accounts.")
+		}
+		enableFeature("exclude-service-accounts")
+	}
+	if arguments["--felix-sync-gating"].(bool) {
+		if pods == nil {
+			log.Fatal("--felix-sync-gating needs --watch-pods.")
+		}
+		selector := arguments["--felix-selector"].(string)
+		felixSync, err = newFelixSyncGate(selector, kube.Informers().Core().V1().Pods().Informer())
+		if err != nil {
+			log.WithFields(log.Fields{
+				"selector": selector,
+				"err":      err,
+			}).Fatal("Invalid --felix-selector.")
+		}
+		enableFeature("felix-sync-gating")
+	}
+	if selector, ok := arguments["--namespace-selector"].(string); ok {
+		namespaces, err = newNamespaceSelector(selector, kube.Informers().Core().V1().Namespaces().Informer())
+		if err != nil {
+			log.WithFields(log.Fields{
+				"selector": selector,
+				"err":      err,
+			}).Fatal("Invalid --namespace-selector.")
+		}
+		readiness.register("namespaces", namespaces.check)
+		enableFeature("namespace-selector")
+	}
+	if mode, ok := arguments["--dikastes-discovery"].(string); ok {
+		switch mode {
+		case "service":
+			dikastes = newServiceResolver(arguments["--dikastes-service"].(string),
+				kube.Informers().Core().V1().Services().Informer())
+		case "daemonset":
+			if pods == nil {
+				log.Fatal("--dikastes-discovery=daemonset needs --watch-pods.")
+			}
+			dikastes = newDaemonSetResolver(arguments["--dikastes-daemonset"].(string),
+				arguments["--dikastes-socket"].(string), kube.Informers().Apps().V1().DaemonSets().Informer(), pods)
+		default:
+			log.WithField("mode", mode).Fatal("Invalid --dikastes-discovery.")
+		}
+		readiness.register("dikastes-discovery", dikastes.check)
+		enableFeature("dikastes-discovery")
+	}
+	if arguments["--exclude-dns-ports"].(bool) {
+		dnsPorts = newDNSPortSet(arguments["--dns-service"].(string),
+			kube.Informers().Core().V1().Services().Informer())
+		readiness.register("dns-ports", dnsPorts.check)
+		enableFeature("exclude-dns-ports")
+	}
+	if arguments["--dikastes-per-namespace"].(bool) {
+		if dikastes != nil {
+			log.Fatal("--dikastes-per-namespace cannot be used with --dikastes-discovery.")
+		}
+		namespaceDikastes, err = newDikastesTemplates(arguments["--dikastes-cluster-template"].(string),
+			arguments["--dikastes-socket-template"].(string))
+		if err != nil {
+			log.WithField("err", err).Fatal("Invalid --dikastes-cluster-template or --dikastes-socket-template.")
+		}
+		enableFeature("dikastes-per-namespace")
+	}
+	if v, ok := arguments["--istio-version"].(string); ok || pods != nil {
+		istioVersions, err = newVersionDetector(v)
+		if err != nil {
+			log.WithField("err", err).Fatal("Invalid --istio-version.")
+		}
+		readiness.register("istio-version", istioVersions.check)
+		go istioVersions.run()
+		enableFeature("istio-version")
+	}
+	cniCompat, err = newCNICompatibility(arguments["--cni-compat"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --cni-compat.")
+	}
+	if cniCompat != nil {
+		if pods == nil {
+			log.Fatal("--cni-compat needs --watch-pods.")
+		}
+		enableFeature("cni-compat")
+	}
+	classifier, err = newListenerClassifier(arguments["--listener-classifier"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --listener-classifier.")
+	}
+	if arguments["--listener-classifier"] != "name" {
+		if arguments["--listener-classifier"] == "metadata" && pods == nil {
+			log.Fatal("--listener-classifier=metadata needs --watch-pods.")
+		}
+		enableFeature("listener-classifier")
+	}
+	ids, idsSet := arguments["--allowed-node-ids"].(string)
+	ips, ipsSet := arguments["--allowed-node-ips"].(string)
+	if idsSet || ipsSet {
+		action := arguments["--unlisted-nodes"].(string)
+		if action != "pass" && action != "reject" {
+			log.WithField("action", action).Fatal("Invalid --unlisted-nodes.")
+		}
+		nodeAllowlist, err = newNodeAllowlist(ids, ips, action == "reject")
+		if err != nil {
+			log.WithField("err", err).Fatal("Invalid --allowed-node-ids or --allowed-node-ips.")
+		}
+		enableFeature("node-allowlist")
+	}
+	protocolSniffing, err = newSniffingConfig(arguments["--protocol-sniffing"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --protocol-sniffing.")
+	}
+	if protocolSniffing != nil {
+		if arguments["--protocol-sniffing"] == "mesh" {
+			c := strings.SplitN(arguments["--mesh-config"].(string), "/", 2)
+			if len(c) != 2 {
+				log.Fatal("Invalid --mesh-config.")
+			}
+			readiness.register("mesh-config", protocolSniffing.check)
+			go protocolSniffing.run(kube.Client().CoreV1().ConfigMaps(c[0]), c[1])
+		}
+		enableFeature("protocol-sniffing")
+	}
+	if arguments["--app-layer-policy"].(bool) {
+		if pods == nil {
+			log.Fatal("--app-layer-policy needs --watch-pods.")
+		}
+		appLayerPolicies = &alpIndex{}
+		if err := mutator.Register(&alpMutator{policies: appLayerPolicies}); err != nil {
+			log.WithField("err", err).Fatal("Unable to register the --app-layer-policy mutator.")
+		}
+		if !simulating {
+			readiness.register("app-layer-policies", appLayerPolicies.check)
+			go appLayerPolicies.run(kube.Client().CoreV1().RESTClient())
+		}
+		enableFeature("app-layer-policy")
+	}
+	if arguments["--sync-envoyfilter"].(bool) && !simulating {
+		f := envoyFilterFromArgs(arguments)
+		store := restEnvoyFilterStore{client: kube.Client().CoreV1().RESTClient()}
+		registerLeaderTask("sync-envoyfilter", func(stop <-chan struct{}) { syncEnvoyFilter(store, f, stop) })
+		enableFeature("sync-envoyfilter")
+	}
+	if arguments["--leader-elect"].(bool) && !simulating {
+		c := strings.SplitN(arguments["--leader-elect-lock"].(string), "/", 2)
+		if len(c) != 2 {
+			log.Fatal("Invalid --leader-elect-lock.")
+		}
+		identity, _ := os.Hostname()
+		recorder := events
+		if recorder == nil {
+			recorder = newEventRecorder(kube.Client())
+		}
+		go electLeader(kube.Client(), recorder, c[0], c[1], identity)
+		enableFeature("leader-elect")
+	} else {
+		runLeaderTasks(make(chan struct{}))
+	}
+	kube.start(make(chan struct{}))
+	if kube.informers != nil {
+		readiness.register("kube-informers", kube.check)
+	}
+	calicoConfig, _ := arguments["--calico-config"].(string)
+	calico := &calicoClients{config: calicoConfig}
+	requireL7 := arguments["--require-l7-policy"].(bool)
+	tiers := arguments["--propagate-tiers"].(bool)
+	if arguments["--calico-endpoints-only"].(bool) || requireL7 || tiers {
+		calicoEndpoints = newEndpointIndex()
+		readiness.register("calico-endpoints", calicoEndpoints.check)
+		enableFeature("calico-endpoints-only")
+		var policies *policyIndex
+		if requireL7 || tiers {
+			policies = newPolicyIndex()
+			policies.all = tiers
+			readiness.register("calico-policies", policies.check)
+		}
+		if requireL7 {
+			l7Policies = policies
+			enableFeature("require-l7-policy")
+		}
+		if tiers {
+			order, _ := arguments["--tier-order"].(string)
+			workloadTiers = newTierResolver(policies, order)
+			enableFeature("propagate-tiers")
+		}
+		if addr, ok := arguments["--typha-address"].(string); ok {
+			options := &syncclient.Options{}
+			options.CAFile, _ = arguments["--typha-ca-file"].(string)
+			options.CertFile, _ = arguments["--typha-cert-file"].(string)
+			options.KeyFile, _ = arguments["--typha-key-file"].(string)
+			options.ServerCN, _ = arguments["--typha-cn"].(string)
+			go newTyphaFeed(addr, options, calicoEndpoints, policies).run()
+			enableFeature("typha")
+		} else {
+			go calicoEndpoints.run(calico.Client())
+			if policies != nil {
+				go policies.run(calico.Client())
+			}
+		}
+	}
+	allowSets, _ := arguments["--eds-allow-networksets"].(string)
+	denySets, _ := arguments["--eds-deny-networksets"].(string)
+	if allowSets != "" || denySets != "" {
+		networkSets, err = newNetworkSetIndex(allowSets, denySets)
+		if err != nil {
+			log.WithField("err", err).Fatal("Invalid --eds-allow-networksets or --eds-deny-networksets.")
+		}
+		readiness.register("calico-networksets", networkSets.check)
+		go networkSets.run(calico.Client())
+		enableFeature("eds-networksets")
+	}
+	if calicoEndpoints != nil || networkSets != nil {
+		readiness.register("calico-datastore", calicoHealth.check)
+	}
+	if arguments["--annotate-pods"].(bool) && !simulating {
+		podStatus = newPodStatusReporter(kube.Client())
+		go podStatus.run()
+		enableFeature("annotate-pods")
+	}
+	if arguments["--propagate-labels"].(bool) {
+		if pods == nil && calicoEndpoints == nil {
+			log.Fatal("--propagate-labels needs --watch-pods or --calico-endpoints-only.")
+		}
+		propagateLabels = true
+		enableFeature("propagate-labels")
+	}
+	probeInterval, err := time.ParseDuration(arguments["--dikastes-probe-interval"].(string))
+	if err != nil {
+		log.WithField("err", err).Fatal("Invalid --dikastes-probe-interval.")
+	}
+	if probeInterval > 0 {
+		prober := newDikastesProber(arguments["--dikastes-socket"].(string),
+			arguments["--dikastes-grpc-health"].(bool), probeInterval)
+		prober.update()
+		readiness.register("dikastes", prober.check)
+		go prober.run(probeInterval)
+		enableFeature("dikastes-probe")
+	}
+	if err := checkPipeline(pipeline, featureEnabled); err != nil {
+		log.WithField("err", err).Fatal("Invalid --middleware.")
+	}
+	if err := runSelfTest().err(); err != nil {
+		log.WithField("err", err).Error("Self-test failed; the webhook will report not ready.")
+	}
+	readiness.register("selftest", selfTestReady)
+	if simulating {
+		simulate(arguments)
+		return
+	}
+	if addr, ok := arguments["--admin-address"].(string); ok {
+		go serveAdmin(addr)
+	}
+
+	if arguments["admission"].(bool) {
+		log.Fatal(serveAdmission(arguments["--admission-address"].(string),
+			arguments["--tls-cert"].(string), arguments["--tls-key"].(string)))
+	}
 
-	ws := newWebhook()
-	restful.Add(ws)
+	if _, ok := arguments["--hook-address"].(string); !ok && arguments["--spiffe-trust-domain"] != nil {
+		log.Fatal("--spiffe-trust-domain needs --hook-address.")
+	}
+	if arguments["--spiffe-ids"] != nil && arguments["--spiffe-trust-domain"] == nil {
+		log.Fatal("--spiffe-ids needs --spiffe-trust-domain.")
+	}
+	if addr, ok := arguments["--hook-address"].(string); ok {
+		var verifier *spiffeVerifier
+		if td, ok := arguments["--spiffe-trust-domain"].(string); ok {
+			ids, _ := arguments["--spiffe-ids"].(string)
+			verifier, err = newSPIFFEVerifier(td, ids)
+			if err != nil {
+				log.WithField("err", err).Fatal("Invalid --spiffe-trust-domain or --spiffe-ids.")
+			}
+			enableFeature("spiffe")
+		}
+		cert, _ := arguments["--hook-tls-cert"].(string)
+		key, _ := arguments["--hook-tls-key"].(string)
+		ca, _ := arguments["--hook-client-ca"].(string)
+		config, err := hookTLSConfig(cert, key, ca, verifier)
+		if err != nil {
+			log.WithField("err", err).Fatal("Unable to set up --hook-address.")
+		}
+		go func() { log.Fatal(serveHooksTLS(addr, config)) }()
+		enableFeature("hook-mtls")
+	}
 
 	filePath := arguments["<path>"].(string)
-	lis := openSocket(filePath)
+	lis := withSocketBuffers(openSocket(filePath), readBuffer, writeBuffer)
 	defer lis.Close()
 
-	server := http.Server{}
-	log.Fatal(server.Serve(lis))
+	log.Fatal(newHookServer().Serve(lis))
+}
+
+// newHookServer returns a server for the Pilot hooks.
+func newHookServer() *http.Server {
+	container := restful.NewContainer()
+	container.Add(newWebhook())
+	container.ServiceErrorHandler(deniedUnexpected)
+	return &http.Server{Handler: container}
+}
+
+// envoyFilterFromArgs generates the EnvoyFilter described by the --envoyfilter options.
+func envoyFilterFromArgs(arguments map[string]interface{}) envoyFilter {
+	labels := map[string]string{}
+	if l, ok := arguments["--envoyfilter-labels"].(string); ok {
+		for _, kv := range strings.Split(l, ",") {
+			c := strings.SplitN(kv, "=", 2)
+			if len(c) != 2 {
+				log.WithField("label", kv).Fatal("Invalid --envoyfilter-labels.")
+			}
+			labels[c[0]] = c[1]
+		}
+	}
+	return generateEnvoyFilter(arguments["--envoyfilter-name"].(string), arguments["--envoyfilter-namespace"].(string),
+		arguments["--dikastes-socket"].(string), labels)
 }
 
-// newWebhook creates a WebService with the xDS webhook routes
+// newWebhook creates a WebService with the xDS webhook routes, each running the stages of the pipeline that apply
+// to it.
 func newWebhook() *restful.WebService {
 	ws := new(restful.WebService)
-	ws.Route(ws.POST("/v1/listeners/{serviceCluster}/{serviceNode}").
-		Consumes(restful.MIME_JSON).
-		Produces(restful.MIME_JSON).
-		To(listeners))
-	ws.Route(ws.POST("/v1/clusters/{serviceCluster}/{serviceNode}").
-		Consumes(restful.MIME_JSON).
-		Produces(restful.MIME_JSON).
-		To(clusters))
-	ws.Route(ws.POST("/v1/routes/{routeConfigName}/{serviceCluster}/{serviceNode}").
-		Consumes(restful.MIME_JSON).
-		Produces(restful.MIME_JSON).
-		To(routes))
-	ws.Route(ws.POST("/v1/registration/{serviceName}").
-		Consumes(restful.MIME_JSON).
-		Produces(restful.MIME_JSON).
-		To(endpoints))
+	for _, r := range hookRoutes {
+		route := ws.POST(r.path).
+			Consumes(restful.MIME_JSON).
+			Produces(restful.MIME_JSON)
+		for _, m := range pipeline {
+			if m.appliesTo(r.hook) {
+				route = route.Filter(m.filter(r.hook))
+			}
+		}
+		ws.Route(route.To(r.handler))
+	}
 	return ws
 }
 
@@ -153,150 +1133,536 @@ func openSocket(filePath string) net.Listener {
 	return lis
 }
 
-// listeners handles LDS hooks and inserts the external authz filter
+// listeners handles LDS hooks, running the registered mutators, the first of which inserts the external authz filter.
 func listeners(req *restful.Request, resp *restful.Response) {
+	ctx := req.Request.Context()
 	serviceNode := req.PathParameter("serviceNode")
 	c := strings.Split(serviceNode, serviceNodeSeparator)
-	nodeType := c[0]
-	ip := c[1]
-	if nodeType != "sidecar" {
-		// Return unmodified.
-		io.Copy(resp, req.Request.Body)
+	nodeType, ip := c[0], ""
+	if len(c) > 1 {
+		ip = c[1]
+	}
+	dryRun := isDryRun(req)
+	if skip := skipNode(req.PathParameter("serviceCluster"), serviceNode, nodeType, ip); skip != "" {
+		// Return without the authz filter.  Dry runs change nothing, so are not counted.
+		if !dryRun {
+			countSkip(skip)
+			if podStatus != nil {
+				podStatus.report(serviceNode, StatusSkipped, skip)
+			}
+		}
+		if onlyAuthz() {
+			copyRequestToResponse("listeners", resp, req)
+			return
+		}
+		mreq := mutatorRequest(req, hookProfile{}, "")
+		mreq.SkipAuthz = skip
+		mutateBody(mutator.HookListeners, req, resp, mreq)
+		return
+	}
+	profile := profileForNode(ip)
+	profile.ClusterName = authzClusterFor(serviceNode)
+	if profile.Deprecated {
+		errorLog.Warn(log.Fields{"serviceNode": serviceNode},
+			"sidecar runs an Istio version without the webhook hooks; use generate-envoyfilter")
+	}
+	stats := statsFor(req)
+	outcome := newListenersOutcome(serviceNode, stats)
+	outcome.dryRun = dryRun
+	mreq := mutatorRequest(req, profile, "")
+	mreq.OnListener = outcome.add
+	// Dry runs and audited requests must report their changes, so are always mutated afresh.
+	useCache := responseCache != nil && !dryRun && auditLog == nil
+	if streamArrays && onlyAuthz() && !useCache && !dryRun && outputSchemas == nil {
+		outcome.audit = auditLog != nil
+		streamListeners(req, resp, mreq, outcome)
 		return
 	}
-	body, err := ioutil.ReadAll(req.Request.Body)
+	span := startStep(ctx, stats, "read")
+	in, err := readBody(req.Request.Body, req.Request.ContentLength)
 	if err != nil {
-		log.Error("failed to read")
+		endWithError(span, err)
+		if rejectOversized("listeners", resp, err) {
+			return
+		}
+		reportError("listeners", ErrorClassRead, log.Fields{"err": err}, "failed to read")
 		resp.WriteErrorString(http.StatusInternalServerError, "failed to read request")
 		return
 	}
-	var lds ldsResponse
-	err = json.Unmarshal(body, &lds)
+	defer putBuffer(in)
+	body := in.Bytes()
+	var cacheKey string
+	if useCache {
+		class := mutatorsNodeClass(req, listenersNodeClass(ip, profile))
+		cacheKey = mutationCacheKey("listeners", class, body)
+		if m, ok := responseCache.get("listeners", cacheKey); ok {
+			span.End()
+			stats.Listeners, stats.Injected = m.listeners, m.changed
+			if podStatus != nil {
+				status, reason := listenersStatus(m.authz)
+				podStatus.report(serviceNode, status, reason)
+			}
+			resp.Write(m.body)
+			return
+		}
+	}
+	span.End()
+
+	// The authz mutator traces its decode, classify, mutate and encode steps, and decodes only what it needs of the
+	// listeners, so only inbound ones are ever decoded in full.
+	out, changed, err := applyMutators(mutator.HookListeners, mreq, mutator.Callbacks{}, body)
 	if err != nil {
-		log.WithField("err", err).Error("failed to decode JSON")
-		fmt.Print(string(body))
+		listenersParseError(serviceNode, body, err, dryRun)
 		resp.WriteErrorString(http.StatusBadRequest, "could not parse request JSON")
 		return
 	}
-	for _, l := range lds.Listeners {
-		updateListener(l, ip)
+	outcome.logDebug()
+
+	if dryRun {
+		resp.AddHeader(DryRunChangesHeader, summarizeChanges(changed))
+		resp.Write(body)
+		return
 	}
-	out, err := json.Marshal(lds)
-	if err != nil {
-		log.WithField("err", err).Error("failed to re-encode")
-		resp.WriteErrorString(http.StatusInternalServerError, "internal error")
+
+	span = startStep(ctx, stats, "validate")
+	valid := checkOutput("listeners", serviceNode, out)
+	span.End()
+	if !valid {
+		resp.Write(body)
 		return
 	}
+	// Read whole, the listeners are diffed as every mutator left them, not only as the authz mutator did.
+	if auditLog != nil {
+		auditLog.recordMutation(req, mutator.HookListeners, body, out, changed)
+	}
+	outcome.reportStatus()
+	// Failures are not cached, so that they are retried and reported on the next push.
+	if cacheKey != "" && !outcome.failed {
+		responseCache.store(cacheKey, serviceNode, cachedMutation{
+			body: out, changed: stats.Injected, listeners: stats.Listeners, authz: outcome.authz,
+		})
+	}
 	resp.Write(out)
 	return
 }
 
-// updateListener processes a single Listener struct and inserts the external authz filter on inbound listeners.
-func updateListener(listener *v1.Listener, ip string) {
-	direction, proto := classifyListener(listener, ip)
-
-	// We only care about inbound listeners
-	if direction == OUTBOUND {
-		log.WithField("name", listener.Name).Debug("Skipping outbound listener")
-		return
-	} else if direction == VIRTUAL {
-		log.Debug("Skipping virtual listener")
+// streamListeners mutates the listeners of an LDS request one at a time as they are read, for --stream-arrays, with the
+// authz mutator, which must be the only one registered.  Unless --stream-writes is set, the response is held until
+// every listener has been mutated, so that a request that turns out to be malformed is answered with an error rather
+// than a truncated body.
+func streamListeners(req *restful.Request, resp *restful.Response, mreq *mutator.Request, outcome *listenersOutcome) {
+	serviceNode := outcome.serviceNode
+	span := startStep(req.Request.Context(), outcome.stats, "stream")
+	out := getBuffer()
+	defer putBuffer(out)
+	w, early := streamTarget(resp, out)
+	err := streamXDS(limitBody(req.Request.Body), w, "listeners", func(raw json.RawMessage) (json.RawMessage, error) {
+		res, err := hookAuthz.Listener(mreq, raw)
+		if err != nil {
+			return nil, err
+		}
+		outcome.add(raw, res)
+		return res.Raw, nil
+	}, func() []json.RawMessage { return nil })
+	if err != nil {
+		if early != nil && early.started {
+			// The status has been sent, so the response is cut short instead, which Pilot cannot parse either.
+			endWithError(span, err)
+			listenersParseError(serviceNode, nil, err, false)
+			return
+		}
+		if rejectOversized("listeners", resp, err) {
+			endWithError(span, err)
+			return
+		}
+		endWithError(span, err)
+		listenersParseError(serviceNode, nil, err, false)
+		resp.WriteErrorString(http.StatusBadRequest, "could not parse request JSON")
 		return
 	}
-	switch proto {
-	case HTTP:
-		updateHTTPListener(listener)
-	case TCP:
-		updateTCPListener(listener)
+	outcome.logDebug()
+	if auditLog != nil {
+		auditLog.recordListeners(req, outcome.names, outcome.before, outcome.after)
+	}
+	span.End()
+	outcome.reportStatus()
+	if early == nil {
+		resp.Write(out.Bytes())
+	}
+}
+
+// listenersOutcome accumulates the results of mutating the listeners of an LDS request, in listener order.
+type listenersOutcome struct {
+	serviceNode string
+	stats       *hookStats
+	changed     []string
+	authz       bool
+	failed      bool
+	// dryRun is set for dry runs, which report their changes but are not counted or evented.
+	dryRun bool
+	// names, before and after are the modified listeners, kept for the audit log if audit is set, when they are
+	// streamed and so cannot be diffed afterwards.
+	audit         bool
+	names         []string
+	before, after []json.RawMessage
+	// skipped counts the listeners skipped by reason, at Debug level only.
+	debug   bool
+	skipped map[skipReason]int
+}
+
+func newListenersOutcome(serviceNode string, stats *hookStats) *listenersOutcome {
+	o := &listenersOutcome{serviceNode: serviceNode, stats: stats, debug: debugEnabled()}
+	if o.debug {
+		o.skipped = make(map[skipReason]int)
+	}
+	return o
+}
+
+func (o *listenersOutcome) add(before json.RawMessage, res mutator.ListenerResult) {
+	o.stats.Listeners++
+	if res.Skip != "" {
+		if !o.dryRun {
+			countSkip(res.Skip)
+		}
+		if o.debug {
+			o.skipped[res.Skip]++
+		}
 	}
+	if res.Err != nil {
+		reportError("listeners", ErrorClassValidation, log.Fields{"listener": res.Name, "err": res.Err},
+			"failed to add authz filter")
+		if !o.dryRun {
+			emitFailureEvent(o.serviceNode, ReasonFailedMutation,
+				"Could not add authorization to listener "+res.Name+": "+res.Err.Error())
+		}
+		o.failed = true
+	}
+	if res.Modified {
+		o.changed = append(o.changed, "listener/"+res.Name)
+		o.stats.Injected++
+		if o.audit {
+			o.names = append(o.names, res.Name)
+			o.before = append(o.before, before)
+			o.after = append(o.after, res.Raw)
+		}
+	}
+	o.authz = o.authz || res.Authz
 }
 
-// classifyListener determines whether the listener is (inbound|outbound|virtual) and whether it is http or tcp protocol
-func classifyListener(listener *v1.Listener, ip string) (Direction, Protocol) {
-	var proto Protocol
-	if listener.Name == "virtual" {
-		return VIRTUAL, proto
+// logDebug logs what was done to the listeners in one line, rather than a line per listener, which with thousands of
+// listeners would cost more than mutating them.
+func (o *listenersOutcome) logDebug() {
+	if !o.debug {
+		return
 	}
-	c := strings.Split(listener.Name, listenerNameSeparator)
-	if c[0] == "http" {
-		proto = HTTP
-	} else if c[0] == "tcp" {
-		proto = TCP
+	skipped := make(log.Fields, len(o.skipped))
+	for reason, n := range o.skipped {
+		skipped[string(reason)] = n
 	}
-	if c[1] == ip {
-		return INBOUND, proto
-	} else {
-		return OUTBOUND, proto
+	log.WithFields(log.Fields{
+		"serviceNode": o.serviceNode,
+		"listeners":   o.stats.Listeners,
+		"updated":     o.changed,
+		"skipped":     skipped,
+		"failed":      o.failed,
+	}).Debug("Mutated listeners")
+}
+
+// reportStatus reports whether the pod's listeners were given the authz filter.
+func (o *listenersOutcome) reportStatus() {
+	if podStatus != nil {
+		status, reason := listenersStatus(o.authz)
+		podStatus.report(o.serviceNode, status, reason)
 	}
 }
 
-// updateHTTPListener inserts the external authz filter into the HTTP connection manager
-func updateHTTPListener(listener *v1.Listener) {
-	log.WithField("name", listener.Name).Debug("Updating HTTP listener")
-	var httpManagerConfig v1.NetworkFilterConfig
-	for _, filter := range listener.Filters {
-		if filter.Name == v1.HTTPConnectionManager {
-			httpManagerConfig = filter.Config
-			break
-		}
-	}
-	if httpManagerConfig != nil {
-		// Found HTTP Listener
-		cfg := httpManagerConfig.(*v1.HTTPFilterConfig)
-		// Prepend; it must be the first filter so a failed authorization will close the connection.
-		authzHttp := v1.HTTPFilter{
-			Type:   "decoder",
-			Name:   AuthZFilterName,
-			Config: &AuthzFilterConfig{GrpcCluster: &GrpcClusterConfig{ClusterName: AuthZClusterName}},
-		}
-		cfg.Filters = append([]v1.HTTPFilter{authzHttp}, cfg.Filters...)
-	} else {
-		log.WithField("listener", *listener).Error("tried to add HTTP Authz filter to non-HTTP listener")
+// listenersParseError reports an LDS response that could not be decoded.  body is nil if it was streamed.  Dry runs
+// were not sent by Pilot, so raise no event.
+func listenersParseError(serviceNode string, body []byte, err error, dryRun bool) {
+	if reportError("listeners", ErrorClassParse, log.Fields{"err": err}, "failed to decode JSON") && body != nil {
+		fmt.Print(string(redactBody(body)))
+	}
+	if !dryRun {
+		emitFailureEvent(serviceNode, ReasonFailedDecode, "Could not parse LDS from Pilot: "+err.Error())
 	}
-	return
 }
 
-// updateTCPListener adds the external authz network filter
-func updateTCPListener(listener *v1.Listener) {
-	log.WithField("name", listener.Name).Debug("Updating TCP listener")
-	authzTCP := v1.NetworkFilter{
-		Type: "read",
-		Name: AuthZFilterName,
-		Config: &AuthzFilterConfig{StatPrefix: AuthZFilterName,
-			GrpcCluster: &GrpcClusterConfig{ClusterName: AuthZClusterName}},
-	}
-	// Prepend; it must be the first filter so a failed authorization will close the connection.
-	listener.Filters = append([]*v1.NetworkFilter{&authzTCP}, listener.Filters...)
-	return
+// isDryRun reports whether the caller asked for the changes to be reported rather than applied.
+func isDryRun(req *restful.Request) bool {
+	dryRun, _ := strconv.ParseBool(req.HeaderParameter(DryRunHeader))
+	return dryRun
+}
+
+// summarizeChanges formats the resources that would have been modified for the dry run response header.
+func summarizeChanges(changed []string) string {
+	if len(changed) == 0 {
+		return "none"
+	}
+	return strings.Join(changed, ",")
 }
 
-// clusters handles the CDS hook and is a passthru
+// clusters handles the CDS hook, running the registered mutators.  The authz mutator passes the clusters through
+// unless dikastes discovery or per namespace dikastes is enabled, or the node's mesh has a dikastes address, in which
+// case it adds the authz cluster for sidecars, pointing at their dikastes.
 func clusters(req *restful.Request, resp *restful.Response) {
-	copyRequestToResponse(resp, req)
+	serviceNode := req.PathParameter("serviceNode")
+	name := authzClusterFor(serviceNode)
+	addr, err := dikastesAddressFor(req.PathParameter("serviceCluster"), serviceNode, name)
+	if err != nil {
+		// Leave the authz cluster out rather than guess.
+		reportError("clusters", ErrorClassLookup, log.Fields{"serviceNode": serviceNode, "err": err},
+			"failed to discover dikastes")
+		addr = ""
+	}
+	if addr == "" && onlyAuthz() {
+		copyRequestToResponse("clusters", resp, req)
+		return
+	}
+	// Audited requests are read whole, so that the cluster added can be diffed.
+	if streamArrays && onlyAuthz() && responseCache == nil && !isDryRun(req) && outputSchemas == nil && auditLog == nil {
+		streamClusters(req, resp, serviceNode, name, addr)
+		return
+	}
+	ctx := req.Request.Context()
+	stats := statsFor(req)
+	span := startStep(ctx, stats, "read")
+	in, err := readBody(req.Request.Body, req.Request.ContentLength)
+	if err != nil {
+		endWithError(span, err)
+		if rejectOversized("clusters", resp, err) {
+			return
+		}
+		reportError("clusters", ErrorClassRead, log.Fields{"err": err}, "failed to read")
+		resp.WriteErrorString(http.StatusInternalServerError, "failed to read request")
+		return
+	}
+	defer putBuffer(in)
+	body := in.Bytes()
+	var cacheKey string
+	if responseCache != nil && !isDryRun(req) && auditLog == nil {
+		cacheKey = mutationCacheKey("clusters", mutatorsNodeClass(req, name+"|"+addr), body)
+		if m, ok := responseCache.get("clusters", cacheKey); ok {
+			span.End()
+			stats.ClustersAdded = m.changed
+			resp.Write(m.body)
+			return
+		}
+	}
+	span.End()
+	// The authz mutator only needs the cluster name, so the rest of the profile is only worked out for the others.
+	profile := hookProfile{ClusterName: name}
+	if !onlyAuthz() {
+		profile = sidecarProfile(serviceNode)
+	}
+	cb := mutator.Callbacks{OnResourceMutated: func(_ mutator.Hook, _ *mutator.Request, m string, added []string) error {
+		if m == mutator.AuthzName {
+			stats.ClustersAdded += len(added)
+		}
+		return nil
+	}}
+	out, changed, err := applyMutators(mutator.HookClusters, mutatorRequest(req, profile, addr), cb, body)
+	if err != nil {
+		reportError("clusters", ErrorClassParse, log.Fields{"err": err}, "failed to decode JSON")
+		if !isDryRun(req) {
+			emitFailureEvent(serviceNode, ReasonFailedDecode, "Could not parse CDS from Pilot: "+err.Error())
+		}
+		resp.WriteErrorString(http.StatusBadRequest, "could not parse request JSON")
+		return
+	}
+	if isDryRun(req) {
+		writeMutated(mutator.HookClusters, req, resp, body, out, changed)
+		return
+	}
+	span = startStep(ctx, stats, "validate")
+	valid := checkOutput("clusters", serviceNode, out)
+	span.End()
+	if !valid {
+		resp.Write(body)
+		return
+	}
+	if auditLog != nil {
+		auditLog.recordMutation(req, mutator.HookClusters, body, out, changed)
+	}
+	if cacheKey != "" {
+		responseCache.store(cacheKey, "", cachedMutation{body: out, changed: stats.ClustersAdded})
+	}
+	resp.Write(out)
 }
 
-// routes handles the RDS hook and is a passthru
-func routes(req *restful.Request, resp *restful.Response) {
-	copyRequestToResponse(resp, req)
+// dikastesAddressFor returns the dikastes address the authz cluster name points at for a node, from its mesh, its
+// namespace's dikastes or dikastes discovery, or "" if the cluster is not added for it.
+func dikastesAddressFor(serviceCluster, serviceNode, name string) (string, error) {
+	c := strings.Split(serviceNode, serviceNodeSeparator)
+	var addr string
+	if mesh := meshFor(serviceCluster, serviceNode); mesh != nil {
+		addr = mesh.DikastesAddress
+	}
+	if name != AuthZClusterName && addr == "" {
+		_, namespace, _ := podFromServiceNode(serviceNode)
+		addr = namespaceDikastes.address(namespace)
+	}
+	if (dikastes == nil && addr == "") || len(c) < 2 || c[0] != "sidecar" {
+		return "", nil
+	}
+	if addr == "" {
+		return dikastes.address(c[1])
+	}
+	return addr, nil
 }
 
-// endpoints handles the EDS hook and is a passthru
-func endpoints(req *restful.Request, resp *restful.Response) {
-	copyRequestToResponse(resp, req)
+// streamClusters adds the authz cluster to a CDS request as its clusters are read, for --stream-arrays.
+func streamClusters(req *restful.Request, resp *restful.Response, serviceNode, name, addr string) {
+	out := getBuffer()
+	defer putBuffer(out)
+	w, early := streamTarget(resp, out)
+	matched := false
+	err := streamXDS(limitBody(req.Request.Body), w, "clusters", func(raw json.RawMessage) (json.RawMessage, error) {
+		if matched {
+			return raw, nil
+		}
+		c, ok, err := mutator.ReplaceAuthzCluster(raw, name, addr)
+		if err != nil {
+			return nil, err
+		}
+		if ok && !bytes.Equal(c, raw) {
+			statsFor(req).ClustersAdded++
+		}
+		matched = ok
+		return c, nil
+	}, func() []json.RawMessage {
+		if matched {
+			return nil
+		}
+		matched = true
+		statsFor(req).ClustersAdded++
+		return []json.RawMessage{mutator.ClusterSnippet(name, addr)}
+	})
+	if err != nil {
+		started := early != nil && early.started
+		if !started && rejectOversized("clusters", resp, err) {
+			return
+		}
+		reportError("clusters", ErrorClassParse, log.Fields{"err": err}, "failed to decode JSON")
+		emitFailureEvent(serviceNode, ReasonFailedDecode, "Could not parse CDS from Pilot: "+err.Error())
+		if !started {
+			resp.WriteErrorString(http.StatusBadRequest, "could not parse request JSON")
+		}
+		return
+	}
+	if early == nil {
+		resp.Write(out.Bytes())
+	}
+}
+
+// routes handles the RDS hook, which the authz mutator passes through, so it is a passthru unless other mutators, such
+// as the --app-layer-policy one, are registered.
+func routes(req *restful.Request, resp *restful.Response) {
+	if onlyAuthz() {
+		copyRequestToResponse("routes", resp, req)
+		return
+	}
+	profile := sidecarProfile(req.PathParameter("serviceNode"))
+	mutateBody(mutator.HookRoutes, req, resp, mutatorRequest(req, profile, ""))
 }
 
-func copyRequestToResponse(resp *restful.Response, req *restful.Request) {
-	body, err := ioutil.ReadAll(req.Request.Body)
+// endpoints handles the EDS hook.  It is a passthru unless GlobalNetworkSet filtering is enabled, in which case hosts
+// the network sets do not permit are removed, or mutators besides the authz mutator are registered.
+func endpoints(req *restful.Request, resp *restful.Response) {
+	if networkSets == nil {
+		if onlyAuthz() {
+			copyRequestToResponse("endpoints", resp, req)
+			return
+		}
+		mutateBody(mutator.HookEndpoints, req, resp, mutatorRequest(req, hookProfile{}, ""))
+		return
+	}
+	ctx := req.Request.Context()
+	stats := statsFor(req)
+	span := startStep(ctx, stats, "read")
+	in, err := readBody(req.Request.Body, req.Request.ContentLength)
 	if err != nil {
-		log.WithField("err", err).Error("failed to read body")
-		resp.WriteErrorString(http.StatusBadRequest, "Could not read request body")
+		endWithError(span, err)
+		if rejectOversized("endpoints", resp, err) {
+			return
+		}
+		reportError("endpoints", ErrorClassRead, log.Fields{"err": err}, "failed to read")
+		resp.WriteErrorString(http.StatusInternalServerError, "failed to read request")
 		return
 	}
-	_, err = resp.Write(body)
+	defer putBuffer(in)
+	body := in.Bytes()
+	span.End()
+
+	span = startStep(ctx, stats, "decode")
+	var sds map[string]json.RawMessage
+	var hosts []json.RawMessage
+	if err = codec.Unmarshal(body, &sds); err == nil && sds["hosts"] != nil {
+		err = codec.Unmarshal(sds["hosts"], &hosts)
+	}
 	if err != nil {
-		log.WithField("err", err).Error("Failed to write response")
-		resp.WriteErrorString(http.StatusBadRequest, "Could not write response")
+		endWithError(span, err)
+		reportError("endpoints", ErrorClassParse, log.Fields{"err": err}, "failed to decode JSON")
+		resp.WriteErrorString(http.StatusBadRequest, "could not parse request JSON")
 		return
 	}
+	span.End()
+
+	span = startStep(ctx, stats, "mutate")
+	kept := make([]json.RawMessage, 0, len(hosts))
+	var changed []string
+	for _, h := range hosts {
+		var host sdsHost
+		if err := codec.Unmarshal(h, &host); err == nil && !networkSets.permits(host.IPAddress) {
+			changed = append(changed, "endpoint/"+host.IPAddress)
+			continue
+		}
+		kept = append(kept, h)
+	}
+	stats.EndpointsRemoved = len(changed)
+	span.End()
+	out := body
+	if len(changed) > 0 {
+		if !isDryRun(req) {
+			endpointsFiltered.Add(float64(len(changed)))
+		}
+		span = startStep(ctx, stats, "encode")
+		sds["hosts"] = mutator.RawArray(kept)
+		buf := getBuffer()
+		defer putBuffer(buf)
+		mutator.WriteRawObject(buf, sds)
+		out = buf.Bytes()
+		span.End()
+	}
+	if !onlyAuthz() {
+		var more []string
+		out, more, err = applyMutators(mutator.HookEndpoints, mutatorRequest(req, hookProfile{}, ""),
+			mutator.Callbacks{}, out)
+		if err != nil {
+			reportError("endpoints", ErrorClassParse, log.Fields{"err": err}, "failed to decode JSON")
+			resp.WriteErrorString(http.StatusBadRequest, "could not parse request JSON")
+			return
+		}
+		changed = append(changed, more...)
+	}
+	writeMutated(mutator.HookEndpoints, req, resp, body, out, changed)
+}
 
+func copyRequestToResponse(hook string, resp *restful.Response, req *restful.Request) {
+	if isDryRun(req) {
+		resp.AddHeader(DryRunChangesHeader, summarizeChanges(nil))
+	}
+	// Streamed rather than read into memory, so passing config through costs next to nothing however large it is.
+	n, readErr, writeErr := streamBody(resp, req.Request.Body)
+	if readErr != nil {
+		if n == 0 && rejectOversized(hook, resp, readErr) {
+			return
+		}
+		reportError(hook, ErrorClassRead, log.Fields{"err": readErr}, "failed to read body")
+		resp.WriteErrorString(http.StatusBadRequest, "Could not read request body")
+		return
+	}
+	if writeErr != nil {
+		reportError(hook, ErrorClassWrite, log.Fields{"err": writeErr}, "Failed to write response")
+		resp.WriteErrorString(http.StatusBadRequest, "Could not write response")
+	}
 }
@@ -17,7 +17,6 @@ package main
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http/httptest"
 	"testing"
@@ -29,51 +28,97 @@ import (
 	"github.com/emicklei/go-restful"
 	. "github.com/onsi/gomega"
 	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+	"github.com/projectcalico/pilot-webhook/pkg/testutil"
 )
 
+// The request builders are in pkg/testutil, so that other repos can use them too.
 const (
-	NODE_IP         = "3.4.5.6"
-	SERVICE_CLUSTER = "testcluster"
-	ROUTE_CONFIG    = "testrds"
-	SERVICE_NAME    = "testeds"
+	NODE_IP         = testutil.NodeIP
+	SERVICE_CLUSTER = testutil.ServiceCluster
+	ROUTE_CONFIG    = testutil.RouteConfig
+	SERVICE_NAME    = testutil.ServiceName
+)
+
+var (
+	serviceNode   = testutil.ServiceNode
+	newLDSRequest = testutil.NewLDSRequest
+	newCDSRequest = testutil.NewCDSRequest
+	newRDSRequest = testutil.NewRDSRequest
+	newEDSRequest = testutil.NewEDSRequest
 )
 
-func serviceNode(nodeType, ip string) string {
-	return fmt.Sprintf("%s~%s~other~items", nodeType, ip)
+// newHooks returns a container serving the hooks, as the server does.
+func newHooks() *restful.Container {
+	container := restful.NewContainer()
+	container.Add(newWebhook())
+	return container
+}
+
+// hookRequest returns a request for a hook, on the path Pilot calls it on for a proxy of the given type on NODE_IP.
+func hookRequest(hook, nodeType string, body io.Reader) *http.Request {
+	return httptest.NewRequest("POST", testutil.HookPath(hook, nodeType), body)
 }
 
-func newLDSRequest(nodeType string, body io.Reader) *restful.Request {
-	sn := serviceNode(nodeType, NODE_IP)
-	url := fmt.Sprintf("http://unix/v1/listeners/%s/%s", SERVICE_CLUSTER, sn)
-	httpReq := httptest.NewRequest("POST", url, body)
-	req := restful.NewRequest(httpReq)
-	req.PathParameters()["serviceNode"] = sn
-	return req
+// serveHook sends a request through the hooks a container serves and returns the response.
+func serveHook(container http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	container.ServeHTTP(rec, req)
+	return rec
+}
+
+// updateListener runs the hooks' authz mutator on one listener of a sidecar on ip, counting it as the LDS hook does, and
+// decodes the raw JSON it returns back into the listener, so that tests inspect the filter the mutator really spliced.
+func updateListener(listener *v1.Listener, ip string) {
+	sn := serviceNode("sidecar", ip)
+	raw, err := json.Marshal(listener)
+	Expect(err).To(BeNil())
+	res, err := hookAuthz.Listener(&mutator.Request{Node: config.ParseNode(sn), Profile: profileForNode(ip)}, raw)
+	Expect(err).To(BeNil())
+	newListenersOutcome(sn, &hookStats{}).add(raw, res)
+	if !res.Modified {
+		return
+	}
+	*listener = decodeListener(res.Raw)
 }
 
-func newCDSRequest(nodeType string, body io.Reader) *restful.Request {
-	sn := serviceNode(nodeType, NODE_IP)
-	url := fmt.Sprintf("http://unix/v1/clusters/%s/%s", SERVICE_CLUSTER, sn)
-	httpReq := httptest.NewRequest("POST", url, body)
-	req := restful.NewRequest(httpReq)
-	req.PathParameters()["serviceNode"] = sn
-	return req
+// decodeListener decodes a listener's JSON with typed configs for the authz filters and HTTP connection managers, the
+// shapes the tests assert on; other filters keep the generic config encoding/json gives them.
+func decodeListener(raw json.RawMessage) v1.Listener {
+	var listener v1.Listener
+	Expect(json.Unmarshal(raw, &listener)).To(Succeed())
+	for _, filter := range listener.Filters {
+		switch filter.Name {
+		case AuthZFilterName:
+			filter.Config = decodeConfig(filter.Config, &mutator.AuthzFilterConfig{})
+		case v1.HTTPConnectionManager:
+			cfg := decodeConfig(filter.Config, &v1.HTTPFilterConfig{}).(*v1.HTTPFilterConfig)
+			for i := range cfg.Filters {
+				if cfg.Filters[i].Name == AuthZFilterName {
+					cfg.Filters[i].Config = decodeConfig(cfg.Filters[i].Config, &mutator.AuthzFilterConfig{})
+				}
+			}
+			filter.Config = cfg
+		}
+	}
+	return listener
 }
 
-func newRDSRequest(nodeType string, body io.Reader) *restful.Request {
-	sn := serviceNode(nodeType, NODE_IP)
-	url := fmt.Sprintf("http://unix/v1/routes/%s/%s/%s", ROUTE_CONFIG, SERVICE_CLUSTER, sn)
-	httpReq := httptest.NewRequest("POST", url, body)
-	req := restful.NewRequest(httpReq)
-	req.PathParameters()["serviceNode"] = sn
-	return req
+// decodeConfig re-decodes a generically decoded filter config into typed.
+func decodeConfig(generic, typed interface{}) interface{} {
+	raw, err := json.Marshal(generic)
+	Expect(err).To(BeNil())
+	Expect(json.Unmarshal(raw, typed)).To(Succeed())
+	return typed
 }
 
-func newEDSRequest(body io.Reader) *restful.Request {
-	url := fmt.Sprintf("http://unix/v1/registration/%s", SERVICE_NAME)
-	httpReq := httptest.NewRequest("POST", url, body)
-	req := restful.NewRequest(httpReq)
-	return req
+func TestTestutilNames(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(testutil.AuthzFilterName).To(Equal(AuthZFilterName))
+	Expect(testutil.AuthzClusterName).To(Equal(AuthZClusterName))
 }
 
 func TestListenersMainline(t *testing.T) {
@@ -170,6 +215,8 @@ func TestUpdateListenersTCP(t *testing.T) {
 	updateListener(&l, "1.2.3.4")
 	Expect(len(l.Filters)).To(Equal(2))
 	Expect(l.Filters[0].Name).To(Equal(AuthZFilterName))
+	Expect(l.Filters[0].Config).To(BeAssignableToTypeOf(&mutator.AuthzFilterConfig{}))
+	Expect(l.Filters[1].Name).To(Equal(v1.TCPProxyFilter))
 }
 
 func TestClusterPassthru(t *testing.T) {
@@ -207,3 +254,40 @@ func TestEndpointsPassthru(t *testing.T) {
 	Expect(rec.Code).To(Equal(http.StatusOK))
 	Expect(rec.Body.String()).To(Equal(body))
 }
+
+func TestListenersDryRun(t *testing.T) {
+	RegisterTestingT(t)
+
+	ldsReq := ldsResponse{Listeners: []*v1.Listener{
+		{
+			Name:    "tcp_0.0.0.0_80",
+			Filters: []*v1.NetworkFilter{{Name: v1.TCPProxyFilter}},
+		},
+		{
+			Name:    "tcp_" + NODE_IP + "_43",
+			Filters: []*v1.NetworkFilter{{Name: v1.TCPProxyFilter}},
+		},
+	}}
+	ldsBytes, err := json.Marshal(ldsReq)
+	Expect(err).To(BeNil())
+	req := newLDSRequest("sidecar", bytes.NewReader(ldsBytes))
+	req.Request.Header.Set(DryRunHeader, "true")
+	recorder := httptest.NewRecorder()
+	resp := restful.NewResponse(recorder)
+	listeners(req, resp)
+	Expect(recorder.Code).To(Equal(http.StatusOK))
+	Expect(recorder.Body.Bytes()).To(Equal(ldsBytes))
+	Expect(recorder.Header().Get(DryRunChangesHeader)).To(Equal("listener/tcp_" + NODE_IP + "_43"))
+}
+
+func TestPassthruDryRun(t *testing.T) {
+	RegisterTestingT(t)
+
+	body := "testing CDS"
+	req := newCDSRequest("sidecar", strings.NewReader(body))
+	req.Request.Header.Set(DryRunHeader, "1")
+	rec := httptest.NewRecorder()
+	clusters(req, restful.NewResponse(rec))
+	Expect(rec.Body.String()).To(Equal(body))
+	Expect(rec.Header().Get(DryRunChangesHeader)).To(Equal("none"))
+}
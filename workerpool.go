@@ -0,0 +1,137 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	workerWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "worker_wait_seconds",
+		Help:      "Time hook requests waited for a mutation worker, by hook.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"hook"})
+	workersBusy = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "workers_busy",
+		Help:      "Mutation workers currently mutating a request.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(workerWait, workersBusy)
+}
+
+// mutationWorkers bounds how many hook requests are mutated at once.  It is nil unless --mutation-workers is set.
+var mutationWorkers *workerPool
+
+// workerPool runs jobs on a fixed number of goroutines.
+type workerPool struct {
+	jobs chan func()
+}
+
+func newWorkerPool(workers int) *workerPool {
+	p := &workerPool{jobs: make(chan func())}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *workerPool) work() {
+	for job := range p.jobs {
+		workersBusy.Inc()
+		job()
+		workersBusy.Dec()
+	}
+}
+
+// run runs f on a worker, waiting for one to be free, and returns once f has.
+func (p *workerPool) run(hook string, f func()) {
+	done := make(chan struct{})
+	start := time.Now()
+	p.jobs <- func() {
+		workerWait.WithLabelValues(hook).Observe(time.Since(start).Seconds())
+		defer close(done)
+		f()
+	}
+	<-done
+}
+
+// pooled is a route filter that runs the rest of the chain on a mutation worker.  The request body is read before
+// taking a worker and the response is written after giving it back, so that a slow peer on the socket holds up only
+// its own request and never a worker.
+func pooled(hook string) restful.FilterFunction {
+	return func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		if mutationWorkers == nil {
+			chain.ProcessFilter(req, resp)
+			return
+		}
+		in, err := readBody(req.Request.Body, req.Request.ContentLength)
+		if err != nil {
+			if rejectOversized(hook, resp, err) {
+				return
+			}
+			reportError(hook, ErrorClassRead, log.Fields{"err": err}, "failed to read")
+			resp.WriteErrorString(http.StatusInternalServerError, "failed to read request")
+			return
+		}
+		defer putBuffer(in)
+		req.Request.Body = ioutil.NopCloser(bytes.NewReader(in.Bytes()))
+		out := &deferredWriter{ResponseWriter: resp.ResponseWriter, buf: getBuffer()}
+		defer putBuffer(out.buf)
+		resp.ResponseWriter = out
+
+		mutationWorkers.run(hook, func() { chain.ProcessFilter(req, resp) })
+
+		resp.ResponseWriter = out.ResponseWriter
+		out.flush()
+	}
+}
+
+// deferredWriter holds a response until flush is called.
+type deferredWriter struct {
+	http.ResponseWriter
+	status int
+	buf    *bytes.Buffer
+}
+
+func (w *deferredWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *deferredWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
+func (w *deferredWriter) flush() {
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if _, err := w.ResponseWriter.Write(w.buf.Bytes()); err != nil {
+		log.WithField("err", err).Debug("Failed to write response")
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+)
+
+func TestWorkerPoolBound(t *testing.T) {
+	RegisterTestingT(t)
+
+	p := newWorkerPool(2)
+	var running, max int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.run("listeners", func() {
+				n := atomic.AddInt32(&running, 1)
+				for {
+					m := atomic.LoadInt32(&max)
+					if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+			})
+		}()
+	}
+	wg.Wait()
+	Expect(atomic.LoadInt32(&max)).To(Equal(int32(2)))
+}
+
+// A peer that is slow to send its body must not hold up other requests, even with a single worker.
+func TestPooledNoHeadOfLine(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { mutationWorkers = nil }()
+	mutationWorkers = newWorkerPool(1)
+	container := newHooks()
+
+	slowBody, slowWriter := io.Pipe()
+	var slow, fast *httptest.ResponseRecorder
+	slowDone := make(chan struct{}, 1)
+	go func() {
+		slow = serveHook(container, hookRequest("clusters", "sidecar", slowBody))
+		slowDone <- struct{}{}
+	}()
+
+	fastDone := make(chan struct{}, 1)
+	go func() {
+		fast = serveHook(container, hookRequest("clusters", "sidecar", strings.NewReader(`{"clusters":[]}`)))
+		fastDone <- struct{}{}
+	}()
+	Eventually(fastDone, time.Second).Should(Receive())
+	Expect(fast.Code).To(Equal(http.StatusOK))
+	Expect(fast.Body.String()).To(Equal(`{"clusters":[]}`))
+
+	slowWriter.Write([]byte(`{"clusters":[]}`))
+	slowWriter.Close()
+	Eventually(slowDone, time.Second).Should(Receive())
+	Expect(slow.Code).To(Equal(http.StatusOK))
+}
+
+func TestPooledErrorStatus(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { mutationWorkers = nil }()
+	mutationWorkers = newWorkerPool(1)
+	rec := serveHook(newHooks(), hookRequest("listeners", "sidecar", strings.NewReader("not JSON")))
+	Expect(rec.Code).To(Equal(http.StatusBadRequest))
+}
+
+// benchLDS returns an LDS response with the given number of inbound listeners for NODE_IP, alternately HTTP and TCP,
+// and four times as many outbound ones, roughly the mix a sidecar in a large mesh gets.
+func benchLDS(inbound int) []byte {
+	lds := ldsResponse{}
+	hcm := func() []*v1.NetworkFilter {
+		return []*v1.NetworkFilter{{
+			Type: "read",
+			Name: v1.HTTPConnectionManager,
+			Config: &v1.HTTPFilterConfig{
+				CodecType:  "auto",
+				StatPrefix: "http",
+				Filters:    []v1.HTTPFilter{{Type: "decoder", Name: v1.CORSFilter}, {Type: "decoder", Name: v1.RouterFilter}},
+			},
+		}}
+	}
+	for i := 0; i < inbound; i++ {
+		port := 8000 + i
+		if i%2 == 0 {
+			lds.Listeners = append(lds.Listeners, &v1.Listener{
+				Name: fmt.Sprintf("http_%s_%d", NODE_IP, port), Address: fmt.Sprintf("tcp://%s:%d", NODE_IP, port),
+				Filters: hcm(),
+			})
+		} else {
+			lds.Listeners = append(lds.Listeners, &v1.Listener{
+				Name: fmt.Sprintf("tcp_%s_%d", NODE_IP, port), Address: fmt.Sprintf("tcp://%s:%d", NODE_IP, port),
+				Filters: []*v1.NetworkFilter{{Type: "read", Name: v1.TCPProxyFilter}},
+			})
+		}
+		for j := 0; j < 4; j++ {
+			addr := fmt.Sprintf("10.96.%d.%d", i, j)
+			lds.Listeners = append(lds.Listeners, &v1.Listener{
+				Name: fmt.Sprintf("http_%s_%d", addr, port), Address: fmt.Sprintf("tcp://%s:%d", addr, port),
+				Filters: hcm(),
+			})
+		}
+	}
+	b, _ := json.Marshal(lds)
+	return b
+}
+
+// BenchmarkConcurrentPushes simulates a number of sidecars fetching LDS at once, as after a Pilot restart, with and
+// without a bounded worker pool.
+func BenchmarkConcurrentPushes(b *testing.B) {
+	body := benchLDS(20)
+	for _, workers := range []int{0, 4} {
+		for _, sidecars := range []int{1, 10, 100} {
+			b.Run(fmt.Sprintf("workers=%d/sidecars=%d", workers, sidecars), func(b *testing.B) {
+				mutationWorkers = nil
+				if workers > 0 {
+					mutationWorkers = newWorkerPool(workers)
+				}
+				defer func() { mutationWorkers = nil }()
+				container := newHooks()
+				b.SetBytes(int64(len(body) * sidecars))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					var wg sync.WaitGroup
+					for s := 0; s < sidecars; s++ {
+						wg.Add(1)
+						go func() {
+							defer wg.Done()
+							rec := serveHook(container, hookRequest("listeners", "sidecar", bytes.NewReader(body)))
+							if rec.Code != http.StatusOK {
+								b.Errorf("status %d", rec.Code)
+							}
+						}()
+					}
+					wg.Wait()
+				}
+			})
+		}
+	}
+}
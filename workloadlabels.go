@@ -0,0 +1,68 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/projectcalico/pilot-webhook/pkg/config"
+)
+
+const (
+	// WorkloadNamespaceMetadata is the gRPC metadata carrying the workload's namespace on authz checks.
+	WorkloadNamespaceMetadata = "x-calico-workload-namespace"
+	// WorkloadLabelsMetadata is the gRPC metadata carrying the workload's labels on authz checks, as k=v pairs
+	// separated by commas in key order.
+	WorkloadLabelsMetadata = "x-calico-workload-labels"
+)
+
+// propagateLabels is set by --propagate-labels.
+var propagateLabels bool
+
+// headerValue is an Envoy HeaderValue, used for gRPC initial metadata.
+type headerValue = config.HeaderValue
+
+// workloadLabels returns the namespace and labels of the workload with the given IP, from its Calico workload
+// endpoint if the endpoint index is enabled, otherwise from its pod.
+func workloadLabels(ip string) (string, map[string]string, bool) {
+	if calicoEndpoints != nil {
+		if ep, ok := calicoEndpoints.endpoint(ip); ok {
+			return ep.Namespace, ep.Labels, true
+		}
+	}
+	if pods != nil {
+		pod, err := pods.byIP(ip)
+		if err != nil {
+			reportError("listeners", ErrorClassLookup, log.Fields{"ip": ip, "err": err}, "failed to look up pod")
+		} else if pod != nil {
+			return pod.Namespace, pod.Labels, true
+		}
+	}
+	return "", nil, false
+}
+
+// workloadMetadata returns the metadata the authz filter should send dikastes for the workload with the given IP, so
+// that it does not need to look the workload up on every check.
+func workloadMetadata(ip string) []headerValue {
+	namespace, l, ok := workloadLabels(ip)
+	if !ok {
+		return nil
+	}
+	return []headerValue{
+		{Key: WorkloadNamespaceMetadata, Value: namespace},
+		{Key: WorkloadLabelsMetadata, Value: labels.Set(l).String()},
+	}
+}
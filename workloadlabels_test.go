@@ -0,0 +1,67 @@
+// Copyright (c) 2018 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/projectcalico/pilot-webhook/pkg/mutator"
+)
+
+func TestWorkloadMetadataFromPod(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() { pods = nil }()
+	pod := testPod("testpod", NODE_IP, corev1.PodRunning, nil)
+	pod.Labels = map[string]string{"version": "v1", "app": "web"}
+	pods = newTestPodIndex(pod)
+
+	Expect(workloadMetadata(NODE_IP)).To(Equal([]headerValue{
+		{Key: WorkloadNamespaceMetadata, Value: "testns"},
+		{Key: WorkloadLabelsMetadata, Value: "app=web,version=v1"},
+	}))
+	Expect(workloadMetadata("9.9.9.9")).To(BeNil())
+}
+
+func TestUpdateListenerPropagatesLabels(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer func() {
+		pods = nil
+		istioVersions = nil
+		propagateLabels = false
+	}()
+	pod := testPod("testpod", "1.2.3.4", corev1.PodRunning, nil)
+	pod.Labels = map[string]string{"app": "web"}
+	pods = newTestPodIndex(pod)
+	propagateLabels = true
+
+	// Older sidecars only understand grpc_cluster, which cannot carry metadata.
+	l := v1.Listener{Name: "tcp_1.2.3.4_76", Filters: []*v1.NetworkFilter{{Name: v1.TCPProxyFilter}}}
+	updateListener(&l, "1.2.3.4")
+	Expect(l.Filters[0].Config.(*mutator.AuthzFilterConfig).GrpcCluster).NotTo(BeNil())
+
+	istioVersions, _ = newVersionDetector("0.8")
+	l = v1.Listener{Name: "tcp_1.2.3.4_76", Filters: []*v1.NetworkFilter{{Name: v1.TCPProxyFilter}}}
+	updateListener(&l, "1.2.3.4")
+	Expect(l.Filters[0].Config.(*mutator.AuthzFilterConfig).GrpcService.InitialMetadata).To(Equal([]headerValue{
+		{Key: WorkloadNamespaceMetadata, Value: "testns"},
+		{Key: WorkloadLabelsMetadata, Value: "app=web"},
+	}))
+}